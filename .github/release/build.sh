@@ -8,5 +8,10 @@ mkdir -p $PROJECT_ROOT
 rmdir $PROJECT_ROOT
 ln -s $GITHUB_WORKSPACE $PROJECT_ROOT
 cd $PROJECT_ROOT
+
+VERSION=$(git describe --tags --always 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo none)
+DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+
 go get -v ./...
-go build
+go build -ldflags "-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.date=${DATE}"
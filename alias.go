@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// aliasesEnv names the alias file when there's no --aliases
+const aliasesEnv = "SSM_LOADER_ALIASES"
+
+// loadAliases reads the path aliases in path, one per line as
+// alias NAME=PATH[@REGION][:ROLE_ARN]. Blank lines and lines starting
+// with # are skipped.
+func loadAliases(path string) (map[string]pathSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[string]pathSpec)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pair := strings.SplitN(strings.TrimPrefix(line, "alias "), "=", 2)
+		if !strings.HasPrefix(line, "alias ") || len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected alias NAME=PATH", path, i+1)
+		}
+
+		spec, err := parsePathSpec(strings.TrimSpace(pair[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+		if spec.Alias != "" {
+			return nil, fmt.Errorf("%s:%d: aliases can't refer to other aliases", path, i+1)
+		}
+		aliases[strings.TrimSpace(pair[0])] = spec
+	}
+
+	return aliases, nil
+}
+
+// resolveAlias replaces an @NAME spec with the path it's an alias for. A
+// region or role given with the alias wins over the alias's own.
+func resolveAlias(spec pathSpec, aliases map[string]pathSpec) (pathSpec, error) {
+	if spec.Alias == "" {
+		return spec, nil
+	}
+
+	target, exists := aliases[spec.Alias]
+	if !exists {
+		return pathSpec{}, fmt.Errorf("unknown path alias @%s", spec.Alias)
+	}
+
+	spec.Path = target.Path
+	if spec.Region == "" {
+		spec.Region = target.Region
+	}
+	if spec.Role == "" {
+		spec.Role = target.Role
+	}
+	spec.Alias = ""
+
+	return spec, nil
+}
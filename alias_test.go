@@ -0,0 +1,182 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]pathSpec
+		wantErr string
+	}{
+		{
+			name: "aliases",
+			data: "# services\nalias api=/prod/services/api/\n\n  alias shared = /prod/shared@eu-west-1:arn:aws:iam::111111111111:role/reader\n",
+			want: map[string]pathSpec{
+				"api":    {Path: "/prod/services/api/"},
+				"shared": {Path: "/prod/shared/", Region: "eu-west-1", Role: "arn:aws:iam::111111111111:role/reader"},
+			},
+		},
+		{name: "empty", data: "", want: map[string]pathSpec{}},
+		{name: "not an alias", data: "api=/prod/api", wantErr: "aliases:1: expected alias NAME=PATH"},
+		{name: "no path", data: "alias api", wantErr: "aliases:1: expected alias NAME=PATH"},
+		{name: "no name", data: "# first\nalias =/prod/api", wantErr: "aliases:2: expected alias NAME=PATH"},
+		{name: "bad path", data: "alias api=prod/api", wantErr: "aliases:1: invalid --path"},
+		{name: "alias of an alias", data: "alias api=@other", wantErr: "aliases:1: aliases can't refer to other aliases"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tempDir(t), "aliases", tt.data)
+
+			got, err := loadAliases(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadAliases() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadAliases() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadAliases(tempDir(t) + "/missing"); err == nil {
+		t.Error("loadAliases() of a missing file didn't fail")
+	}
+}
+
+func TestResolveAlias(t *testing.T) {
+	aliases := map[string]pathSpec{
+		"api":    {Path: "/prod/services/api/"},
+		"shared": {Path: "/prod/shared/", Region: "eu-west-1", Role: "arn:aws:iam::111111111111:role/reader"},
+	}
+
+	tests := []struct {
+		name    string
+		spec    pathSpec
+		want    pathSpec
+		wantErr string
+	}{
+		{name: "not an alias", spec: pathSpec{Path: "/prod/web/"}, want: pathSpec{Path: "/prod/web/"}},
+		{name: "alias", spec: pathSpec{Alias: "api"}, want: pathSpec{Path: "/prod/services/api/"}},
+		{
+			name: "alias region and role",
+			spec: pathSpec{Alias: "shared"},
+			want: pathSpec{Path: "/prod/shared/", Region: "eu-west-1", Role: "arn:aws:iam::111111111111:role/reader"},
+		},
+		{
+			name: "given region wins",
+			spec: pathSpec{Alias: "shared", Region: "us-west-2"},
+			want: pathSpec{Path: "/prod/shared/", Region: "us-west-2", Role: "arn:aws:iam::111111111111:role/reader"},
+		},
+		{
+			name: "keeps the guard",
+			spec: pathSpec{Alias: "api", IfParam: "/flags/api", IfValue: "on"},
+			want: pathSpec{Path: "/prod/services/api/", IfParam: "/flags/api", IfValue: "on"},
+		},
+		{name: "unknown", spec: pathSpec{Alias: "billing"}, wantErr: "unknown path alias @billing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveAlias(tt.spec, aliases)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("resolveAlias() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveAlias() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArgsAliases(t *testing.T) {
+	path := writeFile(t, tempDir(t), "aliases", "alias api=/prod/services/api/\nalias base=/prod/base/\n")
+
+	tests := []struct {
+		name         string
+		env          string
+		args         []string
+		wantPaths    []pathSpec
+		wantOverlays []pathSpec
+		wantErr      string
+	}{
+		{
+			name:      "--aliases",
+			args:      []string{"--aliases", path, "--path", "@api", "--path", "/prod/other"},
+			wantPaths: []pathSpec{{Path: "/prod/services/api/"}, {Path: "/prod/other/"}},
+		},
+		{
+			name:         "env",
+			env:          path,
+			args:         []string{"--overlay", "@base", "--path", "@api@us-west-2"},
+			wantPaths:    []pathSpec{{Path: "/prod/services/api/", Region: "us-west-2"}},
+			wantOverlays: []pathSpec{{Path: "/prod/base/"}},
+		},
+		{
+			name:      "after the path",
+			args:      []string{"--path", "@api", "--aliases", path},
+			wantPaths: []pathSpec{{Path: "/prod/services/api/"}},
+		},
+		{name: "unknown alias", args: []string{"--aliases", path, "--path", "@billing"}, wantErr: "unknown path alias @billing"},
+		{name: "no alias file", args: []string{"--path", "@api"}, wantErr: "can't resolve @api without --aliases or " + aliasesEnv},
+		{name: "missing alias file", args: []string{"--aliases", path + ".missing", "--path", "@api"}, wantErr: "aliases.missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, aliasesEnv, tt.env)
+
+			opts, _, err := parseArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseArgs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opts.Paths, tt.wantPaths) || !reflect.DeepEqual(opts.Overlays, tt.wantOverlays) {
+				t.Errorf("paths = %+v, overlays = %+v, want %+v and %+v", opts.Paths, opts.Overlays, tt.wantPaths, tt.wantOverlays)
+			}
+		})
+	}
+}
+
+func TestAliasFlag(t *testing.T) {
+	aliases := writeFile(t, tempDir(t), "aliases", "alias api=/prod/services/api/\n")
+	params := []fakeParam{{Name: "/prod/services/api/AL_URL", Value: "https://api"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--aliases", aliases, "--path", "@api", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if got := envLines(stdout, "AL_"); got != "AL_URL=https://api\n" {
+		t.Errorf("env = %q, want the aliased path loaded", got)
+	}
+
+	_, stderr, code = runMain(t, params, env, "--aliases", aliases, "--path", "@billing", "-O")
+	if code != exitUsage {
+		t.Errorf("exit code = %d for an unknown alias, want %d", code, exitUsage)
+	}
+	if !strings.Contains(stderr, "unknown path alias @billing") {
+		t.Errorf("stderr = %q, want the unknown alias", stderr)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// cacheFile is the --cache-file contents: the fetched parameters, along
+// with what they were fetched for and when
+type cacheFile struct {
+	Key    string
+	Saved  time.Time
+	Params []*ssm.Parameter
+
+	// Paths holds what was fetched under each path, as the base for
+	// --since
+	Paths map[string][]*ssm.Parameter
+
+	// DataKey is the KMS encrypted key SecureString values are sealed
+	// with, when there's a --cache-kms-key
+	DataKey []byte
+}
+
+// cacheKey identifies what a cache was fetched for, so a cache written
+// for another env, app or set of paths isn't used
+func cacheKey(parts ...interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(parts...)))
+	return hex.EncodeToString(sum[:])
+}
+
+// readCache returns the cache at path if it was written for key, with
+// its SecureStrings decrypted with k
+func readCache(path, key string, k *cacheKMS) (*cacheFile, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var cache cacheFile
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Key != key {
+		return nil, false
+	}
+
+	if err := cache.open(k); err != nil {
+		debugf("Not using the cache: %s", err)
+		return nil, false
+	}
+
+	return &cache, true
+}
+
+// Fresh reports whether the cache was written less than ttl ago
+func (c *cacheFile) Fresh(ttl time.Duration) bool {
+	if age := time.Since(c.Saved); age > ttl {
+		debugf("Cache is stale (%s old)", age.Round(time.Second))
+		return false
+	}
+	return true
+}
+
+// writeCache replaces the cache at path, which only the owner can read.
+// SecureStrings are encrypted with k, and without it nothing is cached.
+func writeCache(path, key string, params []*ssm.Parameter, k *cacheKMS) error {
+	cache := cacheFile{Key: key, Saved: time.Now(), Params: params}
+	if incremental != nil {
+		cache.Paths = incremental.Fetched
+	}
+
+	if err := cache.seal(k); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// runWarmup is the :warmup command: it keeps the cache at path fresh by
+// fetching every interval, so other invocations can read it instead of
+// calling SSM. A failed fetch leaves the cache as it was. It runs until
+// killed.
+func runWarmup(path, key string, k *cacheKMS, interval time.Duration, fetch func() ([]*ssm.Parameter, error)) {
+	for {
+		params, err := fetch()
+		if err != nil {
+			log.Println("Warning: couldn't refresh the cache: ", err)
+		} else if err := writeCache(path, key, params, k); err != nil {
+			log.Println("Warning: couldn't write the cache: ", err)
+		} else {
+			debugf("Cached %d params in %s", len(params), path)
+		}
+
+		time.Sleep(interval)
+	}
+}
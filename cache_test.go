@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestCacheKey(t *testing.T) {
+	key := cacheKey("us-east-1", "prod", "web", []string{"/extra/"})
+
+	if cacheKey("us-east-1", "prod", "web", []string{"/extra/"}) != key {
+		t.Error("the same parts have different keys")
+	}
+
+	others := map[string]string{
+		"region": cacheKey("eu-west-1", "prod", "web", []string{"/extra/"}),
+		"env":    cacheKey("us-east-1", "staging", "web", []string{"/extra/"}),
+		"app":    cacheKey("us-east-1", "prod", "api", []string{"/extra/"}),
+		"paths":  cacheKey("us-east-1", "prod", "web", []string(nil)),
+	}
+	for what, other := range others {
+		if other == key {
+			t.Errorf("another %s has the same key", what)
+		}
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	params := []*ssm.Parameter{
+		{Name: aws.String("/prod/web/DB_HOST"), Type: aws.String(ssm.ParameterTypeString), Value: aws.String("prod-db")},
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		setup func(path string)
+		want  bool
+	}{
+		{name: "written", key: "k", want: true},
+		{name: "other key", key: "other"},
+		{name: "missing", key: "k", setup: func(path string) { os.Remove(path) }},
+		{name: "corrupt", key: "k", setup: func(path string) { ioutil.WriteFile(path, []byte("{"), 0600) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "cache.json")
+			if err := writeCache(path, "k", params, nil); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(path)
+			}
+
+			cache, ok := readCache(path, tt.key, nil)
+			if ok != tt.want {
+				t.Fatalf("readCache() ok = %t, want %t", ok, tt.want)
+			}
+			if ok && !reflect.DeepEqual(cache.Params, params) {
+				t.Errorf("params = %v, want %v", cache.Params, params)
+			}
+		})
+	}
+}
+
+func TestWriteCache(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	if err := writeCache(path, "k", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("cache mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	cache, ok := readCache(path, "k", nil)
+	if !ok {
+		t.Fatal("cache not read back")
+	}
+	if !cache.Fresh(time.Minute) {
+		t.Error("a cache written just now isn't fresh")
+	}
+
+	cache.Saved = time.Now().Add(-2 * time.Minute)
+	if cache.Fresh(time.Minute) {
+		t.Error("a cache older than its ttl is fresh")
+	}
+}
+
+func TestWriteCacheSecureWithoutKey(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	params := []*ssm.Parameter{
+		{Name: aws.String("/prod/web/DB_PASSWORD"), Type: aws.String(ssm.ParameterTypeSecureString), Value: aws.String("hunter2")},
+	}
+
+	if err := writeCache(path, "k", params, nil); err != errCacheSecure {
+		t.Errorf("writeCache() error = %v, want errCacheSecure", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cache written with an unencrypted SecureString (err %v)", err)
+	}
+}
+
+func TestRunWarmup(t *testing.T) {
+	captureLog(t)
+	path := filepath.Join(tempDir(t), "cache.json")
+
+	fetches := make(chan int)
+	n := 0
+	fetch := func() ([]*ssm.Parameter, error) {
+		n++
+		fetches <- n
+		switch n {
+		case 1:
+			return nil, errors.New("throttled")
+		case 2:
+			return []*ssm.Parameter{{Name: aws.String("/prod/web/A"), Value: aws.String("a")}}, nil
+		}
+		// Stops the loop here
+		select {}
+	}
+	go runWarmup(path, "k", nil, time.Millisecond, fetch)
+
+	<-fetches
+	<-fetches
+	<-fetches
+
+	// The failed fetch wrote nothing and the next one was cached
+	cache, ok := readCache(path, "k", nil)
+	if !ok {
+		t.Fatal("no cache written")
+	}
+	if got := names(cache.Params); !reflect.DeepEqual(got, []string{"/prod/web/A"}) {
+		t.Errorf("cached %q, want the second fetch", got)
+	}
+}
+
+func TestCacheFlag(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	params := []fakeParam{{Name: "/prod/web/C_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+
+	run := func(args ...string) (string, string) {
+		t.Helper()
+		stdout, stderr, code := runMain(t, params, env, append([]string{"--cache-file", path}, args...)...)
+		if code != exitOK {
+			t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+		}
+		if got := envLines(stdout, "C_"); got != "C_HOST=prod-db\n" {
+			t.Errorf("env = %q, want C_HOST=prod-db", got)
+		}
+		return stdout, stderr
+	}
+
+	if _, stderr := run("-O"); !strings.Contains(stderr, "fake: GetParametersByPath") {
+		t.Errorf("first run didn't fetch: %q", stderr)
+	}
+	if _, stderr := run("-O"); strings.Contains(stderr, "fake: GetParametersByPath") {
+		t.Errorf("fresh cache wasn't used: %q", stderr)
+	}
+	if _, stderr := run("--cache-ttl", "1ns", "-O"); !strings.Contains(stderr, "fake: GetParametersByPath") {
+		t.Errorf("stale cache wasn't refetched: %q", stderr)
+	}
+
+	// A cache for another app isn't used
+	_, stderr, _ := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=api", "SSM_LOADER_TEST_TRACE=1"}, "--cache-file", path, "-O")
+	if !strings.Contains(stderr, "fake: GetParametersByPath") {
+		t.Errorf("another app's cache was used: %q", stderr)
+	}
+}
+
+func TestWarmupNeedsCacheFile(t *testing.T) {
+	_, stderr, code := runMain(t, nil, []string{"APP_ENV=prod", "APP_NAME=web"}, ":warmup")
+	if code != exitUsage {
+		t.Errorf("exit code = %d, want %d", code, exitUsage)
+	}
+	if !strings.Contains(stderr, ":warmup needs --cache-file") {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
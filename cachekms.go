@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// errCacheSecure is returned for a cache with SecureStrings and no
+// --cache-kms-key, as they're never cached in plaintext
+var errCacheSecure = errors.New("SecureStrings are only cached with --cache-kms-key")
+
+// cacheKMS is the --cache-kms-key a cache's SecureStrings are encrypted
+// with. Each write generates a data key under it, which seals the values
+// with AES-GCM and is stored encrypted beside them.
+type cacheKMS struct {
+	Client kmsiface.KMSAPI
+	KeyID  string
+}
+
+// each calls fn for every parameter in the cache, including the --since
+// base
+func (c *cacheFile) each(fn func(*ssm.Parameter) error) error {
+	for _, param := range c.Params {
+		if err := fn(param); err != nil {
+			return err
+		}
+	}
+	for _, params := range c.Paths {
+		for _, param := range params {
+			if err := fn(param); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *cacheFile) hasSecure() bool {
+	errFound := errors.New("found")
+	return c.each(func(param *ssm.Parameter) error {
+		if aws.StringValue(param.Type) == ssm.ParameterTypeSecureString {
+			return errFound
+		}
+		return nil
+	}) != nil
+}
+
+// seal encrypts the SecureString values in the cache. The parameters are
+// copied first, as they're still in use.
+func (c *cacheFile) seal(k *cacheKMS) error {
+	if !c.hasSecure() {
+		return nil
+	}
+	if k == nil {
+		return errCacheSecure
+	}
+
+	key, err := k.Client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(k.KeyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(key.Plaintext)
+	if err != nil {
+		return err
+	}
+
+	sealAll := func(params []*ssm.Parameter) ([]*ssm.Parameter, error) {
+		sealed := make([]*ssm.Parameter, len(params))
+		for i, param := range params {
+			copied := *param
+			if aws.StringValue(param.Type) == ssm.ParameterTypeSecureString {
+				nonce := make([]byte, gcm.NonceSize())
+				if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+					return nil, err
+				}
+				ciphertext := gcm.Seal(nonce, nonce, []byte(aws.StringValue(param.Value)), nil)
+				copied.Value = aws.String(base64.StdEncoding.EncodeToString(ciphertext))
+			}
+			sealed[i] = &copied
+		}
+		return sealed, nil
+	}
+
+	if c.Params, err = sealAll(c.Params); err != nil {
+		return err
+	}
+
+	paths := make(map[string][]*ssm.Parameter, len(c.Paths))
+	for path, params := range c.Paths {
+		if paths[path], err = sealAll(params); err != nil {
+			return err
+		}
+	}
+	c.Paths = paths
+
+	c.DataKey = key.CiphertextBlob
+	return nil
+}
+
+// open decrypts the SecureString values sealed in the cache. A cache
+// holding SecureStrings in plaintext is refused.
+func (c *cacheFile) open(k *cacheKMS) error {
+	if c.DataKey == nil {
+		if c.hasSecure() {
+			return errCacheSecure
+		}
+		return nil
+	}
+	if k == nil {
+		return fmt.Errorf("the cache is encrypted, but there's no --cache-kms-key")
+	}
+
+	key, err := k.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: c.DataKey})
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(key.Plaintext)
+	if err != nil {
+		return err
+	}
+
+	return c.each(func(param *ssm.Parameter) error {
+		if aws.StringValue(param.Type) != ssm.ParameterTypeSecureString {
+			return nil
+		}
+
+		ciphertext, err := base64.StdEncoding.DecodeString(aws.StringValue(param.Value))
+		if err != nil || len(ciphertext) < gcm.NonceSize() {
+			return fmt.Errorf("%s isn't encrypted", aws.StringValue(param.Name))
+		}
+
+		nonce := ciphertext[:gcm.NonceSize()]
+		plaintext, err := gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], nil)
+		if err != nil {
+			return fmt.Errorf("decrypting %s: %s", aws.StringValue(param.Name), err)
+		}
+
+		param.Value = aws.String(string(plaintext))
+		return nil
+	})
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
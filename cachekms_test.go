@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// fakeKMS hands out the same data key, "encrypted" by prefixing it with
+// the KMS key ID
+type fakeKMS struct {
+	kmsiface.KMSAPI
+
+	Err error
+
+	generated, decrypted int
+}
+
+var fakeDataKey = bytes.Repeat([]byte{7}, 32)
+
+func (f *fakeKMS) GenerateDataKey(input *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	f.generated++
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if aws.StringValue(input.KeySpec) != kms.DataKeySpecAes256 {
+		return nil, awserr.New("ValidationException", "unexpected KeySpec", nil)
+	}
+	blob := append([]byte(aws.StringValue(input.KeyId)+":"), fakeDataKey...)
+	return &kms.GenerateDataKeyOutput{KeyId: input.KeyId, Plaintext: fakeDataKey, CiphertextBlob: blob}, nil
+}
+
+func (f *fakeKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	f.decrypted++
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	i := bytes.IndexByte(input.CiphertextBlob, ':')
+	if i < 0 {
+		return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, "", nil)
+	}
+	return &kms.DecryptOutput{KeyId: aws.String(string(input.CiphertextBlob[:i])), Plaintext: input.CiphertextBlob[i+1:]}, nil
+}
+
+func cacheParams() []*ssm.Parameter {
+	return []*ssm.Parameter{
+		{Name: aws.String("/prod/web/DB_HOST"), Type: aws.String(ssm.ParameterTypeString), Value: aws.String("db.internal")},
+		{Name: aws.String("/prod/web/DB_PASSWORD"), Type: aws.String(ssm.ParameterTypeSecureString), Value: aws.String("hunter2")},
+	}
+}
+
+func TestCacheKMSRoundTrip(t *testing.T) {
+	fake := &fakeKMS{}
+	k := &cacheKMS{Client: fake, KeyID: "alias/cache"}
+	path := filepath.Join(tempDir(t), "cache.json")
+
+	params := cacheParams()
+	if err := writeCache(path, "k", params, k); err != nil {
+		t.Fatal(err)
+	}
+	if fake.generated != 1 {
+		t.Errorf("%d GenerateDataKey calls, want 1", fake.generated)
+	}
+	if aws.StringValue(params[1].Value) != "hunter2" {
+		t.Error("sealing changed the params being written")
+	}
+
+	data := readFile(t, path)
+	if strings.Contains(data, "hunter2") {
+		t.Errorf("the cache holds a SecureString in plaintext: %s", data)
+	}
+	if !strings.Contains(data, "db.internal") {
+		t.Errorf("the cache doesn't hold the String in plaintext: %s", data)
+	}
+
+	cache, ok := readCache(path, "k", k)
+	if !ok {
+		t.Fatal("readCache() didn't read the encrypted cache")
+	}
+	if fake.decrypted != 1 {
+		t.Errorf("%d Decrypt calls, want 1", fake.decrypted)
+	}
+	for i, want := range []string{"db.internal", "hunter2"} {
+		if got := aws.StringValue(cache.Params[i].Value); got != want {
+			t.Errorf("%s = %q, want %q", aws.StringValue(cache.Params[i].Name), got, want)
+		}
+	}
+}
+
+func TestCacheKMSPaths(t *testing.T) {
+	k := &cacheKMS{Client: &fakeKMS{}, KeyID: "alias/cache"}
+	cache := &cacheFile{Paths: map[string][]*ssm.Parameter{"/prod/web/": cacheParams()}}
+
+	if err := cache.seal(k); err != nil {
+		t.Fatal(err)
+	}
+	if cache.DataKey == nil {
+		t.Fatal("seal() didn't store the data key")
+	}
+	if got := aws.StringValue(cache.Paths["/prod/web/"][1].Value); got == "hunter2" {
+		t.Error("the --since base holds a SecureString in plaintext")
+	}
+
+	if err := cache.open(k); err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.StringValue(cache.Paths["/prod/web/"][1].Value); got != "hunter2" {
+		t.Errorf("opened --since base value = %q, want hunter2", got)
+	}
+}
+
+func TestCacheKMSWithoutSecure(t *testing.T) {
+	fake := &fakeKMS{}
+	k := &cacheKMS{Client: fake, KeyID: "alias/cache"}
+	cache := &cacheFile{Params: cacheParams()[:1]}
+
+	if err := cache.seal(k); err != nil {
+		t.Fatal(err)
+	}
+	if fake.generated != 0 || cache.DataKey != nil {
+		t.Errorf("a cache without SecureStrings was encrypted (%d GenerateDataKey calls)", fake.generated)
+	}
+	if err := cache.open(nil); err != nil {
+		t.Errorf("open() error = %v without a key", err)
+	}
+}
+
+func TestCacheKMSOpenFailures(t *testing.T) {
+	k := &cacheKMS{Client: &fakeKMS{}, KeyID: "alias/cache"}
+	sealed := func() *cacheFile {
+		cache := &cacheFile{Params: cacheParams()}
+		if err := cache.seal(k); err != nil {
+			t.Fatal(err)
+		}
+		return cache
+	}
+
+	tests := []struct {
+		name    string
+		cache   func() *cacheFile
+		k       *cacheKMS
+		wantErr string
+	}{
+		{
+			name:    "plaintext SecureString",
+			cache:   func() *cacheFile { return &cacheFile{Params: cacheParams()} },
+			k:       k,
+			wantErr: errCacheSecure.Error(),
+		},
+		{
+			name:    "no key",
+			cache:   sealed,
+			wantErr: "there's no --cache-kms-key",
+		},
+		{
+			name:    "decrypt fails",
+			cache:   sealed,
+			k:       &cacheKMS{Client: &fakeKMS{Err: awserr.New("AccessDeniedException", "denied", nil)}, KeyID: "alias/cache"},
+			wantErr: "denied",
+		},
+		{
+			name: "not encrypted",
+			cache: func() *cacheFile {
+				cache := sealed()
+				cache.Params[1].Value = aws.String("hunter2")
+				return cache
+			},
+			k:       k,
+			wantErr: "/prod/web/DB_PASSWORD isn't encrypted",
+		},
+		{
+			name: "tampered",
+			cache: func() *cacheFile {
+				cache := sealed()
+				value := []byte(aws.StringValue(cache.Params[1].Value))
+				value[len(value)-3] ^= 1
+				cache.Params[1].Value = aws.String(string(value))
+				return cache
+			},
+			k:       k,
+			wantErr: "decrypting /prod/web/DB_PASSWORD",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cache().open(tt.k)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("open() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadCacheRefusesPlaintextSecure(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	data, err := json.Marshal(cacheFile{Key: "k", Params: cacheParams()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	k := &cacheKMS{Client: &fakeKMS{}, KeyID: "alias/cache"}
+	if _, ok := readCache(path, "k", k); ok {
+		t.Error("readCache() used a cache holding a SecureString in plaintext")
+	}
+}
+
+func TestWriteCacheKMSFails(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	kmsErr := errors.New("kms unavailable")
+	k := &cacheKMS{Client: &fakeKMS{Err: kmsErr}, KeyID: "alias/cache"}
+
+	if err := writeCache(path, "k", cacheParams(), k); err != kmsErr {
+		t.Errorf("writeCache() error = %v, want %v", err, kmsErr)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// newSSMClient creates every SSM client, so tests can hand out fakes
+var newSSMClient = func(p client.ConfigProvider, cfgs ...*aws.Config) ssmiface.SSMAPI {
+	return ssm.New(p, cfgs...)
+}
+
+// clientSet hands out SSM clients for each role and region, creating them
+// as needed, and remembers which client each parameter was fetched with so it can be
+// refreshed with the same credentials
+type clientSet struct {
+	Default ssmiface.SSMAPI
+
+	sess    *session.Session
+	byKey   map[clientKey]ssmiface.SSMAPI
+	byParam map[string]ssmiface.SSMAPI
+}
+
+type clientKey struct {
+	Role   string
+	Region string
+}
+
+func newClientSet(sess *session.Session, client ssmiface.SSMAPI) *clientSet {
+	return &clientSet{
+		Default: client,
+		sess:    sess,
+		byKey:   make(map[clientKey]ssmiface.SSMAPI),
+		byParam: make(map[string]ssmiface.SSMAPI),
+	}
+}
+
+// For returns a client that assumes role in region, or the default client
+// when both are empty. An empty role uses the session's credentials and
+// an empty region the session's region.
+func (c *clientSet) For(role, region string) ssmiface.SSMAPI {
+	if role == "" && region == "" {
+		return c.Default
+	}
+
+	key := clientKey{Role: role, Region: region}
+
+	client, exists := c.byKey[key]
+	if !exists {
+		config := &aws.Config{}
+		if role != "" {
+			config.Credentials = stscreds.NewCredentials(c.sess, role)
+		}
+		if region != "" {
+			config.Region = aws.String(region)
+		}
+
+		client = newSSMClient(c.sess, config)
+		c.byKey[key] = client
+	}
+
+	return client
+}
+
+// Track records that params were fetched with client
+func (c *clientSet) Track(client ssmiface.SSMAPI, params []*ssm.Parameter) {
+	for _, param := range params {
+		c.byParam[aws.StringValue(param.Name)] = client
+	}
+}
+
+// ForParam returns the client the named parameter was fetched with
+func (c *clientSet) ForParam(name string) ssmiface.SSMAPI {
+	if client, exists := c.byParam[name]; exists {
+		return client
+	}
+	return c.Default
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// countClients replaces newSSMClient with a factory handing out fakes,
+// for the rest of the test, and returns how many it's created
+func countClients(t *testing.T) *int {
+	t.Helper()
+
+	created := 0
+	factory := newSSMClient
+	newSSMClient = func(p client.ConfigProvider, cfgs ...*aws.Config) ssmiface.SSMAPI {
+		created++
+		return newFakeSSM()
+	}
+	t.Cleanup(func() { newSSMClient = factory })
+
+	return &created
+}
+
+func TestClientSetCreatesEachClientOnce(t *testing.T) {
+	isolateAWSConfig(t)
+	created := countClients(t)
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newFakeSSM()
+	clients := newClientSet(sess, svc)
+
+	for i := 0; i < 5; i++ {
+		if clients.For("", "") != svc {
+			t.Fatal("For with no role or region isn't the default client")
+		}
+		clients.For("arn:aws:iam::123456789012:role/reader", "")
+		clients.For("", "eu-west-1")
+	}
+
+	if *created != 2 {
+		t.Errorf("created %d clients for 5 rounds, want 2", *created)
+	}
+}
+
+func TestClientSetByRole(t *testing.T) {
+	const (
+		sharedRole = "arn:aws:iam::111111111111:role/shared-reader"
+		appRole    = "arn:aws:iam::222222222222:role/app-reader"
+	)
+
+	shared := newFakeSSM(
+		fakeParam{Name: "/shared/LOG_LEVEL", Value: "info"},
+		fakeParam{Name: "/shared/REGION", Value: "us-east-1"},
+	)
+	app := newFakeSSM(
+		fakeParam{Name: "/prod/app/DB_HOST", Value: "db"},
+		fakeParam{Name: "/prod/app/LOG_LEVEL", Value: "debug"},
+	)
+
+	clients := newClientSet(nil, newFakeSSM())
+	clients.byKey[clientKey{Role: sharedRole}] = shared
+	clients.byKey[clientKey{Role: appRole}] = app
+
+	var all []*ssm.Parameter
+	for _, spec := range []pathSpec{{Path: "/shared/", Role: sharedRole}, {Path: "/prod/app/", Role: appRole}} {
+		client := clients.For(spec.Role, spec.Region)
+
+		params, err := getParameters(&getParametersInput{Client: client, Path: aws.String(spec.Path), Clock: newFakeClock()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients.Track(client, params)
+		all = append(all, params...)
+	}
+
+	if shared.Calls("GetParametersByPath") != 1 || app.Calls("GetParametersByPath") != 1 {
+		t.Errorf("each account's path should be fetched with its own client")
+	}
+
+	m := make(paramMap)
+	if err := m.AddParams(all, &paramOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := paramMap{"LOG_LEVEL": "info", "REGION": "us-east-1", "DB_HOST": "db"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("env = %v, want %v", m, want)
+	}
+
+	// Refreshing a parameter goes through the account it came from
+	for name, want := range map[string]ssmiface.SSMAPI{
+		"/shared/REGION":      shared,
+		"/prod/app/DB_HOST":   app,
+		"/prod/app/UNKNOWN":   clients.Default,
+		"/shared/LOG_LEVEL":   shared,
+		"/prod/app/LOG_LEVEL": app,
+	} {
+		if clients.ForParam(name) != want {
+			t.Errorf("ForParam(%s) isn't the client it was fetched with", name)
+		}
+	}
+}
+
+func TestClientSetByRegion(t *testing.T) {
+	isolateAWSConfig(t)
+
+	// A fake for each region, handed out by the region of the client
+	regional := map[string]*fakeSSM{
+		"us-east-1": newFakeSSM(
+			fakeParam{Name: "/shared/LOG_LEVEL", Value: "info"},
+			fakeParam{Name: "/shared/SENTRY_DSN", Value: "https://sentry"},
+		),
+		"eu-west-1": newFakeSSM(
+			fakeParam{Name: "/prod/app/DB_HOST", Value: "eu-db"},
+			fakeParam{Name: "/prod/app/LOG_LEVEL", Value: "debug"},
+		),
+	}
+	factory := newSSMClient
+	newSSMClient = func(p client.ConfigProvider, cfgs ...*aws.Config) ssmiface.SSMAPI {
+		region := ""
+		for _, cfg := range cfgs {
+			region = aws.StringValue(cfg.Region)
+		}
+		return regional[region]
+	}
+	t.Cleanup(func() { newSSMClient = factory })
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("eu-west-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := newClientSet(sess, regional["eu-west-1"])
+
+	specs := []string{"/shared/@us-east-1", "/prod/app/"}
+	var all []*ssm.Parameter
+	for _, s := range specs {
+		spec, err := parsePathSpec(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := clients.For(spec.Role, spec.Region)
+		params, err := getParameters(&getParametersInput{Client: client, Path: aws.String(spec.Path), Clock: newFakeClock()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients.Track(client, params)
+		all = append(all, params...)
+	}
+
+	for region, fake := range regional {
+		if calls := fake.Calls("GetParametersByPath"); calls != 1 {
+			t.Errorf("%d calls in %s, want each path fetched in its own region", calls, region)
+		}
+	}
+
+	// Paths earlier on the command line win, as they do in one region
+	m := make(paramMap)
+	if err := m.AddParams(all, &paramOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := paramMap{"LOG_LEVEL": "info", "SENTRY_DSN": "https://sentry", "DB_HOST": "eu-db"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("env = %v, want %v", m, want)
+	}
+
+	if clients.ForParam("/shared/SENTRY_DSN") != regional["us-east-1"] {
+		t.Error("/shared/SENTRY_DSN isn't refreshed from us-east-1")
+	}
+	if clients.For("", "us-east-1") != clients.ForParam("/shared/LOG_LEVEL") {
+		t.Error("the us-east-1 client was created again")
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "time"
+
+// clock is the time the fetch loops wait on, so their pacing and backoff
+// can be driven without real waits
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
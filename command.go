@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// commandSeparator splits the args into commands to run one after another
+const commandSeparator = "--then"
+
+// shellCommand is the args that run command with the system shell
+func shellCommand(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+	return []string{"/bin/sh", "-c", command}
+}
+
+// subcommandPrefix marks ssm-loader's own commands, as in :find, so they
+// never shadow a program of the same name that's being wrapped
+const subcommandPrefix = ":"
+
+// subcommands are the commands run with subcommandPrefix
+var subcommands = map[string]bool{
+	"warmup":  true,
+	"find":    true,
+	"probe":   true,
+	"connect": true,
+}
+
+// subcommand returns the name of the ssm-loader command args start with,
+// or "" when they start with a program to run
+func subcommand(args []string) (string, error) {
+	if len(args) == 0 || len(args[0]) <= len(subcommandPrefix) || !strings.HasPrefix(args[0], subcommandPrefix) {
+		return "", nil
+	}
+
+	name := strings.TrimPrefix(args[0], subcommandPrefix)
+	if !subcommands[name] {
+		return "", fmt.Errorf("unknown command %s, expected :find, :probe, :warmup or :connect", args[0])
+	}
+	return name, nil
+}
+
+// splitCommands splits args into the commands separated by --then
+func splitCommands(args []string) ([][]string, error) {
+	var commands [][]string
+	start := 0
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	for i := 0; i <= len(args); i++ {
+		if i < len(args) && args[i] != commandSeparator {
+			continue
+		}
+		if i == start {
+			return nil, fmt.Errorf("%s needs a command on both sides", commandSeparator)
+		}
+		commands = append(commands, args[start:i])
+		start = i + 1
+	}
+
+	return commands, nil
+}
+
+// runSequence runs each command to completion in turn with the same env,
+// stopping at the first that fails unless --ignore-child-error is set
+func runSequence(commands [][]string, env paramMap, opts *options) {
+	for _, args := range commands {
+		cmd := startCommand(args, env, opts)
+
+		if err := waitCommand(cmd); err != nil {
+			if !opts.IgnoreChildError {
+				fatal(commandExitCode(err), "Command "+args[0]+" finished with err: ", err)
+			}
+			debugf("Command %s finished with err, continuing: %s", args[0], err)
+		}
+	}
+}
+
+func startCommand(args []string, env paramMap, opts *options) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+
+	// Pipe everything to the command. A nil Stdin reads from the null
+	// device so the command sees EOF straight away, as it does when
+	// --params-stdin has read it already.
+	if !opts.NoStdin && !opts.ParamsStdin {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if opts.RedactOutput {
+		cmd.Stdout = &redactWriter{W: os.Stdout}
+		cmd.Stderr = &redactWriter{W: os.Stderr}
+	}
+	cmd.Env = env.StringArray()
+	cmd.Dir = prepareCommand(args, env, opts)
+
+	err := cmd.Start()
+	if err != nil {
+		fatal(startExitCode(err), "Error while starting command: ", err)
+	}
+
+	return cmd
+}
+
+// prepareCommand checks the env fits and returns the directory to run the
+// command in, or "" for the current one
+func prepareCommand(args []string, env paramMap, opts *options) string {
+	if err := checkEnvSize(args, env); err != nil {
+		if opts.FailEnvSize {
+			fatal(exitUsage, "Environment too large: ", err)
+		}
+		log.Println("Warning: ", err)
+	}
+
+	if opts.Chdir == "" {
+		return ""
+	}
+
+	dir, err := commandDir(env.Interpolate(opts.Chdir))
+	if err != nil {
+		fatal(exitUsage, "Error changing directory: ", err)
+	}
+	return dir
+}
+
+// commandDir checks dir exists and is a directory
+func commandDir(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("directory %s does not exist", dir)
+		}
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,500 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// withStdin replaces os.Stdin with a pipe holding input for the rest of
+// the test
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(input)
+	w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+	})
+}
+
+// runShell runs script with startCommand and waits for it
+func runShell(t *testing.T, script string, env paramMap, opts *options) {
+	t.Helper()
+
+	if err := waitCommand(startCommand([]string{"/bin/sh", "-c", script}, env, opts)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartCommandStdin(t *testing.T) {
+	tests := []struct {
+		name string
+		opts options
+		want string
+	}{
+		{name: "inherited", opts: options{}, want: "from the terminal\n"},
+		{name: "no stdin", opts: options{NoStdin: true}, want: ""},
+		{name: "read by --params-stdin", opts: options{ParamsStdin: true}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStdin(t, "from the terminal\n")
+			out := filepath.Join(tempDir(t), "stdin")
+
+			runShell(t, "cat > "+out, paramMap{}, &tt.opts)
+
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("command read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartCommandChdir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(tempDir(t), "pwd")
+
+	runShell(t, "pwd > "+out, paramMap{"APP_DIR": dir}, &options{Chdir: "%%APP_DIR%%", NoStdin: true})
+
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != dir+"\n" {
+		t.Errorf("command ran in %q, want %q", got, dir)
+	}
+}
+
+func TestCommandDir(t *testing.T) {
+	dir := tempDir(t)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir     string
+		wantErr string
+	}{
+		{dir: dir},
+		{dir: filepath.Join(dir, "missing"), wantErr: "directory " + filepath.Join(dir, "missing") + " does not exist"},
+		{dir: file, wantErr: file + " is not a directory"},
+	}
+
+	for _, tt := range tests {
+		got, err := commandDir(tt.dir)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("commandDir(%s) error = %v, want %q", tt.dir, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.dir {
+			t.Errorf("commandDir(%s) = %q, %v", tt.dir, got, err)
+		}
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    [][]string
+		wantErr bool
+	}{
+		{args: nil, want: nil},
+		{args: []string{"serve"}, want: [][]string{{"serve"}}},
+		{args: []string{"migrate", "--then", "seed", "-n", "5", "--then", "serve"}, want: [][]string{{"migrate"}, {"seed", "-n", "5"}, {"serve"}}},
+		{args: []string{"--then", "serve"}, wantErr: true},
+		{args: []string{"migrate", "--then"}, wantErr: true},
+		{args: []string{"migrate", "--then", "--then", "serve"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitCommands(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("splitCommands(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommands(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSequentialCommands(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/STEP", Value: "ran"}}
+
+	tests := []struct {
+		name   string
+		args   []string
+		middle string
+		code   int
+		want   string
+	}{
+		{name: "all succeed", middle: "exit 0", want: "migrate ran\nseed ran\nserve ran\n"},
+		{name: "abort on failure", middle: "exit 3", code: 3, want: "migrate ran\nseed ran\n"},
+		{name: "ignore failure", args: []string{"--ignore-child-error"}, middle: "exit 3", want: "migrate ran\nseed ran\nserve ran\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(tempDir(t), "out")
+			step := func(name, then string) []string {
+				return []string{"/bin/sh", "-c", "echo " + name + ` "$STEP" >> ` + out + "; " + then}
+			}
+
+			args := append(tt.args, step("migrate", "true")...)
+			args = append(append(args, "--then"), step("seed", tt.middle)...)
+			args = append(append(args, "--then"), step("serve", "true")...)
+
+			_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, args...)
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+
+			got, _ := ioutil.ReadFile(out)
+			if string(got) != tt.want {
+				t.Errorf("ran %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuietOnSuccess(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+		{Name: "/prod/web/DB_PASSWORD", Value: "secret", Type: ssm.ParameterTypeSecureString},
+		{Name: "/prod/web/DB_URL", Value: "postgres://%%DB_HOST%%"},
+	}
+	command := []string{"/bin/sh", "-c", `echo "out $DB_URL"; echo err >&2`}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "command", args: command, wantStdout: "out postgres://prod-db\n", wantStderr: "err\n"},
+		{name: "command after --then", args: append([]string{"/bin/sh", "-c", "true", "--then"}, command...), wantStdout: "out postgres://prod-db\n", wantStderr: "err\n"},
+		{name: "keys only", args: []string{"--keys-only"}, wantStdout: "DB_HOST\nDB_PASSWORD\nDB_URL\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, tt.args...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if stderr != tt.wantStderr {
+				t.Errorf("stderr = %q, want only the command's %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+
+	// --verbose is where the logging goes
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, append([]string{"--verbose"}, command...)...)
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if stderr == "err\n" {
+		t.Error("--verbose logged nothing")
+	}
+}
+
+func TestMaskNotAppliedToCommand(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_PASSWORD", Value: "hunter2", Type: ssm.ParameterTypeSecureString}}
+
+	stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--mask", "DB_PASSWORD", "/bin/sh", "-c", `echo "$DB_PASSWORD"`)
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if stdout != "hunter2\n" {
+		t.Errorf("the command got DB_PASSWORD=%q, want the real value", strings.TrimSpace(stdout))
+	}
+}
+
+func TestRedactOutput(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DB_PASSWORD", Value: "hunter2", Type: ssm.ParameterTypeSecureString},
+		{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+	}
+	command := []string{"/bin/sh", "-c", `echo "connecting to $DB_HOST with $DB_PASSWORD"; printf "failed: $DB_PASSWORD" >&2`}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "redacted", args: []string{"--redact-output"}, wantStdout: "connecting to prod-db with ***\n", wantStderr: "failed: ***"},
+		{name: "off", wantStdout: "connecting to prod-db with hunter2\n", wantStderr: "failed: hunter2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, append(tt.args, command...)...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+			if stdout != tt.wantStdout || stderr != tt.wantStderr {
+				t.Errorf("output = %q, %q, want %q, %q", stdout, stderr, tt.wantStdout, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestParamsStdinCommandStdin(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+
+	// The loader used up stdin, so the command reads nothing from it
+	stdout, stderr, code := runMainStdin(t, "DB_USER=app\n", params, []string{"APP_ENV=prod", "APP_NAME=web"},
+		"--params-stdin", "/bin/sh", "-c", `echo "$DB_USER@$DB_HOST"; cat; echo done`)
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if stdout != "app@prod-db\ndone\n" {
+		t.Errorf("stdout = %q, want the command to get no stdin", stdout)
+	}
+}
+
+func TestResultFile(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+		{Name: "/prod/web/DB_USER", Value: "app"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"success", []string{"/bin/sh", "-c", "true"}, exitOK},
+		{"command fails", []string{"/bin/sh", "-c", "exit 3"}, 3},
+		{"then fails", []string{"/bin/sh", "-c", "true", "--then", "/bin/sh", "-c", "exit 4"}, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "result.json")
+
+			_, stderr, code := runMain(t, params, env, append([]string{"--result-file", path}, test.args...)...)
+			if code != test.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, test.wantCode, stderr)
+			}
+
+			got := readResult(t, path)
+			if got.ExitCode != test.wantCode || got.ParamsLoaded != 2 {
+				t.Errorf("result = %+v, want exit code %d and 2 params", got, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestResultFileRunID(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+	dir := tempDir(t)
+
+	// Each run records the ID its command was given
+	var ids []string
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, "result.json")
+		stdout, stderr, code := runMain(t, params, env, "--result-file", path, "--inject-run-id", "RUN_ID", "/bin/sh", "-c", `echo "$RUN_ID"`)
+		if code != exitOK {
+			t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+		}
+
+		got := readResult(t, path)
+		if got.RunID == "" || got.RunID+"\n" != stdout {
+			t.Errorf("result run ID = %q, want the command's %q", got.RunID, stdout)
+		}
+		ids = append(ids, got.RunID)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("two runs recorded the same ID %q", ids[0])
+	}
+
+	path := filepath.Join(dir, "result.json")
+	runMain(t, params, env, "--result-file", path, "/bin/sh", "-c", "true")
+	if got := readResult(t, path); got.RunID != "" {
+		t.Errorf("result run ID = %q without --inject-run-id", got.RunID)
+	}
+}
+
+func TestResultFileWithoutCommand(t *testing.T) {
+	path := filepath.Join(tempDir(t), "result.json")
+
+	_, stderr, code := runMain(t, []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}, []string{"APP_ENV=prod", "APP_NAME=web"},
+		"--result-file", path, "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("result file written without a command (err %v)", err)
+	}
+}
+
+func TestNoExec(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/NX_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	tests := []struct {
+		name string
+		args func(dir string) []string
+	}{
+		{"alone", func(string) []string { return nil }},
+		{"with a command", func(dir string) []string {
+			return []string{"/bin/sh", "-c", "touch " + filepath.Join(dir, "ran")}
+		}},
+		{"with -O", func(string) []string { return []string{"-O"} }},
+		{"with --write-env", func(dir string) []string { return []string{"--write-env", filepath.Join(dir, "ran")} }},
+		{"with --k8s-secret", func(string) []string { return []string{"--k8s-secret", "web"} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+
+			stdout, stderr, code := runMain(t, params, env, append([]string{"--no-exec"}, tt.args(dir)...)...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+			if stdout != "" {
+				t.Errorf("stdout = %q, want nothing printed", stdout)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "ran")); !os.IsNotExist(err) {
+				t.Errorf("something ran or was written (err %v)", err)
+			}
+		})
+	}
+}
+
+func TestNoExecFailures(t *testing.T) {
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	tests := []struct {
+		name     string
+		env      []string
+		args     []string
+		wantCode int
+	}{
+		{"fetch fails", []string{"SSM_LOADER_TEST_ERR=AccessDeniedException:denied"}, nil, exitAccessDenied},
+		{"check fails", nil, []string{"--types", "NX_HOST:int"}, exitUsage},
+		{"key missing", nil, []string{"--names", "DB_PASSWORD", "--strict"}, exitNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := []fakeParam{{Name: "/prod/web/NX_HOST", Value: "prod-db"}}
+
+			_, stderr, code := runMain(t, params, append(env, tt.env...), append([]string{"--no-exec"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, tt.wantCode, stderr)
+			}
+		})
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	want := []string{"/bin/sh", "-c", "echo $HOME && exit 3"}
+	if got := shellCommand("echo $HOME && exit 3"); !reflect.DeepEqual(got, want) {
+		t.Errorf("shellCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestCommandFromParam(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/CP_HOST", Value: "prod-db"},
+		{Name: "/ops/web/entrypoint", Value: `echo "serving with $CP_HOST" && exit 3`},
+		{Name: "/ops/web/blank", Value: "  "},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "runs the command",
+			args:       []string{"--command-from-param", "/ops/web/entrypoint"},
+			wantCode:   3,
+			wantStdout: "serving with prod-db\n",
+		},
+		{
+			name:       "a given command wins",
+			args:       []string{"--command-from-param", "/ops/web/entrypoint", "/bin/sh", "-c", "echo given"},
+			wantCode:   exitOK,
+			wantStdout: "given\n",
+		},
+		{
+			name:       "missing",
+			args:       []string{"--command-from-param", "/ops/web/missing"},
+			wantCode:   exitNotFound,
+			wantStderr: "Error fetching the command from /ops/web/missing",
+		},
+		{
+			name:       "blank",
+			args:       []string{"--command-from-param", "/ops/web/blank"},
+			wantCode:   exitUsage,
+			wantStderr: "/ops/web/blank has no command",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, env, tt.args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.wantCode, stderr)
+			}
+			if tt.wantStdout != "" && stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+
+	// The parameter is only fetched when there's no command
+	_, stderr, _ := runMain(t, params, env, "--command-from-param", "/ops/web/entrypoint")
+	if got := strings.Count(stderr, "fake: GetParameter\n"); got != 1 {
+		t.Errorf("%d GetParameter calls without a command, want 1", got)
+	}
+	_, stderr, _ = runMain(t, params, env, "--command-from-param", "/ops/web/entrypoint", "/bin/true")
+	if strings.Contains(stderr, "fake: GetParameter\n") {
+		t.Errorf("stderr = %q, want no GetParameter call", stderr)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// effectiveConfig is what --print-effective-config shows: the settings
+// resolved from flags, env vars and the AWS profile
+type effectiveConfig struct {
+	Region  string
+	AppEnv  string
+	AppName string
+
+	// Paths are in load order, so later ones win
+	Paths []pathSpec
+	Names []string
+
+	// Precedence lists where values come from, highest first, and
+	// Interpolation what %%KEY%% resolves against
+	Precedence    []string
+	Interpolation []string
+
+	Options options
+}
+
+// printEffectiveConfig writes config as JSON. Values given with --set
+// are masked, as they could be secrets.
+func printEffectiveConfig(w io.Writer, config effectiveConfig) error {
+	sets := make(paramMap, len(config.Options.Sets))
+	for key := range config.Options.Sets {
+		sets[key] = "***"
+	}
+	config.Options.Sets = sets
+
+	loaded := &loadedParams{InterpolateOSEnv: config.Options.InterpolateOSEnv}
+	config.Precedence = layerNames(loaded.valueLayers(), &config.Options)
+	config.Interpolation = layerNames(loaded.interpolationLayers(), &config.Options)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
+
+// layerNames returns the names of the layers opts loads anything into.
+// The --manifest keys are loaded with SSM's and win over the path keys.
+func layerNames(layers []envLayer, opts *options) []string {
+	var names []string
+	for _, layer := range layers {
+		switch {
+		case layer.Name == layerStdin && !opts.ParamsStdin:
+			continue
+		case layer.Name == layerS3 && opts.S3Config == nil:
+			continue
+		case layer.Name == layerSSM && opts.Manifest != nil:
+			names = append(names, "--manifest")
+		}
+		names = append(names, layer.Name)
+	}
+	return names
+}
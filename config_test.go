@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrintEffectiveConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              options
+		wantPrecedence    []string
+		wantInterpolation []string
+	}{
+		{
+			name:              "defaults",
+			wantPrecedence:    []string{"--set", "OS env", "SSM"},
+			wantInterpolation: []string{"--set", "SSM", "%%/NAME%%", "%%secret:ID%%"},
+		},
+		{
+			name:              "params stdin",
+			opts:              options{ParamsStdin: true},
+			wantPrecedence:    []string{"--set", "--params-stdin", "OS env", "SSM"},
+			wantInterpolation: []string{"--set", "--params-stdin", "SSM", "%%/NAME%%", "%%secret:ID%%"},
+		},
+		{
+			name:              "manifest and s3 config",
+			opts:              options{Manifest: manifest{"DB_HOST": "/prod/db/host"}, S3Config: &url.URL{Scheme: "s3", Host: "config", Path: "/app.json"}},
+			wantPrecedence:    []string{"--set", "OS env", "--manifest", "SSM", "--s3-config"},
+			wantInterpolation: []string{"--set", "--manifest", "SSM", "--s3-config", "%%/NAME%%", "%%secret:ID%%"},
+		},
+		{
+			name:              "interpolate os env",
+			opts:              options{InterpolateOSEnv: true},
+			wantPrecedence:    []string{"--set", "OS env", "SSM"},
+			wantInterpolation: []string{"--set", "SSM", "%%/NAME%%", "%%secret:ID%%", "OS env"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Sets = paramMap{"DB_PASSWORD": "hunter2", "DEBUG": "1"}
+
+			var out bytes.Buffer
+			err := printEffectiveConfig(&out, effectiveConfig{Region: "eu-west-1", Paths: []pathSpec{{Path: "/prod/"}}, Options: tt.opts})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got effectiveConfig
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("not JSON: %v\n%s", err, out.String())
+			}
+
+			if !reflect.DeepEqual(got.Precedence, tt.wantPrecedence) {
+				t.Errorf("precedence = %q, want %q", got.Precedence, tt.wantPrecedence)
+			}
+			if !reflect.DeepEqual(got.Interpolation, tt.wantInterpolation) {
+				t.Errorf("interpolation = %q, want %q", got.Interpolation, tt.wantInterpolation)
+			}
+			if want := (paramMap{"DB_PASSWORD": "***", "DEBUG": "***"}); !reflect.DeepEqual(got.Options.Sets, want) {
+				t.Errorf("sets = %v, want %v", got.Options.Sets, want)
+			}
+			if strings.Contains(out.String(), "hunter2") {
+				t.Errorf("a --set value was printed:\n%s", out.String())
+			}
+			if got.Region != "eu-west-1" || len(got.Paths) != 1 {
+				t.Errorf("config = %+v", got)
+			}
+
+			// Only the printed copy is masked
+			if tt.opts.Sets["DB_PASSWORD"] != "hunter2" {
+				t.Error("masking changed the options")
+			}
+		})
+	}
+}
+
+func TestPrintConfigFlag(t *testing.T) {
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "AWS_REGION=eu-west-1", "SSM_LOADER_TEST_TRACE=1"}
+
+	stdout, stderr, code := runMain(t, nil, env,
+		"--print-effective-config", "--path", "/extra", "--overlay", "/features", "--set", "DB_PASSWORD=hunter2")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	var got effectiveConfig
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, stdout)
+	}
+
+	if got.Region != "eu-west-1" || got.AppEnv != "prod" || got.AppName != "web" {
+		t.Errorf("config = %+v", got)
+	}
+
+	var paths []string
+	for _, spec := range got.Paths {
+		paths = append(paths, spec.Path)
+	}
+	if want := []string{"/prod/", "/prod/web/", "/features/", "/extra/"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+
+	if strings.Contains(stdout, "hunter2") {
+		t.Errorf("a --set value was printed:\n%s", stdout)
+	}
+	if strings.Contains(stderr, "fake: Get") {
+		t.Errorf("params were fetched: %q", stderr)
+	}
+}
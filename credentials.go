@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// fileProviderName is reported as the ProviderName of credentials read
+// from --credentials-file
+const fileProviderName = "CredentialsFileProvider"
+
+// credentialsFile is the JSON read from --credentials-file. It's the same
+// shape credential_process commands print, so the output of one can be
+// saved and used directly.
+type credentialsFile struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      *time.Time
+}
+
+// fileProvider reads credentials from a JSON file, re-reading it once
+// the credentials in it expire so a sidecar can keep it refreshed
+type fileProvider struct {
+	credentials.Expiry
+
+	Path string
+}
+
+func (p *fileProvider) Retrieve() (credentials.Value, error) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return credentials.Value{ProviderName: fileProviderName}, err
+	}
+
+	var file credentialsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return credentials.Value{ProviderName: fileProviderName}, fmt.Errorf("invalid credentials file %s: %s", p.Path, err.Error())
+	}
+
+	if file.AccessKeyID == "" || file.SecretAccessKey == "" {
+		return credentials.Value{ProviderName: fileProviderName}, fmt.Errorf("credentials file %s is missing AccessKeyId or SecretAccessKey", p.Path)
+	}
+
+	// Without an expiration the credentials never expire and the file is
+	// only read once
+	if file.Expiration != nil {
+		p.SetExpiration(*file.Expiration, time.Minute)
+	}
+
+	return credentials.Value{
+		AccessKeyID:     file.AccessKeyID,
+		SecretAccessKey: file.SecretAccessKey,
+		SessionToken:    file.SessionToken,
+		ProviderName:    fileProviderName,
+	}, nil
+}
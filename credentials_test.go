@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleCredentials = `{
+  "Version": 1,
+  "AccessKeyId": "AKIDEXAMPLE",
+  "SecretAccessKey": "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY",
+  "SessionToken": "token",
+  "Expiration": "2099-01-01T00:00:00Z"
+}`
+
+func TestFileProvider(t *testing.T) {
+	dir := tempDir(t)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{name: "sample file", data: sampleCredentials, want: "AKIDEXAMPLE"},
+		{name: "no session token", data: `{"AccessKeyId": "AKID", "SecretAccessKey": "secret"}`, want: "AKID"},
+		{name: "invalid json", data: `{"AccessKeyId": `, wantErr: "invalid credentials file"},
+		{name: "missing secret", data: `{"AccessKeyId": "AKID"}`, wantErr: "missing AccessKeyId or SecretAccessKey"},
+		{name: "missing file", wantErr: "credentials.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "missing", "credentials.json")
+			if tt.data != "" {
+				path = writeFile(t, dir, strings.Replace(tt.name, " ", "-", -1)+".json", tt.data)
+			}
+
+			provider := &fileProvider{Path: path}
+			value, err := provider.Retrieve()
+			if value.ProviderName != fileProviderName {
+				t.Errorf("ProviderName = %q, want %q", value.ProviderName, fileProviderName)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Retrieve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.AccessKeyID != tt.want {
+				t.Errorf("AccessKeyID = %q, want %q", value.AccessKeyID, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileProviderRereadsOnExpiry(t *testing.T) {
+	dir := tempDir(t)
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	path := writeFile(t, dir, "credentials.json", `{"AccessKeyId": "OLD", "SecretAccessKey": "secret", "Expiration": "`+expired+`"}`)
+
+	provider := &fileProvider{Path: path}
+	if _, err := provider.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	if !provider.IsExpired() {
+		t.Fatal("credentials past their Expiration are not expired")
+	}
+
+	writeFile(t, dir, "credentials.json", sampleCredentials)
+	value, err := provider.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "AKIDEXAMPLE" {
+		t.Errorf("AccessKeyID = %q after the file was refreshed", value.AccessKeyID)
+	}
+	if provider.IsExpired() {
+		t.Error("refreshed credentials are already expired")
+	}
+}
+
+func TestSessionCredentialsFile(t *testing.T) {
+	isolateAWSConfig(t)
+	path := writeFile(t, tempDir(t), "credentials.json", sampleCredentials)
+
+	sess, err := newSession(&options{Region: "us-east-1", CredentialsFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := sess.Config.Credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.ProviderName != fileProviderName || value.SessionToken != "token" {
+		t.Errorf("session credentials = %+v, want the ones from %s", value, path)
+	}
+}
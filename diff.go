@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// writeEnvDiff writes how each of keys in env compares with the
+// inherited env base: new, changed (with the old and new values) or
+// unchanged. Keys in masks have their values shown as ***, but are still
+// compared by their real values.
+func writeEnvDiff(w io.Writer, env, base paramMap, keys []string, masks []string) {
+	shownEnv, shownBase := env.Masked(masks), base.Masked(masks)
+
+	for _, key := range keys {
+		value, exists := env[key]
+		if !exists {
+			continue
+		}
+
+		old, inherited := base[key]
+		switch {
+		case !inherited:
+			fmt.Fprintf(w, "new       %s=%s\n", key, shownEnv[key])
+		case old != value:
+			fmt.Fprintf(w, "changed   %s=%s -> %s\n", key, shownBase[key], shownEnv[key])
+		default:
+			fmt.Fprintf(w, "unchanged %s\n", key)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteEnvDiff(t *testing.T) {
+	base := paramMap{"HOME": "/root", "DB_HOST": "localhost", "PORT": "8080", "DB_PASSWORD": "old"}
+
+	tests := []struct {
+		name  string
+		env   paramMap
+		keys  []string
+		masks []string
+		want  string
+	}{
+		{
+			name: "new",
+			env:  paramMap{"HOME": "/root", "API_URL": "https://api"},
+			keys: []string{"API_URL"},
+			want: "new       API_URL=https://api\n",
+		},
+		{
+			name: "overlapping",
+			env:  paramMap{"HOME": "/root", "DB_HOST": "db.internal", "PORT": "8080", "API_URL": "https://api"},
+			keys: []string{"DB_HOST", "PORT", "API_URL"},
+			want: "changed   DB_HOST=localhost -> db.internal\nunchanged PORT\nnew       API_URL=https://api\n",
+		},
+		{
+			name: "only loaded keys",
+			env:  paramMap{"HOME": "/home/app", "PORT": "8080"},
+			keys: []string{"PORT", "MISSING"},
+			want: "unchanged PORT\n",
+		},
+		{
+			name:  "masked",
+			env:   paramMap{"DB_PASSWORD": "new", "TOKEN": "secret", "PORT": "8080"},
+			keys:  []string{"DB_PASSWORD", "TOKEN", "PORT"},
+			masks: []string{"DB_PASSWORD", "TOKEN", "PORT"},
+			want:  "changed   DB_PASSWORD=*** -> ***\nnew       TOKEN=***\nunchanged PORT\n",
+		},
+		{
+			name: "nothing loaded",
+			env:  paramMap{"HOME": "/root"},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writeEnvDiff(&out, tt.env, base, tt.keys, tt.masks)
+			if got := out.String(); got != tt.want {
+				t.Errorf("writeEnvDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffEnvFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DIFF_HOST", Value: "localhost"},
+		{Name: "/prod/web/DIFF_PORT", Value: "5432"},
+		{Name: "/prod/web/DIFF_NEW", Value: "yes"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "DIFF_HOST=localhost", "DIFF_PORT=5432", "DIFF_PASSWORD=old"}
+
+	// The inherited env wins over SSM, so only --set changes anything
+	stdout, stderr, code := runMain(t, params, env, "--diff-env", "--mask", "DIFF_PASSWORD",
+		"--set", "DIFF_HOST=db.internal", "--set", "DIFF_PASSWORD=hunter2")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	want := []string{
+		"changed   DIFF_HOST=localhost -> db.internal",
+		"unchanged DIFF_PORT",
+		"changed   DIFF_PASSWORD=*** -> ***",
+		"new       DIFF_NEW=yes",
+	}
+	for _, line := range want {
+		if !strings.Contains(stdout, line+"\n") {
+			t.Errorf("stdout = %q, want %q", stdout, line)
+		}
+	}
+	if strings.Contains(stdout, "hunter2") || strings.Contains(stdout, "APP_ENV") {
+		t.Errorf("stdout = %q, want only loaded keys and no masked values", stdout)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// envLimits returns the platform's limit on the combined size of a new
+// process's args and env, and on any single KEY=VALUE string (0 when
+// there isn't one). These are the common defaults: Linux allows a quarter
+// of the stack limit (8MB by default) and 128KB per string, macOS a fixed
+// 256KB, and Windows 32767 characters for the whole env block.
+func envLimits() (total int, single int) {
+	switch runtime.GOOS {
+	case "linux":
+		return 2 * 1024 * 1024, 128 * 1024
+	case "windows":
+		return 32767, 0
+	case "darwin":
+		return 256 * 1024, 0
+	}
+	return 256 * 1024, 0
+}
+
+// envSizeWarnRatio is how close to the limit an env has to be for a warning
+const envSizeWarnRatio = 0.9
+
+// checkEnvSize estimates the space the command's args and env take up
+// once passed to exec and returns an error describing the problem when it
+// approaches the platform limit, or any one entry is too large
+func checkEnvSize(args []string, env paramMap) error {
+	total, single := envLimits()
+	pointerSize := strconv.IntSize / 8
+	size := 0
+
+	for _, arg := range args {
+		size += len(arg) + 1 + pointerSize
+	}
+
+	for key, value := range env {
+		entry := len(key) + len(value) + 2
+
+		if single > 0 && entry > single {
+			return fmt.Errorf("%s is %d bytes, over the %d byte limit for a single env var; consider --file-out %s=/path", key, entry, single, key)
+		}
+
+		size += entry + pointerSize
+	}
+
+	if float64(size) >= float64(total)*envSizeWarnRatio {
+		return fmt.Errorf("the env is %d bytes, close to the %d byte limit on %s; consider --file-out for large values", size, total, runtime.GOOS)
+	}
+
+	return nil
+}
+
+// longValues returns those of keys whose value in m is longer than max
+// bytes, in order
+func (m paramMap) longValues(keys []string, max int) []string {
+	var long []string
+	for _, key := range keys {
+		if value, ok := m[key]; ok && len(value) > max {
+			long = append(long, key)
+		}
+	}
+	return long
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckEnvSize(t *testing.T) {
+	total, single := envLimits()
+
+	// Entries comfortably under any single entry limit, adding up to
+	// size bytes
+	env := func(size int) paramMap {
+		m := make(paramMap)
+		for i := 0; size > 0; i++ {
+			n := 1000
+			if n > size {
+				n = size
+			}
+			m[fmt.Sprintf("KEY_%d", i)] = strings.Repeat("x", n)
+			size -= n
+		}
+		return m
+	}
+
+	tests := []struct {
+		name    string
+		env     paramMap
+		wantErr string
+
+		// needsSingle is for the platforms that limit a single entry
+		needsSingle bool
+	}{
+		{name: "small", env: paramMap{"DB_HOST": "db"}},
+		{name: "half the limit", env: env(total / 2)},
+		{name: "close to the limit", env: env(total), wantErr: "close to the"},
+		{
+			name:        "one huge value",
+			env:         paramMap{"TLS_BUNDLE": strings.Repeat("x", single)},
+			wantErr:     "consider --file-out TLS_BUNDLE=/path",
+			needsSingle: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsSingle && single == 0 {
+				t.Skip("no limit on a single entry here")
+			}
+
+			err := checkEnvSize([]string{"app", "--serve"}, tt.env)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLongValues(t *testing.T) {
+	m := paramMap{"SHORT": "12345", "EXACT": "1234567890", "OVER": "12345678901", "JSON": strings.Repeat("{}", 100)}
+
+	tests := []struct {
+		name string
+		keys []string
+		max  int
+		want []string
+	}{
+		{name: "under the limit", keys: []string{"SHORT", "EXACT"}, max: 10},
+		{name: "over the limit", keys: m.SortedKeys(), max: 10, want: []string{"JSON", "OVER"}},
+		{name: "only the keys given", keys: []string{"SHORT", "OVER", "NOT_SET"}, max: 10, want: []string{"OVER"}},
+		{name: "limit above everything", keys: m.SortedKeys(), max: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.longValues(tt.keys, tt.max); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("longValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxValueLengthFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/HOST", Value: "db.internal"},
+		{Name: "/prod/web/URL", Value: "postgres://%%HOST%%/app"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{name: "under", args: []string{"--max-value-length", "40"}},
+		{name: "over once interpolated", args: []string{"--max-value-length", "20"}, stderr: "Warning: values longer than 20 bytes: URL"},
+		{name: "several over", args: []string{"--max-value-length", "5"}, stderr: "Warning: values longer than 5 bytes: HOST, URL"},
+		{name: "failing", args: []string{"--max-value-length", "20", "--fail-long-values"}, code: exitUsage, stderr: "Values longer than 20 bytes: URL"},
+		{name: "no limit", args: []string{"--fail-long-values"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMain(t, params, env, append(tt.args, "--keys-only")...)
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if tt.stderr == "" && stderr != "" {
+				t.Errorf("stderr = %q, want nothing", stderr)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
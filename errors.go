@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Error categories for failures talking to AWS. Errors returned from the
+// fetch functions wrap the underlying AWS error in one of these, so
+// exitCodeFor can pick an exit code with errors.Is while the awserr.Error
+// is still there for errors.As.
+var (
+	errNoCredentials = errors.New("no valid AWS credentials")
+	errAccessDenied  = errors.New("access denied")
+	errThrottled     = errors.New("request throttled")
+	errNotFound      = errors.New("parameter not found")
+	errDecryption    = errors.New("unable to decrypt parameter")
+)
+
+type awsError struct {
+	kind error
+	err  error
+}
+
+func (e *awsError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *awsError) Unwrap() error {
+	return e.err
+}
+
+func (e *awsError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classifyError wraps an AWS error in its error category. Errors that
+// don't fit a category are returned unchanged.
+func classifyError(err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	var kind error
+
+	switch {
+	case isKMSError(err):
+		kind = errDecryption
+	case request.IsErrorThrottle(err):
+		kind = errThrottled
+	case request.IsErrorExpiredCreds(err):
+		kind = errNoCredentials
+	}
+
+	if kind == nil {
+		switch aerr.Code() {
+		case "NoCredentialProviders", "UnrecognizedClientException",
+			"InvalidClientTokenId", "InvalidSignatureException":
+			kind = errNoCredentials
+		case "AccessDeniedException", "AccessDenied":
+			kind = errAccessDenied
+		case ssm.ErrCodeParameterNotFound, ssm.ErrCodeParameterVersionNotFound,
+			secretsmanager.ErrCodeResourceNotFoundException:
+			kind = errNotFound
+		}
+	}
+
+	if kind == nil {
+		return err
+	}
+
+	if kind == errNoCredentials {
+		switch {
+		case isSSOError(err):
+			return &ssoExpiredError{profile: profileName(), err: err}
+		case aerr.Code() == "NoCredentialProviders" && isSSOProfile(profileName()):
+			return &ssoUnsupportedError{profile: profileName(), err: err}
+		}
+	}
+
+	return &awsError{kind: kind, err: err}
+}
+
+// ssoExpiredError is a credentials error reported by a credential_process
+// that logs in with AWS SSO, which almost always means the SSO session has
+// expired
+type ssoExpiredError struct {
+	profile string
+	err     error
+}
+
+func (e *ssoExpiredError) Error() string {
+	return fmt.Sprintf("the AWS SSO session for profile %s has expired or was never started, run `aws sso login --profile %s` and try again (%s)",
+		e.profile, e.profile, e.err)
+}
+
+func (e *ssoExpiredError) Unwrap() error {
+	return e.err
+}
+
+func (e *ssoExpiredError) Is(target error) bool {
+	return target == errNoCredentials
+}
+
+// ssoUnsupportedError is the error for a profile configured for AWS SSO
+// and nothing else. The AWS SDK ssm-loader is built with can't load SSO
+// credentials, so logging in again won't help.
+type ssoUnsupportedError struct {
+	profile string
+	err     error
+}
+
+func (e *ssoUnsupportedError) Error() string {
+	return fmt.Sprintf("profile %s uses AWS SSO, which ssm-loader can't load credentials from. "+
+		"Export them with `aws configure export-credentials --profile %s --format env` or use a credential_process instead (%s)",
+		e.profile, e.profile, e.err)
+}
+
+func (e *ssoUnsupportedError) Unwrap() error {
+	return e.err
+}
+
+func (e *ssoUnsupportedError) Is(target error) bool {
+	return target == errNoCredentials
+}
+
+// isSSOError reports whether err is about an SSO token, as reported by a
+// credential_process that logs in with SSO
+func isSSOError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "sso") && (strings.Contains(msg, "expired") || strings.Contains(msg, "token"))
+}
+
+// isSSOProfile reports whether profile is configured with sso_start_url
+// or sso_session in the shared config file
+func isSSOProfile(profile string) bool {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+		path = filepath.Join(home, ".aws", "config")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	section := "profile " + profile
+	if profile == "default" {
+		section = "default"
+	}
+
+	current := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		if current != section {
+			continue
+		}
+
+		if key := strings.TrimSpace(strings.SplitN(line, "=", 2)[0]); key == "sso_start_url" || key == "sso_session" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isKMSError reports whether err came from SSM failing to decrypt a
+// SecureString with its KMS key
+func isKMSError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch aerr.Code() {
+	case ssm.ErrCodeInvalidKeyId:
+		return true
+	case "AccessDeniedException":
+		return strings.Contains(strings.ToLower(aerr.Message()), "kms")
+	}
+
+	return false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// setenv sets key for the rest of the test
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	old, existed := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if existed {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// awsFailures are the AWS errors each category covers
+var awsFailures = []struct {
+	name string
+	err  error
+	kind error
+}{
+	{name: "no credential providers", err: awserr.New("NoCredentialProviders", "no valid providers in chain", nil), kind: errNoCredentials},
+	{name: "unrecognized client", err: awserr.New("UnrecognizedClientException", "The security token included in the request is invalid", nil), kind: errNoCredentials},
+	{name: "invalid client token", err: awserr.New("InvalidClientTokenId", "The security token included in the request is invalid", nil), kind: errNoCredentials},
+	{name: "bad signature", err: awserr.New("InvalidSignatureException", "The request signature we calculated does not match", nil), kind: errNoCredentials},
+	{name: "expired token", err: awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil), kind: errNoCredentials},
+	{name: "access denied", err: awserr.New("AccessDeniedException", "User is not authorized to perform ssm:GetParametersByPath", nil), kind: errAccessDenied},
+	{name: "throttled", err: awserr.New("ThrottlingException", "Rate exceeded", nil), kind: errThrottled},
+	{name: "too many requests", err: awserr.New("TooManyRequestsException", "Rate exceeded", nil), kind: errThrottled},
+	{name: "parameter not found", err: awserr.New(ssm.ErrCodeParameterNotFound, "", nil), kind: errNotFound},
+	{name: "version not found", err: awserr.New(ssm.ErrCodeParameterVersionNotFound, "", nil), kind: errNotFound},
+	{name: "secret not found", err: awserr.New("ResourceNotFoundException", "Secrets Manager can't find the specified secret", nil), kind: errNotFound},
+	{name: "invalid key", err: awserr.New(ssm.ErrCodeInvalidKeyId, "", nil), kind: errDecryption},
+	{name: "KMS access denied", err: kmsDenied("/prod/app/SECRET"), kind: errDecryption},
+}
+
+// errorKinds are the error categories
+var errorKinds = []error{errNoCredentials, errAccessDenied, errThrottled, errNotFound, errDecryption}
+
+func TestClassifyError(t *testing.T) {
+	setenv(t, "AWS_CONFIG_FILE", os.DevNull)
+
+	for _, tt := range awsFailures {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(tt.err)
+
+			for _, kind := range errorKinds {
+				if got := errors.Is(err, kind); got != (kind == tt.kind) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, kind, got)
+				}
+			}
+
+			// The AWS error is still there for anyone who needs its code
+			var aerr awserr.Error
+			if !errors.As(err, &aerr) || aerr != tt.err {
+				t.Errorf("errors.As(%v) = %v, want %v", err, aerr, tt.err)
+			}
+		})
+	}
+}
+
+func TestClassifyErrorUncategorized(t *testing.T) {
+	for _, err := range []error{
+		awserr.New("InternalServerError", "", nil),
+		errors.New("connection reset by peer"),
+	} {
+		if got := classifyError(err); got != err {
+			t.Errorf("classifyError(%v) = %v, want it unchanged", err, got)
+		}
+	}
+}
+
+// The fetch functions classify what the client returns
+func TestFetchErrorsAreClassified(t *testing.T) {
+	setenv(t, "AWS_CONFIG_FILE", os.DevNull)
+
+	fetches := map[string]func(client *fakeSSM) error{
+		"getParameterValue": func(client *fakeSSM) error {
+			_, err := getParameterValue(client, "/prod/app/A")
+			return err
+		},
+		"getParametersByName": func(client *fakeSSM) error {
+			_, _, err := getParametersByName(client, []string{"/prod/app/A"})
+			return err
+		},
+		"getParameters": func(client *fakeSSM) error {
+			_, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: newFakeClock()})
+			return err
+		},
+	}
+
+	for name, fetch := range fetches {
+		for _, tt := range awsFailures {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				client := newFakeSSM(fakeParam{Name: "/prod/app/A", Value: "a"})
+				client.Err = tt.err
+
+				if err := fetch(client); !errors.Is(err, tt.kind) {
+					t.Errorf("error = %v, want %v", err, tt.kind)
+				}
+			})
+		}
+	}
+}
+
+const ssoConfig = `[default]
+region = us-east-1
+
+[profile dev]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+
+[profile shared]
+sso_session = corp
+sso_account_id = 123456789012
+
+[profile static]
+region = eu-west-1
+# sso_start_url = https://example.awsapps.com/start
+
+[sso-session corp]
+sso_start_url = https://example.awsapps.com/start
+`
+
+func TestIsSSOProfile(t *testing.T) {
+	setenv(t, "AWS_CONFIG_FILE", writeFile(t, tempDir(t), "config", ssoConfig))
+
+	tests := []struct {
+		profile string
+		want    bool
+	}{
+		{"dev", true},
+		{"shared", true},
+		{"static", false},
+		{"default", false},
+		{"missing", false},
+		{"corp", false},
+	}
+
+	for _, tt := range tests {
+		if got := isSSOProfile(tt.profile); got != tt.want {
+			t.Errorf("isSSOProfile(%q) = %t, want %t", tt.profile, got, tt.want)
+		}
+	}
+
+	setenv(t, "AWS_CONFIG_FILE", os.DevNull)
+	if isSSOProfile("dev") {
+		t.Error("isSSOProfile() without a config file = true")
+	}
+}
+
+func TestClassifyErrorSSO(t *testing.T) {
+	setenv(t, "AWS_CONFIG_FILE", writeFile(t, tempDir(t), "config", ssoConfig))
+
+	noProviders := awserr.New("NoCredentialProviders", "no valid providers in chain", nil)
+	processExpired := awserr.New("ExpiredTokenException", "Error loading SSO Token: Token for https://example.awsapps.com/start has expired", nil)
+
+	tests := []struct {
+		name    string
+		profile string
+		err     error
+		want    string
+	}{
+		{name: "expired sso token", profile: "dev", err: processExpired, want: "run `aws sso login --profile dev` and try again"},
+		{name: "sso only profile", profile: "dev", err: noProviders, want: "profile dev uses AWS SSO, which ssm-loader can't load credentials from"},
+		{name: "sso session profile", profile: "shared", err: noProviders, want: "`aws configure export-credentials --profile shared --format env`"},
+		{name: "static profile", profile: "static", err: noProviders, want: ""},
+		{name: "access denied", profile: "dev", err: awserr.New("AccessDeniedException", "not authorized", nil), want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "AWS_PROFILE", tt.profile)
+
+			err := classifyError(tt.err)
+			var ssoExpired *ssoExpiredError
+			var ssoUnsupported *ssoUnsupportedError
+			isSSO := errors.As(err, &ssoExpired) || errors.As(err, &ssoUnsupported)
+
+			if tt.want == "" {
+				if isSSO {
+					t.Errorf("classifyError() = %v, want no SSO advice", err)
+				}
+				return
+			}
+
+			if !isSSO || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("classifyError() = %v, want %q", err, tt.want)
+			}
+			if !errors.Is(err, errNoCredentials) {
+				t.Errorf("errors.Is(%v, errNoCredentials) = false", err)
+			}
+
+			var aerr awserr.Error
+			if !errors.As(err, &aerr) || aerr != tt.err {
+				t.Errorf("errors.As(%v) = %v, want %v", err, aerr, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// execReplace replaces ssm-loader with the command, so the command gets
+// its PID and receives signals directly. It only returns if the exec
+// fails.
+func execReplace(args []string, env paramMap, opts *options) error {
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	if dir := prepareCommand(args, env, opts); dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+	}
+
+	return syscall.Exec(path, args, env.StringArray())
+}
@@ -0,0 +1,94 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExecReplace(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	// Replaced, the command is the process the test started, so its
+	// parent is the test. Run as a child, its parent is ssm-loader.
+	tests := []struct {
+		name     string
+		args     []string
+		replaced bool
+	}{
+		{name: "replaced", args: []string{"--exec-replace", "/bin/sh", "-c", "echo $PPID $DB_HOST"}, replaced: true},
+		{name: "child", args: []string{"/bin/sh", "-c", "echo $PPID $DB_HOST"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, env, tt.args...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+
+			fields := strings.Fields(stdout)
+			if len(fields) != 2 || fields[1] != "prod-db" {
+				t.Fatalf("the command printed %q, want its parent's PID and prod-db", stdout)
+			}
+			if replaced := fields[0] == strconv.Itoa(os.Getpid()); replaced != tt.replaced {
+				t.Errorf("the command's parent is %s, the test is %d: replaced = %v, want %v", fields[0], os.Getpid(), replaced, tt.replaced)
+			}
+		})
+	}
+}
+
+func TestExecReplaceExitCodes(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{name: "command's own exit code", args: []string{"/bin/sh", "-c", "exit 7"}, code: 7},
+		{name: "not found", args: []string{"no-such-command-ssm-loader"}, code: exitCommandNotFound, stderr: "Error replacing ssm-loader with the command"},
+		{name: "not executable", args: []string{os.DevNull}, code: exitCommandNotRun, stderr: "Error replacing ssm-loader with the command"},
+		{name: "with --redact-output", args: []string{"--redact-output", "/bin/sh", "-c", "true"}, code: exitUsage, stderr: "--exec-replace can't be used with --redact-output"},
+		{name: "with --no-stdin", args: []string{"--no-stdin", "/bin/sh", "-c", "true"}, code: exitUsage, stderr: "--exec-replace can't be used with --no-stdin"},
+		{name: "with --refresh-key", args: []string{"--refresh-key", "DB_HOST=1m", "/bin/sh", "-c", "true"}, code: exitUsage, stderr: "--exec-replace can't be used with --refresh-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMain(t, params, env, append([]string{"--exec-replace"}, tt.args...)...)
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
+
+func TestExecReplaceAfterSequence(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/STEP", Value: "ran"}}
+	out := filepath.Join(tempDir(t), "out")
+
+	args := []string{"--exec-replace", "/bin/sh", "-c", `echo migrate "$STEP" >> ` + out, "--then", "/bin/sh", "-c", "echo serve $PPID"}
+	stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, args...)
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	if got := readFile(t, out); got != "migrate ran\n" {
+		t.Errorf("the first command wrote %q, want it run as a child first", got)
+	}
+	if want := "serve " + strconv.Itoa(os.Getpid()) + "\n"; stdout != want {
+		t.Errorf("stdout = %q, want the last command to replace ssm-loader (%q)", stdout, want)
+	}
+}
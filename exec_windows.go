@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// execReplace isn't possible on Windows, which has no exec
+func execReplace(args []string, env paramMap, opts *options) error {
+	return errors.New("--exec-replace is not supported on Windows")
+}
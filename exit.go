@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Exit codes. Anything not listed here is the command's own exit code,
+// passed through unchanged, or 128+n when it was killed by signal n.
+const (
+	exitOK              = 0
+	exitError           = 1
+	exitUsage           = 2
+	exitNoCredentials   = 3
+	exitAccessDenied    = 4
+	exitThrottled       = 5
+	exitNotFound        = 6
+	exitDecryption      = 7
+	exitCommandNotRun   = 126
+	exitCommandNotFound = 127
+	exitSignalBase      = 128
+)
+
+// verbose is set by --verbose to turn on debugf output
+var verbose bool
+
+// debugf logs only when --verbose is set. Anything that isn't a warning or
+// an error goes through here, so a successful run leaves stderr to the
+// command.
+func debugf(format string, v ...interface{}) {
+	if verbose {
+		log.Printf(format+"\n", v...)
+	}
+}
+
+// fatal logs its arguments and exits with code, recording it in the
+// --result-file once the commands have started
+func fatal(code int, v ...interface{}) {
+	log.Println(v...)
+	result.write(code)
+	os.Exit(code)
+}
+
+// codedError is an error that exits with code when it's fatal, for
+// failures that aren't one of the AWS error categories
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// exitCodeFor maps an error category to its exit code
+func exitCodeFor(err error) int {
+	var cerr *codedError
+	if errors.As(err, &cerr) {
+		return cerr.code
+	}
+
+	switch {
+	case errors.Is(err, errNoCredentials):
+		return exitNoCredentials
+	case errors.Is(err, errAccessDenied):
+		return exitAccessDenied
+	case errors.Is(err, errThrottled):
+		return exitThrottled
+	case errors.Is(err, errNotFound):
+		return exitNotFound
+	case errors.Is(err, errDecryption):
+		return exitDecryption
+	}
+	return exitError
+}
+
+// startExitCode is the exit code for a command that couldn't be started
+func startExitCode(err error) int {
+	if errors.Is(err, exec.ErrNotFound) || os.IsNotExist(err) {
+		return exitCommandNotFound
+	}
+	return exitCommandNotRun
+}
+
+// commandExitCode returns the exit code to pass through for a command
+// that finished with err
+func commandExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return exitError
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return exitSignalBase + int(status.Signal())
+	}
+
+	return exitErr.ExitCode()
+}
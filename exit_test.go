@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "no credentials", err: errNoCredentials, want: exitNoCredentials},
+		{name: "access denied", err: errAccessDenied, want: exitAccessDenied},
+		{name: "throttled", err: errThrottled, want: exitThrottled},
+		{name: "not found", err: errNotFound, want: exitNotFound},
+		{name: "decryption", err: &decryptionError{}, want: exitDecryption},
+		{name: "classified", err: classifyError(awserr.New("ThrottlingException", "Rate exceeded", nil)), want: exitThrottled},
+		{name: "wrapped", err: fmt.Errorf("fetching app params: %w", errAccessDenied), want: exitAccessDenied},
+		{name: "coded", err: &codedError{exitUsage, errors.New("too many params")}, want: exitUsage},
+		{name: "coded category", err: &codedError{exitNotFound, errNotFound}, want: exitNotFound},
+		{name: "uncategorized", err: awserr.New("InternalServerError", "", nil), want: exitError},
+		{name: "plain", err: errors.New("connection reset by peer"), want: exitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMainExitCodes(t *testing.T) {
+	tests := []struct {
+		failure string
+		want    int
+	}{
+		{failure: "NoCredentialProviders:no valid providers in chain", want: exitNoCredentials},
+		{failure: "AccessDeniedException:not authorized to perform ssm:GetParametersByPath", want: exitAccessDenied},
+		{failure: "ThrottlingException:Rate exceeded", want: exitThrottled},
+		{failure: "AccessDeniedException:not authorized to perform kms:Decrypt", want: exitDecryption},
+		{failure: "InternalServerError:oops", want: exitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.failure, func(t *testing.T) {
+			_, stderr, code := runMain(t, nil, []string{"APP_ENV=prod", "SSM_LOADER_TEST_ERR=" + tt.failure}, "--keys-only")
+			if code != tt.want {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, tt.want, stderr)
+			}
+		})
+	}
+
+	t.Run("usage", func(t *testing.T) {
+		if _, _, code := runMain(t, nil, nil, "--no-such-flag"); code != exitUsage {
+			t.Errorf("exit code = %d, want %d", code, exitUsage)
+		}
+	})
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		want    int
+	}{
+		{name: "exit status", command: "exit 3", want: 3},
+		{name: "killed", command: "kill -TERM $$", want: exitSignalBase + 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := exec.Command("/bin/sh", "-c", tt.command).Run()
+			if got := commandExitCode(err); got != tt.want {
+				t.Errorf("commandExitCode(%v) = %d, want %d", err, got, tt.want)
+			}
+		})
+	}
+
+	if got := commandExitCode(errors.New("broken pipe")); got != exitError {
+		t.Errorf("commandExitCode of a non-exit error = %d, want %d", got, exitError)
+	}
+}
+
+func TestStartExitCode(t *testing.T) {
+	_, err := exec.LookPath("ssm-loader-no-such-command")
+	if got := startExitCode(err); got != exitCommandNotFound {
+		t.Errorf("startExitCode(%v) = %d, want %d", err, got, exitCommandNotFound)
+	}
+
+	err = exec.Command("./ssm-loader-no-such-command").Start()
+	if got := startExitCode(err); got != exitCommandNotFound {
+		t.Errorf("startExitCode(%v) = %d, want %d", err, got, exitCommandNotFound)
+	}
+
+	if got := startExitCode(errors.New("permission denied")); got != exitCommandNotRun {
+		t.Errorf("startExitCode of another error = %d, want %d", got, exitCommandNotRun)
+	}
+}
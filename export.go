@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var shellName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// Shells Export can write statements for
+const (
+	exportBash       = "bash"
+	exportZsh        = "zsh"
+	exportFish       = "fish"
+	exportPowerShell = "powershell"
+	exportCmd        = "cmd"
+)
+
+var exportFormats = []string{exportBash, exportZsh, exportFish, exportPowerShell, exportCmd}
+
+type exportOptions struct {
+	Format string
+}
+
+// parseExportArgs parses the export command's options. format is the
+// --export-format flag, which --fish overrides.
+func parseExportArgs(args []string, format string) (*exportOptions, error) {
+	eopts := &exportOptions{Format: format}
+
+	for _, arg := range args {
+		switch arg {
+		case "--fish":
+			eopts.Format = exportFish
+		default:
+			return nil, fmt.Errorf("unknown export option %s", arg)
+		}
+	}
+
+	return eopts, nil
+}
+
+// SortedKeys returns the keys of m in order
+func (m paramMap) SortedKeys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Export writes a statement setting each key for the shell in
+// eopts.Format (bash/zsh by default) that can be eval'd or sourced. Keys
+// that aren't valid shell variable names are skipped, since the shell
+// couldn't set them anyway, as are values cmd can't hold.
+func (m paramMap) Export(w io.Writer, eopts *exportOptions) {
+	for _, key := range m.SortedKeys() {
+		if !shellName.MatchString(key) {
+			debugf("Skipping %s: not a valid shell variable name", key)
+			continue
+		}
+
+		value := m[key]
+
+		switch eopts.Format {
+		case exportFish:
+			fmt.Fprintf(w, "set -gx %s %s;\n", key, fishQuote(value))
+		case exportPowerShell:
+			fmt.Fprintf(w, "$env:%s = %s\n", key, psQuote(value))
+		case exportCmd:
+			if strings.ContainsAny(value, "\r\n") {
+				debugf("Skipping %s: cmd can't set a value with a newline", key)
+				continue
+			}
+			fmt.Fprintf(w, "set \"%s=%s\"\n", key, cmdEscape(value))
+		default:
+			fmt.Fprintf(w, "export %s=%s\n", key, shQuote(value))
+		}
+	}
+}
+
+// WriteDotenv writes m as KEY=VALUE lines, sorted, quoting the values
+// that wouldn't read back the same otherwise
+func (m paramMap) WriteDotenv(w io.Writer) {
+	for _, key := range m.SortedKeys() {
+		fmt.Fprintf(w, "%s=%s\n", key, dotenvQuote(m[key]))
+	}
+}
+
+// dotenvEscaper escapes what's special inside a double quoted dotenv
+// value, so it stays on one line
+var dotenvEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, `$`, `\$`)
+
+// dotenvQuote returns s as is when it's plain, so opts=a=b stays
+// readable, and otherwise double quotes it with dotenvEscaper. Leading or
+// trailing spaces, quotes, newlines, $ and # all count as not plain.
+func dotenvQuote(s string) string {
+	if s == strings.TrimSpace(s) && !strings.ContainsAny(s, "\r\n\"'\\#$`") {
+		return s
+	}
+	return `"` + dotenvEscaper.Replace(s) + `"`
+}
+
+// dotenvUnquote undoes dotenvQuote. A value that isn't double quoted is
+// returned unchanged, and an unknown escape keeps its backslash.
+func dotenvUnquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case '\\', '"', '$':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// shQuote single quotes s for POSIX shells. Nothing is special inside
+// single quotes, newlines included, except the quote itself.
+func shQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// fishQuote single quotes s for fish, where backslashes and single
+// quotes are escaped with a backslash
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "'", `\'`, -1)
+	return "'" + s + "'"
+}
+
+// psQuotes are the characters PowerShell ends a single quoted string
+// at: the ASCII quote and the curly ones it treats the same
+var psQuotes = strings.NewReplacer(
+	"'", "''",
+	"\u2018", "\u2018\u2018",
+	"\u2019", "\u2019\u2019",
+	"\u201a", "\u201a\u201a",
+	"\u201b", "\u201b\u201b",
+)
+
+// psQuote single quotes s for PowerShell, where each kind of single
+// quote is escaped by doubling it
+func psQuote(s string) string {
+	return "'" + psQuotes.Replace(s) + "'"
+}
+
+// cmdEscape escapes s for set "KEY=value" in a batch file. The quotes
+// protect &, | and the like, leaving % to be doubled.
+func cmdEscape(s string) string {
+	return strings.Replace(s, "%", "%%", -1)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// shellValues are values that need escaping in one shell or another
+var shellValues = paramMap{
+	"PLAIN":     "value",
+	"SPACES":    "two words  here",
+	"SINGLE":    "it's",
+	"DOUBLE":    `say "hi"`,
+	"NEWLINES":  "line one\nline two\n",
+	"DOLLAR":    "$HOME and $(id) and `id`",
+	"BACKSLASH": `C:\path\`,
+	"EMPTY":     "",
+}
+
+func TestExport(t *testing.T) {
+	m := paramMap{
+		"PLAIN":     "value",
+		"SINGLE":    "it's",
+		"NEWLINE":   "a\nb",
+		"BACKSLASH": `a\'b`,
+		"not-valid": "skipped",
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: exportBash,
+			want: "export BACKSLASH='a\\'\\''b'\n" +
+				"export NEWLINE='a\nb'\n" +
+				"export PLAIN='value'\n" +
+				"export SINGLE='it'\\''s'\n",
+		},
+		{
+			format: exportFish,
+			want: "set -gx BACKSLASH 'a\\\\\\'b';\n" +
+				"set -gx NEWLINE 'a\nb';\n" +
+				"set -gx PLAIN 'value';\n" +
+				"set -gx SINGLE 'it\\'s';\n",
+		},
+		{
+			format: exportZsh,
+			want: "export BACKSLASH='a\\'\\''b'\n" +
+				"export NEWLINE='a\nb'\n" +
+				"export PLAIN='value'\n" +
+				"export SINGLE='it'\\''s'\n",
+		},
+		{
+			format: exportPowerShell,
+			want: "$env:BACKSLASH = 'a\\''b'\n" +
+				"$env:NEWLINE = 'a\nb'\n" +
+				"$env:PLAIN = 'value'\n" +
+				"$env:SINGLE = 'it''s'\n",
+		},
+		{
+			format: exportCmd,
+			want: "set \"BACKSLASH=a\\'b\"\n" +
+				"set \"PLAIN=value\"\n" +
+				"set \"SINGLE=it's\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			m.Export(&buf, &exportOptions{Format: tt.format})
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// The statements set each value exactly when a shell runs them
+func TestExportEvaluated(t *testing.T) {
+	shells := []struct {
+		name   string
+		format string
+		args   []string
+	}{
+		{name: "bash", format: exportBash, args: []string{"-c", `eval "$(cat)"; env -0`}},
+		{name: "sh", format: exportBash, args: []string{"-c", `eval "$(cat)"; env -0`}},
+		{name: "fish", format: exportFish, args: []string{"-c", `cat | source; env -0`}},
+	}
+
+	for _, sh := range shells {
+		t.Run(sh.name, func(t *testing.T) {
+			path, err := exec.LookPath(sh.name)
+			if err != nil {
+				t.Skip(sh.name + " isn't installed")
+			}
+
+			var script bytes.Buffer
+			shellValues.Export(&script, &exportOptions{Format: sh.format})
+
+			cmd := exec.Command(path, sh.args...)
+			cmd.Stdin = &script
+			cmd.Env = []string{}
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(paramMap)
+			for key, value := range parseEnviron(splitNull(out), envDuplicatesLast) {
+				if _, ok := shellValues[key]; ok {
+					got[key] = value
+				}
+			}
+			if !reflect.DeepEqual(got, shellValues) {
+				t.Errorf("got %q, want %q", got, shellValues)
+			}
+		})
+	}
+}
+
+// splitNull splits env -0 output
+func splitNull(b []byte) []string {
+	var list []string
+	for _, entry := range bytes.Split(b, []byte{0}) {
+		if len(entry) > 0 {
+			list = append(list, string(entry))
+		}
+	}
+	return list
+}
+
+func TestExportWindowsQuoting(t *testing.T) {
+	tests := []struct {
+		format string
+		value  string
+		want   string
+	}{
+		{format: exportPowerShell, value: "$HOME and $(id)", want: "$env:V = '$HOME and $(id)'\n"},
+		{format: exportPowerShell, value: "it\u2019s \u2018curly\u2019", want: "$env:V = 'it\u2019\u2019s \u2018\u2018curly\u2019\u2019'\n"},
+		{format: exportPowerShell, value: `say "hi"`, want: "$env:V = 'say \"hi\"'\n"},
+		{format: exportPowerShell, value: "", want: "$env:V = ''\n"},
+		{format: exportCmd, value: "100%", want: "set \"V=100%%\"\n"},
+		{format: exportCmd, value: "a & b | c > d", want: "set \"V=a & b | c > d\"\n"},
+		{format: exportCmd, value: `C:\path\`, want: "set \"V=C:\\path\\\"\n"},
+		{format: exportCmd, value: "", want: "set \"V=\"\n"},
+		{format: exportCmd, value: "line one\r\nline two"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		paramMap{"V": tt.value}.Export(&buf, &exportOptions{Format: tt.format})
+		if buf.String() != tt.want {
+			t.Errorf("%s %q = %q, want %q", tt.format, tt.value, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestParseExportArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{args: nil, want: ""},
+		{args: nil, format: exportPowerShell, want: exportPowerShell},
+		{args: []string{"--fish"}, format: exportBash, want: exportFish},
+		{args: []string{"--csh"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseExportArgs(tt.args, tt.format)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseExportArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+		if err == nil && got.Format != tt.want {
+			t.Errorf("parseExportArgs(%q) = %s, want %s", tt.args, got.Format, tt.want)
+		}
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/GREETING", Value: "it's here"}}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"export"}, want: "export GREETING='it'\\''s here'\n"},
+		{args: []string{"export", "--fish"}, want: "set -gx GREETING 'it\\'s here';\n"},
+		{args: []string{"--export-format", "powershell", "export"}, want: "$env:GREETING = 'it''s here'\n"},
+		{args: []string{"--export-format", "cmd", "-O"}, want: "set \"GREETING=it's here\"\n"},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, tt.args...)
+		if code != exitOK {
+			t.Fatalf("%q: exit code = %d (stderr %q)", tt.args, code, stderr)
+		}
+		if !strings.Contains(stdout, tt.want) {
+			t.Errorf("%q: output %q doesn't contain %q", tt.args, stdout, tt.want)
+		}
+	}
+}
+
+func TestDotenvQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "plain", want: "plain"},
+		{in: "opts=a=b", want: "opts=a=b"},
+		{in: "two words", want: "two words"},
+		{in: "", want: ""},
+		{in: " padded ", want: `" padded "`},
+		{in: "a\nb\r\n", want: `"a\nb\r\n"`},
+		{in: `say "hi"`, want: `"say \"hi\""`},
+		{in: "it's", want: `"it's"`},
+		{in: `C:\path`, want: `"C:\\path"`},
+		{in: "$HOME", want: `"\$HOME"`},
+		{in: "a #comment", want: `"a #comment"`},
+		{in: "`id`", want: "\"`id`\""},
+		{in: `"quoted"`, want: `"\"quoted\""`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := dotenvQuote(tt.in)
+			if got != tt.want {
+				t.Errorf("dotenvQuote(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+			if back := dotenvUnquote(got); back != tt.in {
+				t.Errorf("dotenvUnquote(%s) = %q, want %q", got, back, tt.in)
+			}
+		})
+	}
+}
+
+func TestDotenvUnquote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: `"a\tb"`, want: `a\tb`},
+		{in: `"trailing\"`, want: `trailing\`},
+		{in: `"`, want: `"`},
+		{in: `'single'`, want: `'single'`},
+		{in: `unquoted\n`, want: `unquoted\n`},
+	}
+
+	for _, tt := range tests {
+		if got := dotenvUnquote(tt.in); got != tt.want {
+			t.Errorf("dotenvUnquote(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
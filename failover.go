@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// failoverClient sends requests to the primary region until one fails to
+// reach it, then sends that request and every one after it to the
+// fallback region. Errors from SSM itself, such as access denied, are
+// returned as usual.
+type failoverClient struct {
+	ssmiface.SSMAPI
+
+	fallback       ssmiface.SSMAPI
+	fallbackRegion string
+
+	mu         sync.Mutex
+	failedOver bool
+
+	// fallbackTokens are the NextTokens the fallback region has handed
+	// out, the only ones it will take
+	fallbackTokens map[string]bool
+}
+
+// errPathRestart is returned for the next page of a path started in the
+// primary region once it's failed over, since the fallback can't use the
+// primary's NextToken. The path has to be fetched again from the start.
+var errPathRestart = errors.New("failed over partway through a path")
+
+func newFailoverClient(primary, fallback ssmiface.SSMAPI, fallbackRegion string) *failoverClient {
+	return &failoverClient{SSMAPI: primary, fallback: fallback, fallbackRegion: fallbackRegion}
+}
+
+// isUnreachable reports whether err means the region couldn't be reached
+// at all, rather than SSM rejecting the request
+func isUnreachable(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch aerr.Code() {
+	case "RequestError", request.ErrCodeResponseTimeout, "MissingEndpoint":
+		return true
+	}
+	return false
+}
+
+// do runs call against the current region, failing over and running it
+// again if the primary can't be reached. fallback is whether call is
+// given the fallback region.
+func (c *failoverClient) do(call func(client ssmiface.SSMAPI, fallback bool) error) error {
+	c.mu.Lock()
+	failedOver := c.failedOver
+	c.mu.Unlock()
+
+	if failedOver {
+		return call(c.fallback, true)
+	}
+
+	err := call(c.SSMAPI, false)
+	if err == nil || !isUnreachable(err) {
+		return err
+	}
+
+	c.mu.Lock()
+	if !c.failedOver {
+		log.Printf("Warning: primary region unreachable, failing over to %s: %s\n", c.fallbackRegion, err)
+		c.failedOver = true
+	}
+	c.mu.Unlock()
+
+	return call(c.fallback, true)
+}
+
+func (c *failoverClient) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	var output *ssm.GetParametersByPathOutput
+	err := c.do(func(client ssmiface.SSMAPI, fallback bool) (err error) {
+		if !fallback {
+			output, err = client.GetParametersByPath(input)
+			return err
+		}
+
+		c.mu.Lock()
+		known := input.NextToken == nil || c.fallbackTokens[*input.NextToken]
+		c.mu.Unlock()
+
+		if !known {
+			return errPathRestart
+		}
+
+		output, err = client.GetParametersByPath(input)
+		if err == nil && output.NextToken != nil {
+			c.mu.Lock()
+			if c.fallbackTokens == nil {
+				c.fallbackTokens = make(map[string]bool)
+			}
+			c.fallbackTokens[*output.NextToken] = true
+			c.mu.Unlock()
+		}
+		return err
+	})
+	return output, err
+}
+
+func (c *failoverClient) GetParameters(input *ssm.GetParametersInput) (*ssm.GetParametersOutput, error) {
+	var output *ssm.GetParametersOutput
+	err := c.do(func(client ssmiface.SSMAPI, _ bool) (err error) {
+		output, err = client.GetParameters(input)
+		return err
+	})
+	return output, err
+}
+
+func (c *failoverClient) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	var output *ssm.GetParameterOutput
+	err := c.do(func(client ssmiface.SSMAPI, _ bool) (err error) {
+		output, err = client.GetParameter(input)
+		return err
+	})
+	return output, err
+}
+
+func (c *failoverClient) DescribeParametersPages(input *ssm.DescribeParametersInput, fn func(*ssm.DescribeParametersOutput, bool) bool) error {
+	return c.do(func(client ssmiface.SSMAPI, _ bool) error {
+		return client.DescribeParametersPages(input, fn)
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+func TestIsUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"request error", awserr.New("RequestError", "send request failed", errors.New("dial tcp: no such host")), true},
+		{"response timeout", awserr.New(request.ErrCodeResponseTimeout, "read timeout", nil), true},
+		{"missing endpoint", awserr.New("MissingEndpoint", "'Endpoint' configuration is required", nil), true},
+		{"access denied", awserr.New("AccessDeniedException", "not authorized", nil), false},
+		{"throttled", awserr.New("ThrottlingException", "Rate exceeded", nil), false},
+		{"not found", awserr.New(ssm.ErrCodeParameterNotFound, "", nil), false},
+		{"not an aws error", errors.New("connection reset"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnreachable(tt.err); got != tt.want {
+				t.Errorf("isUnreachable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailoverClient(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/app/A", Value: "a"},
+		{Name: "/prod/app/B", Value: "b"},
+	}
+
+	calls := map[string]func(client ssmiface.SSMAPI) ([]string, error){
+		"GetParametersByPath": func(client ssmiface.SSMAPI) ([]string, error) {
+			fetched, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), PageSize: 1, Clock: newFakeClock()})
+			return names(fetched), err
+		},
+		"GetParameters": func(client ssmiface.SSMAPI) ([]string, error) {
+			fetched, _, err := getParametersByName(client, []string{"/prod/app/A", "/prod/app/B"})
+			return names(fetched), err
+		},
+		"GetParameter": func(client ssmiface.SSMAPI) ([]string, error) {
+			value, err := getParameterValue(client, "/prod/app/A")
+			return []string{value}, err
+		},
+		"DescribeParametersPages": func(client ssmiface.SSMAPI) ([]string, error) {
+			changed, _, err := modifiedSince(client, "/prod/app/", time.Time{}.Add(-time.Hour))
+			return changed, err
+		},
+	}
+
+	want := map[string][]string{
+		"GetParametersByPath":     {"/prod/app/A", "/prod/app/B"},
+		"GetParameters":           {"/prod/app/A", "/prod/app/B"},
+		"GetParameter":            {"a"},
+		"DescribeParametersPages": {"/prod/app/A", "/prod/app/B"},
+	}
+
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			logs := captureLog(t)
+
+			primary := newFakeSSM(params...)
+			primary.Err = awserr.New("RequestError", "send request failed", errors.New("dial tcp: i/o timeout"))
+			fallback := newFakeSSM(params...)
+			client := newFailoverClient(primary, fallback, "us-west-2")
+
+			got, err := call(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want[name]) {
+				t.Errorf("got %q from the fallback, want %q", got, want[name])
+			}
+
+			// Once failed over, the primary isn't tried again
+			call(client)
+			if calls := primary.Calls(name); calls != 1 {
+				t.Errorf("primary got %d %s calls, want 1", calls, name)
+			}
+			if fallback.Calls(name) < 2 {
+				t.Errorf("fallback got %d %s calls, want every one after failing over", fallback.Calls(name), name)
+			}
+
+			if n := strings.Count(logs.String(), "Warning: primary region unreachable, failing over to us-west-2: "); n != 1 {
+				t.Errorf("warned %d times, want once: %q", n, logs.String())
+			}
+		})
+	}
+}
+
+func TestFailoverClientSSMErrors(t *testing.T) {
+	for _, code := range []string{"AccessDeniedException", "ThrottlingException", ssm.ErrCodeParameterNotFound} {
+		t.Run(code, func(t *testing.T) {
+			logs := captureLog(t)
+
+			primary := newFakeSSM(fakeParam{Name: "/prod/app/A", Value: "a"})
+			primary.Err = awserr.New(code, "", nil)
+			fallback := newFakeSSM(fakeParam{Name: "/prod/app/A", Value: "a"})
+
+			if _, err := getParameterValue(newFailoverClient(primary, fallback, "us-west-2"), "/prod/app/A"); err == nil {
+				t.Fatal("expected the primary's error")
+			}
+			if fallback.Calls("GetParameter") != 0 || logs.Len() != 0 {
+				t.Errorf("failed over on %s", code)
+			}
+		})
+	}
+}
+
+func TestFailoverClientHealthy(t *testing.T) {
+	primary := newFakeSSM(fakeParam{Name: "/prod/app/A", Value: "a"})
+	fallback := newFakeSSM(fakeParam{Name: "/prod/app/A", Value: "other"})
+
+	value, err := getParameterValue(newFailoverClient(primary, fallback, "us-west-2"), "/prod/app/A")
+	if err != nil || value != "a" {
+		t.Errorf("got %q, %v, want the primary's value", value, err)
+	}
+	if fallback.Calls("GetParameter") != 0 {
+		t.Error("the fallback was used while the primary was healthy")
+	}
+}
+
+// pathPages wraps a client, failing GetParametersByPath with Err once
+// FailAfter pages have been fetched and recording the NextToken of each
+// call
+type pathPages struct {
+	ssmiface.SSMAPI
+
+	FailAfter int
+	Err       error
+	Tokens    []*string
+}
+
+func (p *pathPages) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	p.Tokens = append(p.Tokens, input.NextToken)
+	if p.Err != nil && len(p.Tokens) > p.FailAfter {
+		return nil, p.Err
+	}
+	return p.SSMAPI.GetParametersByPath(input)
+}
+
+func TestFailoverClientMidPath(t *testing.T) {
+	captureLog(t)
+
+	params := []fakeParam{
+		{Name: "/prod/app/A", Value: "a"},
+		{Name: "/prod/app/B", Value: "b"},
+		{Name: "/prod/app/C", Value: "c"},
+	}
+
+	// The primary goes away after the first page
+	primary := &pathPages{
+		SSMAPI:    newFakeSSM(params...),
+		FailAfter: 1,
+		Err:       awserr.New("RequestError", "send request failed", errors.New("dial tcp: i/o timeout")),
+	}
+	fallback := &pathPages{SSMAPI: newFakeSSM(params...)}
+	client := newFailoverClient(primary, fallback, "us-west-2")
+
+	fetched, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), PageSize: 1, Clock: newFakeClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/prod/app/A", "/prod/app/B", "/prod/app/C"}
+	if got := names(fetched); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetched %q, want %q", got, want)
+	}
+
+	// The fallback starts the path over rather than taking the
+	// primary's token, then follows its own
+	if got := aws.StringValueSlice(fallback.Tokens); !reflect.DeepEqual(got, []string{"", "1", "2"}) {
+		t.Errorf("fallback got tokens %q, want the path from the first page", got)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type fileOut struct {
+	Key  string
+	Path string
+}
+
+func parseFileOut(s string) (fileOut, error) {
+	pair := strings.SplitN(s, "=", 2)
+	if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+		return fileOut{}, fmt.Errorf("invalid --file-out %q, expected KEY=/path", s)
+	}
+	return fileOut{Key: pair[0], Path: pair[1]}, nil
+}
+
+// WriteFiles writes each key's value to its file, readable only by the
+// current user, and removes the key from the env so large or multiline
+// values don't have to go through it
+func (m paramMap) WriteFiles(outs []fileOut) error {
+	for _, out := range outs {
+		value, exists := m[out.Key]
+		if !exists {
+			return fmt.Errorf("--file-out key %s was not loaded", out.Key)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(out.Path), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(out.Path, []byte(value), 0600); err != nil {
+			return err
+		}
+
+		// WriteFile only applies the mode to new files
+		if err := os.Chmod(out.Path, 0600); err != nil {
+			return err
+		}
+	}
+
+	for _, out := range outs {
+		delete(m, out.Key)
+	}
+
+	return nil
+}
+
+// WriteEnvFile writes m to path as export statements that can be sourced
+// by a shell. Concurrent writers are serialized with a lock file, and the
+// file is written to a temp file and renamed into place so readers never
+// see it half written.
+func (m paramMap) WriteEnvFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	m.Export(&buf, &exportOptions{})
+
+	return withFileLock(path, lockTimeout, func() error {
+		return writeFileAtomic(path, buf.Bytes(), 0600)
+	})
+}
+
+// systemdEscaper escapes what's special inside a double quoted systemd
+// EnvironmentFile value. Newlines are kept, as a quoted value may span
+// lines.
+var systemdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+
+// WriteSystemdEnvFile writes m to path as a systemd EnvironmentFile: a
+// KEY="VALUE" line for each key, with no export. Systemd doesn't parse
+// values the way a shell does, so they're quoted by its own rules.
+func (m paramMap) WriteSystemdEnvFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, key := range m.SortedKeys() {
+		if !shellName.MatchString(key) {
+			debugf("Skipping %s: not a valid systemd variable name", key)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s=\"%s\"\n", key, systemdEscaper.Replace(m[key]))
+	}
+
+	return withFileLock(path, lockTimeout, func() error {
+		return writeFileAtomic(path, buf.Bytes(), 0600)
+	})
+}
+
+// writeFileAtomic writes data to a temp file beside path and renames it
+// over path
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
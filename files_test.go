@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseFileOut(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    fileOut
+		wantErr bool
+	}{
+		{in: "TLS_CERT=/run/secrets/tls.crt", want: fileOut{Key: "TLS_CERT", Path: "/run/secrets/tls.crt"}},
+		{in: "KUBECONFIG=kube/config=1", want: fileOut{Key: "KUBECONFIG", Path: "kube/config=1"}},
+		{in: "TLS_CERT", wantErr: true},
+		{in: "=/run/secrets/tls.crt", wantErr: true},
+		{in: "TLS_CERT=", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseFileOut(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := tempDir(t)
+	cert := filepath.Join(dir, "secrets", "tls", "tls.crt")
+	existing := filepath.Join(dir, "kubeconfig")
+
+	// A file that's already there gets its mode tightened too
+	if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := paramMap{
+		"TLS_CERT":   "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+		"KUBECONFIG": "apiVersion: v1",
+		"DB_HOST":    "db",
+	}
+
+	err := m.WriteFiles([]fileOut{{Key: "TLS_CERT", Path: cert}, {Key: "KUBECONFIG", Path: existing}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{cert: "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", existing: "apiVersion: v1"} {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+			t.Errorf("%s mode = %v, want 0600", path, info.Mode().Perm())
+		}
+	}
+
+	// The values only go to the files
+	if len(m) != 1 || m["DB_HOST"] != "db" {
+		t.Errorf("env = %v, want only DB_HOST left", m)
+	}
+}
+
+func TestWriteFilesMissingKey(t *testing.T) {
+	path := filepath.Join(tempDir(t), "tls.crt")
+	m := paramMap{"DB_HOST": "db"}
+
+	err := m.WriteFiles([]fileOut{{Key: "TLS_CERT", Path: path}})
+	if err == nil || err.Error() != "--file-out key TLS_CERT was not loaded" {
+		t.Fatalf("error = %v, want the missing key named", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s was written for a missing key", path)
+	}
+	if m["DB_HOST"] != "db" {
+		t.Errorf("env = %v, want it left alone", m)
+	}
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	path := filepath.Join(tempDir(t), "shm", "app.env")
+	m := paramMap{"DB_HOST": "db", "GREETING": "it's here", "not-valid": "x"}
+
+	if err := m.WriteEnvFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "export DB_HOST='db'\nexport GREETING='it'\\''s here'\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("env file = %q, want %q", got, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestWriteEnvFileConcurrent(t *testing.T) {
+	path := filepath.Join(tempDir(t), "app.env")
+
+	// Each writer's file is large enough to be written in several
+	// pieces, and readers must only ever see one writer's whole file
+	env := func(writer int) paramMap {
+		m := make(paramMap)
+		for i := 0; i < 200; i++ {
+			m[fmt.Sprintf("KEY_%03d", i)] = fmt.Sprintf("writer %d %s", writer, strings.Repeat("x", 200))
+		}
+		return m
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for writer := 0; writer < 10; writer++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			errs <- env(writer).WriteEnvFile(path)
+		}(writer)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkWholeEnvFile(t, string(data))
+	}
+
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkWholeEnvFile(t, readFile(t, path))
+}
+
+// checkWholeEnvFile checks data is all 200 lines of one writer's file
+func checkWholeEnvFile(t *testing.T, data string) {
+	t.Helper()
+
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if len(lines) != 200 {
+		t.Fatalf("env file has %d lines, want 200", len(lines))
+	}
+
+	writer := lines[0][strings.Index(lines[0], "'"):strings.LastIndex(lines[0], " ")]
+	for _, line := range lines {
+		if !strings.Contains(line, writer+" ") {
+			t.Fatalf("env file mixes writers: %q and %q", lines[0], line)
+		}
+	}
+}
+
+func TestWriteSystemdEnvFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "db", `"db"`},
+		{"spaces", "it's here", `"it's here"`},
+		{"double quote", `say "hi"`, `"say \"hi\""`},
+		{"backslash", `C:\app`, `"C:\\app"`},
+		{"dollar", "$HOME/app", `"\$HOME/app"`},
+		{"backtick", "`id`", "\"\\`id\\`\""},
+		{"newline", "line 1\nline 2", "\"line 1\nline 2\""},
+		{"empty", "", `""`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "app.env")
+			if err := (paramMap{"VALUE": tt.value}).WriteSystemdEnvFile(path); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := readFile(t, path), "VALUE="+tt.want+"\n"; got != want {
+				t.Errorf("env file = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteSystemdEnvFileKeys(t *testing.T) {
+	path := filepath.Join(tempDir(t), "systemd", "app.env")
+	m := paramMap{"DB_HOST": "db", "API_KEY": "k", "not-valid": "x", "1ST": "y"}
+
+	if err := m.WriteSystemdEnvFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sorted, with no export and no invalid names
+	want := "API_KEY=\"k\"\nDB_HOST=\"db\"\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("env file = %q, want %q", got, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestSystemdEnvFlag(t *testing.T) {
+	dir := tempDir(t)
+	systemd := filepath.Join(dir, "systemd.env")
+	shell := filepath.Join(dir, "shell.env")
+	params := []fakeParam{{Name: "/prod/web/SD_PASSWORD", Value: `p@ss"$1`}}
+
+	// Without a command, writing the files is all it does
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--systemd-env", systemd, "--write-env", shell)
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	if got := readFile(t, systemd); !strings.Contains(got, "SD_PASSWORD=\"p@ss\\\"\\$1\"\n") || strings.Contains(got, "export ") {
+		t.Errorf("systemd env file = %q", got)
+	}
+	if got := readFile(t, shell); !strings.Contains(got, "export SD_PASSWORD='p@ss\"$1'\n") {
+		t.Errorf("shell env file = %q", got)
+	}
+}
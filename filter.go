@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// filterByType keeps only the parameters of the given type
+func filterByType(params []*ssm.Parameter, paramType string) []*ssm.Parameter {
+	var filtered []*ssm.Parameter
+	for _, param := range params {
+		if aws.StringValue(param.Type) == paramType {
+			filtered = append(filtered, param)
+		}
+	}
+	return filtered
+}
+
+// filterByKeyID keeps only the parameters encrypted with keyID. Parameters
+// fetched by path don't say which key they use, so the names are looked
+// up with DescribeParameters, which can filter on it.
+func filterByKeyID(client ssmiface.SSMAPI, params []*ssm.Parameter, keyID string) ([]*ssm.Parameter, error) {
+	names := make(map[string]bool)
+
+	err := client.DescribeParametersPages(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("KeyId"),
+			Values: aws.StringSlice([]string{keyID}),
+		}},
+	}, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, meta := range page.Parameters {
+			names[aws.StringValue(meta.Name)] = true
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	var filtered []*ssm.Parameter
+	for _, param := range params {
+		if names[aws.StringValue(param.Name)] {
+			filtered = append(filtered, param)
+		}
+	}
+
+	return filtered, nil
+}
+
+// describeNamesMax is the most values a DescribeParameters filter takes
+const describeNamesMax = 50
+
+// describeParameters returns the description of each of params that has
+// one. The path API doesn't return descriptions, so they're looked up by
+// name with DescribeParameters.
+func describeParameters(client ssmiface.SSMAPI, params []*ssm.Parameter) (map[string]string, error) {
+	descriptions := make(map[string]string)
+
+	for start := 0; start < len(params); start += describeNamesMax {
+		end := start + describeNamesMax
+		if end > len(params) {
+			end = len(params)
+		}
+
+		var names []string
+		for _, param := range params[start:end] {
+			names = append(names, aws.StringValue(param.Name))
+		}
+
+		err := client.DescribeParametersPages(&ssm.DescribeParametersInput{
+			ParameterFilters: []*ssm.ParameterStringFilter{{
+				Key:    aws.String("Name"),
+				Values: aws.StringSlice(names),
+			}},
+		}, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+			for _, meta := range page.Parameters {
+				if description := aws.StringValue(meta.Description); description != "" {
+					descriptions[aws.StringValue(meta.Name)] = description
+				}
+			}
+			return true
+		})
+
+		if err != nil {
+			return nil, classifyError(err)
+		}
+	}
+
+	return descriptions, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// filterParams is a path with one parameter of each type, SecureStrings
+// under two keys
+var filterParams = []fakeParam{
+	{Name: "/prod/app/HOST", Value: "db"},
+	{Name: "/prod/app/HOSTS", Value: "a,b", Type: ssm.ParameterTypeStringList},
+	{Name: "/prod/app/PASSWORD", Value: "p", Type: ssm.ParameterTypeSecureString, KeyID: "alias/app"},
+	{Name: "/prod/app/TOKEN", Value: "t", Type: ssm.ParameterTypeSecureString, KeyID: "alias/aws/ssm"},
+}
+
+func TestFilterByType(t *testing.T) {
+	client := newFakeSSM(filterParams...)
+	params, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: newFakeClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		paramType string
+		want      []string
+	}{
+		{paramType: ssm.ParameterTypeSecureString, want: []string{"/prod/app/PASSWORD", "/prod/app/TOKEN"}},
+		{paramType: ssm.ParameterTypeString, want: []string{"/prod/app/HOST"}},
+		{paramType: ssm.ParameterTypeStringList, want: []string{"/prod/app/HOSTS"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.paramType, func(t *testing.T) {
+			if got := names(filterByType(params, tt.paramType)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterByKeyID(t *testing.T) {
+	client := newFakeSSM(filterParams...)
+	params, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: newFakeClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered, err := filterByKeyID(client, params, "alias/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(filtered), []string{"/prod/app/PASSWORD"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFilterTypeFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/HOST", Value: "db"},
+		{Name: "/prod/web/PASSWORD", Value: "p", Type: ssm.ParameterTypeSecureString},
+	}
+
+	stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--filter-type", "SecureString", "--keys-only")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if stdout != "PASSWORD\n" {
+		t.Errorf("keys = %q, want only PASSWORD", stdout)
+	}
+
+	if _, _, code := runMain(t, nil, nil, "--filter-type", "Secret"); code != exitUsage {
+		t.Errorf("unknown type: exit code = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestDescribeParameters(t *testing.T) {
+	var params []fakeParam
+	for i := 0; i < 2*describeNamesMax+5; i++ {
+		params = append(params, fakeParam{Name: fmt.Sprintf("/prod/app/KEY_%03d", i), Value: "v"})
+	}
+	params[0].Description = "the first"
+	params[describeNamesMax].Description = "first of the second batch"
+	params[len(params)-1].Description = "the last"
+
+	tests := []struct {
+		name  string
+		count int
+		want  map[string]string
+		calls int
+	}{
+		{name: "none", count: 0, want: map[string]string{}},
+		{name: "one batch", count: 3, want: map[string]string{"/prod/app/KEY_000": "the first"}, calls: 1},
+		{
+			name:  "several batches",
+			count: len(params),
+			want: map[string]string{
+				"/prod/app/KEY_000": "the first",
+				"/prod/app/KEY_050": "first of the second batch",
+				"/prod/app/KEY_104": "the last",
+			},
+			calls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeSSM(params...)
+			fetched, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: newFakeClock()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			descriptions, err := describeParameters(client, fetched[:tt.count])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(descriptions, tt.want) {
+				t.Errorf("descriptions = %v, want %v", descriptions, tt.want)
+			}
+			if calls := client.Calls("DescribeParametersPages"); calls != tt.calls {
+				t.Errorf("%d DescribeParameters calls, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDescribeParametersError(t *testing.T) {
+	client := newFakeSSM()
+	client.Err = awserr.New("AccessDeniedException", "not authorized to perform ssm:DescribeParameters", nil)
+
+	params := []*ssm.Parameter{{Name: aws.String("/prod/app/HOST"), Value: aws.String("db")}}
+	if _, err := describeParameters(client, params); !errors.Is(err, errAccessDenied) {
+		t.Errorf("describeParameters() error = %v, want errAccessDenied", err)
+	}
+}
+
+func TestTreeDescribe(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DB_HOST", Value: "prod-db", Description: "Primary database"},
+		{Name: "/prod/web/DB_PORT", Value: "5432"},
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"--tree"}, want: "/\n└── prod/\n    └── web/\n        ├── DB_HOST\n        └── DB_PORT\n"},
+		{args: []string{"--tree", "--describe"}, want: "/\n└── prod/\n    └── web/\n        ├── DB_HOST  # Primary database\n        └── DB_PORT\n"},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, tt.args...)
+		if code != exitOK {
+			t.Fatalf("%q: exit code = %d (stderr %q)", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%q: output =\n%s\nwant\n%s", tt.args, stdout, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type findOptions struct {
+	Key      string
+	BasePath string
+	Values   bool
+}
+
+func parseFindArgs(args []string) (*findOptions, error) {
+	fopts := &findOptions{BasePath: "/"}
+
+	var positional []string
+	for _, arg := range args {
+		switch arg {
+		case "--values":
+			fopts.Values = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) == 0 || len(positional) > 2 {
+		return nil, fmt.Errorf("usage: ssm-loader :find KEY [PATH] [--values]")
+	}
+
+	fopts.Key = positional[0]
+	if len(positional) == 2 {
+		fopts.BasePath = normalizePath(positional[1])
+	}
+
+	return fopts, nil
+}
+
+// findKey lists every parameter under fopts.BasePath whose basename is
+// fopts.Key, so it's clear which envs and apps set it. Values are only
+// fetched with --values.
+func findKey(client ssmiface.SSMAPI, w io.Writer, fopts *findOptions) error {
+	input := &ssm.DescribeParametersInput{}
+	if fopts.BasePath != "/" {
+		input.ParameterFilters = []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Path"),
+			Option: aws.String("Recursive"),
+			Values: aws.StringSlice([]string{fopts.BasePath}),
+		}}
+	}
+
+	var found []*ssm.ParameterMetadata
+	err := client.DescribeParametersPages(input, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, meta := range page.Parameters {
+			if path.Base(aws.StringValue(meta.Name)) == fopts.Key {
+				found = append(found, meta)
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return classifyError(err)
+	}
+
+	values := make(map[string]string)
+	if fopts.Values && len(found) > 0 {
+		names := make([]string, len(found))
+		for i, meta := range found {
+			names[i] = aws.StringValue(meta.Name)
+		}
+
+		params, _, err := getParametersByName(client, names)
+		if err != nil {
+			return err
+		}
+
+		for _, param := range params {
+			values[aws.StringValue(param.Name)] = aws.StringValue(param.Value)
+		}
+	}
+
+	for _, meta := range found {
+		name := aws.StringValue(meta.Name)
+
+		value := "***"
+		if fopts.Values {
+			value = values[name]
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, aws.StringValue(meta.Type), value)
+	}
+
+	return nil
+}
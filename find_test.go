@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestParseFindArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    findOptions
+		wantErr bool
+	}{
+		{args: []string{"DATABASE_URL"}, want: findOptions{Key: "DATABASE_URL", BasePath: "/"}},
+		{args: []string{"DATABASE_URL", "/prod"}, want: findOptions{Key: "DATABASE_URL", BasePath: "/prod/"}},
+		{args: []string{"--values", "DATABASE_URL", "/prod/"}, want: findOptions{Key: "DATABASE_URL", BasePath: "/prod/", Values: true}},
+		{args: nil, wantErr: true},
+		{args: []string{"DATABASE_URL", "/prod", "/staging"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		fopts, err := parseFindArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseFindArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+		if err == nil && *fopts != tt.want {
+			t.Errorf("parseFindArgs(%q) = %+v, want %+v", tt.args, *fopts, tt.want)
+		}
+	}
+}
+
+func TestFindKey(t *testing.T) {
+	client := newFakeSSM(
+		fakeParam{Name: "/prod/DATABASE_URL", Value: "postgres://shared"},
+		fakeParam{Name: "/prod/web/DATABASE_URL", Value: "postgres://web", Type: ssm.ParameterTypeSecureString},
+		fakeParam{Name: "/prod/web/DATABASE_URL_RO", Value: "postgres://ro"},
+		fakeParam{Name: "/staging/worker/DATABASE_URL", Value: "postgres://staging"},
+		fakeParam{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+	)
+
+	tests := []struct {
+		name  string
+		fopts findOptions
+		want  string
+	}{
+		{
+			name:  "everywhere",
+			fopts: findOptions{Key: "DATABASE_URL", BasePath: "/"},
+			want:  "/prod/DATABASE_URL\tString\t***\n/prod/web/DATABASE_URL\tSecureString\t***\n/staging/worker/DATABASE_URL\tString\t***\n",
+		},
+		{
+			name:  "under a path",
+			fopts: findOptions{Key: "DATABASE_URL", BasePath: "/prod/"},
+			want:  "/prod/DATABASE_URL\tString\t***\n/prod/web/DATABASE_URL\tSecureString\t***\n",
+		},
+		{
+			name:  "values",
+			fopts: findOptions{Key: "DATABASE_URL", BasePath: "/prod/web/", Values: true},
+			want:  "/prod/web/DATABASE_URL\tSecureString\tpostgres://web\n",
+		},
+		{
+			name:  "not found",
+			fopts: findOptions{Key: "MISSING", BasePath: "/", Values: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := findKey(client, &buf, &tt.fopts); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("findKey() =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+
+	if calls := client.Calls("GetParameters"); calls != 1 {
+		t.Errorf("values were fetched in %d GetParameters calls, want only the one with --values", calls)
+	}
+}
+
+func TestFindKeyError(t *testing.T) {
+	client := newFakeSSM()
+	client.Err = awserr.New("AccessDeniedException", "not authorized to perform ssm:DescribeParameters", nil)
+
+	err := findKey(client, &bytes.Buffer{}, &findOptions{Key: "DATABASE_URL", BasePath: "/"})
+	if err == nil || !strings.Contains(err.Error(), "ssm:DescribeParameters") {
+		t.Fatalf("findKey() error = %v, want the access denied error", err)
+	}
+	if !errors.Is(err, errAccessDenied) {
+		t.Errorf("findKey() error = %v, want it classified as errAccessDenied", err)
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DATABASE_URL", Value: "postgres://web", Type: ssm.ParameterTypeSecureString},
+		{Name: "/staging/web/DATABASE_URL", Value: "postgres://staging"},
+	}
+
+	stdout, stderr, code := runMain(t, params, nil, ":find", "DATABASE_URL", "/staging")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if want := "/staging/web/DATABASE_URL\tString\t***\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// fipsServices are the services ssm-loader calls, which must all go
+// through FIPS endpoints when --fips is set. The value is whether the
+// service's GovCloud endpoints need the FIPS variant as well.
+var fipsServices = map[string]bool{
+	"ssm":            false,
+	"sts":            false,
+	"kms":            false,
+	"secretsmanager": true,
+	"s3":             true,
+}
+
+// fipsResolver rewrites the endpoints of fipsServices to their FIPS
+// variants, <service>-fips.<region>. In GovCloud the regular endpoints
+// are already FIPS validated, except for Secrets Manager and S3. That
+// holds for STS too, which otherwise resolves to the global
+// sts.amazonaws.com that has no FIPS variant.
+type fipsResolver struct {
+	Resolver endpoints.Resolver
+}
+
+func (r *fipsResolver) EndpointFor(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	resolved, err := r.Resolver.EndpointFor(service, region, opts...)
+	govFIPS, ok := fipsServices[service]
+	if err != nil || !ok {
+		return resolved, err
+	}
+
+	partition, _ := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if partition.ID() == "aws" || (partition.ID() == "aws-us-gov" && govFIPS) {
+		resolved.URL = fmt.Sprintf("https://%s-fips.%s.amazonaws.com", service, region)
+		resolved.SigningRegion = region
+	}
+
+	return resolved, nil
+}
+
+// checkFIPSRegion fails for regions whose partition has no FIPS endpoints.
+// Clients swallow resolver errors, so this has to be checked up front.
+func checkFIPSRegion(region string) error {
+	partition, _ := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+
+	switch partition.ID() {
+	case "aws", "aws-us-gov":
+		return nil
+	}
+
+	return fmt.Errorf("no FIPS endpoints in region %s", region)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+func TestSessionFIPS(t *testing.T) {
+	isolateAWSConfig(t)
+
+	tests := []struct {
+		name      string
+		opts      options
+		env       string
+		endpoints map[string]string
+		wantErr   string
+	}{
+		{
+			name: "flag",
+			opts: options{Region: "us-east-1", FIPS: true},
+			endpoints: map[string]string{
+				"ssm":            "https://ssm-fips.us-east-1.amazonaws.com",
+				"sts":            "https://sts-fips.us-east-1.amazonaws.com",
+				"kms":            "https://kms-fips.us-east-1.amazonaws.com",
+				"secretsmanager": "https://secretsmanager-fips.us-east-1.amazonaws.com",
+				"s3":             "https://s3-fips.us-east-1.amazonaws.com",
+			},
+		},
+		{
+			name: "env",
+			opts: options{Region: "us-west-2"},
+			env:  "TRUE",
+			endpoints: map[string]string{
+				"ssm": "https://ssm-fips.us-west-2.amazonaws.com",
+			},
+		},
+		{
+			name: "gov region",
+			opts: options{Region: "us-gov-west-1", FIPS: true},
+			endpoints: map[string]string{
+				"ssm":            "https://ssm.us-gov-west-1.amazonaws.com",
+				"sts":            "https://sts.us-gov-west-1.amazonaws.com",
+				"kms":            "https://kms.us-gov-west-1.amazonaws.com",
+				"secretsmanager": "https://secretsmanager-fips.us-gov-west-1.amazonaws.com",
+				"s3":             "https://s3-fips.us-gov-west-1.amazonaws.com",
+			},
+		},
+		{
+			name: "gov partition",
+			opts: options{Region: "us-gov-east-1", Partition: "aws-us-gov", FIPS: true},
+			endpoints: map[string]string{
+				"ssm": "https://ssm.us-gov-east-1.amazonaws.com",
+			},
+		},
+		{
+			name:    "china region",
+			opts:    options{Region: "cn-north-1", FIPS: true},
+			wantErr: "no FIPS endpoints in region cn-north-1",
+		},
+		{
+			name: "off",
+			opts: options{Region: "us-east-1"},
+			env:  "false",
+			endpoints: map[string]string{
+				"ssm":            "https://ssm.us-east-1.amazonaws.com",
+				"secretsmanager": "https://secretsmanager.us-east-1.amazonaws.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "AWS_USE_FIPS_ENDPOINT", tt.env)
+
+			sess, err := newSession(&tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newSession() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, fips := sess.Config.EndpointResolver.(*fipsResolver)
+			if want := tt.env != "false"; fips != want {
+				t.Errorf("FIPS resolver set = %v, want %v", fips, want)
+			}
+			for service, want := range tt.endpoints {
+				config := sess.ClientConfig(service)
+				if config.Endpoint != want {
+					t.Errorf("%s endpoint = %q, want %q", service, config.Endpoint, want)
+				}
+				if config.SigningRegion != tt.opts.Region {
+					t.Errorf("%s signing region = %q, want %q", service, config.SigningRegion, tt.opts.Region)
+				}
+			}
+		})
+	}
+}
+
+func TestFIPSClients(t *testing.T) {
+	isolateAWSConfig(t)
+
+	sess, err := newSession(&options{Region: "us-east-1", FIPS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests built by each client go to the FIPS host, the bucket
+	// still in front for S3
+	tests := []struct {
+		service string
+		req     *request.Request
+		want    string
+	}{
+		{
+			service: "secretsmanager",
+			req: func() *request.Request {
+				req, _ := secretsmanager.New(sess).GetSecretValueRequest(&secretsmanager.GetSecretValueInput{SecretId: aws.String("prod/db")})
+				return req
+			}(),
+			want: "secretsmanager-fips.us-east-1.amazonaws.com",
+		},
+		{
+			service: "s3",
+			req: func() *request.Request {
+				req, _ := s3.New(sess).GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String("config"), Key: aws.String("web.json")})
+				return req
+			}(),
+			want: "config.s3-fips.us-east-1.amazonaws.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			if err := tt.req.Build(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.req.HTTPRequest.URL.Host; got != tt.want {
+				t.Errorf("host = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// isPathGlob reports whether p has any path.Match wildcards in it
+func isPathGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// expandPathGlob returns the paths matching pattern that have parameters
+// directly under them, in order. SSM can't glob, so every parameter under
+// the part of pattern before the first wildcard is listed with
+// DescribeParameters, which takes a call per 50 parameters on top of
+// fetching the matches.
+func expandPathGlob(client ssmiface.SSMAPI, pattern string) ([]string, error) {
+	pattern = strings.TrimSuffix(pattern, "/")
+	prefix := pattern[:strings.IndexAny(pattern, "*?[")]
+
+	dirs := make(map[string]bool)
+
+	err := client.DescribeParametersPages(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Name"),
+			Option: aws.String("BeginsWith"),
+			Values: aws.StringSlice([]string{prefix}),
+		}},
+	}, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, meta := range page.Parameters {
+			dir := path.Dir(aws.StringValue(meta.Name))
+			if ok, _ := path.Match(pattern, dir); ok {
+				dirs[dir+"/"] = true
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	paths := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		paths = append(paths, dir)
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
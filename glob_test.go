@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestIsPathGlob(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/prod/web/", want: false},
+		{path: "/prod/*/common/", want: true},
+		{path: "/prod/web-?/", want: true},
+		{path: "/prod/[ab]*/", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := isPathGlob(tt.path); got != tt.want {
+			t.Errorf("isPathGlob(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExpandPathGlob(t *testing.T) {
+	client := newFakeSSM(
+		fakeParam{Name: "/prod/web/common/LOG_LEVEL", Value: "info"},
+		fakeParam{Name: "/prod/web/common/SENTRY_DSN", Value: "https://sentry"},
+		fakeParam{Name: "/prod/worker/common/QUEUE", Value: "jobs"},
+		fakeParam{Name: "/prod/web/other/HOST", Value: "no"},
+		fakeParam{Name: "/prod/web/common/nested/DEEP", Value: "no"},
+		fakeParam{Name: "/staging/web/common/LOG_LEVEL", Value: "no"},
+		fakeParam{Name: "/prod/api-1/HOST", Value: "a"},
+		fakeParam{Name: "/prod/api-2/HOST", Value: "b"},
+		fakeParam{Name: "/prod/api-10/HOST", Value: "c"},
+	)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "/prod/*/common/", want: []string{"/prod/web/common/", "/prod/worker/common/"}},
+		{pattern: "/prod/*/common", want: []string{"/prod/web/common/", "/prod/worker/common/"}},
+		{pattern: "/*/web/common/", want: []string{"/prod/web/common/", "/staging/web/common/"}},
+		{pattern: "/prod/api-?/", want: []string{"/prod/api-1/", "/prod/api-2/"}},
+		{pattern: "/prod/api-[2-9]*/", want: []string{"/prod/api-2/"}},
+		{pattern: "/prod/*/missing/", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := expandPathGlob(client, tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandPathGlob(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPathGlobError(t *testing.T) {
+	client := newFakeSSM()
+	client.Err = awserr.New("AccessDeniedException", "not authorized to perform ssm:DescribeParameters", nil)
+
+	if _, err := expandPathGlob(client, "/prod/*/common/"); !errors.Is(err, errAccessDenied) {
+		t.Errorf("expandPathGlob() error = %v, want errAccessDenied", err)
+	}
+}
+
+func TestPathGlobFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/common/G_LOG_LEVEL", Value: "info"},
+		{Name: "/prod/worker/common/G_QUEUE", Value: "jobs"},
+		{Name: "/prod/web/other/G_HOST", Value: "no"},
+	}
+
+	stdout, stderr, code := runMain(t, params, nil, "--path", "/prod/*/common/", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if got, want := envLines(stdout, "G_"), "G_LOG_LEVEL=info\nG_QUEUE=jobs\n"; got != want {
+		t.Errorf("env = %q, want both subtrees and nothing else: %q", got, want)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// incremental is set by --since. Paths with a base only have the params
+// modified since then fetched, merged over the base.
+var incremental *incrementalFetch
+
+// incrementalFetch tracks the base for each path and what's fetched over
+// it, so each round of fetching is the base for the next
+type incrementalFetch struct {
+	Since   time.Time
+	Base    map[string][]*ssm.Parameter
+	Fetched map[string][]*ssm.Parameter
+
+	started time.Time
+}
+
+func newIncrementalFetch(since time.Time) *incrementalFetch {
+	return &incrementalFetch{
+		Since:   since,
+		Base:    make(map[string][]*ssm.Parameter),
+		Fetched: make(map[string][]*ssm.Parameter),
+	}
+}
+
+// Start begins a round of fetching. After the first, the previous round
+// is the base and only changes since it started are fetched.
+func (f *incrementalFetch) Start() {
+	if !f.started.IsZero() {
+		f.Base = f.Fetched
+		f.Since = f.started
+	}
+
+	f.Fetched = make(map[string][]*ssm.Parameter)
+	f.started = time.Now()
+}
+
+// Record keeps the params fetched under path as its next base. They're
+// copied, as the caller goes on to interpolate them in place and the base
+// has to keep the values as they are in SSM.
+func (f *incrementalFetch) Record(path string, params []*ssm.Parameter) {
+	copied := make([]*ssm.Parameter, len(params))
+	for i, param := range params {
+		p := *param
+		copied[i] = &p
+	}
+	f.Fetched[path] = copied
+}
+
+// Fetch returns the params under path by fetching the ones modified
+// since f.Since over its base. It's false when there's no base, or the
+// changes couldn't be listed, and path should be fetched in full.
+func (f *incrementalFetch) Fetch(client ssmiface.SSMAPI, path string) ([]*ssm.Parameter, bool) {
+	base, ok := f.Base[path]
+	if !ok {
+		return nil, false
+	}
+
+	names, current, err := modifiedSince(client, path, f.Since)
+	if err != nil {
+		log.Printf("Warning: couldn't list the params changed under %s, fetching them all: %s\n", path, err)
+		return nil, false
+	}
+
+	changed, _, err := getParametersByName(client, names)
+	if err != nil {
+		log.Printf("Warning: couldn't fetch the params changed under %s, fetching them all: %s\n", path, err)
+		return nil, false
+	}
+
+	debugf("Fetched %d params changed under %s since %s", len(changed), path, f.Since.Format(time.RFC3339))
+
+	params := mergeParams(base, changed, current)
+	f.Record(path, params)
+	return params, true
+}
+
+// modifiedSince lists the names of the params directly under path that
+// were modified after since, along with the names of all of them, so
+// ones deleted since can be told apart. Paths are fetched one level deep,
+// so params further down (another app's under the shared path) are left
+// out.
+func modifiedSince(client ssmiface.SSMAPI, path string, since time.Time) (changed, current []string, err error) {
+
+	filterPath := strings.TrimSuffix(path, "/")
+	if filterPath == "" {
+		filterPath = "/"
+	}
+
+	err = client.DescribeParametersPages(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Path"),
+			Option: aws.String("OneLevel"),
+			Values: aws.StringSlice([]string{filterPath}),
+		}},
+	}, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, meta := range page.Parameters {
+			current = append(current, aws.StringValue(meta.Name))
+			if aws.TimeValue(meta.LastModifiedDate).After(since) {
+				changed = append(changed, aws.StringValue(meta.Name))
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, nil, classifyError(err)
+	}
+
+	return changed, current, nil
+}
+
+// mergeParams replaces the params in base with the changed ones of the
+// same name and adds the rest, sorted by name like a path fetch. Params
+// in base that aren't among the current names have been deleted, and are
+// dropped.
+func mergeParams(base, changed []*ssm.Parameter, current []string) []*ssm.Parameter {
+	exists := make(map[string]bool, len(current))
+	for _, name := range current {
+		exists[name] = true
+	}
+
+	byName := make(map[string]*ssm.Parameter, len(base)+len(changed))
+	for _, param := range base {
+		if name := aws.StringValue(param.Name); exists[name] {
+			byName[name] = param
+		} else {
+			debugf("%s was deleted, dropping it", name)
+		}
+	}
+	for _, param := range changed {
+		byName[aws.StringValue(param.Name)] = param
+	}
+
+	merged := make([]*ssm.Parameter, 0, len(byName))
+	for _, param := range byName {
+		merged = append(merged, param)
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return aws.StringValue(merged[i].Name) < aws.StringValue(merged[j].Name)
+	})
+	return merged
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+var (
+	sinceTime = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	before    = sinceTime.Add(-time.Hour)
+	after     = sinceTime.Add(time.Hour)
+)
+
+func TestModifiedSince(t *testing.T) {
+	fake := newFakeSSM(
+		fakeParam{Name: "/prod/app/OLD", Value: "a", Modified: before},
+		fakeParam{Name: "/prod/app/AT", Value: "b", Modified: sinceTime},
+		fakeParam{Name: "/prod/app/NEW", Value: "c", Modified: after},
+		fakeParam{Name: "/prod/app/nested/NEW", Value: "d", Modified: after},
+		fakeParam{Name: "/prod/other/NEW", Value: "e", Modified: after},
+	)
+
+	changed, current, err := modifiedSince(fake, "/prod/app/", sinceTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One level deep, like the path fetch it stands in for
+	if want := []string{"/prod/app/NEW"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("modifiedSince() changed = %q, want %q", changed, want)
+	}
+	if want := []string{"/prod/app/AT", "/prod/app/NEW", "/prod/app/OLD"}; !reflect.DeepEqual(current, want) {
+		t.Errorf("modifiedSince() current = %q, want %q", current, want)
+	}
+}
+
+func TestMergeParams(t *testing.T) {
+	param := func(name, value string) *ssm.Parameter {
+		return &ssm.Parameter{Name: aws.String(name), Value: aws.String(value)}
+	}
+
+	base := []*ssm.Parameter{param("/p/A", "a"), param("/p/C", "c"), param("/p/D", "deleted")}
+	changed := []*ssm.Parameter{param("/p/C", "c2"), param("/p/B", "b")}
+	current := []string{"/p/A", "/p/B", "/p/C"}
+
+	got := make(map[string]string)
+	for _, p := range mergeParams(base, changed, current) {
+		got[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+	}
+
+	if want := map[string]string{"/p/A": "a", "/p/B": "b", "/p/C": "c2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged = %v, want %v", got, want)
+	}
+	if got := names(mergeParams(base, changed, current)); !reflect.DeepEqual(got, []string{"/p/A", "/p/B", "/p/C"}) {
+		t.Errorf("names = %q, want them sorted", got)
+	}
+}
+
+func TestIncrementalFetch(t *testing.T) {
+	base := []*ssm.Parameter{
+		{Name: aws.String("/prod/app/OLD"), Value: aws.String("cached")},
+		{Name: aws.String("/prod/app/NEW"), Value: aws.String("cached")},
+		{Name: aws.String("/prod/app/GONE"), Value: aws.String("cached")},
+	}
+	params := []fakeParam{
+		{Name: "/prod/app/OLD", Value: "current", Modified: before},
+		{Name: "/prod/app/NEW", Value: "current", Modified: after},
+		{Name: "/prod/app/ADDED", Value: "current", Modified: after},
+	}
+
+	tests := []struct {
+		name     string
+		base     map[string][]*ssm.Parameter
+		err      error
+		want     map[string]string
+		wantWarn string
+	}{
+		{
+			name: "changes over the base, without deleted params",
+			base: map[string][]*ssm.Parameter{"/prod/app/": base},
+			want: map[string]string{"/prod/app/OLD": "cached", "/prod/app/NEW": "current", "/prod/app/ADDED": "current"},
+		},
+		{
+			name: "no base",
+			base: map[string][]*ssm.Parameter{"/prod/other/": base},
+		},
+		{
+			name:     "listing fails",
+			base:     map[string][]*ssm.Parameter{"/prod/app/": base},
+			err:      errors.New("boom"),
+			wantWarn: "Warning: couldn't list the params changed under /prod/app/, fetching them all: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+
+			fake := newFakeSSM(params...)
+			fake.Err = tt.err
+
+			f := newIncrementalFetch(sinceTime)
+			f.Base = tt.base
+
+			fetched, ok := f.Fetch(fake, "/prod/app/")
+			if ok != (tt.want != nil) {
+				t.Fatalf("Fetch() ok = %t, want %t", ok, tt.want != nil)
+			}
+			if !strings.Contains(logs.String(), tt.wantWarn) {
+				t.Errorf("log = %q, want %q", logs.String(), tt.wantWarn)
+			}
+			if !ok {
+				return
+			}
+
+			got := make(map[string]string)
+			for _, p := range fetched {
+				got[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("params = %v, want %v", got, tt.want)
+			}
+			if fake.Calls("GetParametersByPath") != 0 {
+				t.Error("the whole path was fetched")
+			}
+			if !reflect.DeepEqual(f.Fetched["/prod/app/"], fetched) {
+				t.Error("the merged params weren't recorded as the next base")
+			}
+		})
+	}
+}
+
+func TestIncrementalRecordCopies(t *testing.T) {
+	f := newIncrementalFetch(sinceTime)
+	params := []*ssm.Parameter{
+		{Name: aws.String("/prod/app/HOST"), Value: aws.String("db")},
+		{Name: aws.String("/prod/app/URL"), Value: aws.String("postgres://%%HOST%%")},
+	}
+	f.Record("/prod/app/", params)
+
+	// Interpolating the layer in place, as --interpolate-layers does,
+	// leaves the base with the value as it is in SSM, so a change to HOST
+	// still reaches URL next time
+	po := &paramOptions{}
+	po.InterpolateWithin(params)
+	if got := aws.StringValue(params[1].Value); got != "postgres://db" {
+		t.Fatalf("URL = %q, want it interpolated", got)
+	}
+	if got := aws.StringValue(f.Fetched["/prod/app/"][1].Value); got != "postgres://%%HOST%%" {
+		t.Errorf("recorded URL = %q, want the uninterpolated value", got)
+	}
+}
+
+func TestIncrementalStart(t *testing.T) {
+	f := newIncrementalFetch(sinceTime)
+	f.Base["/p/"] = nil
+
+	// The first round keeps --since and the base it was given
+	f.Start()
+	if !f.Since.Equal(sinceTime) || len(f.Base) != 1 {
+		t.Errorf("first round since %s with %d bases", f.Since, len(f.Base))
+	}
+
+	fetched := []*ssm.Parameter{{Name: aws.String("/q/A")}}
+	f.Record("/q/", fetched)
+	started := time.Now()
+
+	// Each later round builds on the one before
+	f.Start()
+	if f.Since.After(started) || started.Sub(f.Since) > time.Minute {
+		t.Errorf("second round since %s, want when the first round started", f.Since)
+	}
+	if !reflect.DeepEqual(f.Base, map[string][]*ssm.Parameter{"/q/": fetched}) || len(f.Fetched) != 0 {
+		t.Errorf("second round base %v, fetched %v", f.Base, f.Fetched)
+	}
+}
+
+func TestSinceFlag(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+
+	first := []fakeParam{
+		{Name: "/prod/web/I_OLD", Value: "v1", Modified: before},
+		{Name: "/prod/web/I_NEW", Value: "v1", Modified: before},
+	}
+	since := sinceTime.Format(time.RFC3339)
+
+	// Without a base the first run fetches everything, caching each path
+	if _, stderr, code := runMain(t, first, env, "--cache-file", path, "--since", since, "-O"); code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	// Only I_NEW changed since, so I_OLD comes from the stale cache
+	second := []fakeParam{
+		{Name: "/prod/web/I_OLD", Value: "v2", Modified: before},
+		{Name: "/prod/web/I_NEW", Value: "v2", Modified: after},
+	}
+	stdout, stderr, code := runMain(t, second, env, "--cache-file", path, "--cache-ttl", "1ns", "--since", since, "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	if got, want := envLines(stdout, "I_"), "I_NEW=v2\nI_OLD=v1\n"; got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+	if strings.Contains(stderr, "fake: GetParametersByPath") || strings.Count(stderr, "fake: GetParameters\n") != 1 {
+		t.Errorf("stderr = %q, want only the changed params fetched", stderr)
+	}
+
+	if _, _, code := runMain(t, nil, env, "--since", "yesterday", "-O"); code != exitUsage {
+		t.Errorf("exit code = %d for an invalid --since, want %d", code, exitUsage)
+	}
+}
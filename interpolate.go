@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+)
+
+// interpolationFuncs are the functions a reference can pipe its value
+// through, as in %%KEY|trim|upper%%
+var interpolationFuncs = map[string]func(string) string{
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+	"trim":   strings.TrimSpace,
+	"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+}
+
+// interpolationRef is a parsed %%KEY:-default|func|func%% reference
+type interpolationRef struct {
+	Name     string
+	Fallback string
+	Funcs    []string
+}
+
+// parseRef parses the text between the %% of a reference
+func parseRef(s string) interpolationRef {
+	parts := strings.Split(s, "|")
+	ref := interpolationRef{Name: parts[0], Funcs: parts[1:]}
+
+	if i := strings.Index(ref.Name, ":-"); i != -1 {
+		ref.Name, ref.Fallback = ref.Name[:i], ref.Name[i+2:]
+	}
+
+	return ref
+}
+
+// Apply pipes value through the reference's functions in order. Unknown
+// functions are skipped; UnknownFuncs reports them.
+func (r interpolationRef) Apply(value string) string {
+	for _, name := range r.Funcs {
+		if fn, ok := interpolationFuncs[name]; ok {
+			value = fn(value)
+		}
+	}
+	return value
+}
+
+// UnknownFuncs returns the functions referenced in the values of m that
+// don't exist, in order
+func (m paramMap) UnknownFuncs() []string {
+	seen := make(map[string]bool)
+	var unknown []string
+
+	for _, value := range m {
+		for _, match := range paramInterpolation.FindAllStringSubmatch(value, -1) {
+			for _, name := range parseRef(match[1]).Funcs {
+				if _, ok := interpolationFuncs[name]; !ok && !seen[name] {
+					seen[name] = true
+					unknown = append(unknown, name)
+				}
+			}
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
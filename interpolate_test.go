@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interpolationRef
+	}{
+		{"DB_HOST", interpolationRef{Name: "DB_HOST", Funcs: []string{}}},
+		{"DB_HOST:-localhost", interpolationRef{Name: "DB_HOST", Fallback: "localhost", Funcs: []string{}}},
+		{"DB_HOST|upper", interpolationRef{Name: "DB_HOST", Funcs: []string{"upper"}}},
+		{"DB_HOST:- local |trim|upper", interpolationRef{Name: "DB_HOST", Fallback: " local ", Funcs: []string{"trim", "upper"}}},
+		{"/prod/common/REGION|lower", interpolationRef{Name: "/prod/common/REGION", Funcs: []string{"lower"}}},
+		{"URL:-http://a:-b", interpolationRef{Name: "URL", Fallback: "http://a:-b", Funcs: []string{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := parseRef(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolationFuncs(t *testing.T) {
+	layer := paramMap{"NAME": "  Web App  ", "ENV": "prod", "USER": "app:secret"}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"%%ENV|upper%%", "PROD"},
+		{"%%NAME|lower%%", "  web app  "},
+		{"%%NAME|trim%%", "Web App"},
+		{"%%NAME|trim|upper%%", "WEB APP"},
+		{"%%USER|base64%%", "YXBwOnNlY3JldA=="},
+		{"Basic %%USER|base64%%", "Basic YXBwOnNlY3JldA=="},
+		{"%%MISSING:-dev|upper%%", "DEV"},
+		{"%%MISSING|upper%%", ""},
+		{"%%ENV|nosuchfunc|upper%%", "PROD"},
+		{"%%ENV%%", "prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			m := paramMap{"KEY": tt.in}
+			m.ReplaceInterpolations(layer)
+			if m["KEY"] != tt.want {
+				t.Errorf("%s = %q, want %q", tt.in, m["KEY"], tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolatePartialFuncs(t *testing.T) {
+	// Funcs apply to what one layer resolves, and the rest is left whole
+	got := interpolatePartial("%%A|upper%% %%B:-b|upper%%", paramMap{"A": "a"})
+	if got != "A %%B:-b|upper%%" {
+		t.Errorf("interpolatePartial() = %q", got)
+	}
+}
+
+func TestUnknownFuncs(t *testing.T) {
+	m := paramMap{
+		"A": "%%X|upper|rot13%%",
+		"B": "%%Y|rot13%% %%Z|reverse%%",
+		"C": "%%Z|trim|lower|base64%%",
+		"D": "no references",
+	}
+
+	if got := m.UnknownFuncs(); !reflect.DeepEqual(got, []string{"reverse", "rot13"}) {
+		t.Errorf("UnknownFuncs() = %q, want reverse and rot13 once each", got)
+	}
+	if got := (paramMap{"A": "%%X|upper%%"}).UnknownFuncs(); got != nil {
+		t.Errorf("UnknownFuncs() = %q, want none", got)
+	}
+}
+
+func TestInterpolationFuncsFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/IF_ENV", Value: "%%APP_ENV|upper%%"},
+		{Name: "/prod/web/IF_ODD", Value: "%%APP_NAME|rot13%%"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--set", "APP_ENV=prod", "--set", "APP_NAME=web", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if got := envLines(stdout, "IF_"); got != "IF_ENV=PROD\nIF_ODD=web\n" {
+		t.Errorf("env = %q", got)
+	}
+	if !strings.Contains(stderr, "Warning: unknown interpolation functions are ignored:  rot13") {
+		t.Errorf("stderr = %q, want a warning", stderr)
+	}
+
+	_, stderr, code = runMain(t, params, env, "--set", "APP_NAME=web", "--strict", "-O")
+	if code != exitUsage {
+		t.Errorf("exit code = %d with --strict, want %d (stderr %q)", code, exitUsage, stderr)
+	}
+	if !strings.Contains(stderr, "Unknown interpolation functions:  rot13") {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+)
+
+var (
+	// k8sName is a DNS subdomain, which is what a Secret's name has to be
+	k8sName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+	// k8sKeyInvalid matches what isn't allowed in a Secret's data keys
+	k8sKeyInvalid = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+)
+
+func validateK8sName(name string) error {
+	if len(name) > 253 || !k8sName.MatchString(name) {
+		return fmt.Errorf("invalid Secret name %q, expected lowercase letters, digits, - and .", name)
+	}
+	return nil
+}
+
+// k8sSecret writes a Kubernetes Secret manifest named name holding the
+// values of keys from m, base64 encoded. Characters a data key can't
+// have are replaced with _.
+func (m paramMap) k8sSecret(w io.Writer, name string, keys []string) {
+	data := make(map[string]string)
+	for _, key := range keys {
+		value, ok := m[key]
+		if !ok {
+			continue
+		}
+
+		dataKey := k8sKeyInvalid.ReplaceAllString(key, "_")
+		if dataKey != key {
+			debugf("Writing %s to the Secret as %s", key, dataKey)
+		}
+		data[dataKey] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	dataKeys := make([]string, 0, len(data))
+	for key := range data {
+		dataKeys = append(dataKeys, key)
+	}
+	sort.Strings(dataKeys)
+
+	fmt.Fprintln(w, "apiVersion: v1")
+	fmt.Fprintln(w, "kind: Secret")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintf(w, "  name: %s\n", name)
+	fmt.Fprintln(w, "type: Opaque")
+
+	if len(dataKeys) == 0 {
+		fmt.Fprintln(w, "data: {}")
+		return
+	}
+
+	// Keys are quoted so ones like "true" or "1.0" stay strings, as are
+	// empty values, which would otherwise be null
+	fmt.Fprintln(w, "data:")
+	for _, key := range dataKeys {
+		value := data[key]
+		if value == "" {
+			value = `""`
+		}
+		fmt.Fprintf(w, "  %q: %s\n", key, value)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestValidateK8sName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "app-config"},
+		{name: "app.config.v2"},
+		{name: "a"},
+		{name: strings.Repeat("a", 253)},
+		{name: strings.Repeat("a", 254), wantErr: true},
+		{name: "", wantErr: true},
+		{name: "App-Config", wantErr: true},
+		{name: "app_config", wantErr: true},
+		{name: "-app", wantErr: true},
+		{name: "app.", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := validateK8sName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("validateK8sName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestK8sSecret(t *testing.T) {
+	m := paramMap{
+		"DB_HOST":     "prod-db",
+		"DB_PASSWORD": "p@ss: 'word'\n",
+		"true":        "yes",
+		"my key/name": "sanitized",
+		"OS_ONLY":     "not loaded",
+		"EMPTY":       "",
+	}
+
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{
+			name: "loaded keys",
+			keys: []string{"DB_PASSWORD", "DB_HOST", "true", "my key/name", "EMPTY", "MISSING"},
+			want: `apiVersion: v1
+kind: Secret
+metadata:
+  name: app-config
+type: Opaque
+data:
+  "DB_HOST": cHJvZC1kYg==
+  "DB_PASSWORD": cEBzczogJ3dvcmQnCg==
+  "EMPTY": ""
+  "my_key_name": c2FuaXRpemVk
+  "true": eWVz
+`,
+		},
+		{
+			name: "nothing loaded",
+			want: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-config\ntype: Opaque\ndata: {}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			m.k8sSecret(&buf, "app-config", tt.keys)
+			if buf.String() != tt.want {
+				t.Errorf("k8sSecret() =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+// The data values decode back to exactly what was loaded
+func TestK8sSecretDecodes(t *testing.T) {
+	m := paramMap{"JSON": `{"a": [1, 2], "b": "c"}`, "BINARY": "\x00\xff\r\n", "UNICODE": "héllo wörld ✓", "EMPTY": ""}
+
+	var buf bytes.Buffer
+	m.k8sSecret(&buf, "app-config", m.SortedKeys())
+
+	decoded := make(paramMap)
+	inData := false
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line == "data:" {
+			inData = true
+			continue
+		}
+		if !inData {
+			continue
+		}
+
+		pair := strings.SplitN(strings.TrimPrefix(line, "  "), ": ", 2)
+		value, err := base64.StdEncoding.DecodeString(strings.Trim(pair[1], `"`))
+		if err != nil {
+			t.Fatalf("%s: %s", pair[0], err)
+		}
+		decoded[strings.Trim(pair[0], `"`)] = string(value)
+	}
+
+	for key, want := range m {
+		if decoded[key] != want {
+			t.Errorf("%s decodes to %q, want %q", key, decoded[key], want)
+		}
+	}
+}
+
+func TestK8sSecretFlag(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--k8s-secret", "web-config", "--set", "EXTRA=1")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	want := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: web-config\ntype: Opaque\ndata:\n  \"DB_HOST\": cHJvZC1kYg==\n  \"EXTRA\": MQ==\n"
+	if stdout != want {
+		t.Errorf("stdout =\n%s\nwant only the loaded keys\n%s", stdout, want)
+	}
+
+	if _, _, code := runMain(t, params, env, "--k8s-secret", "Web_Config"); code != exitUsage {
+		t.Errorf("exit code = %d for an invalid name, want %d", code, exitUsage)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// How to pick between parameters that map to the same key
+const (
+	dedupFirst          = "first"
+	dedupLast           = "last"
+	dedupHighestVersion = "highest-version"
+	dedupLongestPath    = "longest-path"
+)
+
+// Where --names stand against the paths
+const (
+	namesHighest = "highest"
+	namesDedup   = "dedup"
+)
+
+const (
+	keyFormatBasename = "basename"
+	keyFormatFullPath = "full-path"
+	keyFormatRelative = "relative"
+)
+
+// paramOptions controls how fetched parameters are turned into keys
+type paramOptions struct {
+	// KeyFormat is one of basename (the last path segment, the default),
+	// full-path (every segment) or relative (the segments below the path
+	// the parameter was fetched from)
+	KeyFormat string
+
+	// Delimiter joins path segments in the full-path and relative formats
+	Delimiter string
+
+	// Separator replaces Delimiter in the full-path format when set
+	Separator string
+
+	// StripSuffixes are removed from the end of keys, the first that
+	// matches only
+	StripSuffixes []string
+
+	// BasePaths are the paths being fetched, used by the relative format
+	BasePaths []string
+
+	// Sanitize is how control characters in values are handled: left
+	// alone (empty), stripped, or rejected with strict
+	Sanitize string
+
+	// DedupStrategy picks which parameter wins when several map to the
+	// same key. Empty is the same as first.
+	DedupStrategy string
+
+	// Named are the full names given with --names, which win over
+	// parameters from paths whatever DedupStrategy is
+	Named map[string]bool
+
+	// Protected are keys SSM isn't allowed to set
+	Protected []string
+
+	// Overlay strips the env prefixes of params under the --overlay
+	// paths before they're turned into keys
+	Overlay *overlayOptions
+}
+
+// defaultProtectedKeys are the variables that would let whoever can write
+// to SSM change which binaries and libraries the command runs
+var defaultProtectedKeys = []string{
+	"PATH",
+	"HOME",
+	"SHELL",
+	"IFS",
+	"LD_PRELOAD",
+	"LD_LIBRARY_PATH",
+	"LD_AUDIT",
+	"DYLD_INSERT_LIBRARIES",
+	"DYLD_LIBRARY_PATH",
+}
+
+// protected reports whether key is one SSM can't set
+func (o *paramOptions) protected(key string) bool {
+	for _, p := range o.Protected {
+		if p == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Dedup maps each key to the parameter it takes its value from. With
+// first or last the fetch order decides, so the shared path wins over
+// the app path and so on. highest-version picks the parameter with the
+// highest version number and longest-path the most specific one; ties in
+// either go to the first fetched. Named parameters win over the others.
+func (o *paramOptions) Dedup(params []*ssm.Parameter) map[string]*ssm.Parameter {
+	winners := make(map[string]*ssm.Parameter)
+
+	for _, param := range params {
+		key := o.Key(*param.Name)
+		current, exists := winners[key]
+
+		if !exists || o.replaces(param, current) {
+			winners[key] = param
+		}
+	}
+
+	return winners
+}
+
+// InterpolateWithin resolves references between params from the same
+// layer in place, so a layer's values see its own keys first. References
+// to keys the layer doesn't have are left for the merged interpolation.
+func (o *paramOptions) InterpolateWithin(params []*ssm.Parameter) {
+	layer := make(paramMap)
+	for key, param := range o.Dedup(params) {
+		layer[key] = aws.StringValue(param.Value)
+	}
+
+	for _, param := range params {
+		param.Value = aws.String(interpolatePartial(aws.StringValue(param.Value), layer))
+	}
+}
+
+// replaces reports whether param should win over current
+func (o *paramOptions) replaces(param, current *ssm.Parameter) bool {
+	if named := o.Named[aws.StringValue(param.Name)]; named != o.Named[aws.StringValue(current.Name)] {
+		return named
+	}
+
+	switch o.DedupStrategy {
+	case dedupLast:
+		return true
+	case dedupHighestVersion:
+		return aws.Int64Value(param.Version) > aws.Int64Value(current.Version)
+	case dedupLongestPath:
+		return len(aws.StringValue(param.Name)) > len(aws.StringValue(current.Name))
+	}
+	return false
+}
+
+// Key converts a parameter name such as /prod/app/db/host to its key
+func (o *paramOptions) Key(name string) string {
+	key := o.key(o.Overlay.KeyName(name))
+
+	for _, suffix := range o.StripSuffixes {
+		if len(key) > len(suffix) && strings.HasSuffix(key, suffix) {
+			return strings.TrimSuffix(key, suffix)
+		}
+	}
+
+	return key
+}
+
+func (o *paramOptions) key(name string) string {
+	switch o.KeyFormat {
+	case keyFormatFullPath:
+		if o.Separator != "" {
+			return o.join(name, o.Separator)
+		}
+		return o.join(name, o.Delimiter)
+	case keyFormatRelative:
+		return o.join(o.relative(name), o.Delimiter)
+	}
+
+	ss := strings.Split(name, "/")
+	return ss[len(ss)-1]
+}
+
+// relative strips the longest base path that name falls under
+func (o *paramOptions) relative(name string) string {
+	longest := ""
+	for _, base := range o.BasePaths {
+		if strings.HasPrefix(name, base) && len(base) > len(longest) {
+			longest = base
+		}
+	}
+	return strings.TrimPrefix(name, longest)
+}
+
+func (o *paramOptions) join(name, sep string) string {
+	return strings.Join(strings.Split(strings.Trim(name, "/"), "/"), sep)
+}
+
+// UnknownKeys returns the keys in m that aren't in known, sorted
+func (m paramMap) UnknownKeys(known []string) []string {
+	set := make(map[string]bool, len(known))
+	for _, k := range known {
+		set[k] = true
+	}
+
+	var unknown []string
+	for k := range m {
+		if !set[k] {
+			unknown = append(unknown, k)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// envNameInvalid matches what isn't allowed in an env var name
+var envNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// InvalidNames returns the keys in m that aren't valid env var names,
+// which the OS can silently drop from a command's env, sorted
+func (m paramMap) InvalidNames() []string {
+	var invalid []string
+	for key := range m {
+		if !shellName.MatchString(key) {
+			invalid = append(invalid, key)
+		}
+	}
+
+	sort.Strings(invalid)
+	return invalid
+}
+
+// NormalizeNames renames the keys in m that aren't valid env var names,
+// replacing what isn't allowed with _ and prefixing a leading digit with
+// _. Keys whose new name is protected (when protected is set) are dropped.
+// It fails if a new name is already taken.
+func (m paramMap) NormalizeNames(protected func(key string) bool) error {
+	for _, key := range m.InvalidNames() {
+		name := envNameInvalid.ReplaceAllString(key, "_")
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+
+		if protected != nil && protected(name) {
+			log.Printf("Warning: not setting %s from %s, it's a protected key (see --protected-keys)\n", name, key)
+			delete(m, key)
+			continue
+		}
+
+		if _, exists := m[name]; exists {
+			return fmt.Errorf("can't normalize %s, %s is already set", key, name)
+		}
+
+		m[name] = m[key]
+		delete(m, key)
+	}
+	return nil
+}
+
+type rename struct {
+	From string
+	To   string
+}
+
+func parseRename(s string) (rename, error) {
+	pair := strings.SplitN(s, "=", 2)
+	if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+		return rename{}, fmt.Errorf("invalid --rename %q, expected oldKey=NEW_KEY", s)
+	}
+	return rename{From: pair[0], To: pair[1]}, nil
+}
+
+// Rename moves each renamed key's value to its new name
+func (m paramMap) Rename(renames []rename) {
+	for _, r := range renames {
+		if value, exists := m[r.From]; exists {
+			delete(m, r.From)
+			m[r.To] = value
+		}
+	}
+}
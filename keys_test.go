@@ -0,0 +1,476 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestParamOptionsKey(t *testing.T) {
+	tests := []struct {
+		name string
+		po   paramOptions
+		in   string
+		want string
+	}{
+		{name: "basename", po: paramOptions{}, in: "/prod/app/db/host", want: "host"},
+		{name: "full path", po: paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "_"}, in: "/prod/app/db/host", want: "prod_app_db_host"},
+		{name: "double delimiter", po: paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "__"}, in: "/prod/app/db/host", want: "prod__app__db__host"},
+		{name: "underscores in segments", po: paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "__"}, in: "/prod/my_app/DB_HOST", want: "prod__my_app__DB_HOST"},
+		{name: "underscores with single delimiter", po: paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "_"}, in: "/prod/my_app/DB_HOST", want: "prod_my_app_DB_HOST"},
+		{name: "dot delimiter", po: paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "."}, in: "/prod/app/db/host", want: "prod.app.db.host"},
+		{
+			name: "relative",
+			po:   paramOptions{KeyFormat: keyFormatRelative, Delimiter: "__", BasePaths: []string{"/prod/", "/prod/app/"}},
+			in:   "/prod/app/db/host",
+			want: "db__host",
+		},
+		{
+			name: "relative to the shorter base",
+			po:   paramOptions{KeyFormat: keyFormatRelative, Delimiter: "_", BasePaths: []string{"/prod/", "/prod/app/"}},
+			in:   "/prod/other/DB_HOST",
+			want: "other_DB_HOST",
+		},
+		{
+			name: "namespace separator",
+			po:   paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "_", Separator: "."},
+			in:   "/prod/my_app/DB_HOST",
+			want: "prod.my_app.DB_HOST",
+		},
+		{
+			name: "dash namespace separator",
+			po:   paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "__", Separator: "-"},
+			in:   "/prod/app/db/host",
+			want: "prod-app-db-host",
+		},
+		{
+			name: "namespace separator not used for relative",
+			po:   paramOptions{KeyFormat: keyFormatRelative, Delimiter: "_", Separator: ".", BasePaths: []string{"/prod/"}},
+			in:   "/prod/app/DB_HOST",
+			want: "app_DB_HOST",
+		},
+		{
+			name: "namespace separator not used for basename",
+			po:   paramOptions{Delimiter: "_", Separator: "."},
+			in:   "/prod/app/DB_HOST",
+			want: "DB_HOST",
+		},
+		{name: "strip suffix", po: paramOptions{StripSuffixes: []string{"-prod"}}, in: "/prod/app/db-host-prod", want: "db-host"},
+		{name: "strip suffix no match", po: paramOptions{StripSuffixes: []string{"-prod"}}, in: "/prod/app/db-host-staging", want: "db-host-staging"},
+		{name: "strip suffix not the whole key", po: paramOptions{StripSuffixes: []string{"-prod"}}, in: "/prod/app/-prod", want: "-prod"},
+		{name: "strip suffix only once", po: paramOptions{StripSuffixes: []string{"-prod"}}, in: "/prod/app/db-prod-prod", want: "db-prod"},
+		{name: "strip first matching suffix", po: paramOptions{StripSuffixes: []string{"_PROD", "_HOST_PROD"}}, in: "/prod/app/DB_HOST_PROD", want: "DB_HOST"},
+		{name: "strip later suffix", po: paramOptions{StripSuffixes: []string{"_STAGING", "_PROD"}}, in: "/prod/app/DB_HOST_PROD", want: "DB_HOST"},
+		{
+			name: "strip suffix after full path",
+			po:   paramOptions{KeyFormat: keyFormatFullPath, Delimiter: "_", StripSuffixes: []string{"_prod"}},
+			in:   "/prod/app/db_prod",
+			want: "prod_app_db",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.po.Key(tt.in); got != tt.want {
+				t.Errorf("Key(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		m     paramMap
+		known []string
+		want  []string
+	}{
+		{name: "known only", m: paramMap{"DB_HOST": "db", "DB_PORT": "5432"}, known: []string{"DB_HOST", "DB_PORT", "DEBUG"}},
+		{name: "extra keys", m: paramMap{"DB_HOST": "db", "STRAY": "x", "OTHER_APP_KEY": "y"}, known: []string{"DB_HOST"}, want: []string{"OTHER_APP_KEY", "STRAY"}},
+		{name: "nothing known", m: paramMap{"DB_HOST": "db"}, want: []string{"DB_HOST"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.UnknownKeys(tt.known); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnknownKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownKeys(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DB_HOST", Value: "db"},
+		{Name: "/prod/STRAY", Value: "x"},
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		code    int
+		warning bool
+	}{
+		{name: "known only", args: []string{"--known-keys", "DB_HOST,STRAY", "--fail-unknown"}},
+		{name: "extra key", args: []string{"--known-keys", "DB_HOST", "--fail-unknown"}, code: exitUsage, warning: true},
+		{name: "extra key warns", args: []string{"--known-keys", "DB_HOST"}, warning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, append(tt.args, "--no-exec")...)
+
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if got := strings.Contains(stderr, "Unknown parameters loaded") && strings.Contains(stderr, "STRAY"); got != tt.warning {
+				t.Errorf("stderr = %q, want STRAY reported: %v", stderr, tt.warning)
+			}
+		})
+	}
+}
+
+func TestParseRename(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    rename
+		wantErr bool
+	}{
+		{in: "db-host=DATABASE_HOST", want: rename{From: "db-host", To: "DATABASE_HOST"}},
+		{in: "db-host", wantErr: true},
+		{in: "=DATABASE_HOST", wantErr: true},
+		{in: "db-host=", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRename(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseRename(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseRename(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenameInterpolated(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/db-host", Value: "db.internal"},
+		{Name: "/prod/web/DATABASE_URL", Value: "postgres://%%DATABASE_HOST%%/app"},
+		{Name: "/prod/web/OLD_REF", Value: "[%%db-host%%]"},
+	}
+
+	stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--rename", "db-host=DATABASE_HOST", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	want := "DATABASE_HOST=db.internal\nDATABASE_URL=postgres://db.internal/app\n"
+	if got := envLines(stdout, "DATABASE_"); got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+	if strings.Contains(stdout, "db-host=") || !strings.Contains(stdout, "OLD_REF=[]\n") {
+		t.Errorf("the old name is still set: %q", stdout)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	param := func(name, value string, version int64) *ssm.Parameter {
+		return &ssm.Parameter{Name: aws.String(name), Value: aws.String(value), Version: aws.Int64(version)}
+	}
+	// In fetch order, each one the winner of a different strategy
+	params := []*ssm.Parameter{
+		param("/prod/HOST", "first", 2),
+		param("/prod/app/very/deep/HOST", "longest", 1),
+		param("/prod/app/HOST", "highest", 9),
+		param("/prod/x/HOST", "last", 3),
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		params   []*ssm.Parameter
+		named    []string
+		want     string
+	}{
+		{name: "default", params: params, want: "first"},
+		{name: "first", strategy: dedupFirst, params: params, want: "first"},
+		{name: "last", strategy: dedupLast, params: params, want: "last"},
+		{name: "highest version", strategy: dedupHighestVersion, params: params, want: "highest"},
+		{name: "longest path", strategy: dedupLongestPath, params: params, want: "longest"},
+		{
+			name:     "version tie goes to the first fetched",
+			strategy: dedupHighestVersion,
+			params:   []*ssm.Parameter{param("/prod/HOST", "first", 4), param("/prod/app/HOST", "second", 4)},
+			want:     "first",
+		},
+		{
+			name:     "path tie goes to the first fetched",
+			strategy: dedupLongestPath,
+			params:   []*ssm.Parameter{param("/prod/a/HOST", "first", 1), param("/prod/b/HOST", "second", 2)},
+			want:     "first",
+		},
+		{name: "named wins over first", strategy: dedupFirst, params: params, named: []string{"/prod/x/HOST"}, want: "last"},
+		{name: "named wins over highest version", strategy: dedupHighestVersion, params: params, named: []string{"/prod/HOST"}, want: "first"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			po := paramOptions{DedupStrategy: tt.strategy, Named: make(map[string]bool)}
+			for _, name := range tt.named {
+				po.Named[name] = true
+			}
+
+			winners := po.Dedup(tt.params)
+			if len(winners) != 1 {
+				t.Fatalf("Dedup() = %d keys, want only HOST", len(winners))
+			}
+			if got := aws.StringValue(winners["HOST"].Value); got != tt.want {
+				t.Errorf("HOST = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArgsDedupStrategy(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{args: nil, want: dedupFirst},
+		{args: []string{"--dedup-strategy", "last"}, want: dedupLast},
+		{args: []string{"--dedup-strategy", "highest-version"}, want: dedupHighestVersion},
+		{args: []string{"--dedup-strategy", "longest-path"}, want: dedupLongestPath},
+		{args: []string{"--dedup-strategy", "newest"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		opts, _, err := parseArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+		if err == nil && opts.DedupStrategy != tt.want {
+			t.Errorf("parseArgs(%q) strategy = %q, want %q", tt.args, opts.DedupStrategy, tt.want)
+		}
+	}
+}
+
+func TestNamesPrecedenceFlag(t *testing.T) {
+	// Enough of each to take several pages and GetParameters calls
+	var params []fakeParam
+	var names []string
+	for i := 0; i < 12; i++ {
+		params = append(params,
+			fakeParam{Name: fmt.Sprintf("/extra/NP_%02d", i), Value: "path", Version: 5},
+			fakeParam{Name: fmt.Sprintf("/named/NP_%02d", i), Value: "named", Version: 1},
+		)
+		names = append(names, fmt.Sprintf("/named/NP_%02d", i))
+	}
+	params = append(params, fakeParam{Name: "/extra/NP_ONLY_PATH", Value: "path"})
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+	args := []string{"--path", "/extra", "--names", strings.Join(names, ","), "--page-size", "5", "-O"}
+
+	tests := []struct {
+		name  string
+		flags []string
+		want  string
+	}{
+		{name: "default", want: "named"},
+		{name: "highest", flags: []string{"--names-precedence", "highest", "--dedup-strategy", "highest-version"}, want: "named"},
+		{name: "dedup first", flags: []string{"--names-precedence", "dedup"}, want: "path"},
+		{name: "dedup last", flags: []string{"--names-precedence", "dedup", "--dedup-strategy", "last"}, want: "named"},
+		{name: "dedup highest version", flags: []string{"--names-precedence", "dedup", "--dedup-strategy", "highest-version"}, want: "path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, env, append(tt.flags, args...)...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+
+			var want string
+			for i := 0; i < 12; i++ {
+				want += fmt.Sprintf("NP_%02d=%s\n", i, tt.want)
+			}
+			want += "NP_ONLY_PATH=path\n"
+			if got := envLines(stdout, "NP_"); got != want {
+				t.Errorf("env =\n%s\nwant\n%s", got, want)
+			}
+
+			if got := strings.Count(stderr, "fake: GetParameters\n"); got != 2 {
+				t.Errorf("%d GetParameters calls, want 12 names in 2", got)
+			}
+			if got := strings.Count(stderr, "fake: GetParametersByPath\n"); got < 3 {
+				t.Errorf("%d GetParametersByPath calls, want 13 params under /extra/ in 3 pages", got)
+			}
+		})
+	}
+
+	if _, _, code := runMain(t, params, env, append([]string{"--names-precedence", "lowest"}, args...)...); code != exitUsage {
+		t.Errorf("exit code = %d for an unknown --names-precedence, want %d", code, exitUsage)
+	}
+}
+
+func TestNamespaceSeparatorFlag(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--key-format", "full-path", "--namespace-separator", ".", "--k8s-secret", "web-config")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if !strings.Contains(stdout, "\n  \"prod.web.DB_HOST\": cHJvZC1kYg==\n") {
+		t.Errorf("stdout = %q, want the key joined with dots", stdout)
+	}
+
+	// --flatten-delimiter still applies without it
+	stdout, stderr, code = runMain(t, params, env, "--key-format", "full-path", "--flatten-delimiter", "__", "--k8s-secret", "web-config")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if !strings.Contains(stdout, "\n  \"prod__web__DB_HOST\": cHJvZC1kYg==\n") {
+		t.Errorf("stdout = %q, want the key joined with __", stdout)
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	m := paramMap{"DB_HOST": "", "_PRIVATE": "", "db-host": "", "1ST": "", "a.b": "", "x9": "", "SPACE KEY": ""}
+
+	want := []string{"1ST", "SPACE KEY", "a.b", "db-host"}
+	if got := m.InvalidNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("InvalidNames() = %q, want %q", got, want)
+	}
+	if got := (paramMap{"OK": ""}).InvalidNames(); got != nil {
+		t.Errorf("InvalidNames() = %q, want none", got)
+	}
+}
+
+func TestNormalizeNames(t *testing.T) {
+	protected := func(key string) bool { return key == "LD_PRELOAD" }
+
+	tests := []struct {
+		name     string
+		m        paramMap
+		want     paramMap
+		wantErr  string
+		wantWarn string
+	}{
+		{
+			name: "invalid characters",
+			m:    paramMap{"db-host": "db", "api.key": "k", "DB_PORT": "5432"},
+			want: paramMap{"db_host": "db", "api_key": "k", "DB_PORT": "5432"},
+		},
+		{
+			name: "leading digit",
+			m:    paramMap{"1ST": "a", "2-nd": "b"},
+			want: paramMap{"_1ST": "a", "_2_nd": "b"},
+		},
+		{
+			name:     "protected after normalizing",
+			m:        paramMap{"LD-PRELOAD": "/tmp/evil.so", "db-host": "db"},
+			want:     paramMap{"db_host": "db"},
+			wantWarn: "Warning: not setting LD_PRELOAD from LD-PRELOAD, it's a protected key (see --protected-keys)",
+		},
+		{
+			name:    "clash",
+			m:       paramMap{"db-host": "a", "db_host": "b"},
+			wantErr: "can't normalize db-host, db_host is already set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+
+			err := tt.m.NormalizeNames(protected)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("NormalizeNames() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.m, tt.want) {
+				t.Errorf("normalized = %v, want %v", tt.m, tt.want)
+			}
+			if !strings.Contains(logs.String(), tt.wantWarn) {
+				t.Errorf("log = %q, want %q", logs.String(), tt.wantWarn)
+			}
+		})
+	}
+
+	// Without a check nothing is dropped
+	m := paramMap{"LD-PRELOAD": "x"}
+	if err := m.NormalizeNames(nil); err != nil || m["LD_PRELOAD"] != "x" {
+		t.Errorf("NormalizeNames(nil) = %v, %v", m, err)
+	}
+}
+
+func TestNormalizeKeysFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/nk-host", Value: "prod-db"},
+		{Name: "/prod/web/LD-PRELOAD", Value: "/tmp/evil.so"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--normalize-keys", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if got := envLines(stdout, "nk"); got != "nk_host=prod-db\n" {
+		t.Errorf("env = %q, want nk_host", got)
+	}
+	if envLines(stdout, "LD_PRELOAD=") != "" || envLines(stdout, "LD-PRELOAD=") != "" {
+		t.Errorf("stdout = %q, want LD-PRELOAD dropped", stdout)
+	}
+	if !strings.Contains(stderr, "not setting LD_PRELOAD from LD-PRELOAD") {
+		t.Errorf("stderr = %q, want a warning", stderr)
+	}
+}
+
+func TestValidateNamesFlag(t *testing.T) {
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	_, stderr, code := runMain(t, []fakeParam{{Name: "/prod/web/nk-host", Value: "prod-db"}}, env, "--validate-names", "--no-exec")
+	if code != exitUsage {
+		t.Errorf("exit code = %d, want %d (stderr %q)", code, exitUsage, stderr)
+	}
+	if !strings.Contains(stderr, "Keys that aren't valid env var names (see --normalize-keys):  nk-host") {
+		t.Errorf("stderr = %q", stderr)
+	}
+
+	// Normalized first, they pass
+	if _, stderr, code := runMain(t, []fakeParam{{Name: "/prod/web/nk-host", Value: "prod-db"}}, env, "--validate-names", "--normalize-keys", "--no-exec"); code != exitOK {
+		t.Errorf("exit code = %d with --normalize-keys (stderr %q)", code, stderr)
+	}
+}
+
+func TestStripSuffixFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/ss-host-prod", Value: "prod-db"},
+		{Name: "/prod/web/ss-port-live", Value: "5432"},
+		{Name: "/prod/web/ss-user", Value: "app"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--strip-suffix", "-prod", "--strip-suffix", "-live", "--normalize-keys", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	want := "ss_host=prod-db\nss_port=5432\nss_user=app\n"
+	if got := envLines(stdout, "ss"); got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+
+	if _, _, code := runMain(t, params, env, "--strip-suffix", "", "-O"); code != exitUsage {
+		t.Errorf("exit code = %d for an empty suffix, want %d", code, exitUsage)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	lockPollInterval = 50 * time.Millisecond
+	lockTimeout      = 10 * time.Second
+)
+
+// errLockTimeout is returned when a lock is still held after the timeout
+var errLockTimeout = fmt.Errorf("timed out waiting for lock")
+
+// errLocked is returned by tryLock when another process holds the lock
+var errLocked = errors.New("lock is held")
+
+// withFileLock runs fn while holding a lock on path+".lock", waiting up
+// to timeout for it. The lock is the OS's, so it goes away with a process
+// that dies, and is never taken from one that's just slow. The lock file
+// is left in place: removing it would let another process lock a new
+// file while the old one is still held.
+func withFileLock(path string, timeout time.Duration, fn func() error) error {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(timeout)
+
+	for {
+		f, err := tryLock(lockPath)
+		if err == nil {
+			// Closing the file releases the lock
+			defer f.Close()
+			return fn()
+		}
+
+		if err != errLocked {
+			return err
+		}
+
+		if time.Now().After(deadline) {
+			return errLockTimeout
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+}
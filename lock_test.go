@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithFileLockExclusive(t *testing.T) {
+	path := filepath.Join(tempDir(t), "app.env")
+
+	var mu sync.Mutex
+	holders, most := 0, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := withFileLock(path, 5*time.Second, func() error {
+				mu.Lock()
+				holders++
+				if holders > most {
+					most = holders
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if most != 1 {
+		t.Errorf("%d held the lock at once", most)
+	}
+}
+
+func TestWithFileLockTimeout(t *testing.T) {
+	path := filepath.Join(tempDir(t), "app.env")
+
+	held := make(chan struct{})
+	release := make(chan struct{})
+	go withFileLock(path, time.Second, func() error {
+		close(held)
+		<-release
+		return nil
+	})
+	<-held
+	defer close(release)
+
+	start := time.Now()
+	err := withFileLock(path, 100*time.Millisecond, func() error {
+		t.Error("got the lock while it was held")
+		return nil
+	})
+
+	if err != errLockTimeout {
+		t.Errorf("error = %v, want errLockTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("gave up after %s, want the whole timeout", elapsed)
+	}
+}
+
+// TestLockHelperProcess isn't a test: it's the other process for
+// TestWithFileLockAcrossProcesses, holding the lock until it's killed
+func TestLockHelperProcess(t *testing.T) {
+	path := os.Getenv("SSM_LOADER_TEST_LOCK")
+	if path == "" {
+		t.Skip("only run as a helper")
+	}
+
+	withFileLock(path, time.Second, func() error {
+		fmt.Println("locked")
+		select {}
+	})
+}
+
+func TestWithFileLockAcrossProcesses(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "SSM_LOADER_TEST_LOCK="+path)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+
+	if line, _ := bufio.NewReader(stdout).ReadString('\n'); line != "locked\n" {
+		t.Fatalf("helper said %q, want it to take the lock", line)
+	}
+
+	err = withFileLock(path, 200*time.Millisecond, func() error {
+		t.Error("got the lock while another process held it")
+		return nil
+	})
+	if err != errLockTimeout {
+		t.Errorf("error = %v, want errLockTimeout", err)
+	}
+
+	// The lock goes with the process holding it
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	ran := false
+	if err := withFileLock(path, time.Second, func() error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("lock not taken after its holder died (err %v)", err)
+	}
+}
+
+func TestCacheFileFetchedOnce(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+
+	var mu sync.Mutex
+	fetches := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, stderr, code := runMain(t, params, env, "--cache-file", path, "-O")
+			if code != exitOK {
+				t.Errorf("exit code = %d (stderr %q)", code, stderr)
+			}
+
+			mu.Lock()
+			fetches += strings.Count(stderr, "fake: GetParametersByPath\n")
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// One process fetched the shared and app paths, the rest used its cache
+	if fetches != 2 {
+		t.Errorf("%d path fetches across the processes, want 2", fetches)
+	}
+}
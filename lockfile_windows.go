@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is ERROR_SHARING_VIOLATION, when another process
+// has the file open
+const errorSharingViolation syscall.Errno = 32
+
+// tryLock opens path with no sharing, which Windows holds for as long as
+// the file is open, returning errLocked if another process has it
+func tryLock(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(h), path), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// manifest maps the full names of parameters to the env var each sets,
+// as in {"/prod/db/host": "DB_HOST"}
+type manifest map[string]string
+
+func loadManifest(path string) (manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+
+	for name, key := range m {
+		if !shellName.MatchString(key) {
+			return nil, fmt.Errorf("%s: %q isn't a valid env var name", name, key)
+		}
+	}
+
+	return m, nil
+}
+
+// Fetch gets every parameter in the manifest and returns the names that
+// don't exist separately
+func (m manifest) Fetch(client ssmiface.SSMAPI) ([]*ssm.Parameter, []string, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return getParametersByName(client, names)
+}
+
+// Env keys the values of params by their env vars
+func (m manifest) Env(params []*ssm.Parameter) paramMap {
+	values := make(paramMap, len(params))
+	for _, param := range params {
+		values[m[aws.StringValue(param.Name)]] = aws.StringValue(param.Value)
+	}
+	return values
+}
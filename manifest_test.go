@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := tempDir(t)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    manifest
+		wantErr string
+	}{
+		{
+			name: "valid",
+			data: `{"/prod/db/host": "DB_HOST", "/shared/region": "AWS_REGION"}`,
+			want: manifest{"/prod/db/host": "DB_HOST", "/shared/region": "AWS_REGION"},
+		},
+		{name: "empty", data: `{}`, want: manifest{}},
+		{name: "invalid json", data: `{"/prod/db/host": `, wantErr: "parsing "},
+		{name: "not a string", data: `{"/prod/db/host": 1}`, wantErr: "parsing "},
+		{name: "invalid name", data: `{"/prod/db/host": "db-host"}`, wantErr: `/prod/db/host: "db-host" isn't a valid env var name`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadManifest(writeFile(t, dir, "manifest.json", tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadManifest() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadManifest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadManifest(dir + "/missing.json"); err == nil {
+		t.Error("loadManifest() of a missing file succeeded")
+	}
+}
+
+func TestManifestFetch(t *testing.T) {
+	fake := newFakeSSM(
+		fakeParam{Name: "/prod/db/host", Value: "prod-db"},
+		fakeParam{Name: "/shared/region", Value: "eu-west-1"},
+		fakeParam{Name: "/prod/db/unlisted", Value: "x"},
+	)
+	m := manifest{"/prod/db/host": "DB_HOST", "/shared/region": "REGION", "/prod/db/missing": "MISSING"}
+
+	params, missing, err := m.Fetch(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"/prod/db/missing"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing = %q, want %q", missing, want)
+	}
+	if got, want := m.Env(params), (paramMap{"DB_HOST": "prod-db", "REGION": "eu-west-1"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Env() = %v, want %v", got, want)
+	}
+	if calls := fake.Calls("GetParameters"); calls != 1 {
+		t.Errorf("%d GetParameters calls, want the names fetched together", calls)
+	}
+}
+
+func TestManifestFlag(t *testing.T) {
+	path := writeFile(t, tempDir(t), "manifest.json",
+		`{"/shared/db/host": "MF_HOST", "/shared/missing": "MF_MISSING", "/shared/preload": "LD_PRELOAD"}`)
+	params := []fakeParam{
+		{Name: "/prod/web/MF_HOST", Value: "from-path"},
+		{Name: "/prod/web/MF_PORT", Value: "5432"},
+		{Name: "/shared/db/host", Value: "from-manifest"},
+		{Name: "/shared/preload", Value: "/tmp/evil.so"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--manifest", path, "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	// The manifest wins over the path's key of the same name
+	if got, want := envLines(stdout, "MF_"), "MF_HOST=from-manifest\nMF_PORT=5432\n"; got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+	if envLines(stdout, "LD_PRELOAD=") != "" {
+		t.Errorf("stdout = %q, want the protected key left out", stdout)
+	}
+	for _, warning := range []string{
+		"Warning: manifest parameters not found:  /shared/missing",
+		"Warning: not setting LD_PRELOAD from the manifest, it's a protected key",
+	} {
+		if !strings.Contains(stderr, warning) {
+			t.Errorf("stderr = %q, want %q", stderr, warning)
+		}
+	}
+
+	_, stderr, code = runMain(t, params, env, "--manifest", path, "--strict", "-O")
+	if code != exitNotFound || !strings.Contains(stderr, "manifest parameters not found: /shared/missing") {
+		t.Errorf("exit code = %d, stderr %q, want %d with --strict", code, stderr, exitNotFound)
+	}
+
+	invalid := writeFile(t, tempDir(t), "manifest.json", `{"/shared/db/host": "db-host"}`)
+	if _, _, code := runMain(t, params, env, "--manifest", invalid, "-O"); code != exitUsage {
+		t.Errorf("exit code = %d for an invalid manifest, want %d", code, exitUsage)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// paramLimit is set by --max-params. It counts the params loaded by each
+// round of fetching so paths stop being fetched once there are too many.
+var paramLimit *paramBudget
+
+// paramBudget is how many params a round of fetching may load
+type paramBudget struct {
+	Max  int
+	Fail bool
+
+	loaded int
+}
+
+// Start begins a round of fetching with the whole budget
+func (b *paramBudget) Start() {
+	b.loaded = 0
+}
+
+// Remaining is how many more params may be loaded, which is never less
+// than zero
+func (b *paramBudget) Remaining() int {
+	if b.loaded >= b.Max {
+		return 0
+	}
+	return b.Max - b.loaded
+}
+
+// Take counts params loaded from label against the budget, returning as
+// many as fit. Going over is an error with --fail-max-params, otherwise
+// the rest are dropped with a warning.
+func (b *paramBudget) Take(label string, params []*ssm.Parameter) ([]*ssm.Parameter, error) {
+	remaining := b.Remaining()
+	if len(params) <= remaining {
+		b.loaded += len(params)
+		return params, nil
+	}
+
+	if b.Fail {
+		return nil, &codedError{exitUsage, fmt.Errorf("more than %d params, stopped at %s (--max-params)", b.Max, label)}
+	}
+	log.Printf("Warning: more than %d params loaded, stopped at %s (--max-params)\n", b.Max, label)
+
+	b.loaded = b.Max
+	return params[:remaining], nil
+}
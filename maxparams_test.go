@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func someParams(n int) []*ssm.Parameter {
+	params := make([]*ssm.Parameter, n)
+	for i := range params {
+		params[i] = &ssm.Parameter{Name: aws.String(fmt.Sprintf("/prod/app/P%d", i)), Value: aws.String("v")}
+	}
+	return params
+}
+
+func TestParamBudgetTake(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     bool
+		loaded   int
+		take     int
+		want     int
+		wantWarn bool
+		wantErr  string
+	}{
+		{name: "under", take: 3, want: 3},
+		{name: "exactly", take: 5, want: 5},
+		{name: "none", take: 0, want: 0},
+		{name: "over", take: 8, want: 5, wantWarn: true},
+		{name: "over with earlier params", loaded: 4, take: 3, want: 1, wantWarn: true},
+		{name: "already full", loaded: 5, take: 2, want: 0, wantWarn: true},
+		{name: "fail when over", fail: true, loaded: 2, take: 4, wantErr: "more than 5 params, stopped at app (--max-params)"},
+		{name: "fail when exactly", fail: true, loaded: 2, take: 3, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+
+			b := &paramBudget{Max: 5, Fail: tt.fail}
+			if _, err := b.Take("shared", someParams(tt.loaded)); err != nil {
+				t.Fatal(err)
+			}
+
+			params := someParams(tt.take)
+			got, err := b.Take("app", params)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr || exitCodeFor(err) != exitUsage {
+					t.Errorf("Take() error = %v, want %q with exit code %d", err, tt.wantErr, exitUsage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != tt.want {
+				t.Errorf("Take() kept %d params, want %d", len(got), tt.want)
+			}
+			for i := range got {
+				if got[i] != params[i] {
+					t.Errorf("Take() param %d = %s, want the first ones kept in order", i, aws.StringValue(got[i].Name))
+				}
+			}
+
+			warned := strings.Contains(logged.String(), "Warning: more than 5 params loaded, stopped at app (--max-params)")
+			if warned != tt.wantWarn {
+				t.Errorf("logged %q, want a warning %t", logged, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestParamBudgetRemaining(t *testing.T) {
+	captureLog(t)
+	b := &paramBudget{Max: 5}
+
+	if got := b.Remaining(); got != 5 {
+		t.Errorf("Remaining() = %d before anything's loaded, want 5", got)
+	}
+	b.Take("shared", someParams(3))
+	if got := b.Remaining(); got != 2 {
+		t.Errorf("Remaining() = %d, want 2", got)
+	}
+	b.Take("app", someParams(9))
+	if got := b.Remaining(); got != 0 {
+		t.Errorf("Remaining() = %d when over, want 0", got)
+	}
+
+	// Each round of fetching, such as a reload, gets the whole budget
+	b.Start()
+	if got := b.Remaining(); got != 5 {
+		t.Errorf("Remaining() = %d after Start(), want 5", got)
+	}
+}
+
+func TestGetParametersMaxParams(t *testing.T) {
+	var params []fakeParam
+	for i := 0; i < 50; i++ {
+		params = append(params, fakeParam{Name: fmt.Sprintf("/prod/app/P%02d", i), Value: "v"})
+	}
+
+	tests := []struct {
+		maxParams int
+		wantPages int
+	}{
+		{maxParams: 0, wantPages: 5},
+		{maxParams: 15, wantPages: 2},
+		{maxParams: 20, wantPages: 3},
+		{maxParams: 1, wantPages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.maxParams), func(t *testing.T) {
+			fake := newFakeSSM(params...)
+
+			got, err := getParameters(&getParametersInput{
+				Client:    fake,
+				Path:      aws.String("/prod/app/"),
+				PageSize:  10,
+				MaxParams: tt.maxParams,
+				Clock:     newFakeClock(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pages := fake.Calls("GetParametersByPath"); pages != tt.wantPages {
+				t.Errorf("%d pages fetched, want %d", pages, tt.wantPages)
+			}
+			if len(got) != tt.wantPages*10 {
+				t.Errorf("%d params fetched, want the %d pages' worth", len(got), tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestMaxParamsFlag(t *testing.T) {
+	var params []fakeParam
+	for i := 0; i < 30; i++ {
+		params = append(params, fakeParam{Name: fmt.Sprintf("/prod/web/MP_%02d", i), Value: "v"})
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+
+	stdout, stderr, code := runMain(t, params, env, "--max-params", "12", "--page-size", "5", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if got := strings.Count(envLines(stdout, "MP_"), "\n"); got != 12 {
+		t.Errorf("%d params loaded, want 12", got)
+	}
+	if !strings.Contains(stderr, "Warning: more than 12 params loaded, stopped at") {
+		t.Errorf("stderr = %q, want the warning", stderr)
+	}
+	// The shared path's page, then 3 of the app path's 6 to get past 12
+	if pages := strings.Count(stderr, "fake: GetParametersByPath"); pages != 4 {
+		t.Errorf("%d pages fetched, want 4", pages)
+	}
+
+	// Everything fits, so nothing's dropped
+	stdout, stderr, code = runMain(t, params, env, "--max-params", "30", "-O")
+	if code != exitOK || strings.Count(envLines(stdout, "MP_"), "\n") != 30 || strings.Contains(stderr, "Warning") {
+		t.Errorf("exit code = %d, stderr = %q, want all 30 params loaded", code, stderr)
+	}
+
+	_, stderr, code = runMain(t, params, env, "--max-params", "12", "--fail-max-params", "-O")
+	if code != exitUsage {
+		t.Errorf("exit code = %d with --fail-max-params, want %d", code, exitUsage)
+	}
+	if !strings.Contains(stderr, "more than 12 params, stopped at") {
+		t.Errorf("stderr = %q, want the limit", stderr)
+	}
+}
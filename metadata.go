@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// metadataTimeout bounds each metadata call, since off EC2 and ECS the
+// endpoint just doesn't answer
+const metadataTimeout = 2 * time.Second
+
+// metadataRegion looks up the region from the ECS task metadata when
+// running in a task, or else from the EC2 instance metadata
+func metadataRegion(sess *session.Session) (string, string, error) {
+	httpClient := &http.Client{Timeout: metadataTimeout}
+
+	if uri := os.Getenv("ECS_CONTAINER_METADATA_URI_V4"); uri != "" {
+		region, err := ecsRegion(httpClient, uri+"/task")
+		return region, "ECS task metadata", err
+	}
+
+	client := ec2metadata.New(sess, aws.NewConfig().WithHTTPClient(httpClient).WithMaxRetries(0))
+	region, err := client.Region()
+	return region, "EC2 instance metadata", err
+}
+
+// ecsTask is the part of the task metadata response with the region in it
+type ecsTask struct {
+	AvailabilityZone string
+	Cluster          string
+}
+
+func ecsRegion(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("task metadata returned %s", resp.Status)
+	}
+
+	var task ecsTask
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return "", err
+	}
+
+	// us-west-2a is in us-west-2. Fargate tasks on older platforms don't
+	// report the zone, but the cluster ARN has the region too.
+	if az := task.AvailabilityZone; az != "" {
+		return az[:len(az)-1], nil
+	}
+	if arn := strings.Split(task.Cluster, ":"); len(arn) > 3 && arn[3] != "" {
+		return arn[3], nil
+	}
+
+	return "", errors.New("task metadata has no region")
+}
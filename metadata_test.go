@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// metadataServer serves body with status for every request, counting
+// the requests made
+func metadataServer(t *testing.T, status int, body string) (*httptest.Server, *int) {
+	t.Helper()
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func TestECSRegion(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "availability zone", status: 200, body: `{"AvailabilityZone": "us-west-2a", "Cluster": "arn:aws:ecs:eu-west-1:123456789012:cluster/default"}`, want: "us-west-2"},
+		{name: "cluster arn", status: 200, body: `{"Cluster": "arn:aws:ecs:eu-west-1:123456789012:cluster/default"}`, want: "eu-west-1"},
+		{name: "cluster name", status: 200, body: `{"Cluster": "default"}`, wantErr: "task metadata has no region"},
+		{name: "invalid json", status: 200, body: `{"AvailabilityZone": `, wantErr: "unexpected EOF"},
+		{name: "error status", status: 500, body: `{"AvailabilityZone": "us-west-2a"}`, wantErr: "task metadata returned 500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := metadataServer(t, tt.status, tt.body)
+
+			region, err := ecsRegion(srv.Client(), srv.URL+"/task")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ecsRegion() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if region != tt.want {
+				t.Errorf("ecsRegion() = %q, want %q", region, tt.want)
+			}
+		})
+	}
+}
+
+func TestEC2MetadataRegion(t *testing.T) {
+	setenv(t, "ECS_CONTAINER_METADATA_URI_V4", "")
+	srv, _ := metadataServer(t, 200, "ap-southeast-2a")
+
+	sess := session.Must(session.NewSession(&aws.Config{Endpoint: aws.String(srv.URL + "/latest")}))
+	region, source, err := metadataRegion(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region != "ap-southeast-2" || source != "EC2 instance metadata" {
+		t.Errorf("metadataRegion() = %q from %q, want ap-southeast-2 from EC2 instance metadata", region, source)
+	}
+}
+
+func TestSessionRegionFromMetadata(t *testing.T) {
+	isolateAWSConfig(t)
+	srv, requests := metadataServer(t, 200, `{"AvailabilityZone": "eu-central-1b"}`)
+	setenv(t, "ECS_CONTAINER_METADATA_URI_V4", srv.URL)
+
+	tests := []struct {
+		name     string
+		opts     options
+		want     string
+		requests int
+	}{
+		{name: "fallback", opts: options{MetadataRegion: true}, want: "eu-central-1", requests: 1},
+		{name: "region set", opts: options{Region: "us-east-1", MetadataRegion: true}, want: "us-east-1"},
+		{name: "off", opts: options{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*requests = 0
+
+			sess, err := newSession(&tt.opts)
+			if tt.want == "" {
+				if err != errNoRegion {
+					t.Fatalf("newSession() error = %v, want errNoRegion", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got := aws.StringValue(sess.Config.Region); got != tt.want {
+				t.Errorf("region = %q, want %q", got, tt.want)
+			}
+
+			if *requests != tt.requests {
+				t.Errorf("%d metadata requests, want %d", *requests, tt.requests)
+			}
+		})
+	}
+}
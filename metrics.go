@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// fetchStats counts the AWS requests made while loading
+type fetchStats struct {
+	mu sync.Mutex
+
+	Requests  int
+	Attempts  int
+	Throttles int
+	Errors    int
+	Latency   time.Duration
+	Params    int
+
+	// Latencies has the latency of each request, for probe
+	Latencies []time.Duration
+}
+
+// Attach adds handlers recording every request made by clients created
+// from h afterwards
+func (s *fetchStats) Attach(h *request.Handlers) {
+	h.CompleteAttempt.PushBack(func(r *request.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.Attempts++
+		if request.IsErrorThrottle(r.Error) {
+			s.Throttles++
+		}
+	})
+
+	h.Complete.PushBack(func(r *request.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.Requests++
+		s.Latency += time.Since(r.Time)
+		s.Latencies = append(s.Latencies, time.Since(r.Time))
+		if r.Error != nil {
+			s.Errors++
+		}
+	})
+}
+
+// statsdPayload formats the stats as statsd metrics, one per line
+func (s *fetchStats) statsdPayload() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "ssm_loader.requests:%d|c\n", s.Requests)
+	fmt.Fprintf(&buf, "ssm_loader.retries:%d|c\n", s.Attempts-s.Requests)
+	fmt.Fprintf(&buf, "ssm_loader.throttles:%d|c\n", s.Throttles)
+	fmt.Fprintf(&buf, "ssm_loader.errors:%d|c\n", s.Errors)
+	fmt.Fprintf(&buf, "ssm_loader.fetch_latency:%d|ms\n", s.Latency/time.Millisecond)
+	fmt.Fprintf(&buf, "ssm_loader.params_loaded:%d|g\n", s.Params)
+	return buf.Bytes()
+}
+
+// parseMetricsURL checks a --metrics sink, currently only statsd://host:port
+func parseMetricsURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "statsd" || u.Host == "" {
+		return nil, fmt.Errorf("invalid --metrics %q, expected statsd://host:port", s)
+	}
+
+	return u, nil
+}
+
+// Emit sends the stats to the metrics sink. It's best effort: statsd is
+// UDP so nothing waits on the receiver, and failures are only logged at
+// verbose.
+func (s *fetchStats) Emit(sink *url.URL) {
+	conn, err := net.DialTimeout("udp", sink.Host, time.Second)
+	if err != nil {
+		debugf("Unable to send metrics: %s", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(s.statsdPayload()); err != nil {
+		debugf("Unable to send metrics: %s", err.Error())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// statsdListener is a fake statsd server. Each packet it receives is sent
+// on the channel.
+func statsdListener(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	packets := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packets <- string(buf[:n])
+		}
+	}()
+
+	return "statsd://" + conn.LocalAddr().String(), packets
+}
+
+// receive waits for a packet
+func receive(t *testing.T, packets <-chan string) string {
+	t.Helper()
+
+	select {
+	case packet := <-packets:
+		return packet
+	case <-time.After(5 * time.Second):
+		t.Fatal("no metrics received")
+		return ""
+	}
+}
+
+func TestEmitMetrics(t *testing.T) {
+	sink, packets := statsdListener(t)
+	server, _ := throttlingSSM(t)
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+		Retryer:     newBudgetRetryer(10 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := &fetchStats{Params: 12}
+	stats.Attach(&sess.Handlers)
+	getParameterValue(ssm.New(sess), "/prod/app/DB_HOST")
+
+	stats.Emit(mustParseMetricsURL(t, sink))
+	packet := receive(t, packets)
+
+	for _, want := range []string{
+		"ssm_loader.requests:1|c\n",
+		"ssm_loader.retries:1|c\n",
+		"ssm_loader.throttles:2|c\n",
+		"ssm_loader.errors:1|c\n",
+		"ssm_loader.params_loaded:12|g\n",
+	} {
+		if !strings.Contains(packet, want) {
+			t.Errorf("metrics %q don't contain %q", packet, want)
+		}
+	}
+	if !strings.Contains(packet, "ssm_loader.fetch_latency:") {
+		t.Errorf("metrics %q have no latency", packet)
+	}
+}
+
+func TestMetricsFlag(t *testing.T) {
+	sink, packets := statsdListener(t)
+	params := []fakeParam{
+		{Name: "/prod/web/DB_HOST", Value: "db"},
+		{Name: "/prod/web/DB_PORT", Value: "5432"},
+	}
+
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--metrics", sink, "--no-exec")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	if packet := receive(t, packets); !strings.Contains(packet, "ssm_loader.params_loaded:2|g\n") {
+		t.Errorf("metrics = %q, want 2 params loaded", packet)
+	}
+}
+
+func TestMetricsUnreachable(t *testing.T) {
+	// Nothing listens here, which mustn't hold anything up
+	start := time.Now()
+	(&fetchStats{}).Emit(mustParseMetricsURL(t, "statsd://127.0.0.1:1"))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("emitting took %s", elapsed)
+	}
+}
+
+func mustParseMetricsURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+
+	u, err := parseMetricsURL(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestParseMetricsURL(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		wantErr bool
+	}{
+		{in: "statsd://localhost:8125"},
+		{in: "statsd://10.0.0.1:8125"},
+		{in: "statsd://", wantErr: true},
+		{in: "udp://localhost:8125", wantErr: true},
+		{in: "localhost:8125", wantErr: true},
+	} {
+		if _, err := parseMetricsURL(tt.in); (err != nil) != tt.wantErr {
+			t.Errorf("parseMetricsURL(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// nestKeys rebuilds nested objects from the keys in m by splitting them
+// on sep, so DB_HOST and DB_PORT become {"DB": {"HOST": ..., "PORT": ...}}.
+// It fails when a key is both a value and an object, as with DB and
+// DB_HOST.
+func (m paramMap) nestKeys(keys []string, sep string) (map[string]interface{}, error) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	root := make(map[string]interface{})
+	for _, key := range sorted {
+		value, exists := m[key]
+		if !exists {
+			continue
+		}
+
+		segments := strings.Split(key, sep)
+		node := root
+
+		for i, segment := range segments[:len(segments)-1] {
+			switch child := node[segment].(type) {
+			case nil:
+				next := make(map[string]interface{})
+				node[segment] = next
+				node = next
+			case map[string]interface{}:
+				node = child
+			default:
+				return nil, fmt.Errorf("can't nest %s, %s already has a value", key, strings.Join(segments[:i+1], sep))
+			}
+		}
+
+		leaf := segments[len(segments)-1]
+		if _, exists := node[leaf]; exists {
+			return nil, fmt.Errorf("can't nest %s, it's also an object", key)
+		}
+		node[leaf] = value
+	}
+
+	return root, nil
+}
+
+// writeNestedJSON writes the values of keys from m as nested JSON
+func (m paramMap) writeNestedJSON(w io.Writer, keys []string, sep string) error {
+	nested, err := m.nestKeys(keys, sep)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(nested)
+}
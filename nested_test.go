@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNestKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       paramMap
+		keys    []string
+		sep     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "flat",
+			m:    paramMap{"HOST": "db", "PORT": "5432"},
+			sep:  "_",
+			want: `{"HOST":"db","PORT":"5432"}`,
+		},
+		{
+			name: "nested",
+			m:    paramMap{"DB_HOST": "db", "DB_PORT": "5432", "DEBUG": "1"},
+			sep:  "_",
+			want: `{"DB":{"HOST":"db","PORT":"5432"},"DEBUG":"1"}`,
+		},
+		{
+			name: "deep",
+			m:    paramMap{"prod.web.db.host": "db", "prod.web.db.port": "5432", "prod.api.url": "http://api"},
+			sep:  ".",
+			want: `{"prod":{"api":{"url":"http://api"},"web":{"db":{"host":"db","port":"5432"}}}}`,
+		},
+		{
+			name: "double delimiter",
+			m:    paramMap{"prod__my_app__DB_HOST": "db"},
+			sep:  "__",
+			want: `{"prod":{"my_app":{"DB_HOST":"db"}}}`,
+		},
+		{
+			name: "only the given keys",
+			m:    paramMap{"DB_HOST": "db", "PATH": "/usr/bin"},
+			keys: []string{"DB_HOST", "MISSING"},
+			sep:  "_",
+			want: `{"DB":{"HOST":"db"}}`,
+		},
+		{
+			name:    "value and object",
+			m:       paramMap{"DB": "db", "DB_HOST": "db"},
+			sep:     "_",
+			wantErr: "can't nest DB_HOST, DB already has a value",
+		},
+		{
+			name:    "deeper value and object",
+			m:       paramMap{"A_B": "1", "A_B_C_D": "2"},
+			sep:     "_",
+			wantErr: "can't nest A_B_C_D, A_B already has a value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := tt.keys
+			if keys == nil {
+				for key := range tt.m {
+					keys = append(keys, key)
+				}
+			}
+
+			nested, err := tt.m.nestKeys(keys, tt.sep)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("nestKeys() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, _ := json.Marshal(nested)
+			if string(got) != tt.want {
+				t.Errorf("nestKeys() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteNestedJSON(t *testing.T) {
+	var out bytes.Buffer
+	m := paramMap{"DB_HOST": "db"}
+	if err := m.writeNestedJSON(&out, []string{"DB_HOST"}, "_"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "{\n  \"DB\": {\n    \"HOST\": \"db\"\n  }\n}\n"
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestNestedJSONFlag(t *testing.T) {
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+	params := []fakeParam{
+		{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+		{Name: "/prod/web/DB_PORT", Value: "5432"},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want map[string]interface{}
+	}{
+		{
+			name: "flatten delimiter",
+			want: map[string]interface{}{"DB": map[string]interface{}{"HOST": "prod-db", "PORT": "5432"}},
+		},
+		{
+			name: "namespace separator",
+			args: []string{"--key-format", "full-path", "--namespace-separator", "."},
+			want: map[string]interface{}{"prod": map[string]interface{}{"web": map[string]interface{}{
+				"DB_HOST": "prod-db", "DB_PORT": "5432",
+			}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, env, append(tt.args, "--nested-json")...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+				t.Fatalf("not JSON: %v\n%s", err, stdout)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nested JSON = %v, want %v (without the OS env)", got, tt.want)
+			}
+		})
+	}
+
+	conflicting := append(params, fakeParam{Name: "/prod/web/DB", Value: "db"})
+	_, stderr, code := runMain(t, conflicting, env, "--nested-json")
+	if code != exitUsage {
+		t.Errorf("exit code = %d for a conflict, want %d", code, exitUsage)
+	}
+	if !strings.Contains(stderr, "can't nest DB_HOST, DB already has a value") {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// What to do when neither APP_ENV nor WORKPATH_ENV is set
+const (
+	onMissingEnvSkip  = "skip"
+	onMissingEnvWarn  = "warn"
+	onMissingEnvError = "error"
+)
+
+type options struct {
+	Help              bool
+	Output            bool
+	Version           bool
+	OnMissingEnv      string
+	SkipUndecryptable bool
+	RefreshKeys       []refreshKey
+	KeyFormat         string
+	FlattenDelimiter  string
+	Partition         string
+	FIPS              bool
+	NoStdin           bool
+	FileOuts          []fileOut
+	Chdir             string
+	Paths             []pathSpec
+	PathsFromParams   []string
+	KnownKeys         []string
+	FailUnknown       bool
+	Renders           []renderPair
+	Strict            bool
+	Region            string
+	MetadataRegion    bool
+	Verbose           bool
+	Sets              paramMap
+	FailEnvSize       bool
+	InterpolateOSEnv  bool
+	Renames           []rename
+	RetryBudget       time.Duration
+	SanitizeValues    string
+	Names             []string
+	Metrics           *url.URL
+	FilterType        string
+	FilterKeyID       string
+	WarnEmptyPath     bool
+	IgnoreChildError  bool
+	WriteEnv          string
+	MaxPages          int
+	MaxParams         int
+	FailMaxParams     bool
+	CredentialsFile   string
+	DedupStrategy     string
+	InterpolateLayers bool
+	Schema            *schema
+	Types             *schema
+	Tree              bool
+	Masks             []string
+	ExecReplace       bool
+	Describe          bool
+	AdaptivePageSize  bool
+	RedactOutput      bool
+	PageSize          int
+	WaitFor           []string
+	WaitTimeout       time.Duration
+	ExportFormat      string
+	ProtectedKeys     []string
+	DecryptEach       bool
+	DecryptWorkers    int
+	ParamsStdin       bool
+	EnvsubstFiles     []string
+	EnvsubstSuffix    string
+	K8sSecret         string
+	MaxValueLength    int
+	FailLongValues    bool
+	Serve             string
+	ServeRefresh      time.Duration
+	Trace             bool
+	Overlays          []pathSpec
+	OverlayEnvs       []string
+	ResultFile        string
+	ResumeDir         string
+	ResumeTTL         time.Duration
+	CacheFile         string
+	CacheTTL          time.Duration
+	Since             time.Time
+	PrintConfig       bool
+	S3Config          *url.URL
+	NoExec            bool
+	NamespaceSep      string
+	NestedJSON        bool
+	CacheLockWait     time.Duration
+	SystemdEnv        string
+	ValidateNames     bool
+	FallbackRegion    string
+	Manifest          manifest
+	CacheKMSKey       string
+	StripSuffixes     []string
+	DiffEnv           bool
+	NamesPrecedence   string
+	CommandParam      string
+	SWRTTL            time.Duration
+	KeysOnly          bool
+	EnvDuplicates     string
+	RunIDKey          string
+	RunIDFormat       string
+	AliasFile         string
+	NormalizeKeys     bool
+
+	// pendingIf is the --if-param waiting for the --path it gates
+	pendingIf *pathSpec
+}
+
+// shortFlags are single letter aliases, listed alongside their long name
+var shortFlags = map[string]string{
+	"h": "help",
+	"v": "verbose",
+}
+
+// repeatedValue is a flag.Value that hands every occurrence of the flag
+// to the function, for flags that may be repeated
+type repeatedValue func(string) error
+
+func (f repeatedValue) String() string     { return "" }
+func (f repeatedValue) Set(s string) error { return f(s) }
+
+// boolFuncValue is a repeatedValue that doesn't need a value
+type boolFuncValue func(string) error
+
+func (f boolFuncValue) String() string     { return "" }
+func (f boolFuncValue) Set(s string) error { return f(s) }
+func (f boolFuncValue) IsBoolFlag() bool   { return true }
+
+// enumValue is a string flag restricted to a set of values
+type enumValue struct {
+	value   *string
+	allowed []string
+}
+
+func (e *enumValue) String() string {
+	if e.value == nil {
+		return ""
+	}
+	return *e.value
+}
+
+func (e *enumValue) Set(s string) error {
+	for _, a := range e.allowed {
+		if s == a {
+			*e.value = s
+			return nil
+		}
+	}
+	var names []string
+	for _, a := range e.allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	return fmt.Errorf("expected one of %s", strings.Join(names, ", "))
+}
+
+func newFlagSet(opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("ssm-loader", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	fs.BoolVar(&opts.Help, "help", false, "Shows this output")
+	fs.BoolVar(&opts.Help, "h", false, "")
+	fs.BoolVar(&opts.Output, "O", false, "Prints the env to stdout as sorted KEY=VALUE lines, split on the first \"=\" when reading them back. Values with newlines, quotes, $ or # are double quoted with backslash escapes")
+	fs.BoolVar(&opts.Version, "version", false, "Prints version information")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "Logs what the loader is doing")
+	fs.BoolVar(&opts.Verbose, "v", false, "")
+	fs.StringVar(&opts.Region, "region", "", "The AWS `REGION`. Otherwise taken from AWS_REGION, AWS_DEFAULT_REGION or the AWS profile, in that order")
+	fs.BoolVar(&opts.MetadataRegion, "region-from-metadata", false, "Falls back to the region in the ECS task or EC2 instance metadata when no other region is set")
+	fs.StringVar(&opts.Partition, "partition", "", "Resolves endpoints in the AWS partition `ID` (aws, aws-us-gov, aws-cn). By default it's inferred from the region")
+	fs.BoolVar(&opts.FIPS, "fips", false, "Uses FIPS endpoints for SSM, STS, KMS, Secrets Manager and S3. Also enabled by AWS_USE_FIPS_ENDPOINT=true")
+
+	onMissingEnv := []string{onMissingEnvSkip, onMissingEnvWarn, onMissingEnvError}
+	fs.Var(&enumValue{&opts.OnMissingEnv, onMissingEnv}, "on-missing-env", "What to do when neither APP_ENV nor WORKPATH_ENV is set: `skip|warn|error`")
+	fs.Var(boolFuncValue(func(s string) error {
+		opts.OnMissingEnv = onMissingEnvError
+		return nil
+	}), "require-env", "Same as --on-missing-env error")
+
+	fs.BoolVar(&opts.SkipUndecryptable, "skip-undecryptable", false, "Skips SecureStrings that can't be decrypted instead of failing")
+
+	fs.Var(repeatedValue(func(s string) error {
+		rk, err := parseRefreshKey(s)
+		if err != nil {
+			return err
+		}
+		opts.RefreshKeys = append(opts.RefreshKeys, rk)
+		return nil
+	}), "refresh-key", "Polls a key on an interval, given as `KEY=interval` (e.g. DB_HOST=30s), and restarts the command when its value changes. May be repeated")
+
+	fs.BoolVar(&opts.NoStdin, "no-stdin", false, "Gives the command an empty stdin instead of the loader's")
+
+	fs.Var(repeatedValue(func(s string) error {
+		out, err := parseFileOut(s)
+		if err != nil {
+			return err
+		}
+		opts.FileOuts = append(opts.FileOuts, out)
+		return nil
+	}), "file-out", "Writes a key's value to a file (mode 0600) instead of passing it in the env, given as `KEY=/path`. May be repeated")
+
+	fs.StringVar(&opts.Chdir, "chdir", "", "Runs the command in `PATH`, which may use %%KEY%% interpolations")
+
+	fs.Var(repeatedValue(func(s string) error {
+		spec, err := parsePathSpec(s)
+		if err != nil {
+			return err
+		}
+		if opts.pendingIf != nil {
+			spec.IfParam, spec.IfValue = opts.pendingIf.IfParam, opts.pendingIf.IfValue
+			opts.pendingIf = nil
+		}
+		opts.Paths = append(opts.Paths, spec)
+		return nil
+	}), "path", "Also loads the parameters under a path, after the shared and app paths, given as `PATH[@REGION][:ROLE_ARN]` to load it from REGION and assume ROLE_ARN for it. PATH can have * and ? wildcards, as in /prod/*/common, which takes extra DescribeParameters calls to list what's under the path up to the first wildcard. May be repeated")
+
+	fs.StringVar(&opts.AliasFile, "aliases", "", "Reads path aliases from `FILE`, one per line as alias NAME=PATH, so --path @NAME and --overlay @NAME load PATH. Defaults to $"+aliasesEnv)
+
+	fs.Var(repeatedValue(func(s string) error {
+		pair := strings.SplitN(s, "=", 2)
+		if len(pair) != 2 || !strings.HasPrefix(pair[0], "/") {
+			return fmt.Errorf("expected /NAME=VALUE")
+		}
+		opts.pendingIf = &pathSpec{IfParam: pair[0], IfValue: pair[1]}
+		return nil
+	}), "if-param", "Only loads the --path that follows if the parameter is set to the value, given as `/NAME=VALUE`. The path is skipped when the parameter doesn't exist")
+
+	fs.Var(repeatedValue(func(s string) error {
+		spec, err := parsePathSpec(s)
+		if err != nil {
+			return err
+		}
+		opts.Overlays = append(opts.Overlays, spec)
+		return nil
+	}), "overlay", "Loads a path keeping every environment's values together, as ENV.KEY and default.KEY, after the shared and app paths. The APP_ENV variant of each key wins over the default and its prefix is dropped. Given as `PATH[@REGION][:ROLE_ARN]` like --path. May be repeated")
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.OverlayEnvs = splitList(s)
+		return nil
+	}), "overlay-envs", "Replaces the environments whose variants --overlay drops with `ENV1,ENV2`. Other dotted names, such as log.level, are loaded as they are. Defaults to "+strings.Join(defaultOverlayEnvs, ", "))
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.PathsFromParams = append(opts.PathsFromParams, s)
+		return nil
+	}), "path-from-param", "Fetches the parameter `NAME` and loads the path stored in it, as with --path. May be repeated")
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.Names = append(opts.Names, splitList(s)...)
+		return nil
+	}), "names", "Also loads the parameters with the full names `NAME1,NAME2`, after any paths. Names that don't exist are a warning, or an error with --strict")
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.KnownKeys = append(opts.KnownKeys, splitList(s)...)
+		return nil
+	}), "known-keys", "Warns about any key loaded from SSM that isn't in `KEY1,KEY2`")
+	fs.BoolVar(&opts.FailUnknown, "fail-unknown", false, "Fails instead of warning about keys not in --known-keys")
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.ProtectedKeys = splitList(s)
+		return nil
+	}), "protected-keys", "Replaces the keys SSM isn't allowed to set with `KEY1,KEY2`, or none with an empty list. Defaults to "+strings.Join(defaultProtectedKeys, ", "))
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.WaitFor = append(opts.WaitFor, splitList(s)...)
+		return nil
+	}), "wait-for", "Fetches again until the keys `KEY1,KEY2` are loaded from SSM (before --rename), for parameters written just after startup")
+	fs.DurationVar(&opts.WaitTimeout, "wait-timeout", 60*time.Second, "How long --wait-for waits, as a `DURATION`, before failing")
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.Masks = append(opts.Masks, splitList(s)...)
+		return nil
+	}), "mask", "Prints `KEY1,KEY2` as *** in -O, export and --diff-env output. The command still gets the real values")
+
+	fs.Var(repeatedValue(func(s string) error {
+		pair, err := parseRenderPair(s)
+		if err != nil {
+			return err
+		}
+		opts.Renders = append(opts.Renders, pair)
+		return nil
+	}), "render", "Renders a Go template file to an output file, given as `TEMPLATE:OUTPUT[:DEFAULTS]`, with each key available as {{.KEY}}. DEFAULTS is a file of KEY=VALUE lines for keys that aren't otherwise set. May be repeated")
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.EnvsubstFiles = append(opts.EnvsubstFiles, s)
+		return nil
+	}), "envsubst-files", "Replaces ${KEY} and ${KEY:-default} with values in the files matching `GLOB`, rewriting them in place. May be repeated")
+	fs.StringVar(&opts.EnvsubstSuffix, "envsubst-suffix", "", "Writes each --envsubst-files file ending in `SUFFIX` next to itself without it (app.conf.tmpl to app.conf) instead of in place")
+	fs.BoolVar(&opts.Strict, "strict", false, "Treats missing keys in templates, missing --names and unknown interpolation functions as errors")
+
+	fs.Var(repeatedValue(func(s string) error {
+		pair := strings.SplitN(s, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return fmt.Errorf("expected KEY=VALUE")
+		}
+		opts.Sets[pair[0]] = pair[1]
+		return nil
+	}), "set", "Sets a key, given as `KEY=VALUE`, overriding SSM and the OS env. Other values can interpolate it. May be repeated")
+
+	fs.BoolVar(&opts.FailEnvSize, "fail-env-size", false, "Fails instead of warning when the env is close to the platform's size limit")
+	fs.IntVar(&opts.MaxValueLength, "max-value-length", 0, "Warns about values loaded from SSM that are longer than `N` bytes once interpolated. 0 means no limit")
+	fs.BoolVar(&opts.FailLongValues, "fail-long-values", false, "Fails instead of warning about values over --max-value-length")
+	fs.BoolVar(&opts.InterpolateOSEnv, "interpolate-os-env", true, "Lets interpolations resolve against the OS env. With =false only keys loaded from SSM or --set are used")
+
+	fs.Var(repeatedValue(func(s string) error {
+		r, err := parseRename(s)
+		if err != nil {
+			return err
+		}
+		opts.Renames = append(opts.Renames, r)
+		return nil
+	}), "rename", "Loads an SSM key under a new name, given as `oldKey=NEW_KEY`. May be repeated")
+
+	fs.DurationVar(&opts.RetryBudget, "retry-budget", 0, "Caps the total `DURATION` spent waiting to retry failed AWS requests across the whole run (e.g. 30s)")
+
+	fs.Var(boolFuncValue(func(s string) error {
+		switch s {
+		case "true", sanitizeStrip:
+			opts.SanitizeValues = sanitizeStrip
+		case "false":
+			opts.SanitizeValues = sanitizeOff
+		case sanitizeStrict:
+			opts.SanitizeValues = sanitizeStrict
+		default:
+			return fmt.Errorf("expected strip or strict")
+		}
+		return nil
+	}), "sanitize-values", "Strips control characters other than newlines and tabs from SSM values, or with =strict fails when one is found")
+
+	fs.Var(repeatedValue(func(s string) error {
+		u, err := parseMetricsURL(s)
+		if err != nil {
+			return err
+		}
+		opts.Metrics = u
+		return nil
+	}), "metrics", "Sends request counts, throttles and fetch latency to `statsd://host:port` after loading")
+
+	fs.Var(repeatedValue(func(s string) error {
+		u, err := parseS3URL(s)
+		if err != nil {
+			return err
+		}
+		opts.S3Config = u
+		return nil
+	}), "s3-config", "Also loads the flat JSON object of strings at `s3://BUCKET/KEY`. SSM and everything else take precedence over it")
+
+	paramTypes := []string{"", ssm.ParameterTypeString, ssm.ParameterTypeStringList, ssm.ParameterTypeSecureString}
+	fs.Var(&enumValue{&opts.FilterType, paramTypes}, "filter-type", "Only loads parameters of type `String|StringList|SecureString`")
+	fs.StringVar(&opts.FilterKeyID, "filter-keyid", "", "Only loads SecureStrings encrypted with the KMS key `KEY_ID`, as given when the parameter was created. Costs extra DescribeParameters calls")
+
+	fs.BoolVar(&opts.WarnEmptyPath, "warn-empty-path", false, "Warns when a path has no parameters, which usually means a typo in APP_ENV or APP_NAME")
+
+	fs.BoolVar(&opts.Trace, "trace", false, "Logs every AWS request: what it asked for, how many parameters came back, retries and latency. Values are never logged")
+
+	fs.BoolVar(&opts.ExecReplace, "exec-replace", false, "Replaces ssm-loader with the command instead of running it as a child, so the command gets its PID and signals directly. Not supported on Windows or with --refresh-key")
+
+	fs.BoolVar(&opts.RedactOutput, "redact-output", false, "Replaces SecureString values with *** in the command's stdout and stderr. Output is passed on a line at a time")
+
+	fs.StringVar(&opts.ResultFile, "result-file", "", "Writes {exitCode, durationMs, paramsLoaded} as JSON to `FILE` once the commands finish, whether or not they succeed. Not written with --exec-replace")
+
+	fs.BoolVar(&opts.IgnoreChildError, "ignore-child-error", false, "Keeps running the commands after --then when one fails")
+
+	fs.StringVar(&opts.SystemdEnv, "systemd-env", "", "Writes the env to `FILE` as a systemd EnvironmentFile, with values quoted by systemd's rules. The command is optional")
+	fs.StringVar(&opts.WriteEnv, "write-env", "", "Writes the env to `FILE` (e.g. /dev/shm/app.env) as export statements other processes can source. The command is optional")
+
+	fs.IntVar(&opts.MaxPages, "max-pages", defaultMaxPages, "Stops fetching a path after `N` pages, in case pagination never ends. 0 means no limit. The default is scaled up for a smaller --page-size, so it always allows 1000 params")
+	fs.IntVar(&opts.MaxParams, "max-params", 0, "Stops fetching, with a warning, once more than `N` params would be loaded in total. 0 means no limit")
+	fs.BoolVar(&opts.FailMaxParams, "fail-max-params", false, "Fails instead of warning when more than --max-params params would be loaded")
+
+	fs.IntVar(&opts.PageSize, "page-size", 10, "Fetches `N` parameters per page, between 1 and 10")
+	fs.BoolVar(&opts.AdaptivePageSize, "adaptive-page-size", false, "Starts each path with pages of 2 parameters and doubles the page size up to --page-size, so the first parameters come back sooner")
+
+	fs.BoolVar(&opts.DecryptEach, "decrypt-each", false, "Fetches SecureStrings encrypted and decrypts each with its own call, so one that can't be decrypted doesn't fail its whole page. Takes more API calls")
+	fs.IntVar(&opts.DecryptWorkers, "decrypt-concurrency", 4, "Decrypts up to `N` SecureStrings at once with --decrypt-each, or when a page fails to decrypt")
+
+	fs.Var(&enumValue{&opts.EnvDuplicates, []string{envDuplicatesFirst, envDuplicatesLast}}, "env-duplicates", "Which entry wins when the inherited env has a key more than once, `first|last` (the default)")
+	fs.StringVar(&opts.RunIDKey, "inject-run-id", "", "Sets `KEY` to an ID generated for this run, unless it's already set, to tie the run's logs together. With --result-file the ID is recorded there too")
+	fs.Var(&enumValue{&opts.RunIDFormat, []string{runIDUUID, runIDULID}}, "run-id-format", "Generates --inject-run-id as a `uuid|ulid`, uuid by default")
+	fs.BoolVar(&opts.KeysOnly, "keys-only", false, "Prints the sorted names of the keys loaded, without values, and exits")
+	fs.BoolVar(&opts.DiffEnv, "diff-env", false, "Prints whether each key loaded is new, changed or unchanged compared with the env ssm-loader was started with, and exits")
+	fs.BoolVar(&opts.NestedJSON, "nested-json", false, "Prints the params loaded as a JSON object, nested by splitting keys on --namespace-separator (or --flatten-delimiter), so DB_HOST becomes {\"DB\": {\"HOST\": ...}}, and exits")
+	fs.BoolVar(&opts.ValidateNames, "validate-names", false, "Fails if any key loaded isn't a valid env var name, which the OS may silently drop from the command's env")
+	fs.Var(repeatedValue(func(s string) error {
+		if s == "" {
+			return fmt.Errorf("the suffix can't be empty")
+		}
+		opts.StripSuffixes = append(opts.StripSuffixes, s)
+		return nil
+	}), "strip-suffix", "Removes `SUFFIX` from the end of keys, so with -prod the parameter db-host-prod sets db-host. Combine with --normalize-keys to get db_host. May be repeated; only the first that matches is removed")
+	fs.BoolVar(&opts.NormalizeKeys, "normalize-keys", false, "Renames keys that aren't valid env var names, replacing invalid characters with _ and prefixing a leading digit with _")
+	fs.BoolVar(&opts.NoExec, "no-exec", false, "Loads and checks the params (--known-keys, --schema, --types and the rest), then exits without running or printing anything. For CI checks")
+	fs.BoolVar(&opts.PrintConfig, "print-effective-config", false, "Prints the region, paths and options that would be used as JSON, without values, and exits")
+
+	fs.StringVar(&opts.CacheFile, "cache-file", "", "Uses the params cached in `FILE` when it's fresher than --cache-ttl, and otherwise fetches and caches them. The file is only readable by its owner. Params including SecureStrings are only cached with --cache-kms-key")
+	fs.StringVar(&opts.CacheKMSKey, "cache-kms-key", "", "Encrypts the SecureStrings in the --cache-file with a data key from the KMS key `KEY_ID`")
+	fs.DurationVar(&opts.CacheTTL, "cache-ttl", 5*time.Minute, "How long the --cache-file stays fresh, as a `DURATION`")
+	fs.DurationVar(&opts.SWRTTL, "swr-ttl", 0, "Keeps using a --cache-file up to `DURATION` past --cache-ttl, while it's refreshed in the background. In watch mode the command is restarted if the refresh changes anything")
+	fs.DurationVar(&opts.CacheLockWait, "cache-lock-timeout", lockTimeout, "How long to wait for another process populating the --cache-file before fetching directly, as a `DURATION`")
+
+	fs.Var(repeatedValue(func(s string) error {
+		since, err := time.Parse(time.RFC3339, s)
+		opts.Since = since
+		return err
+	}), "since", "Only fetches the params modified after the RFC3339 `TIME`, and merges them over the ones from the --cache-file, or from the previous reload in watch mode. Without a base, everything is fetched. Deleted params aren't noticed until the next full fetch")
+
+	fs.StringVar(&opts.ResumeDir, "resume-dir", "", "Saves each path's progress to `DIR` after every page, so a fetch that's interrupted resumes where it left off. The files hold decrypted values and are only readable by their owner")
+	fs.DurationVar(&opts.ResumeTTL, "resume-ttl", 10*time.Minute, "Starts a path over if its --resume-dir progress is older than `DURATION`")
+
+	fs.StringVar(&opts.CommandParam, "command-from-param", "", "When no command is given, runs the command in the parameter `NAME` with the system shell. Anyone who can write the parameter can run anything with this process's access, so restrict who can write it as tightly as who can deploy")
+	fs.StringVar(&opts.FallbackRegion, "fallback-region", "", "Fetches from `REGION` instead if the primary region can't be reached, for parameters replicated to it. Errors such as access denied don't fail over. Paths given with their own @REGION don't fail over")
+	fs.StringVar(&opts.CredentialsFile, "credentials-file", "", "Reads AWS credentials from the JSON `FILE` {AccessKeyId, SecretAccessKey, SessionToken, Expiration}, re-reading it when they expire")
+
+	dedupStrategies := []string{dedupFirst, dedupLast, dedupHighestVersion, dedupLongestPath}
+	fs.Var(&enumValue{&opts.DedupStrategy, dedupStrategies}, "dedup-strategy", "Which parameter wins when several map to the same key, one of `first|last|highest-version|longest-path`. first and last go by fetch order: shared, app, --path, then --names, though --names win by default (see --names-precedence)")
+	fs.Var(&enumValue{&opts.NamesPrecedence, []string{namesHighest, namesDedup}}, "names-precedence", "Whether --names win over the same keys from paths (highest, the default) or are picked between by --dedup-strategy like the rest (dedup), as `highest|dedup`")
+
+	fs.BoolVar(&opts.ParamsStdin, "params-stdin", false, "Reads KEY=VALUE lines from stdin, such as another loader's -O output, before running the command with no stdin. They win over the OS env and SSM, but not --set")
+
+	fs.BoolVar(&opts.InterpolateLayers, "interpolate-layers", false, "Resolves %%KEY%% within each path first, so an app value referencing a key the app path also defines gets the app's value rather than the shared one. Remaining references then resolve across everything as usual")
+
+	fs.Var(repeatedValue(func(s string) error {
+		loaded, err := loadManifest(s)
+		opts.Manifest = loaded
+		return err
+	}), "manifest", "Also loads the parameters listed in the JSON `FILE`, which maps each full name to the env var it sets, as in {\"/prod/db/host\": \"DB_HOST\"}. These win over keys from paths. Names that don't exist are a warning, or an error with --strict")
+
+	fs.Var(repeatedValue(func(s string) error {
+		loaded, err := loadSchema(s)
+		opts.Schema = loaded
+		return err
+	}), "schema", "Validates the environment against the JSON schema in `FILE` before running the command. Supports required, and type (string, integer, number, boolean), enum, pattern, minimum and maximum for properties")
+
+	fs.Var(repeatedValue(func(s string) error {
+		path, err := parseServeURL(s)
+		opts.Serve = path
+		return err
+	}), "serve", "Serves the loaded params on the socket `unix:///PATH` to ssm-loader :connect, instead of running a command, until stopped")
+	fs.DurationVar(&opts.ServeRefresh, "serve-refresh", 5*time.Minute, "How often --serve fetches the params again, as a `DURATION`. 0 means never")
+
+	fs.Var(repeatedValue(func(s string) error {
+		opts.K8sSecret = s
+		return validateK8sName(s)
+	}), "k8s-secret", "Prints a Kubernetes Secret manifest named `NAME` with the keys loaded from SSM, stdin and --set, and exits")
+
+	fs.Var(&enumValue{&opts.ExportFormat, exportFormats}, "export-format", "Writes export and -O output as statements for `bash|zsh|fish|powershell|cmd`")
+
+	fs.BoolVar(&opts.Tree, "tree", false, "Prints the names of the fetched parameters as a tree, without their values, and exits")
+	fs.BoolVar(&opts.Describe, "describe", false, "Adds each parameter's description to the --tree output. This takes extra DescribeParameters calls")
+
+	fs.Var(repeatedValue(func(s string) error {
+		if opts.Types == nil {
+			opts.Types = &schema{}
+		}
+		return parseTypes(s, opts.Types)
+	}), "types", "Checks the values of keys parse as their type once interpolated, given as `KEY:TYPE,KEY:TYPE` with TYPE one of string, int, float or bool. Keys that aren't set are skipped")
+
+	keyFormats := []string{keyFormatBasename, keyFormatFullPath, keyFormatRelative}
+	fs.Var(&enumValue{&opts.KeyFormat, keyFormats}, "key-format", "How parameter names become keys, one of `basename|full-path|relative`")
+	fs.StringVar(&opts.FlattenDelimiter, "flatten-delimiter", "_", "Joins path segments with `DELIM` in the full-path and relative key formats")
+	fs.StringVar(&opts.NamespaceSep, "namespace-separator", "", "Joins path segments with `SEP` in the full-path key format instead of --flatten-delimiter. A separator such as . or - makes keys that aren't valid env var names, so is only useful where keys don't become an env, such as --k8s-secret")
+
+	return fs
+}
+
+func defaultOptions() *options {
+	return &options{
+		KeyFormat:     keyFormatBasename,
+		OnMissingEnv:  onMissingEnvWarn,
+		DedupStrategy: dedupFirst,
+		Sets:          make(paramMap),
+		ProtectedKeys: defaultProtectedKeys,
+		OverlayEnvs:   defaultOverlayEnvs,
+	}
+}
+
+// parseArgs reads loader options from the front of args, stopping at the
+// first argument that isn't an option (or a literal "--"), and returns
+// the options along with the remaining command
+func parseArgs(args []string) (*options, []string, error) {
+	opts := defaultOptions()
+	fs := newFlagSet(opts)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.FlattenDelimiter == "" {
+		return nil, nil, fmt.Errorf("--flatten-delimiter can't be empty")
+	}
+
+	if opts.pendingIf != nil {
+		return nil, nil, fmt.Errorf("--if-param %s needs a --path after it", opts.pendingIf.IfParam)
+	}
+
+	if err := resolveAliases(opts); err != nil {
+		return nil, nil, err
+	}
+
+	opts.PageSize = clampPageSize(opts.PageSize)
+
+	maxPagesSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "max-pages" {
+			maxPagesSet = true
+		}
+	})
+	if !maxPagesSet {
+		opts.MaxPages = scaleMaxPages(opts.PageSize, opts.AdaptivePageSize)
+	}
+
+	return opts, fs.Args(), nil
+}
+
+// resolveAliases replaces every @NAME --path and --overlay with the path
+// from the --aliases file
+func resolveAliases(opts *options) error {
+	if opts.AliasFile == "" {
+		opts.AliasFile = os.Getenv(aliasesEnv)
+	}
+
+	var aliases map[string]pathSpec
+	for _, specs := range [][]pathSpec{opts.Paths, opts.Overlays} {
+		for i, spec := range specs {
+			if spec.Alias == "" {
+				continue
+			}
+
+			if aliases == nil {
+				if opts.AliasFile == "" {
+					return fmt.Errorf("can't resolve @%s without --aliases or %s", spec.Alias, aliasesEnv)
+				}
+
+				var err error
+				if aliases, err = loadAliases(opts.AliasFile); err != nil {
+					return err
+				}
+			}
+
+			resolved, err := resolveAlias(spec, aliases)
+			if err != nil {
+				return err
+			}
+			specs[i] = resolved
+		}
+	}
+
+	return nil
+}
+
+// pathSpec is a path to load, along with the role to assume and the
+// region to load it from
+type pathSpec struct {
+	Path   string
+	Role   string
+	Region string
+
+	// IfParam and IfValue are the --if-param guarding the path, if any
+	IfParam string
+	IfValue string
+
+	// Alias is the NAME of an @NAME path until it's resolved
+	Alias string
+}
+
+// parsePathSpec parses PATH[@REGION][:ROLE_ARN], where PATH may be an
+// @NAME alias, resolved once the alias file is read
+func parsePathSpec(s string) (pathSpec, error) {
+	spec := pathSpec{Path: s}
+
+	if i := strings.Index(s, ":arn:"); i != -1 {
+		spec.Path, spec.Role = s[:i], s[i+1:]
+	}
+
+	alias := strings.HasPrefix(spec.Path, "@")
+	if alias {
+		spec.Path = spec.Path[1:]
+	}
+
+	// Parameter names can't contain @, so it can only be the region
+	if i := strings.LastIndex(spec.Path, "@"); i != -1 {
+		spec.Path, spec.Region = spec.Path[:i], spec.Path[i+1:]
+		if spec.Region == "" {
+			return pathSpec{}, fmt.Errorf("invalid --path %q, no region after @", s)
+		}
+	}
+
+	if alias {
+		if spec.Path == "" {
+			return pathSpec{}, fmt.Errorf("invalid --path %q, no alias name after @", s)
+		}
+		spec.Alias, spec.Path = spec.Path, ""
+		return spec, nil
+	}
+
+	if !strings.HasPrefix(spec.Path, "/") {
+		return pathSpec{}, fmt.Errorf("invalid --path %q, paths must start with /", s)
+	}
+
+	spec.Path = normalizePath(spec.Path)
+	return spec, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseRefreshKey(s string) (refreshKey, error) {
+	pair := strings.SplitN(s, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return refreshKey{}, fmt.Errorf("invalid --refresh-key %q, expected KEY=interval", s)
+	}
+
+	interval, err := time.ParseDuration(pair[1])
+	if err != nil || interval <= 0 {
+		return refreshKey{}, fmt.Errorf("invalid --refresh-key interval %q", pair[1])
+	}
+
+	return refreshKey{Key: pair[0], Interval: interval}, nil
+}
+
+// printFlags lists every flag with its description and default, wrapping
+// descriptions to fit a terminal
+func printFlags(w io.Writer, fs *flag.FlagSet) {
+	long := make(map[string]string)
+	for short, name := range shortFlags {
+		long[name] = short
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, isShort := shortFlags[f.Name]; isShort {
+			return
+		}
+
+		name, usage := flag.UnquoteUsage(f)
+		line := "--" + f.Name
+		if len(f.Name) == 1 {
+			line = "-" + f.Name
+		}
+		if short, exists := long[f.Name]; exists {
+			line = "-" + short + ", " + line
+		}
+		if name != "" {
+			line += " " + name
+		}
+
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0s" && f.DefValue != "[]" {
+			usage += fmt.Sprintf(" (default %q)", f.DefValue)
+		}
+
+		fmt.Fprintln(w, "  "+line)
+		for _, l := range wrap(usage, 64) {
+			fmt.Fprintln(w, "              "+l)
+		}
+	})
+}
+
+// wrap breaks s into lines of at most width characters at spaces
+func wrap(s string, width int) []string {
+	var lines []string
+	line := ""
+
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+
+	return append(lines, line)
+}
+
+func printUsage() {
+	w := os.Stdout
+
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Usage:  ssm-loader [options] [command] [--then command...]")
+	fmt.Fprintln(w, "        ssm-loader [options] export [--fish]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Loads parameters from the SSM Parameter Store")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands separated by --then run one after another with the same env,")
+	fmt.Fprintln(w, "stopping at the first that fails.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  export    Prints the env as export statements for bash/zsh, to use")
+	fmt.Fprintln(w, "            with eval \"$(ssm-loader export)\". With --fish, prints")
+	fmt.Fprintln(w, "            set -gx statements for fish, or see --export-format")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "The rest start with a colon, so a program of the same name can still be run:")
+	fmt.Fprintln(w, "  :connect  :connect unix:///PATH [command...] runs the command with")
+	fmt.Fprintln(w, "            the env from a --serve server instead of SSM, or prints the")
+	fmt.Fprintln(w, "            env with no command")
+	fmt.Fprintln(w, "  :probe    :probe --path PATH [--iterations N] fetches PATH N times")
+	fmt.Fprintln(w, "            (default 10) and prints the p50, p95 and max latency of the")
+	fmt.Fprintln(w, "            fetches and their requests, with retries and throttles")
+	fmt.Fprintln(w, "  :warmup   Keeps the --cache-file fresh by fetching every half")
+	fmt.Fprintln(w, "            --cache-ttl, so other invocations start without calling SSM")
+	fmt.Fprintln(w, "  :find     :find KEY [PATH] lists the parameters under PATH (default /)")
+	fmt.Fprintln(w, "            named KEY, with their type. Values are shown as *** unless")
+	fmt.Fprintln(w, "            --values is given")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Environment Variables:")
+	fmt.Fprintln(w, "  APP_ENV   The application's environment")
+	fmt.Fprintln(w, "  APP_NAME  The name of the application")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Options:")
+	printFlags(w, newFlagSet(defaultOptions()))
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Exit Codes:")
+	fmt.Fprintln(w, "  0       Success")
+	fmt.Fprintln(w, "  1       General error")
+	fmt.Fprintln(w, "  2       Invalid options or missing environment")
+	fmt.Fprintln(w, "  3       No valid AWS credentials")
+	fmt.Fprintln(w, "  4       Access denied")
+	fmt.Fprintln(w, "  5       Throttled by AWS after retries")
+	fmt.Fprintln(w, "  6       Parameter not found")
+	fmt.Fprintln(w, "  7       Parameters couldn't be decrypted")
+	fmt.Fprintln(w, "  126     Command couldn't be started")
+	fmt.Fprintln(w, "  127     Command not found")
+	fmt.Fprintln(w, "  Otherwise the command's own exit code, or 128+n if it was killed by signal n")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRefreshKey(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    refreshKey
+		wantErr bool
+	}{
+		{in: "DB_PASSWORD=30s", want: refreshKey{Key: "DB_PASSWORD", Interval: 30 * time.Second}},
+		{in: "FLAG=1m30s", want: refreshKey{Key: "FLAG", Interval: 90 * time.Second}},
+		{in: "FLAG", wantErr: true},
+		{in: "=30s", wantErr: true},
+		{in: "FLAG=soon", wantErr: true},
+		{in: "FLAG=0s", wantErr: true},
+		{in: "FLAG=-1s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRefreshKey(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArgsFlattenDelimiter(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{args: nil, want: "_"},
+		{args: []string{"--flatten-delimiter", "__"}, want: "__"},
+		{args: []string{"--flatten-delimiter", ""}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		opts, _, err := parseArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+		if err == nil && opts.FlattenDelimiter != tt.want {
+			t.Errorf("parseArgs(%q) delimiter = %q, want %q", tt.args, opts.FlattenDelimiter, tt.want)
+		}
+	}
+}
+
+func TestParsePathSpec(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    pathSpec
+		wantErr bool
+	}{
+		{in: "/shared", want: pathSpec{Path: "/shared/"}},
+		{in: "/shared/:arn:aws:iam::111111111111:role/shared-reader", want: pathSpec{Path: "/shared/", Role: "arn:aws:iam::111111111111:role/shared-reader"}},
+		{in: "/shared@eu-west-1", want: pathSpec{Path: "/shared/", Region: "eu-west-1"}},
+		{
+			in:   "/shared/@eu-west-1:arn:aws-us-gov:iam::111111111111:role/reader",
+			want: pathSpec{Path: "/shared/", Region: "eu-west-1", Role: "arn:aws-us-gov:iam::111111111111:role/reader"},
+		},
+		{in: "@common", want: pathSpec{Alias: "common"}},
+		{in: "@common@eu-west-1", want: pathSpec{Alias: "common", Region: "eu-west-1"}},
+		{in: "shared", wantErr: true},
+		{in: "/shared@", wantErr: true},
+		{in: "@", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parsePathSpec(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHelpListsFlags(t *testing.T) {
+	var buf bytes.Buffer
+	fs := newFlagSet(&options{})
+	printFlags(&buf, fs)
+	help := buf.String()
+
+	for _, want := range []string{
+		"  --region REGION\n",
+		"  -v, --verbose\n",
+		"  -h, --help\n",
+		"  -O\n",
+		"(default \"_\")",
+	} {
+		if !strings.Contains(help, want) {
+			t.Errorf("help doesn't contain %q", want)
+		}
+	}
+
+	// Every flag is listed, so help can't drift from what's parsed
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, isShort := shortFlags[f.Name]; isShort || len(f.Name) == 1 {
+			return
+		}
+		if !strings.Contains(help, "--"+f.Name) {
+			t.Errorf("help doesn't list --%s", f.Name)
+		}
+	})
+}
+
+func TestHelpCommand(t *testing.T) {
+	stdout, _, code := runMain(t, nil, nil, "--help")
+	if code != exitOK || !strings.Contains(stdout, "--flatten-delimiter DELIM") {
+		t.Errorf("exit code = %d, output %q, want --flatten-delimiter listed", code, stdout)
+	}
+}
+
+func TestParseArgsCommand(t *testing.T) {
+	tests := []struct {
+		args   []string
+		want   []string
+		output bool
+	}{
+		{args: []string{"--region", "eu-west-1", "node", "server.js", "--port", "80"}, want: []string{"node", "server.js", "--port", "80"}},
+		{args: []string{"-O"}, want: []string{}, output: true},
+		{args: []string{"-O", "--", "-not-a-flag"}, want: []string{"-not-a-flag"}, output: true},
+	}
+
+	for _, tt := range tests {
+		opts, args, err := parseArgs(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, tt.want) || opts.Output != tt.output {
+			t.Errorf("parseArgs(%q) = %q, -O %v, want %q, %v", tt.args, args, opts.Output, tt.want, tt.output)
+		}
+	}
+}
+
+func TestParseArgsIfParam(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []pathSpec
+		wantErr string
+	}{
+		{
+			name: "guards the next path",
+			args: []string{"--if-param", "/flags/beta=on", "--path", "/beta", "--path", "/always"},
+			want: []pathSpec{
+				{Path: "/beta/", IfParam: "/flags/beta", IfValue: "on"},
+				{Path: "/always/"},
+			},
+		},
+		{
+			name: "each path its own guard",
+			args: []string{"--if-param", "/flags/a=1", "--path", "/a", "--if-param", "/flags/b=", "--path", "/b"},
+			want: []pathSpec{
+				{Path: "/a/", IfParam: "/flags/a", IfValue: "1"},
+				{Path: "/b/", IfParam: "/flags/b", IfValue: ""},
+			},
+		},
+		{
+			name: "value with =",
+			args: []string{"--if-param", "/flags/mode=a=b", "--path", "/a"},
+			want: []pathSpec{{Path: "/a/", IfParam: "/flags/mode", IfValue: "a=b"}},
+		},
+		{name: "no path after", args: []string{"--path", "/a", "--if-param", "/flags/a=1"}, wantErr: "--if-param /flags/a needs a --path after it"},
+		{name: "no value", args: []string{"--if-param", "/flags/a", "--path", "/a"}, wantErr: "expected /NAME=VALUE"},
+		{name: "not a name", args: []string{"--if-param", "BETA=on", "--path", "/a"}, wantErr: "expected /NAME=VALUE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, _, err := parseArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseArgs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opts.Paths, tt.want) {
+				t.Errorf("paths = %+v, want %+v", opts.Paths, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// overlayDefault is the prefix of overlay keys used when there's no
+// variant for the current environment
+const overlayDefault = "default"
+
+// defaultOverlayEnvs are the prefixes taken as other environments'
+// variants in an overlay path, when there's no --overlay-envs
+var defaultOverlayEnvs = []string{"dev", "development", "test", "qa", "staging", "stage", "prod", "production"}
+
+// overlayOptions is how the env prefixes of params under the --overlay
+// paths are resolved. Only the prefixes of known environments count, so
+// a dotted name such as log.level is kept whole.
+type overlayOptions struct {
+	Paths []string
+
+	// Env is the environment whose variants win, and OtherEnvs the ones
+	// whose variants are dropped
+	Env       string
+	OtherEnvs []string
+}
+
+// split returns name without its env prefix and how it ranks: 2 for the
+// variant for Env, 1 for the default and 0 for a name with no prefix.
+// It's false for another environment's variant.
+func (o *overlayOptions) split(name string) (string, int, bool) {
+	dir, base := path.Split(name)
+
+	i := strings.Index(base, ".")
+	if i == -1 {
+		return name, 0, true
+	}
+
+	switch prefix := base[:i]; {
+	case prefix == o.Env:
+		return dir + base[i+1:], 2, true
+	case prefix == overlayDefault:
+		return dir + base[i+1:], 1, true
+	}
+
+	for _, env := range o.OtherEnvs {
+		if base[:i] == env {
+			return "", 0, false
+		}
+	}
+	return name, 0, true
+}
+
+// KeyName is the name the key for the parameter name comes from, which
+// for one under an overlay path is its name without the env prefix
+func (o *overlayOptions) KeyName(name string) string {
+	if o == nil {
+		return name
+	}
+
+	for _, p := range o.Paths {
+		if strings.HasPrefix(name, strings.TrimSuffix(p, "/")+"/") {
+			if stripped, _, ok := o.split(name); ok {
+				return stripped
+			}
+		}
+	}
+	return name
+}
+
+// overlayParams resolves parameters stored under one path with env
+// prefixed names, such as prod.DB_HOST and default.DB_HOST. For each key
+// the variant for o.Env wins over the default one, then over a name with
+// no prefix at all. Variants for other environments are dropped. The
+// winners keep their names in SSM, so they can be polled and described;
+// KeyName strips the prefix when they're turned into keys.
+func overlayParams(params []*ssm.Parameter, o *overlayOptions) []*ssm.Parameter {
+	type candidate struct {
+		Param *ssm.Parameter
+		Rank  int
+	}
+	best := make(map[string]candidate)
+
+	for _, param := range params {
+		stripped, rank, ok := o.split(aws.StringValue(param.Name))
+		if !ok {
+			continue
+		}
+
+		if current, exists := best[stripped]; exists && current.Rank >= rank {
+			continue
+		}
+		best[stripped] = candidate{Param: param, Rank: rank}
+	}
+
+	names := make([]string, 0, len(best))
+	for name := range best {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resolved := make([]*ssm.Parameter, len(names))
+	for i, name := range names {
+		resolved[i] = best[name].Param
+	}
+	return resolved
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestOverlayParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		env    string
+		envs   []string
+		want   map[string]string
+	}{
+		{
+			"env wins over default",
+			map[string]string{"/o/prod.DB_HOST": "prod-db", "/o/default.DB_HOST": "db"},
+			"prod",
+			nil,
+			map[string]string{"/o/DB_HOST": "prod-db"},
+		},
+		{
+			"default without an env variant",
+			map[string]string{"/o/staging.DB_HOST": "staging-db", "/o/default.DB_HOST": "db"},
+			"prod",
+			nil,
+			map[string]string{"/o/DB_HOST": "db"},
+		},
+		{
+			"default wins over no prefix",
+			map[string]string{"/o/DB_HOST": "plain", "/o/default.DB_HOST": "db"},
+			"prod",
+			nil,
+			map[string]string{"/o/DB_HOST": "db"},
+		},
+		{
+			"no prefix is kept",
+			map[string]string{"/o/DB_HOST": "plain"},
+			"prod",
+			nil,
+			map[string]string{"/o/DB_HOST": "plain"},
+		},
+		{
+			"other environments dropped",
+			map[string]string{"/o/staging.DB_HOST": "staging-db", "/o/dev.DB_HOST": "dev-db"},
+			"prod",
+			nil,
+			map[string]string{},
+		},
+		{
+			"dotted names kept",
+			map[string]string{"/o/log.level": "debug", "/o/default.log.level": "info"},
+			"prod",
+			nil,
+			map[string]string{"/o/log.level": "info"},
+		},
+		{
+			"other environments replaced",
+			map[string]string{"/o/eu.DB_HOST": "eu-db", "/o/staging.DB_HOST": "staging-db"},
+			"prod",
+			[]string{"eu"},
+			map[string]string{"/o/staging.DB_HOST": "staging-db"},
+		},
+		{
+			"nested paths kept apart",
+			map[string]string{"/o/a/prod.KEY": "a", "/o/b/default.KEY": "b"},
+			"prod",
+			nil,
+			map[string]string{"/o/a/KEY": "a", "/o/b/KEY": "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var params []*ssm.Parameter
+			for name, value := range test.params {
+				params = append(params, &ssm.Parameter{Name: aws.String(name), Value: aws.String(value)})
+			}
+
+			o := &overlayOptions{Paths: []string{"/o"}, Env: test.env, OtherEnvs: test.envs}
+			if o.OtherEnvs == nil {
+				o.OtherEnvs = defaultOverlayEnvs
+			}
+
+			got := make(map[string]string)
+			for _, param := range overlayParams(params, o) {
+				name := aws.StringValue(param.Name)
+				got[o.KeyName(name)] = aws.StringValue(param.Value)
+
+				// The winners keep their names in SSM so they can be polled
+				if _, exists := test.params[name]; !exists {
+					t.Errorf("param renamed to %s", name)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("overlayParams() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOverlayParamsSorted(t *testing.T) {
+	params := []*ssm.Parameter{
+		{Name: aws.String("/o/default.C")},
+		{Name: aws.String("/o/prod.A")},
+		{Name: aws.String("/o/B")},
+	}
+
+	o := &overlayOptions{Paths: []string{"/o"}, Env: "prod", OtherEnvs: defaultOverlayEnvs}
+	want := []string{"/o/prod.A", "/o/B", "/o/default.C"}
+	if got := names(overlayParams(params, o)); !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %q, want %q", got, want)
+	}
+}
+
+func TestOverlayFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/OV_NAME", Value: "from-app"},
+		{Name: "/features/prod.OV_FLAG", Value: "on"},
+		{Name: "/features/default.OV_FLAG", Value: "off"},
+		{Name: "/features/default.OV_LIMIT", Value: "10"},
+		{Name: "/features/staging.OV_DEBUG", Value: "true"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	stdout, stderr, code := runMain(t, params, env, "--overlay", "/features", "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	want := "OV_FLAG=on\nOV_LIMIT=10\nOV_NAME=from-app\n"
+	if got := envLines(stdout, "OV_"); got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+}
+
+func TestOverlayKey(t *testing.T) {
+	po := &paramOptions{
+		KeyFormat: keyFormatBasename,
+		Overlay:   &overlayOptions{Paths: []string{"/features/"}, Env: "prod", OtherEnvs: defaultOverlayEnvs},
+	}
+
+	tests := map[string]string{
+		"/features/prod.FLAG":    "FLAG",
+		"/features/default.FLAG": "FLAG",
+		"/features/log.level":    "log.level",
+		"/prod/web/default.FLAG": "default.FLAG",
+	}
+	for name, want := range tests {
+		if got := po.Key(name); got != want {
+			t.Errorf("Key(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestOverlayDescribe(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/features/prod.OV_FLAG", Value: "on", Description: "Rollout flag"},
+		{Name: "/features/default.OV_FLAG", Value: "off"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	// Describing the overlay's winner needs its name in SSM
+	stdout, stderr, code := runMain(t, params, env, "--overlay", "/features", "--tree", "--describe")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if want := "prod.OV_FLAG  # Rollout flag"; !strings.Contains(stdout, want) {
+		t.Errorf("output =\n%s\nwant it to contain %q", stdout, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type probeOptions struct {
+	Path       string
+	Iterations int
+}
+
+func parseProbeArgs(args []string) (*probeOptions, error) {
+	popts := &probeOptions{Iterations: 10}
+	usage := fmt.Errorf("usage: ssm-loader :probe --path PATH [--iterations N]")
+
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return nil, usage
+		}
+
+		switch args[i] {
+		case "--path":
+			popts.Path = normalizePath(args[i+1])
+		case "--iterations":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid --iterations %q, expected a positive number", args[i+1])
+			}
+			popts.Iterations = n
+		default:
+			return nil, usage
+		}
+		i++
+	}
+
+	if popts.Path == "" {
+		return nil, usage
+	}
+
+	return popts, nil
+}
+
+// probe fetches popts.Path in full popts.Iterations times, nothing else,
+// and writes the latency of the fetches and of the requests they made,
+// as recorded by stats, along with throttles and errors. Fetch times
+// include the pause before each page, as they would when loading.
+func probe(client ssmiface.SSMAPI, stats *fetchStats, w io.Writer, popts *probeOptions, opts *options) {
+	var fetches []time.Duration
+	for i := 0; i < popts.Iterations; i++ {
+		start := time.Now()
+		_, err := getParameters(&getParametersInput{
+			Client:   client,
+			Path:     aws.String(popts.Path),
+			PageSize: int64(opts.PageSize),
+		})
+		fetches = append(fetches, time.Since(start))
+
+		if err != nil {
+			debugf("Fetch %d failed: %s", i+1, err)
+		}
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	fmt.Fprintf(w, "fetches:   %s\n", latencySummary(fetches))
+	fmt.Fprintf(w, "requests:  %s\n", latencySummary(stats.Latencies))
+	fmt.Fprintf(w, "retries:   %d\n", stats.Attempts-stats.Requests)
+	fmt.Fprintf(w, "throttles: %d\n", stats.Throttles)
+	fmt.Fprintf(w, "errors:    %d\n", stats.Errors)
+}
+
+// latencySummary formats the count, p50, p95 and max of latencies
+func latencySummary(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "0"
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+
+	return fmt.Sprintf("%d  p50 %s  p95 %s  max %s", len(sorted),
+		percentile(50).Round(time.Millisecond), percentile(95).Round(time.Millisecond), sorted[len(sorted)-1].Round(time.Millisecond))
+}
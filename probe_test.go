@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestParseProbeArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    *probeOptions
+		wantErr string
+	}{
+		{args: []string{"--path", "/prod/app"}, want: &probeOptions{Path: "/prod/app/", Iterations: 10}},
+		{args: []string{"--iterations", "3", "--path", "/prod/app/"}, want: &probeOptions{Path: "/prod/app/", Iterations: 3}},
+		{args: nil, wantErr: "usage: ssm-loader :probe"},
+		{args: []string{"--iterations", "3"}, wantErr: "usage: ssm-loader :probe"},
+		{args: []string{"--path"}, wantErr: "usage: ssm-loader :probe"},
+		{args: []string{"--path", "/a", "--verbose", "1"}, wantErr: "usage: ssm-loader :probe"},
+		{args: []string{"--path", "/a", "--iterations", "0"}, wantErr: `invalid --iterations "0", expected a positive number`},
+		{args: []string{"--path", "/a", "--iterations", "many"}, wantErr: `invalid --iterations "many", expected a positive number`},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			got, err := parseProbeArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseProbeArgs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProbeArgs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatencySummary(t *testing.T) {
+	ms := func(n ...int) []time.Duration {
+		var d []time.Duration
+		for _, i := range n {
+			d = append(d, time.Duration(i)*time.Millisecond)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		want      string
+	}{
+		{"none", nil, "0"},
+		{"one", ms(12), "1  p50 12ms  p95 12ms  max 12ms"},
+		{"unsorted", ms(30, 10, 20), "3  p50 20ms  p95 20ms  max 30ms"},
+		{"many", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 100), "21  p50 11ms  p95 20ms  max 100ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latencySummary(tt.latencies); got != tt.want {
+				t.Errorf("latencySummary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	fake := newFakeSSM(
+		fakeParam{Name: "/prod/app/A", Value: "a"},
+		fakeParam{Name: "/prod/app/B", Value: "b"},
+		fakeParam{Name: "/prod/other/C", Value: "c"},
+	)
+
+	// The handlers on a real session fill these in
+	stats := &fetchStats{Requests: 6, Attempts: 8, Throttles: 2, Errors: 1, Latencies: []time.Duration{10 * time.Millisecond}}
+
+	var out bytes.Buffer
+	probe(fake, stats, &out, &probeOptions{Path: "/prod/app/", Iterations: 3}, &options{PageSize: 10})
+
+	if calls := fake.Calls("GetParametersByPath"); calls != 3 {
+		t.Errorf("%d GetParametersByPath calls, want one fetch per iteration", calls)
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 6 || !strings.HasPrefix(lines[0], "fetches:   3  p50 ") {
+		t.Fatalf("output =\n%s", out.String())
+	}
+	want := "requests:  1  p50 10ms  p95 10ms  max 10ms\nretries:   2\nthrottles: 2\nerrors:    1\n"
+	if got := strings.Join(lines[1:], "\n"); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestProbeFailures(t *testing.T) {
+	fake := newFakeSSM()
+	fake.Err = awserr.New("ThrottlingException", "Rate exceeded", nil)
+
+	// Failed fetches are still timed, and don't stop the probe
+	var out bytes.Buffer
+	probe(fake, &fetchStats{}, &out, &probeOptions{Path: "/prod/app/", Iterations: 2}, &options{PageSize: 10})
+
+	if calls := fake.Calls("GetParametersByPath"); calls != 2 {
+		t.Errorf("%d GetParametersByPath calls, want 2", calls)
+	}
+	if !strings.HasPrefix(out.String(), "fetches:   2  p50 ") {
+		t.Errorf("output =\n%s", out.String())
+	}
+}
+
+func TestProbeCommand(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/app/A", Value: "a"}}
+
+	stdout, stderr, code := runMain(t, params, nil, ":probe", "--path", "/prod/app", "--iterations", "2")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	for _, prefix := range []string{"fetches:   2  p50 ", "requests:  ", "retries:   ", "throttles: ", "errors:    "} {
+		if !strings.Contains(stdout, "\n"+prefix) && !strings.HasPrefix(stdout, prefix) {
+			t.Errorf("stdout = %q, want a %q line", stdout, prefix)
+		}
+	}
+	if strings.Contains(stdout, "A=a") {
+		t.Errorf("probe printed values: %q", stdout)
+	}
+
+	if _, _, code := runMain(t, params, nil, ":probe"); code != exitUsage {
+		t.Errorf("exit code = %d without --path, want %d", code, exitUsage)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// redacted holds the values --redact-output hides from the command's
+// output. Each source adds its secrets as it's loaded, which in watch
+// mode can be while a command's output is being redacted.
+var (
+	redacted   []string
+	redactedMu sync.RWMutex
+)
+
+// addRedacted adds values to those redacted, keeping them longest first
+// so a secret that contains another is replaced whole
+func addRedacted(values []string) {
+	redactedMu.Lock()
+	defer redactedMu.Unlock()
+
+	for _, value := range values {
+		if value != "" {
+			redacted = append(redacted, value)
+		}
+	}
+	sort.Slice(redacted, func(i, j int) bool { return len(redacted[i]) > len(redacted[j]) })
+}
+
+// secureValues returns the values of the SecureStrings in params
+func secureValues(params []*ssm.Parameter) []string {
+	var values []string
+	for _, param := range params {
+		if aws.StringValue(param.Type) == ssm.ParameterTypeSecureString {
+			values = append(values, aws.StringValue(param.Value))
+		}
+	}
+	return values
+}
+
+// redactWriter replaces redacted values with *** before writing to W.
+// It works a line at a time, so output is held until a newline (or the
+// command exits) and a value spanning lines isn't caught.
+type redactWriter struct {
+	W io.Writer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf = append(r.buf, p...)
+
+	if i := bytes.LastIndexByte(r.buf, '\n'); i != -1 {
+		if _, err := r.W.Write(redact(r.buf[:i+1])); err != nil {
+			return 0, err
+		}
+		r.buf = append(r.buf[:0], r.buf[i+1:]...)
+	}
+
+	return len(p), nil
+}
+
+// Flush writes out anything still waiting for a newline
+func (r *redactWriter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.buf) == 0 {
+		return nil
+	}
+
+	_, err := r.W.Write(redact(r.buf))
+	r.buf = r.buf[:0]
+	return err
+}
+
+func redact(b []byte) []byte {
+	redactedMu.RLock()
+	defer redactedMu.RUnlock()
+
+	for _, value := range redacted {
+		b = bytes.Replace(b, []byte(value), []byte("***"), -1)
+	}
+	return b
+}
+
+// waitCommand waits for cmd and flushes any redacted output it left
+// without a trailing newline
+func waitCommand(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	for _, w := range []io.Writer{cmd.Stdout, cmd.Stderr} {
+		if rw, ok := w.(*redactWriter); ok {
+			rw.Flush()
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// withRedacted sets the values redacted for the rest of the test
+func withRedacted(t *testing.T, values ...string) {
+	t.Helper()
+
+	redactedMu.Lock()
+	saved := redacted
+	redacted = nil
+	redactedMu.Unlock()
+
+	addRedacted(values)
+	t.Cleanup(func() {
+		redactedMu.Lock()
+		redacted = saved
+		redactedMu.Unlock()
+	})
+}
+
+func TestRedactWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{name: "whole line", writes: []string{"password is hunter2\n"}, want: "password is ***\n"},
+		{name: "secret split across writes", writes: []string{"password is hun", "ter2\n"}, want: "password is ***\n"},
+		{name: "several in a line", writes: []string{"hunter2 and s3cr3t-token\n"}, want: "*** and ***\n"},
+		{name: "secret containing another", writes: []string{"key=s3cr3t-token-long\n"}, want: "key=***\n"},
+		{name: "no trailing newline", writes: []string{"line one hunter2\nline two hunter2"}, want: "line one ***\nline two ***"},
+		{name: "clean output", writes: []string{"nothing to hide\n"}, want: "nothing to hide\n"},
+	}
+
+	withRedacted(t, "hunter2", "s3cr3t-token", "s3cr3t-token-long", "")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := &redactWriter{W: &out}
+
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactWriterHoldsPartialLines(t *testing.T) {
+	withRedacted(t, "hunter2")
+
+	var out bytes.Buffer
+	w := &redactWriter{W: &out}
+
+	w.Write([]byte("done\npassword is hun"))
+	if out.String() != "done\n" {
+		t.Errorf("output = %q before the line ended, want only the finished line", out.String())
+	}
+}
+
+func TestSecureValues(t *testing.T) {
+	params := []*ssm.Parameter{
+		{Name: aws.String("/prod/app/HOST"), Value: aws.String("db"), Type: aws.String(ssm.ParameterTypeString)},
+		{Name: aws.String("/prod/app/PASSWORD"), Value: aws.String("hunter2"), Type: aws.String(ssm.ParameterTypeSecureString)},
+		{Name: aws.String("/prod/app/HOSTS"), Value: aws.String("a,b"), Type: aws.String(ssm.ParameterTypeStringList)},
+	}
+
+	if got := secureValues(params); !reflect.DeepEqual(got, []string{"hunter2"}) {
+		t.Errorf("secureValues() = %q, want only the SecureString", got)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+type renderPair struct {
+	Template string
+	Output   string
+	Defaults string
+}
+
+func parseRenderPair(s string) (renderPair, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] == "") {
+		return renderPair{}, fmt.Errorf("invalid --render %q, expected template:output[:defaults]", s)
+	}
+
+	pair := renderPair{Template: parts[0], Output: parts[1]}
+	if len(parts) == 3 {
+		pair.Defaults = parts[2]
+	}
+	return pair, nil
+}
+
+// readDefaults reads a file of KEY=VALUE lines
+func readDefaults(path string) (paramMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseEnvLines(data, path)
+}
+
+// Render executes each Go template with m as its data, so {{.DB_HOST}}
+// is the value of DB_HOST, and writes the result to its output file.
+// When strict is set a key missing from m is an error, otherwise it
+// renders as an empty string. A pair's defaults file fills in keys m
+// doesn't have; anything loaded from SSM or the environment wins.
+func (m paramMap) Render(pairs []renderPair, strict bool) error {
+	missingKey := "missingkey=zero"
+	if strict {
+		missingKey = "missingkey=error"
+	}
+
+	for _, pair := range pairs {
+		tmpl, err := template.New(filepath.Base(pair.Template)).Option(missingKey).ParseFiles(pair.Template)
+		if err != nil {
+			return err
+		}
+
+		data := m
+		if pair.Defaults != "" {
+			defaults, err := readDefaults(pair.Defaults)
+			if err != nil {
+				return err
+			}
+
+			data = defaults.Copy()
+			for key, value := range m {
+				data[key] = value
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, map[string]string(data)); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(pair.Output), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(pair.Output, buf.Bytes(), 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var envsubstVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// envsubstFile is a file for Envsubst, read before anything is written
+// so it stays a template when it's rewritten in place
+type envsubstFile struct {
+	Path string
+	Dest string
+	Data []byte
+	Mode os.FileMode
+}
+
+// readEnvsubstFiles reads each file matching globs. A file ending in
+// suffix (when set) is written next to itself without it, so
+// app.conf.tmpl becomes app.conf; any other is rewritten in place.
+func readEnvsubstFiles(globs []string, suffix string) ([]envsubstFile, error) {
+	var files []envsubstFile
+
+	for _, glob := range globs {
+		paths, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			// The output keeps the mode of the file it came from
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+
+			dest := path
+			if suffix != "" && strings.HasSuffix(path, suffix) {
+				dest = strings.TrimSuffix(path, suffix)
+			}
+
+			files = append(files, envsubstFile{Path: path, Dest: dest, Data: data, Mode: info.Mode().Perm()})
+		}
+	}
+
+	return files, nil
+}
+
+// Envsubst replaces ${KEY} and ${KEY:-default} in each of files with
+// values from m, like envsubst, and writes them out. A key missing from m
+// with no default is an empty string, or an error when strict is set.
+func (m paramMap) Envsubst(files []envsubstFile, strict bool) error {
+	for _, file := range files {
+		var missing []string
+		out := envsubstVar.ReplaceAllStringFunc(string(file.Data), func(s string) string {
+			match := envsubstVar.FindStringSubmatch(s)
+			if value, ok := m[match[1]]; ok {
+				return value
+			}
+			if match[2] == "" {
+				missing = append(missing, match[1])
+			}
+			return match[3]
+		})
+
+		if strict && len(missing) > 0 {
+			return fmt.Errorf("%s: %s not set", file.Path, strings.Join(missing, ", "))
+		}
+
+		if err := ioutil.WriteFile(file.Dest, []byte(out), file.Mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
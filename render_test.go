@@ -0,0 +1,272 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeFile writes data to name in dir and returns its path
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readFile returns the contents of path
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseRenderPair(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    renderPair
+		wantErr bool
+	}{
+		{in: "nginx.conf.tmpl:/etc/nginx/nginx.conf", want: renderPair{Template: "nginx.conf.tmpl", Output: "/etc/nginx/nginx.conf"}},
+		{in: "nginx.conf.tmpl", wantErr: true},
+		{in: ":/etc/nginx/nginx.conf", wantErr: true},
+		{in: "nginx.conf.tmpl:", wantErr: true},
+		{in: "app.tmpl:app.conf:defaults.env", want: renderPair{Template: "app.tmpl", Output: "app.conf", Defaults: "defaults.env"}},
+		{in: "app.tmpl:app.conf:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRenderPair(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := tempDir(t)
+	nginx := writeFile(t, dir, "nginx.conf.tmpl", "upstream app { server {{.APP_HOST}}:{{.APP_PORT}}; }\n")
+	app := writeFile(t, dir, "app.yaml.tmpl", "db: {{.DB_HOST}}\ndebug: {{.DEBUG}}\n")
+
+	m := paramMap{"APP_HOST": "10.0.0.1", "APP_PORT": "8080", "DB_HOST": "db.internal"}
+
+	tests := []struct {
+		name    string
+		strict  bool
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "missing keys are empty",
+			want: map[string]string{
+				"nginx.conf": "upstream app { server 10.0.0.1:8080; }\n",
+				"app.yaml":   "db: db.internal\ndebug: \n",
+			},
+		},
+		{name: "missing keys fail when strict", strict: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := tempDir(t)
+			pairs := []renderPair{
+				{Template: nginx, Output: filepath.Join(out, "nginx.conf")},
+				{Template: app, Output: filepath.Join(out, "conf", "app.yaml")},
+			}
+
+			err := m.Render(pairs, tt.strict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for name, want := range tt.want {
+				path := filepath.Join(out, name)
+				if name == "app.yaml" {
+					path = filepath.Join(out, "conf", name)
+				}
+				if got := readFile(t, path); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderDefaults(t *testing.T) {
+	dir := tempDir(t)
+	tmpl := writeFile(t, dir, "app.tmpl", "host={{.DB_HOST}} port={{.DB_PORT}} pool={{.POOL}}\n")
+	defaults := writeFile(t, dir, "defaults.env", "DB_HOST=localhost\nDB_PORT=5432\nPOOL=5\n")
+
+	tests := []struct {
+		name     string
+		m        paramMap
+		strict   bool
+		defaults string
+		want     string
+		wantErr  bool
+	}{
+		{name: "all defaults", m: paramMap{}, defaults: defaults, want: "host=localhost port=5432 pool=5\n"},
+		{name: "SSM overrides some", m: paramMap{"DB_HOST": "prod-db", "POOL": "20"}, defaults: defaults, want: "host=prod-db port=5432 pool=20\n"},
+		{name: "empty SSM value wins", m: paramMap{"POOL": ""}, defaults: defaults, want: "host=localhost port=5432 pool=\n"},
+		{name: "defaults satisfy strict", m: paramMap{"DB_HOST": "prod-db"}, strict: true, defaults: defaults, want: "host=prod-db port=5432 pool=5\n"},
+		{name: "no defaults when strict", m: paramMap{"DB_HOST": "prod-db"}, strict: true, wantErr: true},
+		{name: "missing defaults file", m: paramMap{}, defaults: filepath.Join(dir, "missing.env"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(tempDir(t), "app.conf")
+
+			err := tt.m.Render([]renderPair{{Template: tmpl, Output: out, Defaults: tt.defaults}}, tt.strict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got := readFile(t, out); got != tt.want {
+					t.Errorf("rendered %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderDefaultsFlag(t *testing.T) {
+	dir := tempDir(t)
+	tmpl := writeFile(t, dir, "app.tmpl", "{{.DB_HOST}}:{{.DB_PORT}}\n")
+	defaults := writeFile(t, dir, "defaults.env", "DB_HOST=localhost\nDB_PORT=5432\n")
+	out := filepath.Join(dir, "app.conf")
+
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--render", tmpl+":"+out+":"+defaults, "--write-env", filepath.Join(dir, "env"))
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if got := readFile(t, out); got != "prod-db:5432\n" {
+		t.Errorf("rendered %q, want the SSM host and the default port", got)
+	}
+
+	// Defaults are only for the template, not the env
+	if env := readFile(t, filepath.Join(dir, "env")); strings.Contains(env, "DB_PORT") {
+		t.Errorf("--write-env has the default DB_PORT: %q", env)
+	}
+}
+
+func TestEnvsubst(t *testing.T) {
+	m := paramMap{"DB_HOST": "prod-db", "DB_PORT": "5432"}
+
+	tests := []struct {
+		name    string
+		data    string
+		strict  bool
+		want    string
+		wantErr string
+	}{
+		{name: "variables", data: "host=${DB_HOST} port=${DB_PORT}\n", want: "host=prod-db port=5432\n"},
+		{name: "set over default", data: "port=${DB_PORT:-3306}\n", want: "port=5432\n"},
+		{name: "default", data: "pool=${POOL:-5} url=${URL:-http://localhost:8080/}\n", want: "pool=5 url=http://localhost:8080/\n"},
+		{name: "missing is empty", data: "pool=[${POOL}]\n", want: "pool=[]\n"},
+		{name: "other syntax left alone", data: "$DB_HOST %%DB_HOST%% ${1} ${DB-HOST}\n", want: "$DB_HOST %%DB_HOST%% ${1} ${DB-HOST}\n"},
+		{name: "strict with defaults", data: "pool=${POOL:-5} empty=${EMPTY:-}\n", strict: true, want: "pool=5 empty=\n"},
+		{name: "strict missing", data: "${POOL} ${DB_HOST} ${USER}\n", strict: true, wantErr: "POOL, USER not set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tempDir(t), "app.conf", tt.data)
+
+			files, err := readEnvsubstFiles([]string{path}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = m.Envsubst(files, tt.strict)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Envsubst() error = %v, want %q", err, tt.wantErr)
+				}
+				if got := readFile(t, path); got != tt.data {
+					t.Errorf("the file was rewritten to %q on error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("rewrote the file to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadEnvsubstFiles(t *testing.T) {
+	dir := tempDir(t)
+	writeFile(t, dir, "app.conf.tmpl", "a")
+	writeFile(t, dir, "worker.conf.tmpl", "b")
+	writeFile(t, dir, "nginx.conf", "c")
+	for name, mode := range map[string]os.FileMode{"app.conf.tmpl": 0644, "worker.conf.tmpl": 0600, "nginx.conf": 0644} {
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := readEnvsubstFiles([]string{filepath.Join(dir, "*.tmpl"), filepath.Join(dir, "nginx.*")}, ".tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []envsubstFile{
+		{Path: filepath.Join(dir, "app.conf.tmpl"), Dest: filepath.Join(dir, "app.conf"), Data: []byte("a"), Mode: 0644},
+		{Path: filepath.Join(dir, "worker.conf.tmpl"), Dest: filepath.Join(dir, "worker.conf"), Data: []byte("b"), Mode: 0600},
+		{Path: filepath.Join(dir, "nginx.conf"), Dest: filepath.Join(dir, "nginx.conf"), Data: []byte("c"), Mode: 0644},
+	}
+	if runtime.GOOS == "windows" {
+		// Windows only has read-only or not
+		for i := range want {
+			want[i].Mode = 0666
+		}
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("readEnvsubstFiles() = %+v, want %+v", files, want)
+	}
+
+	if _, err := readEnvsubstFiles([]string{"["}, ""); err == nil {
+		t.Error("readEnvsubstFiles() with a bad glob didn't fail")
+	}
+}
+
+func TestEnvsubstFlag(t *testing.T) {
+	dir := tempDir(t)
+	writeFile(t, dir, "app.conf.tmpl", "host=${DB_HOST} pool=${POOL:-5}\n")
+
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+	args := []string{"--envsubst-files", filepath.Join(dir, "*.tmpl"), "--envsubst-suffix", ".tmpl", "--write-env", filepath.Join(dir, "env")}
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, args...)
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	if got := readFile(t, filepath.Join(dir, "app.conf")); got != "host=prod-db pool=5\n" {
+		t.Errorf("app.conf = %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "app.conf.tmpl")); got != "host=${DB_HOST} pool=${POOL:-5}\n" {
+		t.Errorf("the template was changed to %q", got)
+	}
+}
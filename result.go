@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// runResult is what --result-file records once the commands finish
+type runResult struct {
+	Path   string
+	Start  time.Time
+	Params int
+	RunID  string
+}
+
+// result is set just before the commands run when --result-file is
+// given, so fatal records the outcome of a failed command too
+var result *runResult
+
+// write records code as the run's outcome. It's best effort: failing to
+// write the file only warns, since the command's exit code matters more.
+func (r *runResult) write(code int) {
+	if r == nil {
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		ExitCode     int    `json:"exitCode"`
+		DurationMs   int64  `json:"durationMs"`
+		ParamsLoaded int    `json:"paramsLoaded"`
+		RunID        string `json:"runId,omitempty"`
+	}{code, int64(time.Since(r.Start) / time.Millisecond), r.Params, r.RunID})
+
+	if err == nil {
+		err = writeFileAtomic(r.Path, append(data, '\n'), 0644)
+	}
+
+	if err != nil {
+		log.Println("Warning: couldn't write the result file: ", err)
+	}
+}
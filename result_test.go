@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resultFile is what --result-file holds
+type resultFile struct {
+	ExitCode     int    `json:"exitCode"`
+	DurationMs   int64  `json:"durationMs"`
+	ParamsLoaded int    `json:"paramsLoaded"`
+	RunID        string `json:"runId"`
+}
+
+// readResult decodes the result file at path
+func readResult(t *testing.T, path string) resultFile {
+	t.Helper()
+
+	var got resultFile
+	if err := json.Unmarshal([]byte(readFile(t, path)), &got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestRunResultWrite(t *testing.T) {
+	path := filepath.Join(tempDir(t), "result.json")
+
+	r := &runResult{Path: path, Start: time.Now().Add(-1500 * time.Millisecond), Params: 4}
+	r.write(exitNotFound)
+
+	got := readResult(t, path)
+	if got.ExitCode != exitNotFound || got.ParamsLoaded != 4 {
+		t.Errorf("result = %+v, want exit code %d and 4 params", got, exitNotFound)
+	}
+	if got.DurationMs < 1500 || got.DurationMs > 10000 {
+		t.Errorf("durationMs = %d, want about 1500", got.DurationMs)
+	}
+
+	// Written again, the last outcome wins
+	r.write(exitOK)
+	if got := readResult(t, path); got.ExitCode != exitOK {
+		t.Errorf("exit code = %d after rewriting, want %d", got.ExitCode, exitOK)
+	}
+}
+
+func TestRunResultWriteNil(t *testing.T) {
+	logs := captureLog(t)
+
+	var r *runResult
+	r.write(exitOK)
+
+	if logs.Len() != 0 {
+		t.Errorf("logged %q without --result-file", logs.String())
+	}
+}
+
+func TestRunResultWriteFails(t *testing.T) {
+	logs := captureLog(t)
+
+	r := &runResult{Path: filepath.Join(tempDir(t), "missing", "result.json"), Start: time.Now()}
+	r.write(exitOK)
+
+	if !strings.Contains(logs.String(), "Warning: couldn't write the result file: ") {
+		t.Errorf("log = %q, want a warning", logs.String())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// resumeState is how far through a path a fetch got, so an interrupted
+// run can pick up from the last page instead of starting over. The same
+// path can be fetched from another region or as another role, so the
+// state is only for the source it came from.
+type resumeState struct {
+	Source    clientKey
+	Path      string
+	NextToken string
+	Params    []*ssm.Parameter
+	Saved     time.Time
+}
+
+// resumeFile is where the state for path from source is kept in dir
+func resumeFile(dir string, source clientKey, path string) string {
+	sum := sha256.Sum256([]byte(source.Role + "\x00" + source.Region + "\x00" + path))
+	return filepath.Join(dir, "ssm-loader-"+hex.EncodeToString(sum[:8])+".resume")
+}
+
+// loadResume returns the saved state for path from source, or nil when
+// there's none or it's older than ttl
+func loadResume(dir string, source clientKey, path string, ttl time.Duration) *resumeState {
+	data, err := ioutil.ReadFile(resumeFile(dir, source, path))
+	if err != nil {
+		return nil
+	}
+
+	var state resumeState
+	if err := json.Unmarshal(data, &state); err != nil || state.Path != path || state.Source != source {
+		return nil
+	}
+
+	if ttl > 0 && time.Since(state.Saved) > ttl {
+		debugf("Not resuming %s: the saved state has expired", path)
+		return nil
+	}
+
+	return &state
+}
+
+// saveResume writes the state. It holds decrypted values, so only the
+// owner can read it.
+func saveResume(dir string, state *resumeState) {
+	state.Saved = time.Now()
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = writeFileAtomic(resumeFile(dir, state.Source, state.Path), data, 0600)
+	}
+
+	if err != nil {
+		debugf("Couldn't save resume state for %s: %s", state.Path, err)
+	}
+}
+
+// clearResume removes the state for path from source once it's been
+// fetched in full
+func clearResume(dir string, source clientKey, path string) {
+	os.Remove(resumeFile(dir, source, path))
+}
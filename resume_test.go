@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// interruptedSSM fails every GetParametersByPath call after the first
+// Pages, as though the run was cut off part way through a path
+type interruptedSSM struct {
+	ssmiface.SSMAPI
+	Pages int
+
+	calls int
+}
+
+func (c *interruptedSSM) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	c.calls++
+	if c.calls > c.Pages {
+		return nil, awserr.New("RequestError", "send request failed", errors.New("connection reset"))
+	}
+	return c.SSMAPI.GetParametersByPath(input)
+}
+
+func resumeParams() *fakeSSM {
+	return newFakeSSM(
+		fakeParam{Name: "/prod/app/A", Value: "a"},
+		fakeParam{Name: "/prod/app/B", Value: "b"},
+		fakeParam{Name: "/prod/app/C", Value: "c"},
+		fakeParam{Name: "/prod/app/D", Value: "d"},
+		fakeParam{Name: "/prod/app/E", Value: "e"},
+	)
+}
+
+func TestResumeFile(t *testing.T) {
+	dir := "/tmp/resume"
+	source := clientKey{Region: "us-east-1"}
+
+	if resumeFile(dir, source, "/prod/app/") != resumeFile(dir, source, "/prod/app/") {
+		t.Error("the same path and source have different files")
+	}
+
+	others := map[string]string{
+		"path":   resumeFile(dir, source, "/prod/other/"),
+		"region": resumeFile(dir, clientKey{Region: "eu-west-1"}, "/prod/app/"),
+		"role":   resumeFile(dir, clientKey{Role: "arn:aws:iam::1:role/r", Region: "us-east-1"}, "/prod/app/"),
+	}
+	for what, file := range others {
+		if file == resumeFile(dir, source, "/prod/app/") {
+			t.Errorf("another %s has the same file", what)
+		}
+	}
+}
+
+func TestLoadResume(t *testing.T) {
+	source := clientKey{Region: "us-east-1"}
+	saved := &resumeState{Source: source, Path: "/prod/app/", NextToken: "2", Params: []*ssm.Parameter{{Name: aws.String("/prod/app/A")}}}
+
+	tests := []struct {
+		name   string
+		source clientKey
+		path   string
+		ttl    time.Duration
+		setup  func(dir string)
+		want   bool
+	}{
+		{"saved", source, "/prod/app/", 0, nil, true},
+		{"within ttl", source, "/prod/app/", time.Hour, nil, true},
+		{"expired", source, "/prod/app/", time.Nanosecond, func(string) { time.Sleep(time.Millisecond) }, false},
+		{"other path", source, "/prod/other/", 0, nil, false},
+		{"other source", clientKey{Region: "eu-west-1"}, "/prod/app/", 0, nil, false},
+		{"corrupt", source, "/prod/app/", 0, func(dir string) {
+			ioutil.WriteFile(resumeFile(dir, source, "/prod/app/"), []byte("{"), 0600)
+		}, false},
+		{"cleared", source, "/prod/app/", 0, func(dir string) { clearResume(dir, source, "/prod/app/") }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			saveResume(dir, saved)
+			if test.setup != nil {
+				test.setup(dir)
+			}
+
+			state := loadResume(dir, test.source, test.path, test.ttl)
+			if (state != nil) != test.want {
+				t.Fatalf("loadResume() = %+v, want resumed %t", state, test.want)
+			}
+			if state != nil && (state.NextToken != "2" || !reflect.DeepEqual(names(state.Params), []string{"/prod/app/A"})) {
+				t.Errorf("state = %+v, want what was saved", state)
+			}
+		})
+	}
+}
+
+func TestGetParametersResumes(t *testing.T) {
+	dir := tempDir(t)
+	source := clientKey{Region: "us-east-1"}
+	input := func(client ssmiface.SSMAPI) *getParametersInput {
+		return &getParametersInput{
+			Client:    client,
+			Path:      aws.String("/prod/app/"),
+			PageSize:  2,
+			Clock:     newFakeClock(),
+			ResumeDir: dir,
+			Source:    source,
+		}
+	}
+
+	// Cut off after two of the three pages
+	fake := resumeParams()
+	if _, err := getParameters(input(&interruptedSSM{SSMAPI: fake, Pages: 2})); err == nil {
+		t.Fatal("expected the interrupted fetch to fail")
+	}
+
+	info, err := os.Stat(resumeFile(dir, source, "/prod/app/"))
+	if err != nil {
+		t.Fatalf("no resume state saved: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("resume state mode = %v, want 0600 as it holds values", info.Mode().Perm())
+	}
+
+	// The next run only fetches the last page
+	fake = resumeParams()
+	params, err := getParameters(input(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/prod/app/A", "/prod/app/B", "/prod/app/C", "/prod/app/D", "/prod/app/E"}
+	if got := names(params); !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %q, want %q", got, want)
+	}
+	if calls := fake.Calls("GetParametersByPath"); calls != 1 {
+		t.Errorf("%d GetParametersByPath calls resuming, want 1", calls)
+	}
+
+	// Finished, so there's nothing left to resume
+	if _, err := os.Stat(resumeFile(dir, source, "/prod/app/")); !os.IsNotExist(err) {
+		t.Errorf("resume state left after a complete fetch (err %v)", err)
+	}
+}
+
+func TestGetParametersResumeOtherSource(t *testing.T) {
+	dir := tempDir(t)
+
+	fake := resumeParams()
+	getParameters(&getParametersInput{
+		Client: &interruptedSSM{SSMAPI: fake, Pages: 1}, Path: aws.String("/prod/app/"), PageSize: 2,
+		Clock: newFakeClock(), ResumeDir: dir, Source: clientKey{Region: "us-east-1"},
+	})
+
+	// Another region starts from the beginning
+	fake = resumeParams()
+	params, err := getParameters(&getParametersInput{
+		Client: fake, Path: aws.String("/prod/app/"), PageSize: 2,
+		Clock: newFakeClock(), ResumeDir: dir, Source: clientKey{Region: "eu-west-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 5 || fake.Calls("GetParametersByPath") != 3 {
+		t.Errorf("got %d params in %d calls, want 5 in 3", len(params), fake.Calls("GetParametersByPath"))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// budgetMaxRetries is the per-request retry limit under a retry budget. It's
+// deliberately generous since the budget is what bounds the total.
+const budgetMaxRetries = 10
+
+// budgetRetryer retries like the SDK's default retryer, but every client
+// shares one budget of time spent waiting between retries. Once it's used
+// up nothing is retried, so a sustained outage fails in bounded time
+// instead of each page and path retrying on its own. It needs
+// EnforceShouldRetryCheck on the config to cover network errors too.
+type budgetRetryer struct {
+	client.DefaultRetryer
+
+	mu        sync.Mutex
+	remaining time.Duration
+	exhausted bool
+}
+
+func newBudgetRetryer(budget time.Duration) *budgetRetryer {
+	return &budgetRetryer{
+		DefaultRetryer: client.DefaultRetryer{NumMaxRetries: budgetMaxRetries},
+		remaining:      budget,
+	}
+}
+
+func (r *budgetRetryer) ShouldRetry(req *request.Request) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.remaining <= 0 {
+		if !r.exhausted {
+			r.exhausted = true
+			debugf("Retry budget exhausted, no longer retrying failed requests")
+		}
+		return false
+	}
+
+	return r.DefaultRetryer.ShouldRetry(req)
+}
+
+func (r *budgetRetryer) RetryRules(req *request.Request) time.Duration {
+	delay := r.DefaultRetryer.RetryRules(req)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if delay > r.remaining {
+		delay = r.remaining
+	}
+	r.remaining -= delay
+
+	return delay
+}
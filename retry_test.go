@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// throttlingSSM is an SSM endpoint that throttles every request, counting
+// them
+func throttlingSSM(t *testing.T) (*httptest.Server, *int32) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"ThrottlingException","message":"Rate exceeded"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestRetryBudget(t *testing.T) {
+	server, requests := throttlingSSM(t)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+		Retryer:     newBudgetRetryer(300 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Separate clients, as for separate paths, share the one budget
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := getParameterValue(ssm.New(sess), "/prod/app/DB_HOST")
+		if !errors.Is(err, errThrottled) {
+			t.Fatalf("error = %v, want errThrottled", err)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// A throttled request waits at least 500ms before its retry, so the
+	// budget goes on the first call's one retry and nothing after it is
+	// retried
+	if got := atomic.LoadInt32(requests); got != 4 {
+		t.Errorf("made %d requests, want 4", got)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("took %s, want about the 300ms budget", elapsed)
+	}
+}
+
+func TestBudgetRetryerRules(t *testing.T) {
+	r := newBudgetRetryer(time.Second)
+
+	// Every delay comes out of the budget, the last one cut short
+	var total time.Duration
+	for i := 0; i < 20; i++ {
+		req := &request.Request{RetryCount: i % 5, HTTPResponse: &http.Response{StatusCode: 500}}
+		total += r.RetryRules(req)
+	}
+
+	if total != time.Second {
+		t.Errorf("delays add up to %s, want the 1s budget", total)
+	}
+	if r.ShouldRetry(&request.Request{HTTPResponse: &http.Response{StatusCode: 500}}) {
+		t.Error("retrying once the budget is used up")
+	}
+}
+
+func TestRetryBudgetNetworkErrors(t *testing.T) {
+	isolateAWSConfig(t)
+
+	// Nothing is listening at the address once it's closed, so every
+	// request fails to dial
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL
+	server.Close()
+
+	sess, err := newSession(&options{Region: "us-east-1", RetryBudget: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssm.New(sess, &aws.Config{
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	})
+
+	var attempts int32
+	client.Handlers.Send.PushFront(func(*request.Request) { atomic.AddInt32(&attempts, 1) })
+
+	// The first retry waits at least 30ms, which uses up the whole
+	// budget, so there's no second one
+	if _, err := getParameterValue(client, "/prod/app/DB_HOST"); err == nil {
+		t.Fatal("fetching from a closed port didn't fail")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("made %d attempts, want 2", got)
+	}
+}
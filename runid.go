@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Formats for --run-id-format
+const (
+	runIDUUID = "uuid"
+	runIDULID = "ulid"
+)
+
+// crockford is the base32 alphabet ULIDs are written in
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newRunID generates an ID for this run: a random (version 4) UUID, or a
+// ULID, which sorts by the time it was generated
+func newRunID(format string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	if format == runIDULID {
+		// 48 bits of milliseconds, then 80 random bits
+		ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], ms)
+		copy(b[:6], ts[2:])
+
+		// 128 bits as 26 characters of 5 bits, the first holding only 3
+		id := make([]byte, 26)
+		hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+		for i := 25; i >= 0; i-- {
+			id[i] = crockford[lo&0x1f]
+			lo = lo>>5 | hi<<59
+			hi >>= 5
+		}
+		return string(id), nil
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
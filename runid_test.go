@@ -0,0 +1,99 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func TestNewRunID(t *testing.T) {
+	tests := []struct {
+		format  string
+		pattern *regexp.Regexp
+	}{
+		{"", uuidPattern},
+		{runIDUUID, uuidPattern},
+		{runIDULID, ulidPattern},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				id, err := newRunID(tt.format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !tt.pattern.MatchString(id) {
+					t.Fatalf("newRunID() = %q, want it to match %s", id, tt.pattern)
+				}
+				if seen[id] {
+					t.Fatalf("newRunID() = %q twice", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func TestULIDSortsByTime(t *testing.T) {
+	first, err := newRunID(runIDULID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := newRunID(runIDULID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first >= second {
+		t.Errorf("ULID %s isn't before the later %s", first, second)
+	}
+}
+
+func TestInjectRunIDFlag(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/RI_HOST", Value: "prod-db"}}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	runID := func(flags ...string) string {
+		t.Helper()
+		stdout, stderr, code := runMain(t, params, env, append(flags, "-O")...)
+		if code != exitOK {
+			t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(envLines(stdout, "RI_ID="), "RI_ID="), "\n")
+	}
+
+	first, second := runID("--inject-run-id", "RI_ID"), runID("--inject-run-id", "RI_ID")
+	if !uuidPattern.MatchString(first) || !uuidPattern.MatchString(second) {
+		t.Errorf("run IDs %q and %q, want UUIDs", first, second)
+	}
+	if first == second {
+		t.Errorf("two runs got the same ID %q", first)
+	}
+
+	if id := runID("--inject-run-id", "RI_ID", "--run-id-format", "ulid"); !ulidPattern.MatchString(id) {
+		t.Errorf("run ID %q, want a ULID", id)
+	}
+
+	// An ID that's already set is kept
+	stdout, _, _ := runMain(t, params, env, "--inject-run-id", "RI_HOST", "-O")
+	if got := envLines(stdout, "RI_"); got != "RI_HOST=prod-db\n" {
+		t.Errorf("env = %q, want RI_HOST from SSM kept", got)
+	}
+	stdout, _, _ = runMain(t, params, env, "--inject-run-id", "RI_ID", "--set", "RI_ID=from-upstream", "-O")
+	if got := envLines(stdout, "RI_ID="); got != "RI_ID=from-upstream\n" {
+		t.Errorf("env = %q, want the upstream ID kept", got)
+	}
+
+	if _, _, code := runMain(t, params, env, "--inject-run-id", "RI_ID", "--run-id-format", "snowflake", "-O"); code != exitUsage {
+		t.Errorf("exit code = %d for an unknown --run-id-format, want %d", code, exitUsage)
+	}
+}
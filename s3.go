@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// parseS3URL parses the s3://bucket/key given to --s3-config
+func parseS3URL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "s3" || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("expected s3://BUCKET/KEY, got %q", s)
+	}
+
+	return u, nil
+}
+
+// getS3Config downloads the JSON object at u, which must be a flat
+// object of string values
+func getS3Config(client s3iface.S3API, u *url.URL) (paramMap, error) {
+	result, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer result.Body.Close()
+
+	data, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	m := make(paramMap, len(values))
+	for key, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s isn't a string", key)
+		}
+		m[key] = s
+	}
+
+	return m, nil
+}
+
+// AddS3Config checks the --s3-config values from source like AddParams
+// checks SSM's: protected keys are dropped and values sanitized. Whoever
+// can write the object is no more trusted than whoever can write SSM.
+func (m paramMap) AddS3Config(source string, po *paramOptions) error {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if po.protected(key) {
+			log.Printf("Warning: not setting %s from %s, it's a protected key (see --protected-keys)\n", key, source)
+			delete(m, key)
+			continue
+		}
+
+		value, err := sanitizeValue(source+" "+key, m[key], po.Sanitize)
+		if err != nil {
+			return err
+		}
+		m[key] = value
+	}
+	return nil
+}
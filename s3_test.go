@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 is an in-memory S3 holding objects by bucket/key
+type fakeS3 struct {
+	s3iface.S3API
+
+	Objects map[string]string
+	Err     error
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	body, ok := f.Objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestParseS3URL(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantBucket string
+		wantKey    string
+		wantErr    bool
+	}{
+		{in: "s3://config/prod/web.json", wantBucket: "config", wantKey: "/prod/web.json"},
+		{in: "s3://config/web.json", wantBucket: "config", wantKey: "/web.json"},
+		{in: "s3://config", wantErr: true},
+		{in: "s3://config/", wantErr: true},
+		{in: "s3:///web.json", wantErr: true},
+		{in: "https://config.s3.amazonaws.com/web.json", wantErr: true},
+		{in: "config/web.json", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			u, err := parseS3URL(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseS3URL(%q) = %v, want an error", tt.in, u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Host != tt.wantBucket || u.Path != tt.wantKey {
+				t.Errorf("bucket %q key %q, want %q %q", u.Host, u.Path, tt.wantBucket, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestGetS3Config(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		err     error
+		want    paramMap
+		wantErr string
+		wantIs  error
+	}{
+		{name: "flat", body: `{"DB_HOST": "db", "EMPTY": ""}`, want: paramMap{"DB_HOST": "db", "EMPTY": ""}},
+		{name: "empty object", body: `{}`, want: paramMap{}},
+		{name: "number", body: `{"PORT": 5432}`, wantErr: "PORT isn't a string"},
+		{name: "nested", body: `{"DB": {"HOST": "db"}}`, wantErr: "DB isn't a string"},
+		{name: "array", body: `["DB_HOST"]`, wantErr: "cannot unmarshal array"},
+		{name: "not json", body: `DB_HOST=db`, wantErr: "invalid character"},
+		{name: "missing", wantErr: s3.ErrCodeNoSuchKey},
+		{name: "denied", err: awserr.New("AccessDenied", "Access Denied", nil), wantIs: errAccessDenied},
+	}
+
+	u, err := parseS3URL("s3://config/prod/web.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeS3{Objects: map[string]string{}, Err: tt.err}
+			if tt.body != "" {
+				client.Objects["config/prod/web.json"] = tt.body
+			}
+
+			got, err := getS3Config(client, u)
+			switch {
+			case tt.wantIs != nil:
+				if !errors.Is(err, tt.wantIs) {
+					t.Errorf("error = %v, want %v", err, tt.wantIs)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !reflect.DeepEqual(got, tt.want):
+				t.Errorf("getS3Config() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3ConfigPrecedence(t *testing.T) {
+	loaded := &loadedParams{
+		OSEnv:     paramMap{"FROM_OS": "os", "OS_AND_S3": "os"},
+		SSM:       paramMap{"SSM_AND_S3": "ssm", "URL": "%%S3_HOST%%:%%SSM_AND_S3%%"},
+		S3:        paramMap{"S3_HOST": "s3-db", "SSM_AND_S3": "s3", "OS_AND_S3": "s3", "S3_REF": "%%SSM_AND_S3%%"},
+		Overrides: paramMap{},
+	}
+
+	env := loaded.Env()
+	want := map[string]string{
+		"S3_HOST":    "s3-db",
+		"SSM_AND_S3": "ssm",
+		"OS_AND_S3":  "os",
+		"URL":        "s3-db:ssm",
+		"S3_REF":     "ssm",
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("%s = %q, want %q", key, env[key], value)
+		}
+	}
+
+	if got := loaded.LoadedKeys(); !reflect.DeepEqual(got, []string{"OS_AND_S3", "S3_HOST", "S3_REF", "SSM_AND_S3", "URL"}) {
+		t.Errorf("LoadedKeys() = %q, want the S3 keys included", got)
+	}
+}
+
+func TestAddS3Config(t *testing.T) {
+	object := func() paramMap {
+		return paramMap{"DB_HOST": "db\x1b[2J", "LD_PRELOAD": "/tmp/evil.so", "PATH": "/tmp", "PORT": "5432"}
+	}
+
+	tests := []struct {
+		name     string
+		sanitize string
+		want     paramMap
+		wantErr  string
+	}{
+		{name: "unsanitized", want: paramMap{"DB_HOST": "db\x1b[2J", "PORT": "5432"}},
+		{name: "strip", sanitize: sanitizeStrip, want: paramMap{"DB_HOST": "db[2J", "PORT": "5432"}},
+		{name: "strict", sanitize: sanitizeStrict, wantErr: `s3://config/web.json DB_HOST contains control character '\x1b' at byte 2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+			po := &paramOptions{Protected: defaultProtectedKeys, Sanitize: tt.sanitize}
+
+			m := object()
+			err := m.AddS3Config("s3://config/web.json", po)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("AddS3Config() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("AddS3Config() = %q, want %q", m, tt.want)
+			}
+			if !strings.Contains(logged.String(), "Warning: not setting LD_PRELOAD from s3://config/web.json, it's a protected key") {
+				t.Errorf("logged %q, want the protected key", logged)
+			}
+		})
+	}
+}
+
+func TestS3ConfigFlag(t *testing.T) {
+	_, stderr, code := runMain(t, nil, []string{"APP_ENV=prod", "APP_NAME=web"}, "--s3-config", "https://example.com/web.json", "-O")
+	if code != exitUsage {
+		t.Errorf("exit code = %d, want %d (stderr %q)", code, exitUsage, stderr)
+	}
+	if !strings.Contains(stderr, `expected s3://BUCKET/KEY, got "https://example.com/web.json"`) {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
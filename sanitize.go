@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	sanitizeOff    = ""
+	sanitizeStrip  = "strip"
+	sanitizeStrict = "strict"
+)
+
+// isUnwantedControl reports whether r is a control character that has no
+// business in a config value. Newlines and tabs are allowed through.
+func isUnwantedControl(r rune) bool {
+	return unicode.IsControl(r) && r != '\n' && r != '\t'
+}
+
+// sanitizeValue strips control characters from value, or with the strict
+// mode returns an error naming the first one found
+func sanitizeValue(name, value, mode string) (string, error) {
+	if mode == sanitizeOff || strings.IndexFunc(value, isUnwantedControl) == -1 {
+		return value, nil
+	}
+
+	if mode == sanitizeStrict {
+		i := strings.IndexFunc(value, isUnwantedControl)
+		return "", fmt.Errorf("%s contains control character %q at byte %d", name, value[i], i)
+	}
+
+	return strings.Map(func(r rune) rune {
+		if isUnwantedControl(r) {
+			return -1
+		}
+		return r
+	}, value), nil
+}
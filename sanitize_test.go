@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestSanitizeValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		mode    string
+		want    string
+		wantErr string
+	}{
+		{name: "off", value: "secret\r", mode: sanitizeOff, want: "secret\r"},
+		{name: "windows paste", value: "secret\r", mode: sanitizeStrip, want: "secret"},
+		{name: "null", value: "a\x00b", mode: sanitizeStrip, want: "ab"},
+		{name: "escape sequence", value: "\x1b[31mred\x1b[0m", mode: sanitizeStrip, want: "[31mred[0m"},
+		{name: "newlines and tabs", value: "a\tb\nc\n", mode: sanitizeStrip, want: "a\tb\nc\n"},
+		{name: "clean strict", value: "a\tb\nc", mode: sanitizeStrict, want: "a\tb\nc"},
+		{name: "strict carriage return", value: "secret\r\n", mode: sanitizeStrict, wantErr: `/prod/app/KEY contains control character '\r' at byte 6`},
+		{name: "strict null", value: "a\x00b", mode: sanitizeStrict, wantErr: `/prod/app/KEY contains control character '\x00' at byte 1`},
+		{name: "strict escape", value: "\x1b[0m", mode: sanitizeStrict, wantErr: `/prod/app/KEY contains control character '\x1b' at byte 0`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeValue("/prod/app/KEY", tt.value, tt.mode)
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddParamsSanitizes(t *testing.T) {
+	params := []*ssm.Parameter{{Name: aws.String("/prod/app/PASSWORD"), Value: aws.String("hunter2\r")}}
+
+	m := make(paramMap)
+	if err := m.AddParams(params, &paramOptions{Sanitize: sanitizeStrip}); err != nil {
+		t.Fatal(err)
+	}
+	if m["PASSWORD"] != "hunter2" {
+		t.Errorf("PASSWORD = %q, want it stripped", m["PASSWORD"])
+	}
+
+	if err := make(paramMap).AddParams(params, &paramOptions{Sanitize: sanitizeStrict}); err == nil {
+		t.Error("expected an error in strict mode")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// schema is the subset of JSON schema --schema supports: required keys,
+// and a type, enum, pattern and range for each property. Every value in
+// the env is a string, so a property's type says what it must parse as.
+type schema struct {
+	Required   []string                   `json:"required"`
+	Properties map[string]*propertySchema `json:"properties"`
+}
+
+type propertySchema struct {
+	Type    string        `json:"type"`
+	Enum    []interface{} `json:"enum"`
+	Pattern string        `json:"pattern"`
+	Minimum *float64      `json:"minimum"`
+	Maximum *float64      `json:"maximum"`
+}
+
+// schemaError lists every violation found, one per line
+type schemaError []string
+
+func (e schemaError) Error() string {
+	return "schema violations:\n  " + strings.Join(e, "\n  ")
+}
+
+func loadSchema(path string) (*schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+
+	for key, prop := range s.Properties {
+		if prop == nil {
+			return nil, fmt.Errorf("property %s: expected an object, got null", key)
+		}
+
+		switch prop.Type {
+		case "", "string", "integer", "number", "boolean":
+		default:
+			return nil, fmt.Errorf("property %s: unsupported type %q", key, prop.Type)
+		}
+
+		if _, err := regexp.Compile(prop.Pattern); err != nil {
+			return nil, fmt.Errorf("property %s: %s", key, err)
+		}
+	}
+
+	return &s, nil
+}
+
+// Validate checks m against s and returns a schemaError listing every
+// violation, so they can all be fixed in one go
+func (m paramMap) Validate(s *schema) error {
+	var violations schemaError
+
+	for _, key := range s.Required {
+		if _, ok := m[key]; !ok {
+			violations = append(violations, key+": required but not set")
+		}
+	}
+
+	keys := make([]string, 0, len(s.Properties))
+	for key := range s.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := m[key]
+		if !ok {
+			continue
+		}
+
+		if err := s.Properties[key].check(value); err != nil {
+			violations = append(violations, key+": "+err.Error())
+		}
+	}
+
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// check parses value as the property's type and checks it against the
+// property's constraints
+func (p *propertySchema) check(value string) error {
+	var typed interface{} = value
+
+	switch p.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		typed = float64(n)
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		typed = n
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected a boolean, got %q", value)
+		}
+		typed = b
+	}
+
+	if n, ok := typed.(float64); ok {
+		if p.Minimum != nil && n < *p.Minimum {
+			return fmt.Errorf("%s is less than the minimum %v", value, *p.Minimum)
+		}
+		if p.Maximum != nil && n > *p.Maximum {
+			return fmt.Errorf("%s is greater than the maximum %v", value, *p.Maximum)
+		}
+	}
+
+	if p.Pattern != "" && !regexp.MustCompile(p.Pattern).MatchString(value) {
+		return fmt.Errorf("%q doesn't match %s", value, p.Pattern)
+	}
+
+	if len(p.Enum) > 0 {
+		for _, allowed := range p.Enum {
+			if allowed == typed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q isn't one of the allowed values", value)
+	}
+
+	return nil
+}
+
+// typeNames maps the names --types takes to schema types
+var typeNames = map[string]string{
+	"string": "string",
+	"int":    "integer",
+	"float":  "number",
+	"bool":   "boolean",
+}
+
+// parseTypes parses --types KEY:TYPE,KEY:TYPE into a schema checking
+// each key's value parses as its type
+func parseTypes(s string, into *schema) error {
+	if into.Properties == nil {
+		into.Properties = make(map[string]*propertySchema)
+	}
+
+	for _, item := range splitList(s) {
+		pair := strings.SplitN(item, ":", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return fmt.Errorf("invalid --types %q, expected KEY:TYPE", item)
+		}
+
+		t, ok := typeNames[pair[1]]
+		if !ok {
+			return fmt.Errorf("invalid --types %q, TYPE is one of string, int, float or bool", item)
+		}
+		into.Properties[pair[0]] = &propertySchema{Type: t}
+	}
+
+	return nil
+}
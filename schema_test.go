@@ -0,0 +1,251 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+  "required": ["DB_HOST", "PORT"],
+  "properties": {
+    "PORT": {"type": "integer", "minimum": 1, "maximum": 65535},
+    "RATIO": {"type": "number", "maximum": 1},
+    "DEBUG": {"type": "boolean"},
+    "LOG_LEVEL": {"type": "string", "enum": ["debug", "info", "warn"]},
+    "WORKERS": {"type": "integer", "enum": [1, 2, 4]},
+    "DB_HOST": {"pattern": "^[a-z.-]+$"}
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := loadSchema(writeFile(t, tempDir(t), "schema.json", testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  paramMap
+		want []string
+	}{
+		{
+			name: "passing",
+			env:  paramMap{"DB_HOST": "db.internal", "PORT": "5432", "RATIO": "0.5", "DEBUG": "true", "LOG_LEVEL": "info", "WORKERS": "4", "OTHER": "anything"},
+		},
+		{
+			name: "only required",
+			env:  paramMap{"DB_HOST": "db", "PORT": "1"},
+		},
+		{
+			name: "missing required",
+			env:  paramMap{"PORT": "80"},
+			want: []string{"DB_HOST: required but not set"},
+		},
+		{
+			name: "every violation",
+			env:  paramMap{"DB_HOST": "DB_1", "PORT": "http", "RATIO": "1.5", "DEBUG": "yes", "LOG_LEVEL": "trace", "WORKERS": "3"},
+			want: []string{
+				`DB_HOST: "DB_1" doesn't match ^[a-z.-]+$`,
+				`DEBUG: expected a boolean, got "yes"`,
+				`LOG_LEVEL: "trace" isn't one of the allowed values`,
+				`PORT: expected an integer, got "http"`,
+				"RATIO: 1.5 is greater than the maximum 1",
+				`WORKERS: "3" isn't one of the allowed values`,
+			},
+		},
+		{
+			name: "below the minimum",
+			env:  paramMap{"DB_HOST": "db", "PORT": "0"},
+			want: []string{"PORT: 0 is less than the minimum 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.env.Validate(s)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want no violations", err)
+				}
+				return
+			}
+
+			violations, ok := err.(schemaError)
+			if !ok {
+				t.Fatalf("Validate() = %v, want a schemaError", err)
+			}
+			if !reflect.DeepEqual([]string(violations), tt.want) {
+				t.Errorf("violations = %q, want %q", violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	dir := tempDir(t)
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "empty", data: `{}`},
+		{name: "invalid json", data: `{"required": [`, wantErr: "parsing"},
+		{name: "unsupported type", data: `{"properties": {"A": {"type": "array"}}}`, wantErr: `property A: unsupported type "array"`},
+		{name: "null property", data: `{"properties": {"A": null}}`, wantErr: "property A: expected an object"},
+		{name: "invalid pattern", data: `{"properties": {"A": {"pattern": "("}}}`, wantErr: "property A: error parsing regexp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadSchema(writeFile(t, dir, "schema.json", tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadSchema() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaFlag(t *testing.T) {
+	schemaPath := writeFile(t, tempDir(t), "schema.json", testSchema)
+
+	tests := []struct {
+		name   string
+		params []fakeParam
+		code   int
+		stderr string
+	}{
+		{
+			name:   "passing",
+			params: []fakeParam{{Name: "/prod/web/DB_HOST", Value: "db"}, {Name: "/prod/web/PORT", Value: "5432"}},
+		},
+		{
+			name:   "failing",
+			params: []fakeParam{{Name: "/prod/web/PORT", Value: "99999"}},
+			code:   exitUsage,
+			stderr: "schema violations:\n  DB_HOST: required but not set\n  PORT: 99999 is greater than the maximum 65535",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMain(t, tt.params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--schema", schemaPath, "--no-exec")
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "each type",
+			in:   []string{"NAME:string,PORT:int,RATIO:float,DEBUG:bool"},
+			want: map[string]string{"NAME": "string", "PORT": "integer", "RATIO": "number", "DEBUG": "boolean"},
+		},
+		{
+			name: "repeated",
+			in:   []string{"PORT:int", "DEBUG:bool"},
+			want: map[string]string{"PORT": "integer", "DEBUG": "boolean"},
+		},
+		{
+			name: "later wins",
+			in:   []string{"PORT:int", "PORT:string"},
+			want: map[string]string{"PORT": "string"},
+		},
+		{name: "no type", in: []string{"PORT"}, wantErr: `invalid --types "PORT", expected KEY:TYPE`},
+		{name: "no key", in: []string{":int"}, wantErr: `invalid --types ":int", expected KEY:TYPE`},
+		{name: "unknown type", in: []string{"PORT:integer"}, wantErr: `invalid --types "PORT:integer", TYPE is one of string, int, float or bool`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &schema{}
+
+			var err error
+			for _, in := range tt.in {
+				if err = parseTypes(in, s); err != nil {
+					break
+				}
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("parseTypes() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]string)
+			for key, property := range s.Properties {
+				got[key] = property.Type
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("types = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypesFlag(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []fakeParam
+		code   int
+		stderr string
+	}{
+		{
+			name:   "passing",
+			params: []fakeParam{{Name: "/prod/web/PORT", Value: "5432"}, {Name: "/prod/web/DEBUG", Value: "false"}},
+		},
+		{
+			name:   "interpolated",
+			params: []fakeParam{{Name: "/prod/web/BASE", Value: "80"}, {Name: "/prod/web/PORT", Value: "%%BASE%%80"}},
+		},
+		{
+			name:   "unset keys skipped",
+			params: []fakeParam{{Name: "/prod/web/OTHER", Value: "x"}},
+		},
+		{
+			name:   "failing",
+			params: []fakeParam{{Name: "/prod/web/PORT", Value: "http"}, {Name: "/prod/web/RATIO", Value: "half"}},
+			code:   exitUsage,
+			stderr: "schema violations:\n  PORT: expected an integer, got \"http\"\n  RATIO: expected a number, got \"half\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMain(t, tt.params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--types", "PORT:int,RATIO:float", "--types", "DEBUG:bool", "--no-exec")
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+		})
+	}
+
+	if _, stderr, code := runMain(t, nil, []string{"APP_ENV=prod", "APP_NAME=web"}, "--types", "PORT:long", "--no-exec"); code != exitUsage {
+		t.Errorf("exit code = %d for an unknown type, want %d (stderr %q)", code, exitUsage, stderr)
+	}
+}
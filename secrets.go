@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// secretRefPrefix marks a reference to Secrets Manager, as in
+// %%secret:prod/db/password%%, where the rest is the secret's name or ARN
+const secretRefPrefix = "secret:"
+
+// getSecrets fetches the secret for each of refs, keyed by the ref,
+// returning the refs with no such secret separately. Only string secrets
+// are supported.
+func getSecrets(client secretsmanageriface.SecretsManagerAPI, refs []string) (paramMap, []string, error) {
+	secrets := make(paramMap)
+	var notFound []string
+
+	for _, ref := range refs {
+		result, err := client.GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(strings.TrimPrefix(ref, secretRefPrefix)),
+		})
+
+		if err != nil {
+			if err = classifyError(err); errors.Is(err, errNotFound) {
+				notFound = append(notFound, ref)
+				continue
+			}
+			return nil, nil, err
+		}
+
+		if result.SecretString == nil {
+			return nil, nil, fmt.Errorf("%s is a binary secret, only string secrets can be interpolated", ref)
+		}
+		secrets[ref] = aws.StringValue(result.SecretString)
+	}
+
+	return secrets, notFound, nil
+}
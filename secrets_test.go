@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// fakeSecrets is an in-memory Secrets Manager, recording the IDs asked for
+type fakeSecrets struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	Strings map[string]string
+	Binary  map[string][]byte
+	Err     error
+
+	ids []string
+}
+
+func (f *fakeSecrets) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	id := aws.StringValue(input.SecretId)
+	f.ids = append(f.ids, id)
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if value, ok := f.Strings[id]; ok {
+		return &secretsmanager.GetSecretValueOutput{Name: aws.String(id), SecretString: aws.String(value)}, nil
+	}
+	if value, ok := f.Binary[id]; ok {
+		return &secretsmanager.GetSecretValueOutput{Name: aws.String(id), SecretBinary: value}, nil
+	}
+	return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "Secrets Manager can't find the specified secret.", nil)
+}
+
+func TestGetSecrets(t *testing.T) {
+	arn := "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/api-AbCdEf"
+
+	tests := []struct {
+		name         string
+		client       *fakeSecrets
+		refs         []string
+		want         paramMap
+		wantNotFound []string
+		wantErr      string
+		wantIs       error
+	}{
+		{
+			name:   "by name and arn",
+			client: &fakeSecrets{Strings: map[string]string{"prod/db/password": "hunter2", arn: "key"}},
+			refs:   []string{"secret:prod/db/password", "secret:" + arn},
+			want:   paramMap{"secret:prod/db/password": "hunter2", "secret:" + arn: "key"},
+		},
+		{
+			name:         "not found",
+			client:       &fakeSecrets{Strings: map[string]string{"prod/db/password": "hunter2"}},
+			refs:         []string{"secret:prod/db/password", "secret:prod/missing"},
+			want:         paramMap{"secret:prod/db/password": "hunter2"},
+			wantNotFound: []string{"secret:prod/missing"},
+		},
+		{
+			name:    "binary",
+			client:  &fakeSecrets{Binary: map[string][]byte{"prod/cert": {0x30, 0x82}}},
+			refs:    []string{"secret:prod/cert"},
+			wantErr: "secret:prod/cert is a binary secret, only string secrets can be interpolated",
+		},
+		{
+			name:   "denied",
+			client: &fakeSecrets{Err: awserr.New("AccessDeniedException", "not authorized to perform secretsmanager:GetSecretValue", nil)},
+			refs:   []string{"secret:prod/db/password"},
+			wantIs: errAccessDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, notFound, err := getSecrets(tt.client, tt.refs)
+			switch {
+			case tt.wantIs != nil:
+				if !errors.Is(err, tt.wantIs) {
+					t.Errorf("error = %v, want %v", err, tt.wantIs)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("secrets = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(notFound, tt.wantNotFound) {
+				t.Errorf("not found = %q, want %q", notFound, tt.wantNotFound)
+			}
+
+			// The secret: prefix isn't part of the ID
+			for _, id := range tt.client.ids {
+				if strings.HasPrefix(id, secretRefPrefix) {
+					t.Errorf("asked for %q", id)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretRefs(t *testing.T) {
+	loaded := &loadedParams{
+		SSM: paramMap{
+			"DB_URL":   "postgres://app:%%secret:prod/db/password%%@db/app",
+			"API_KEY":  "%%secret:prod/api|trim%%",
+			"FALLBACK": "%%secret:prod/optional:-none%%",
+			"LOCAL":    "%%DB_HOST%% %%/prod/common/REGION%%",
+		},
+		Overrides: paramMap{"AGAIN": "%%secret:prod/db/password%%"},
+		Secrets:   paramMap{"secret:prod/api": "already"},
+	}
+
+	want := []string{"secret:prod/db/password", "secret:prod/optional"}
+	if got := loaded.SecretRefs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretRefs() = %q, want %q", got, want)
+	}
+}
+
+func TestSecretInterpolation(t *testing.T) {
+	loaded := &loadedParams{
+		SSM: paramMap{
+			"DB_URL":   "postgres://app:%%secret:prod/db/password%%@db/app",
+			"API_KEY":  "%%secret:prod/api|trim%%",
+			"FALLBACK": "%%secret:prod/optional:-none%%",
+		},
+		Secrets: paramMap{"secret:prod/db/password": "hunter2", "secret:prod/api": " key \n"},
+	}
+
+	env := loaded.Env()
+	want := map[string]string{"DB_URL": "postgres://app:hunter2@db/app", "API_KEY": "key", "FALLBACK": "none"}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("%s = %q, want %q", key, env[key], value)
+		}
+	}
+
+	// Secrets are only for interpolating, not keys of their own
+	if _, exists := env["secret:prod/db/password"]; exists {
+		t.Error("a secret became a key")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The --serve protocol is one request per connection: the client sends
+// serveRequest on a line and gets a serveResponse back as JSON, after
+// which the connection is closed.
+const (
+	serveRequest = "ENV"
+	serveTimeout = 5 * time.Second
+)
+
+// parseServeURL checks a --serve or :connect address, currently only
+// unix:///path/to/socket, and returns the socket's path
+func parseServeURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "unix" || u.Path == "" {
+		return "", fmt.Errorf("invalid socket %q, expected unix:///path/to/socket", s)
+	}
+
+	return u.Path, nil
+}
+
+type serveResponse struct {
+	Env   paramMap `json:"env,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// envServer hands out the current env to each client that asks
+type envServer struct {
+	mu  sync.RWMutex
+	env paramMap
+}
+
+func (s *envServer) set(env paramMap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.env = env
+}
+
+func (s *envServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(serveTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(conn)
+	if strings.TrimSpace(line) != serveRequest {
+		enc.Encode(serveResponse{Error: "unknown request"})
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	enc.Encode(serveResponse{Env: s.env})
+}
+
+// serveEnv serves the loaded params on the unix socket at path until
+// SIGINT or SIGTERM, reloading them every interval when it's set. The
+// socket is only accessible to the user ssm-loader runs as.
+func serveEnv(path string, loaded *loadedParams, reload func() (paramMap, error), interval time.Duration) error {
+	// A socket left behind by a server that died would fail the listen
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		os.Remove(path)
+	}
+
+	ln, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	// It's already owner only, this just drops the execute bit
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+
+	srv := &envServer{env: loaded.LoadedEnv()}
+
+	if interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				// A failed reload, say from throttling, keeps serving
+				// the last env that loaded
+				m, err := reload()
+				if err != nil {
+					log.Println("Warning: couldn't reload params, serving the previous ones: ", err)
+					continue
+				}
+				loaded.SSM = m
+				srv.set(loaded.LoadedEnv())
+				debugf("Reloaded params")
+			}
+		}()
+	}
+
+	// Closing the listener removes the socket
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	stopped := make(chan struct{})
+	go func() {
+		<-sig
+		close(stopped)
+		ln.Close()
+	}()
+
+	debugf("Serving env on %s", path)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-stopped:
+				return nil
+			default:
+				return err
+			}
+		}
+		go srv.handle(conn)
+	}
+}
+
+// fetchServedEnv asks the server on the unix socket at path for its env
+func fetchServedEnv(path string) (paramMap, error) {
+	conn, err := net.DialTimeout("unix", path, serveTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(serveTimeout))
+
+	if _, err := fmt.Fprintln(conn, serveRequest); err != nil {
+		return nil, err
+	}
+
+	var resp serveResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	}
+
+	if resp.Env == nil {
+		resp.Env = make(paramMap)
+	}
+	return resp.Env, nil
+}
+
+// runConnect is the :connect command: it gets the env from a --serve
+// server and runs the command with it, in place of SSM, or prints it when
+// there's no command. The OS env and --set win over served values, as
+// they would over SSM.
+func runConnect(args []string, opts *options) {
+	if len(args) == 0 {
+		fatal(exitUsage, "usage: ssm-loader :connect unix:///path/to/socket [command...]")
+	}
+
+	path, err := parseServeURL(args[0])
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+
+	served, err := fetchServedEnv(path)
+	if err != nil {
+		fatal(exitError, "Error fetching env from "+args[0]+": ", err)
+	}
+
+	loaded := &loadedParams{
+		OSEnv:     getOSEnv(opts.EnvDuplicates),
+		SSM:       served,
+		Overrides: opts.Sets,
+	}
+	env := loaded.Env()
+
+	if len(args) == 1 {
+		env.Masked(opts.Masks).WriteDotenv(os.Stdout)
+		os.Exit(0)
+	}
+
+	cmd := startCommand(args[1:], env, opts)
+	if err := waitCommand(cmd); err != nil {
+		fatal(commandExitCode(err), "Command finished with err: ", err)
+	}
+	os.Exit(0)
+}
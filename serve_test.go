@@ -0,0 +1,240 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseServeURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "unix:///tmp/ssm.sock", want: "/tmp/ssm.sock"},
+		{in: "unix:///run/ssm-loader/env.sock", want: "/run/ssm-loader/env.sock"},
+		{in: "tcp://localhost:8080", wantErr: true},
+		{in: "/tmp/ssm.sock", wantErr: true},
+		{in: "unix://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseServeURL(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseServeURL(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseServeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEnvServerHandle(t *testing.T) {
+	srv := &envServer{env: paramMap{"DB_HOST": "prod-db"}}
+
+	tests := []struct {
+		request string
+		want    string
+	}{
+		{request: "ENV\n", want: `{"env":{"DB_HOST":"prod-db"}}`},
+		{request: "ENV\r\n", want: `{"env":{"DB_HOST":"prod-db"}}`},
+		{request: "GET /\n", want: `{"error":"unknown request"}`},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		go srv.handle(server)
+
+		go client.Write([]byte(tt.request))
+		line, err := bufio.NewReader(client).ReadString('\n')
+		client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(line) != tt.want {
+			t.Errorf("%q: response = %s, want %s", tt.request, line, tt.want)
+		}
+	}
+}
+
+// startServer runs serveEnv on a socket in a temporary directory until
+// the end of the test, returning the socket's path
+func startServer(t *testing.T, loaded *loadedParams, reload func() (paramMap, error), interval time.Duration) string {
+	t.Helper()
+
+	path := filepath.Join(tempDir(t), "env.sock")
+
+	done := make(chan error, 1)
+	go func() { done <- serveEnv(path, loaded, reload, interval) }()
+
+	waitFor(t, 2*time.Second, "the server", func() bool {
+		_, err := fetchServedEnv(path)
+		return err == nil
+	})
+
+	t.Cleanup(func() {
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("serveEnv() = %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Error("serveEnv() didn't stop on SIGTERM")
+		}
+	})
+
+	return path
+}
+
+func TestServeAndFetch(t *testing.T) {
+	loaded := &loadedParams{
+		OSEnv: paramMap{"HOME": "/home/app"},
+		SSM:   paramMap{"DB_HOST": "prod-db", "DB_URL": "postgres://%%DB_HOST%%"},
+	}
+	path := startServer(t, loaded, nil, 0)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want only the owner to connect", info.Mode().Perm())
+	}
+
+	// Several clients at once each get the loaded keys, interpolated,
+	// without the server's OS env
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			env, err := fetchServedEnv(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if want := (paramMap{"DB_HOST": "prod-db", "DB_URL": "postgres://prod-db"}); !reflect.DeepEqual(env, want) {
+				t.Errorf("served env = %v, want %v", env, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServeReloads(t *testing.T) {
+	captureLog(t)
+
+	var mu sync.Mutex
+	reloads := 0
+	reload := func() (paramMap, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first reload fails, and the old env is kept
+		if reloads++; reloads == 1 {
+			return nil, errors.New("throttled")
+		}
+		return paramMap{"VERSION": "2"}, nil
+	}
+
+	path := startServer(t, &loadedParams{SSM: paramMap{"VERSION": "1"}}, reload, 20*time.Millisecond)
+
+	env, err := fetchServedEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["VERSION"] != "1" {
+		t.Fatalf("VERSION = %q before any reload, want 1", env["VERSION"])
+	}
+
+	waitFor(t, 2*time.Second, "the reloaded env", func() bool {
+		env, err := fetchServedEnv(path)
+		return err == nil && env["VERSION"] == "2"
+	})
+}
+
+func TestServeSocketPath(t *testing.T) {
+	dir := tempDir(t)
+
+	// A file that isn't a socket is left alone
+	file := writeFile(t, dir, "not-a-socket", "data")
+	if err := serveEnv(file, &loadedParams{}, nil, 0); err == nil || !strings.Contains(err.Error(), "isn't a socket") {
+		t.Errorf("serveEnv() on a file = %v, want it refused", err)
+	}
+	if readFile(t, file) != "data" {
+		t.Error("the file was replaced")
+	}
+
+	// A socket left behind by a server that died is replaced
+	stale := filepath.Join(dir, "stale.sock")
+	ln, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	loaded := &loadedParams{SSM: paramMap{"A": "1"}}
+	done := make(chan error, 1)
+	go func() { done <- serveEnv(stale, loaded, nil, 0) }()
+	waitFor(t, 2*time.Second, "the server on the stale socket", func() bool {
+		env, err := fetchServedEnv(stale)
+		return err == nil && env["A"] == "1"
+	})
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err := <-done; err != nil {
+		t.Errorf("serveEnv() = %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("the socket is still there after stopping: %v", err)
+	}
+}
+
+func TestFetchServedEnvNoServer(t *testing.T) {
+	if _, err := fetchServedEnv(filepath.Join(tempDir(t), "missing.sock")); err == nil {
+		t.Error("fetchServedEnv() with no server didn't fail")
+	}
+}
+
+func TestConnectCommand(t *testing.T) {
+	path := startServer(t, &loadedParams{SSM: paramMap{"C_HOST": "served-db", "C_PORT": "5432"}}, nil, 0)
+
+	tests := []struct {
+		name string
+		args []string
+		env  []string
+		want string
+	}{
+		{name: "print", args: []string{":connect", "unix://" + path}, want: "C_HOST=served-db\nC_PORT=5432\n"},
+		{name: "--set wins", args: []string{"--set", "C_PORT=6432", ":connect", "unix://" + path}, want: "C_HOST=served-db\nC_PORT=6432\n"},
+		{name: "OS env wins", args: []string{":connect", "unix://" + path}, env: []string{"C_HOST=local-db"}, want: "C_HOST=local-db\nC_PORT=5432\n"},
+		{name: "command", args: []string{":connect", "unix://" + path, "/bin/sh", "-c", `echo "C_HOST=$C_HOST"`}, want: "C_HOST=served-db\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, nil, tt.env, tt.args...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+			if got := envLines(stdout, "C_"); got != tt.want {
+				t.Errorf("env = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
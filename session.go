@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+var errNoRegion = errors.New("no AWS region set: use --region, AWS_REGION, AWS_DEFAULT_REGION or set region in your AWS profile")
+
+// newSession creates the AWS session shared by every client. Endpoints are
+// resolved from the region's partition (aws, aws-us-gov, aws-cn), or from
+// the partition given with --partition when set, and switched to FIPS
+// endpoints with --fips or AWS_USE_FIPS_ENDPOINT=true.
+//
+// The region is resolved in order from --region, AWS_REGION,
+// AWS_DEFAULT_REGION and then the profile's config, falling back to the
+// ECS or EC2 metadata with --region-from-metadata.
+func newSession(opts *options) (*session.Session, error) {
+	region, source := opts.Region, "--region"
+
+	for _, key := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region, source = os.Getenv(key), key
+		}
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region: aws.String(region),
+		},
+		SharedConfigState: session.SharedConfigEnable,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if region == "" {
+		if region = aws.StringValue(sess.Config.Region); region != "" {
+			source = "profile " + profileName()
+		}
+	}
+
+	if region == "" && opts.MetadataRegion {
+		var err error
+		if region, source, err = metadataRegion(sess); err != nil {
+			debugf("No region from %s: %s", source, err)
+			region = ""
+		}
+	}
+
+	if region == "" {
+		return nil, errNoRegion
+	}
+
+	debugf("Using region %s from %s", region, source)
+	sess.Config.Region = aws.String(region)
+
+	// Tag every request so CloudTrail shows which calls came from us
+	sess.Handlers.Build.PushBack(request.MakeAddToUserAgentHandler("ssm-loader", version))
+
+	// credential_process in the shared config is already handled by the
+	// session. A credentials file replaces the default provider chain.
+	if opts.CredentialsFile != "" {
+		sess.Config.Credentials = credentials.NewCredentials(&fileProvider{Path: opts.CredentialsFile})
+	}
+
+	// Every client is created from this session, so they all share the
+	// one retry budget. The SDK marks network errors retryable itself,
+	// skipping ShouldRetry unless it's enforced, so they'd otherwise keep
+	// retrying once the budget is gone.
+	if opts.RetryBudget > 0 {
+		sess.Config.Retryer = newBudgetRetryer(opts.RetryBudget)
+		sess.Config.EnforceShouldRetryCheck = aws.Bool(true)
+	}
+
+	if opts.Partition != "" {
+		partition, err := findPartition(opts.Partition, aws.StringValue(sess.Config.Region))
+		if err != nil {
+			return nil, err
+		}
+		sess.Config.EndpointResolver = partition
+	}
+
+	if opts.FIPS || strings.EqualFold(os.Getenv("AWS_USE_FIPS_ENDPOINT"), "true") {
+		if err := checkFIPSRegion(region); err != nil {
+			return nil, err
+		}
+
+		resolver := sess.Config.EndpointResolver
+		if resolver == nil {
+			resolver = endpoints.DefaultResolver()
+		}
+		sess.Config.EndpointResolver = &fipsResolver{Resolver: resolver}
+		debugf("Using FIPS endpoints")
+	}
+
+	return sess, nil
+}
+
+// findPartition looks up a partition by ID and checks region belongs to it
+func findPartition(id string, region string) (endpoints.Partition, error) {
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() != id {
+			continue
+		}
+
+		if region != "" {
+			if rp, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok && rp.ID() != id {
+				return p, fmt.Errorf("region %s is in partition %s, not %s", region, rp.ID(), id)
+			}
+		}
+
+		return p, nil
+	}
+
+	return endpoints.Partition{}, fmt.Errorf("unknown partition %q", id)
+}
+
+// profileName returns the shared config profile the session loads
+func profileName() string {
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// isolateAWSConfig keeps the shared config files and env of whoever runs
+// the tests out of the session
+func isolateAWSConfig(t *testing.T) {
+	t.Helper()
+
+	setenv(t, "AWS_CONFIG_FILE", os.DevNull)
+	setenv(t, "AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+	for _, key := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_USE_FIPS_ENDPOINT"} {
+		setenv(t, key, "")
+	}
+}
+
+func TestSessionEndpoints(t *testing.T) {
+	isolateAWSConfig(t)
+
+	tests := []struct {
+		name      string
+		opts      options
+		endpoints map[string]string
+	}{
+		{
+			name: "gov region",
+			opts: options{Region: "us-gov-west-1"},
+			endpoints: map[string]string{
+				"ssm": "https://ssm.us-gov-west-1.amazonaws.com",
+				"kms": "https://kms.us-gov-west-1.amazonaws.com",
+				"sts": "https://sts.us-gov-west-1.amazonaws.com",
+			},
+		},
+		{
+			name: "gov partition",
+			opts: options{Region: "us-gov-east-1", Partition: "aws-us-gov"},
+			endpoints: map[string]string{
+				"ssm": "https://ssm.us-gov-east-1.amazonaws.com",
+				"kms": "https://kms.us-gov-east-1.amazonaws.com",
+			},
+		},
+		{
+			name: "china region",
+			opts: options{Region: "cn-north-1"},
+			endpoints: map[string]string{
+				"ssm": "https://ssm.cn-north-1.amazonaws.com.cn",
+				"kms": "https://kms.cn-north-1.amazonaws.com.cn",
+				"sts": "https://sts.cn-north-1.amazonaws.com.cn",
+			},
+		},
+		{
+			name: "commercial region",
+			opts: options{Region: "eu-west-1"},
+			endpoints: map[string]string{
+				"ssm": "https://ssm.eu-west-1.amazonaws.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, err := newSession(&tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for service, want := range tt.endpoints {
+				if got := sess.ClientConfig(service).Endpoint; got != want {
+					t.Errorf("%s endpoint = %q, want %q", service, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFindPartition(t *testing.T) {
+	tests := []struct {
+		id      string
+		region  string
+		wantErr bool
+	}{
+		{id: "aws-us-gov", region: "us-gov-west-1"},
+		{id: "aws-cn", region: "cn-northwest-1"},
+		{id: "aws", region: "us-east-1"},
+		{id: "aws-us-gov", region: ""},
+		{id: "aws", region: "us-gov-west-1", wantErr: true},
+		{id: "aws-us-gov", region: "eu-west-1", wantErr: true},
+		{id: "aws-mars", region: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id+"/"+tt.region, func(t *testing.T) {
+			p, err := findPartition(tt.id, tt.region)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.ID() != tt.id {
+				t.Errorf("partition = %s, want %s", p.ID(), tt.id)
+			}
+		})
+	}
+}
+
+func TestSessionRegionOrder(t *testing.T) {
+	config := writeFile(t, tempDir(t), "config", "[default]\nregion = ap-south-1\n\n[profile other]\nregion = sa-east-1\n")
+
+	tests := []struct {
+		name    string
+		flag    string
+		env     map[string]string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "--region",
+			flag: "eu-west-1",
+			env:  map[string]string{"AWS_REGION": "us-west-2", "AWS_DEFAULT_REGION": "us-east-2", "AWS_CONFIG_FILE": config},
+			want: "eu-west-1",
+		},
+		{
+			name: "AWS_REGION",
+			env:  map[string]string{"AWS_REGION": "us-west-2", "AWS_DEFAULT_REGION": "us-east-2", "AWS_CONFIG_FILE": config},
+			want: "us-west-2",
+		},
+		{
+			name: "AWS_DEFAULT_REGION",
+			env:  map[string]string{"AWS_DEFAULT_REGION": "us-east-2", "AWS_CONFIG_FILE": config},
+			want: "us-east-2",
+		},
+		{
+			name: "profile",
+			env:  map[string]string{"AWS_CONFIG_FILE": config},
+			want: "ap-south-1",
+		},
+		{
+			name: "named profile",
+			env:  map[string]string{"AWS_CONFIG_FILE": config, "AWS_PROFILE": "other"},
+			want: "sa-east-1",
+		},
+		{
+			name:    "none",
+			wantErr: errNoRegion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateAWSConfig(t)
+			for key, value := range tt.env {
+				setenv(t, key, value)
+			}
+
+			sess, err := newSession(&options{Region: tt.flag})
+			if err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && aws.StringValue(sess.Config.Region) != tt.want {
+				t.Errorf("region = %s, want %s", aws.StringValue(sess.Config.Region), tt.want)
+			}
+		})
+	}
+}
+
+func TestMainWithoutRegion(t *testing.T) {
+	_, stderr, code := runMain(t, nil, []string{"AWS_REGION=", "APP_ENV=prod"}, "--no-exec")
+	if code != exitUsage || !strings.Contains(stderr, errNoRegion.Error()) {
+		t.Errorf("exit code = %d, stderr %q, want %d and %q", code, stderr, exitUsage, errNoRegion)
+	}
+}
+
+func TestSessionUserAgent(t *testing.T) {
+	isolateAWSConfig(t)
+
+	agents := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"Parameter":{"Name":"/prod/app/A","Value":"a"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	sess, err := newSession(&options{Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+		MaxRetries:  aws.Int(0),
+	}
+
+	// Every client made from the session is tagged, whatever the service
+	ssm.New(sess, cfg).GetParameter(&ssm.GetParameterInput{Name: aws.String("/prod/app/A")})
+	sts.New(sess, cfg).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+
+	for _, service := range []string{"ssm", "sts"} {
+		agent := <-agents
+		if !strings.Contains(agent, "ssm-loader/"+version) {
+			t.Errorf("%s User-Agent = %q, want ssm-loader/%s in it", service, agent, version)
+		}
+		if !strings.HasPrefix(agent, "aws-sdk-go/") {
+			t.Errorf("%s User-Agent = %q, want the SDK's own kept first", service, agent)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on a unix socket at path that only the current
+// user can connect to. The umask is what creates it that way, since a
+// chmod after the listen would leave a window where anyone could
+// connect. It's process wide, so no other file should be created while
+// it's set.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", path)
+}
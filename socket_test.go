@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenPrivate(t *testing.T) {
+	// Even with a umask that lets anyone in, the socket is owner only
+	// from the moment it's created
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	path := filepath.Join(tempDir(t), "env.sock")
+	ln, err := listenPrivate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		t.Errorf("socket mode = %v, want no access for group or others", perm)
+	}
+
+	if got := syscall.Umask(0); got != 0 {
+		t.Errorf("umask = %#o after listening, want it restored to 0", got)
+	}
+}
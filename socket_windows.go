@@ -0,0 +1,10 @@
+package main
+
+import "net"
+
+// listenPrivate listens on a unix socket at path. Windows has no umask;
+// who can connect comes from the ACL the socket inherits from its
+// directory.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
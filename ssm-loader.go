@@ -1,218 +1,1639 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
-var paramInterpolation = regexp.MustCompile("%%(.*)%%")
+var paramInterpolation = regexp.MustCompile("%%(.*?)%%")
+
+// Build information, injected at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
 
 type getParametersInput struct {
-	Client        *ssm.SSM
-	Path          *string
-	NextToken     *string
-	FetchedParams []*ssm.Parameter
+	Client   ssmiface.SSMAPI
+	Path     *string
+	MaxPages int
+	PageSize int64
+
+	// AdaptivePageSize starts with small pages, so the first parameters
+	// come back quickly, and doubles the page size up to PageSize
+	AdaptivePageSize bool
+
+	// DecryptEach fetches pages encrypted and decrypts each SecureString
+	// with its own call, DecryptConcurrency at a time
+	DecryptEach        bool
+	DecryptConcurrency int
+
+	// Clock paces the requests, defaulting to the wall clock
+	Clock clock
+
+	// ResumeDir, when set, is where progress is saved after each page so
+	// a fetch that's interrupted resumes from there, if within ResumeTTL.
+	// Source is the role and region Client fetches as, which the saved
+	// progress is only good for.
+	ResumeDir string
+	ResumeTTL time.Duration
+	Source    clientKey
+
+	// MaxParams, when set, stops fetching once more than this many
+	// parameters have been fetched
+	MaxParams int
 }
 
-type paramMap map[string]string
+// pageInterval is the pause before fetching each page
+const pageInterval = 100 * time.Millisecond
 
-func getParameters(params *getParametersInput, itr int) ([]*ssm.Parameter, error) {
-	if itr != 0 && params.NextToken == nil {
-		return params.FetchedParams, nil
+// The range of page sizes GetParametersByPath accepts
+const (
+	minPageSize = 1
+	maxPageSize = 10
+)
+
+// defaultMaxPages is the --max-pages default for full pages. Smaller
+// pages get proportionally more, so the same number of params fits.
+const defaultMaxPages = 100
+
+// scaleMaxPages is the default --max-pages for pages of pageSize, with
+// room for the small pages --adaptive-page-size starts with
+func scaleMaxPages(pageSize int, adaptive bool) int {
+	pages := defaultMaxPages * maxPageSize / pageSize
+	if adaptive {
+		pages += 3
 	}
+	return pages
+}
 
-	// Sleep for a tenth of a second before doing the next fetch
-	// so we don't get rate-limited
-	time.Sleep(100 * time.Millisecond)
+// clampPageSize keeps size within what the API accepts, warning when it
+// had to change it
+func clampPageSize(size int) int {
+	clamped := size
+	if clamped < minPageSize {
+		clamped = minPageSize
+	}
+	if clamped > maxPageSize {
+		clamped = maxPageSize
+	}
 
-	result, err := params.Client.GetParametersByPath(&ssm.GetParametersByPathInput{
-		Path:           params.Path,
-		NextToken:      params.NextToken,
-		Recursive:      aws.Bool(false),
-		MaxResults:     aws.Int64(10),
-		WithDecryption: aws.Bool(true),
-	})
+	if clamped != size {
+		log.Printf("Warning: --page-size %d is outside %d-%d, using %d\n", size, minPageSize, maxPageSize, clamped)
+	}
+	return clamped
+}
 
-	if err != nil {
-		return nil, err
+// nextPageSize returns the page size after size when growing adaptively
+// towards limit
+func nextPageSize(size, limit int64) int64 {
+	if size*2 > limit {
+		return limit
+	}
+	return size * 2
+}
+
+type decryptFailure struct {
+	Name string
+	Err  error
+}
+
+// decryptionError is returned by getParameters when one or more
+// SecureString parameters could not be decrypted. Params holds
+// everything that was fetched successfully.
+type decryptionError struct {
+	Failures []decryptFailure
+	Params   []*ssm.Parameter
+}
+
+func (e *decryptionError) Is(target error) bool {
+	return target == errDecryption
+}
+
+func (e *decryptionError) Error() string {
+	names := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		names[i] = f.Name
+	}
+	return fmt.Sprintf("unable to decrypt %d parameter(s): %s", len(names), strings.Join(names, ", "))
+}
+
+type paramMap map[string]string
+
+// loadedParams holds the params from each source before interpolation
+type loadedParams struct {
+	OSEnv     paramMap
+	SSM       paramMap
+	Stdin     paramMap
+	Overrides paramMap
+
+	// S3 holds the --s3-config values, which SSM takes precedence over
+	S3 paramMap
+
+	// Qualified holds parameters referenced by their full name, as in
+	// %%/prod/common/REGION%%, keyed by that name
+	Qualified paramMap
+
+	// Secrets holds the Secrets Manager values referenced as
+	// %%secret:ID%%, keyed by secret:ID
+	Secrets paramMap
+
+	// InterpolateOSEnv lets interpolations resolve against the OS env
+	InterpolateOSEnv bool
+}
+
+// The names of the sources, as --print-effective-config lists them
+const (
+	layerOverrides = "--set"
+	layerStdin     = "--params-stdin"
+	layerOSEnv     = "OS env"
+	layerSSM       = "SSM"
+	layerS3        = "--s3-config"
+	layerQualified = "%%/NAME%%"
+	layerSecrets   = "%%secret:ID%%"
+)
+
+type envLayer struct {
+	Name   string
+	Params paramMap
+}
+
+// valueLayers are the sources of the env's values, highest precedence
+// first. Overrides from --set win over everything, then pairs read with
+// --params-stdin, then the OS env, then SSM, then --s3-config.
+func (l *loadedParams) valueLayers() []envLayer {
+	return []envLayer{
+		{layerOverrides, l.Overrides},
+		{layerStdin, l.Stdin},
+		{layerOSEnv, l.OSEnv},
+		{layerSSM, l.SSM},
+		{layerS3, l.S3},
+	}
+}
+
+// interpolationLayers are what interpolations resolve against, highest
+// precedence first. They're the value layers in the same order, except
+// the OS env comes last and only when InterpolateOSEnv is set, after the
+// parameters and secrets referenced by their full name.
+func (l *loadedParams) interpolationLayers() []envLayer {
+	var layers []envLayer
+	for _, layer := range l.valueLayers() {
+		if layer.Name != layerOSEnv {
+			layers = append(layers, layer)
+		}
 	}
 
-	return getParameters(&getParametersInput{
-		Client:        params.Client,
-		Path:          params.Path,
-		NextToken:     result.NextToken,
-		FetchedParams: append(params.FetchedParams, result.Parameters...),
-	}, itr+1)
+	layers = append(layers, envLayer{layerQualified, l.Qualified}, envLayer{layerSecrets, l.Secrets})
+	if l.InterpolateOSEnv {
+		layers = append(layers, envLayer{layerOSEnv, l.OSEnv})
+	}
+	return layers
 }
 
-func getOSEnv() paramMap {
+// Env merges the sources into the env for the command and interpolates
+// it, in the order of valueLayers and interpolationLayers
+func (l *loadedParams) Env() paramMap {
 	m := make(paramMap)
 
-	for _, e := range os.Environ() {
-		pair := strings.SplitN(e, "=", 2)
-		m[pair[0]] = pair[1]
+	values := l.valueLayers()
+	for i := len(values) - 1; i >= 0; i-- {
+		for key, value := range values[i].Params {
+			m[key] = value
+		}
+	}
+
+	var layers []paramMap
+	for _, layer := range l.interpolationLayers() {
+		layers = append(layers, layer.Params)
 	}
 
+	m.ReplaceInterpolations(layers...)
 	return m
 }
 
-func (m paramMap) AddParams(params []*ssm.Parameter) {
-	for _, param := range params {
-		ss := strings.Split(*param.Name, "/")
-		name := ss[len(ss)-1]
-		_, exists := m[name]
-		if !exists {
-			m[name] = *param.Value
+// QualifiedRefs returns the parameter names referenced with %%/NAME%%
+// that aren't in Qualified yet, in order
+func (l *loadedParams) QualifiedRefs() []string {
+	return l.refs("/", l.Qualified)
+}
+
+// SecretRefs returns the references of the form %%secret:ID%% that
+// aren't in Secrets yet, in order
+func (l *loadedParams) SecretRefs() []string {
+	return l.refs(secretRefPrefix, l.Secrets)
+}
+
+// refs returns the names referenced in the loaded values that start with
+// prefix and aren't in fetched, in order
+func (l *loadedParams) refs(prefix string, fetched paramMap) []string {
+	seen := make(map[string]bool)
+	var refs []string
+
+	for _, m := range []paramMap{l.S3, l.SSM, l.Stdin, l.Overrides} {
+		for _, value := range m {
+			for _, match := range paramInterpolation.FindAllStringSubmatch(value, -1) {
+				name := parseRef(match[1]).Name
+
+				if _, exists := fetched[name]; exists || seen[name] || !strings.HasPrefix(name, prefix) {
+					continue
+				}
+				seen[name] = true
+				refs = append(refs, name)
+			}
 		}
 	}
+
+	sort.Strings(refs)
+	return refs
 }
 
-func (m paramMap) ReplaceInterpolations() {
-	for key, value := range m {
-		replaced := paramInterpolation.ReplaceAllStringFunc(value, func(s string) string {
-			varName := strings.Trim(s, "%")
-			replacement, exists := m[varName]
+// LoadedKeys returns the keys that came from SSM, S3, stdin or --set, rather
+// than the OS env, in order
+func (l *loadedParams) LoadedKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
 
-			if exists {
-				return replacement
+	for _, m := range []paramMap{l.S3, l.SSM, l.Stdin, l.Overrides} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
 			}
+		}
+	}
 
-			return ""
-		})
-		m[key] = replaced
+	sort.Strings(keys)
+	return keys
+}
+
+// LoadedEnv returns the interpolated values of LoadedKeys
+func (l *loadedParams) LoadedEnv() paramMap {
+	env := l.Env()
+
+	m := make(paramMap)
+	for _, key := range l.LoadedKeys() {
+		m[key] = env[key]
 	}
+	return m
 }
 
-func (m paramMap) StringArray() []string {
-	i := len(m)
-	list := make([]string, i)
-	n := 0
+// NormalizeNames normalizes the keys from every source but the OS env.
+// Only what comes from SSM and --s3-config is kept off the protected
+// keys, under its normalized name as well.
+func (l *loadedParams) NormalizeNames(protected func(key string) bool) error {
+	for _, m := range []paramMap{l.SSM, l.S3} {
+		if err := m.NormalizeNames(protected); err != nil {
+			return err
+		}
+	}
 
-	for k, v := range m {
-		list[n] = fmt.Sprintf("%s=%s", k, v)
-		n = n + 1
+	for _, m := range []paramMap{l.Stdin, l.Overrides} {
+		if err := m.NormalizeNames(nil); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return list
+// getParameters fetches every page of parameters under params.Path. It
+// stops after params.MaxPages pages (when set) in case the API keeps
+// handing back a NextToken.
+func getParameters(params *getParametersInput) ([]*ssm.Parameter, error) {
+	var fetched []*ssm.Parameter
+	var undecryptable []decryptFailure
+	var nextToken *string
+
+	clk := params.Clock
+	if clk == nil {
+		clk = realClock{}
+	}
+
+	pageSize := params.PageSize
+	if pageSize == 0 {
+		pageSize = maxPageSize
+	}
+	limit := pageSize
+
+	if params.AdaptivePageSize && pageSize > 2 {
+		pageSize = 2
+	}
+
+	path := aws.StringValue(params.Path)
+	if params.ResumeDir != "" {
+		if state := loadResume(params.ResumeDir, params.Source, path, params.ResumeTTL); state != nil {
+			debugf("Resuming %s after %d params", path, len(state.Params))
+			fetched, nextToken = state.Params, aws.String(state.NextToken)
+		}
+	}
+
+	for page := 0; ; page++ {
+		if params.MaxPages > 0 && page >= params.MaxPages {
+			log.Printf("Warning: stopped fetching %s after %d pages (--max-pages)\n", aws.StringValue(params.Path), page)
+			break
+		}
+
+		// Sleep for a tenth of a second before doing the next fetch
+		// so we don't get rate-limited
+		clk.Sleep(pageInterval)
+
+		// MaxResults limits the number of parameters per page, not their size,
+		// so advanced-tier values (up to 8KB) come back whole like any other
+		input := &ssm.GetParametersByPathInput{
+			Path:           params.Path,
+			NextToken:      nextToken,
+			Recursive:      aws.Bool(false),
+			MaxResults:     aws.Int64(pageSize),
+			WithDecryption: aws.Bool(!params.DecryptEach),
+		}
+
+		result, err := params.Client.GetParametersByPath(input)
+
+		if errors.Is(err, errPathRestart) {
+			debugf("Fetching %s again from the first page in the fallback region", path)
+			fetched, undecryptable, nextToken = nil, nil, nil
+			page = -1
+			continue
+		}
+
+		if err != nil {
+			if !isKMSError(err) || params.DecryptEach {
+				return nil, classifyError(err)
+			}
+
+			// One of the SecureStrings on this page couldn't be decrypted, which
+			// fails the whole page. Refetch it encrypted and decrypt each
+			// parameter on its own so we can tell which ones are the problem.
+			input.WithDecryption = aws.Bool(false)
+			result, err = params.Client.GetParametersByPath(input)
+
+			if err != nil {
+				return nil, classifyError(err)
+			}
+		}
+
+		if !aws.BoolValue(input.WithDecryption) {
+			var failures []decryptFailure
+			result.Parameters, failures = decryptEach(params.Client, result.Parameters, params.DecryptConcurrency)
+			undecryptable = append(undecryptable, failures...)
+		}
+
+		fetched = append(fetched, result.Parameters...)
+
+		if aws.StringValue(result.NextToken) == "" {
+			break
+		}
+
+		if params.MaxParams > 0 && len(fetched) > params.MaxParams {
+			break
+		}
+		nextToken = result.NextToken
+
+		// Parameters that failed to decrypt aren't saved, so once there
+		// are any a resumed run has to start again from here
+		if params.ResumeDir != "" && len(undecryptable) == 0 {
+			saveResume(params.ResumeDir, &resumeState{
+				Source:    params.Source,
+				Path:      path,
+				NextToken: aws.StringValue(nextToken),
+				Params:    fetched,
+			})
+		}
+
+		if params.AdaptivePageSize {
+			pageSize = nextPageSize(pageSize, limit)
+		}
+	}
+
+	if params.ResumeDir != "" {
+		clearResume(params.ResumeDir, params.Source, path)
+	}
+
+	if len(undecryptable) > 0 {
+		return fetched, &decryptionError{
+			Failures: undecryptable,
+			Params:   fetched,
+		}
+	}
+
+	return fetched, nil
 }
 
-func (m paramMap) SetOSEnv() {
-	for key, value := range m {
-		os.Setenv(key, value)
+// getParameterValue fetches a single parameter's decrypted value
+func getParameterValue(client ssmiface.SSMAPI, name string) (string, error) {
+	result, err := client.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+
+	if err != nil {
+		return "", classifyError(err)
 	}
+
+	return aws.StringValue(result.Parameter.Value), nil
 }
 
-func contains(a []string, x string) bool {
-	for _, n := range a {
-		if x == n {
-			return true
+// getParametersMaxNames is the most names GetParameters accepts at once
+const getParametersMaxNames = 10
+
+// getParametersByName fetches the named parameters in batches, returning
+// the parameters found along with any names SSM reported as invalid
+func getParametersByName(client ssmiface.SSMAPI, names []string) ([]*ssm.Parameter, []string, error) {
+	var params []*ssm.Parameter
+	var invalid []string
+
+	for start := 0; start < len(names); start += getParametersMaxNames {
+		end := start + getParametersMaxNames
+		if end > len(names) {
+			end = len(names)
+		}
+
+		result, err := client.GetParameters(&ssm.GetParametersInput{
+			Names:          aws.StringSlice(names[start:end]),
+			WithDecryption: aws.Bool(true),
+		})
+
+		if err != nil {
+			return nil, nil, classifyError(err)
 		}
+
+		params = append(params, result.Parameters...)
+		invalid = append(invalid, aws.StringValueSlice(result.InvalidParameters)...)
 	}
-	return false
+
+	return params, invalid, nil
 }
 
-func main() {
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+// normalizePath makes sure path ends in a slash so it only matches
+// parameters beneath it
+func normalizePath(path string) string {
+	return strings.TrimSuffix(strings.TrimSpace(path), "/") + "/"
+}
+
+// decryptEach fetches every SecureString in params individually with
+// decryption, up to concurrency at once, returning the decrypted
+// parameters and any that failed
+func decryptEach(client ssmiface.SSMAPI, params []*ssm.Parameter, concurrency int) ([]*ssm.Parameter, []decryptFailure) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
-	svc := ssm.New(sess)
+	// Each call fills in its own slot, so the order is kept however the
+	// calls finish
+	results := make([]*ssm.Parameter, len(params))
+	errs := make([]error, len(params))
 
-	appName := os.Getenv("APP_NAME")
-	appEnv := os.Getenv("APP_ENV")
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for i, param := range params {
+		if aws.StringValue(param.Type) != ssm.ParameterTypeSecureString {
+			results[i] = param
+			continue
+		}
 
-	paramMap := getOSEnv()
+		wg.Add(1)
+		sem <- struct{}{}
 
-	if appEnv == "" {
-		appEnv = os.Getenv("WORKPATH_ENV")
+		go func(i int, param *ssm.Parameter) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			result, err := client.GetParameter(&ssm.GetParameterInput{
+				Name:           param.Name,
+				WithDecryption: aws.Bool(true),
+			})
+
+			if err != nil {
+				errs[i] = classifyError(err)
+				return
+			}
+			results[i] = result.Parameter
+		}(i, param)
 	}
 
-	var allParams []*ssm.Parameter
+	wg.Wait()
+
+	var decrypted []*ssm.Parameter
+	var failures []decryptFailure
+
+	for i, param := range params {
+		if errs[i] != nil {
+			failures = append(failures, decryptFailure{Name: aws.StringValue(param.Name), Err: errs[i]})
+			continue
+		}
+		decrypted = append(decrypted, results[i])
+	}
 
-	if appEnv != "" {
-		sharedParams, sharedErr := getParameters(&getParametersInput{
-			Client: svc,
-			Path:   aws.String(fmt.Sprintf("/%s/", appEnv)),
-		}, 0)
+	return decrypted, failures
+}
 
-		if sharedErr != nil {
-			log.Fatalln("Error fetching shared params: ", sharedErr.Error())
+// fetchPath loads the parameters under path with client, which fetches
+// as source, handling any that couldn't be decrypted according to
+// --skip-undecryptable
+func fetchPath(client ssmiface.SSMAPI, source clientKey, label string, path string, opts *options) ([]*ssm.Parameter, error) {
+	if incremental != nil {
+		if params, ok := incremental.Fetch(client, path); ok {
+			return limitParams(label, params)
 		}
+	}
 
-		allParams = sharedParams
+	// Fetch one more than the budget allows, so going over can be told
+	// apart from loading exactly --max-params
+	maxParams := 0
+	if paramLimit != nil {
+		maxParams = paramLimit.Remaining() + 1
 	}
 
-	if appName != "" {
-		appParams, appErr := getParameters(&getParametersInput{
-			Client: svc,
-			Path:   aws.String(fmt.Sprintf("/%s/%s/", appEnv, appName)),
-		}, 0)
+	params, err := getParameters(&getParametersInput{
+		Client:   client,
+		Path:     aws.String(path),
+		MaxPages: opts.MaxPages,
+		PageSize: int64(opts.PageSize),
 
-		if appErr != nil {
-			log.Fatalln("Error fetching app params: ", appErr.Error())
+		AdaptivePageSize: opts.AdaptivePageSize,
+
+		DecryptEach:        opts.DecryptEach,
+		DecryptConcurrency: opts.DecryptWorkers,
+
+		ResumeDir: opts.ResumeDir,
+		ResumeTTL: opts.ResumeTTL,
+		Source:    source,
+
+		MaxParams: maxParams,
+	})
+
+	if err != nil {
+		if params, err = handleDecryptionError(label, err, opts.SkipUndecryptable); err != nil {
+			return nil, err
 		}
+	}
+
+	if len(params) == 0 && opts.WarnEmptyPath {
+		log.Printf("Warning: no parameters found under %s\n", path)
+	}
 
-		allParams = append(allParams, appParams...)
+	if incremental != nil {
+		incremental.Record(path, params)
 	}
 
-	paramMap.AddParams(allParams)
-	paramMap.ReplaceInterpolations()
+	return limitParams(label, params)
+}
+
+// limitParams counts params against --max-params, when set
+func limitParams(label string, params []*ssm.Parameter) ([]*ssm.Parameter, error) {
+	if paramLimit == nil {
+		return params, nil
+	}
+	return paramLimit.Take(label, params)
+}
 
-	// Grab runner args
-	args := os.Args[1:]
+// handleDecryptionError reports which parameters couldn't be decrypted.
+// When skip is set the remaining parameters are returned, otherwise it's
+// an error, as is anything other than a decryption failure.
+func handleDecryptionError(label string, err error, skip bool) ([]*ssm.Parameter, error) {
+	var derr *decryptionError
+	if !errors.As(err, &derr) {
+		return nil, fmt.Errorf("fetching %s params: %w", label, err)
+	}
 
-	if len(args) == 0 || contains(args, "-h") || contains(args, "--help") {
-		fmt.Println("")
-		fmt.Println("Usage:  ssm-loader [options] [command]")
-		fmt.Println("")
-		fmt.Println("Loads parameters from the SSM Parameter Store")
-		fmt.Println("")
-		fmt.Println("Environment Variables:")
-		fmt.Println("  APP_ENV   The application's environment")
-		fmt.Println("  APP_NAME  The name of the application")
-		fmt.Println("")
-		fmt.Println("Options:")
-		fmt.Println("  -h, --help  Shows this output")
-		fmt.Println("  -O          Prints the env to stdout")
-		// fmt.Println("  -a, --app   Application name (default \"$APP_NAME\"")
-		// fmt.Println("  -e, --env   Application environment (default \"$APP_ENV\"")
-		os.Exit(0)
+	for _, f := range derr.Failures {
+		log.Printf("Unable to decrypt %s: %s\n", f.Name, f.Err.Error())
 	}
 
-	// If we have the output flag
-	if contains(args, "-O") {
-		for _, value := range paramMap.StringArray() {
-			fmt.Println(value)
+	if !skip {
+		return nil, fmt.Errorf("fetching %s params: %w (use --skip-undecryptable to continue without them)", label, derr)
+	}
+
+	return derr.Params, nil
+}
+
+// How to pick between duplicate entries for a key in the OS env
+const (
+	envDuplicatesFirst = "first"
+	envDuplicatesLast  = "last"
+)
+
+// getOSEnv splits each KEY=VALUE entry on its first "=", so values that
+// contain "=" themselves are kept whole. Entries without "=" are skipped,
+// and duplicates is which entry for a key wins, first or last.
+func getOSEnv(duplicates string) paramMap {
+	return parseEnviron(os.Environ(), duplicates)
+}
+
+func parseEnviron(environ []string, duplicates string) paramMap {
+	m := make(paramMap)
+
+	for _, e := range environ {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) != 2 {
+			debugf("Skipping malformed env entry %q", e)
+			continue
 		}
-		os.Exit(0)
+
+		if _, exists := m[pair[0]]; exists && duplicates == envDuplicatesFirst {
+			continue
+		}
+		m[pair[0]] = pair[1]
+	}
+
+	return m
+}
+
+// parseEnvLines parses KEY=VALUE lines, as written by -O, unquoting
+// double quoted values. Blank lines and lines starting with # are skipped.
+// source names the input in errors.
+func parseEnvLines(data []byte, source string) (paramMap, error) {
+	m := make(paramMap)
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", source, i+1)
+		}
+		m[strings.TrimSpace(pair[0])] = dotenvUnquote(pair[1])
 	}
 
-	// Set command to first arg
-	cmd := exec.Command(args[0], args[1:]...)
+	return m, nil
+}
 
-	// Pipe everything to the command
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = paramMap.StringArray()
+func (m paramMap) AddParams(params []*ssm.Parameter, po *paramOptions) error {
+	for name, param := range po.Dedup(params) {
+		if po.protected(name) {
+			log.Printf("Warning: not setting %s from %s, it's a protected key (see --protected-keys)\n", name, *param.Name)
+			continue
+		}
 
-	err := cmd.Start()
-	if err != nil {
-		log.Fatalln("Error while starting command: ", err)
+		_, exists := m[name]
+		if !exists {
+			value, err := sanitizeValue(*param.Name, *param.Value, po.Sanitize)
+			if err != nil {
+				return err
+			}
+			m[name] = value
+		}
+	}
+	return nil
+}
+
+// ReplaceInterpolations replaces each %%KEY%% in the values of m. KEY is
+// looked up in each of layers in order, falling back to the default in
+// %%KEY:-default%% (or an empty string) when no layer has it. With no
+// layers, KEY is looked up in m itself. The value is then piped through
+// any functions, as in %%KEY|trim|upper%%.
+func (m paramMap) ReplaceInterpolations(layers ...paramMap) {
+	if len(layers) == 0 {
+		layers = []paramMap{m}
+	}
+
+	for key, value := range m {
+		m[key] = interpolate(value, layers)
+	}
+}
+
+// Interpolate replaces each %%KEY%% in s with its value from m
+func (m paramMap) Interpolate(s string) string {
+	return interpolate(s, []paramMap{m})
+}
+
+// interpolatePartial replaces the %%KEY%% references in value that m has
+// a key for, leaving the rest (and their defaults) for a later pass
+func interpolatePartial(value string, m paramMap) string {
+	return paramInterpolation.ReplaceAllStringFunc(value, func(s string) string {
+		ref := parseRef(s[2 : len(s)-2])
+
+		if replacement, exists := m[ref.Name]; exists {
+			return ref.Apply(replacement)
+		}
+		return s
+	})
+}
+
+func interpolate(value string, layers []paramMap) string {
+	return paramInterpolation.ReplaceAllStringFunc(value, func(s string) string {
+		ref := parseRef(s[2 : len(s)-2])
+
+		for _, layer := range layers {
+			if replacement, exists := layer[ref.Name]; exists {
+				return ref.Apply(replacement)
+			}
+		}
+
+		return ref.Apply(ref.Fallback)
+	})
+}
+
+// StringArray formats each entry as KEY=VALUE for a command's env. Values
+// are not escaped, so a value containing "=" such as opts=a=b reads back
+// identically when split on the first "=" (as getOSEnv does). Output
+// meant to be parsed goes through WriteDotenv instead.
+func (m paramMap) StringArray() []string {
+	i := len(m)
+	list := make([]string, i)
+	n := 0
+
+	for k, v := range m {
+		list[n] = fmt.Sprintf("%s=%s", k, v)
+		n = n + 1
+	}
+
+	return list
+}
+
+func (m paramMap) Copy() paramMap {
+	c := make(paramMap, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Masked returns a copy of m with the values of keys replaced by ***, for
+// output meant to be read rather than used
+func (m paramMap) Masked(keys []string) paramMap {
+	c := m.Copy()
+	for _, key := range keys {
+		if _, ok := c[key]; ok {
+			c[key] = "***"
+		}
+	}
+	return c
+}
+
+func (m paramMap) SetOSEnv() {
+	for key, value := range m {
+		os.Setenv(key, value)
 	}
+}
+
+func main() {
+	opts, args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+
+	// Print build info before doing any AWS work so this works
+	// without credentials present
+	if opts.Version {
+		fmt.Printf("ssm-loader %s (commit %s, built %s)\n", version, commit, date)
+		os.Exit(0)
+	}
+
+	if opts.Help || (len(args) == 0 && !opts.Output && !opts.Tree && opts.WriteEnv == "" && opts.SystemdEnv == "" && opts.K8sSecret == "" && opts.Serve == "" && !opts.PrintConfig && !opts.NoExec && !opts.NestedJSON && !opts.DiffEnv && !opts.KeysOnly && opts.CommandParam == "") {
+		printUsage()
+		os.Exit(0)
+	}
+
+	sub, err := subcommand(args)
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+
+	// ssm-loader export [--fish] prints the env as shell statements, as
+	// does -O with --export-format. There's no program called export to
+	// shadow, so it needs no prefix.
+	var exportOpts *exportOptions
+	if len(args) > 0 && args[0] == "export" {
+		exportOpts, err = parseExportArgs(args[1:], opts.ExportFormat)
+		if err != nil {
+			fatal(exitUsage, err)
+		}
+	}
+
+	// ssm-loader :warmup keeps the --cache-file fresh for other invocations
+	warmup := sub == "warmup"
+	if warmup && opts.CacheFile == "" {
+		fatal(exitUsage, ":warmup needs --cache-file")
+	}
+
+	// ssm-loader :find KEY [PATH] lists the parameters that set KEY
+	var findOpts *findOptions
+	if sub == "find" {
+		findOpts, err = parseFindArgs(args[1:])
+		if err != nil {
+			fatal(exitUsage, err)
+		}
+	}
+
+	// ssm-loader :probe --path PATH times fetching PATH
+	var probeOpts *probeOptions
+	if sub == "probe" {
+		probeOpts, err = parseProbeArgs(args[1:])
+		if err != nil {
+			fatal(exitUsage, err)
+		}
+	}
+
+	verbose = opts.Verbose
+
+	// ssm-loader :connect SOCKET [command...] takes the env from a --serve
+	// server instead of SSM, so it needs no AWS session
+	if sub == "connect" {
+		runConnect(args[1:], opts)
+	}
+
+	sess, err := newSession(opts)
+	if err != nil {
+		if err == errNoRegion {
+			fatal(exitUsage, err)
+		}
+		fatal(exitError, "Error creating AWS session: ", err)
+	}
+
+	// The client is created once and shared by every fetch, including the
+	// polling in watch mode. Its credentials refresh themselves through the
+	// session's provider, so there's no need to recreate it.
+	// Handlers have to be in place before any client is created
+	stats := &fetchStats{}
+	stats.Attach(&sess.Handlers)
+	if opts.Trace {
+		attachTrace(&sess.Handlers)
+	}
+
+	svc := newSSMClient(sess)
+	if opts.FallbackRegion != "" {
+		fallback := newSSMClient(sess, &aws.Config{Region: aws.String(opts.FallbackRegion)})
+		svc = newFailoverClient(svc, fallback, opts.FallbackRegion)
+	}
+	clients := newClientSet(sess, svc)
+
+	if findOpts != nil {
+		if err := findKey(svc, os.Stdout, findOpts); err != nil {
+			fatal(exitCodeFor(err), "Error finding "+findOpts.Key+": ", err)
+		}
+		os.Exit(0)
+	}
+
+	if probeOpts != nil {
+		probe(svc, stats, os.Stdout, probeOpts, opts)
+		os.Exit(0)
+	}
+
+	// Whoever can write the parameter decides what runs here, so it
+	// should be as locked down as the deploy itself
+	if len(args) == 0 && opts.CommandParam != "" {
+		command, err := getParameterValue(svc, opts.CommandParam)
+		if err != nil {
+			fatal(exitCodeFor(err), "Error fetching the command from "+opts.CommandParam+": ", err.Error())
+		}
+		if strings.TrimSpace(command) == "" {
+			fatal(exitUsage, opts.CommandParam+" has no command")
+		}
+
+		debugf("Running the command from %s: %s", opts.CommandParam, command)
+		args = shellCommand(command)
+	}
+
+	appName := os.Getenv("APP_NAME")
+	appEnv := os.Getenv("APP_ENV")
+
+	loaded := &loadedParams{
+		OSEnv:     getOSEnv(opts.EnvDuplicates),
+		SSM:       make(paramMap),
+		Overrides: opts.Sets,
+
+		InterpolateOSEnv: opts.InterpolateOSEnv,
+	}
+
+	// Stdin is read to EOF here, so the command gets the null device
+	// rather than a stdin that's already used up
+	if opts.ParamsStdin {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(exitError, "Error reading params from stdin: ", err)
+		}
+
+		if loaded.Stdin, err = parseEnvLines(data, "stdin"); err != nil {
+			fatal(exitUsage, "Error reading params from stdin: ", err)
+		}
+	}
+
+	if opts.S3Config != nil {
+		var err error
+		if loaded.S3, err = getS3Config(s3.New(sess), opts.S3Config); err != nil {
+			fatal(exitCodeFor(err), "Error fetching "+opts.S3Config.String()+": ", err)
+		}
+	}
+
+	if appEnv == "" {
+		appEnv = os.Getenv("WORKPATH_ENV")
+	}
+
+	if appEnv == "" {
+		switch opts.OnMissingEnv {
+		case onMissingEnvError:
+			fatal(exitUsage, "No environment set: APP_ENV or WORKPATH_ENV is required")
+		case onMissingEnvWarn:
+			log.Println("Warning: neither APP_ENV nor WORKPATH_ENV is set, skipping the shared parameters")
+		}
+	}
+
+	sharedPath := fmt.Sprintf("/%s/", appEnv)
+	appPath := fmt.Sprintf("/%s/%s/", appEnv, appName)
+
+	// Additional paths, including any read from bootstrap parameters, with
+	// globs expanded to the paths they match
+	var extraPaths []pathSpec
+	guards := make(map[string]*string)
+	for _, spec := range opts.Paths {
+		if spec.IfParam != "" {
+			if _, checked := guards[spec.IfParam]; !checked {
+				value, err := getParameterValue(svc, spec.IfParam)
+				switch {
+				case err == nil:
+					guards[spec.IfParam] = &value
+				case errors.Is(err, errNotFound):
+					guards[spec.IfParam] = nil
+				default:
+					fatal(exitCodeFor(err), "Error fetching "+spec.IfParam+": ", err.Error())
+				}
+			}
+
+			if value := guards[spec.IfParam]; value == nil || *value != spec.IfValue {
+				debugf("Skipping %s: %s isn't %s", spec.Path, spec.IfParam, spec.IfValue)
+				continue
+			}
+		}
+
+		if !isPathGlob(spec.Path) {
+			extraPaths = append(extraPaths, spec)
+			continue
+		}
+
+		matches, err := expandPathGlob(clients.For(spec.Role, spec.Region), spec.Path)
+		if err != nil {
+			fatal(exitCodeFor(err), "Error expanding "+spec.Path+": ", err.Error())
+		}
+		if len(matches) == 0 {
+			debugf("No paths match %s", spec.Path)
+		}
+
+		for _, match := range matches {
+			extraPaths = append(extraPaths, pathSpec{Path: match, Role: spec.Role, Region: spec.Region})
+		}
+	}
+
+	for _, name := range opts.PathsFromParams {
+		value, err := getParameterValue(svc, name)
+		if err != nil {
+			fatal(exitCodeFor(err), "Error fetching path from "+name+": ", err.Error())
+		}
+		extraPaths = append(extraPaths, pathSpec{Path: normalizePath(value)})
+	}
+
+	po := &paramOptions{
+		KeyFormat:     opts.KeyFormat,
+		Delimiter:     opts.FlattenDelimiter,
+		Separator:     opts.NamespaceSep,
+		StripSuffixes: opts.StripSuffixes,
+		BasePaths:     []string{sharedPath, appPath},
+		Sanitize:      opts.SanitizeValues,
+		DedupStrategy: opts.DedupStrategy,
+		Protected:     opts.ProtectedKeys,
+	}
+
+	if opts.NamesPrecedence != namesDedup {
+		po.Named = make(map[string]bool)
+		for _, name := range opts.Names {
+			po.Named[name] = true
+		}
+	}
+
+	if len(opts.Overlays) > 0 {
+		po.Overlay = &overlayOptions{Env: appEnv, OtherEnvs: opts.OverlayEnvs}
+	}
+	for _, spec := range opts.Overlays {
+		po.BasePaths = append(po.BasePaths, spec.Path)
+		po.Overlay.Paths = append(po.Overlay.Paths, spec.Path)
+	}
+
+	for _, spec := range extraPaths {
+		po.BasePaths = append(po.BasePaths, spec.Path)
+	}
+
+	if opts.PrintConfig {
+		config := effectiveConfig{
+			Region:  aws.StringValue(sess.Config.Region),
+			AppEnv:  appEnv,
+			AppName: appName,
+			Names:   opts.Names,
+			Options: *opts,
+		}
+
+		if appEnv != "" {
+			config.Paths = append(config.Paths, pathSpec{Path: sharedPath})
+		}
+		if appName != "" {
+			config.Paths = append(config.Paths, pathSpec{Path: appPath})
+		}
+		config.Paths = append(config.Paths, opts.Overlays...)
+		config.Paths = append(config.Paths, extraPaths...)
+
+		if err := printEffectiveConfig(os.Stdout, config); err != nil {
+			fatal(exitError, "Error printing the config: ", err)
+		}
+		os.Exit(exitOK)
+	}
+
+	// fetchAll fetches every path and the named params, filtered by
+	// --filter-type and --filter-key-id. It's a function so --wait-for
+	// and reloads in watch mode can fetch again.
+	fetchAll := func() ([]*ssm.Parameter, error) {
+		var allParams []*ssm.Parameter
+
+		if incremental != nil {
+			incremental.Start()
+		}
+		if paramLimit != nil {
+			paramLimit.Start()
+		}
+
+		// Each path (and the named params) is a layer. With
+		// --interpolate-layers, references within a layer are resolved
+		// against that layer before everything is merged.
+		addLayer := func(params []*ssm.Parameter) {
+			if opts.InterpolateLayers {
+				po.InterpolateWithin(params)
+			}
+			allParams = append(allParams, params...)
+		}
+
+		// source is who a path is fetched as, with the session's region
+		// filled in
+		source := func(role, region string) clientKey {
+			if region == "" {
+				region = aws.StringValue(sess.Config.Region)
+			}
+			return clientKey{Role: role, Region: region}
+		}
+
+		if appEnv != "" {
+			params, err := fetchPath(svc, source("", ""), "shared", sharedPath, opts)
+			if err != nil {
+				return nil, err
+			}
+			addLayer(params)
+		}
+
+		if appName != "" {
+			params, err := fetchPath(svc, source("", ""), "app", appPath, opts)
+			if err != nil {
+				return nil, err
+			}
+			addLayer(params)
+		}
+
+		for _, spec := range opts.Overlays {
+			client := clients.For(spec.Role, spec.Region)
+			params, err := fetchPath(client, source(spec.Role, spec.Region), spec.Path, spec.Path, opts)
+			if err != nil {
+				return nil, err
+			}
+			overlay := overlayParams(params, po.Overlay)
+
+			clients.Track(client, overlay)
+			addLayer(overlay)
+		}
+
+		for _, spec := range extraPaths {
+			client := clients.For(spec.Role, spec.Region)
+			pathParams, err := fetchPath(client, source(spec.Role, spec.Region), spec.Path, spec.Path, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			clients.Track(client, pathParams)
+			addLayer(pathParams)
+		}
+
+		if len(opts.Names) > 0 {
+			nameParams, invalid, err := getParametersByName(svc, opts.Names)
+			if err != nil {
+				return nil, fmt.Errorf("fetching named params: %w", err)
+			}
+
+			if len(invalid) > 0 {
+				if opts.Strict {
+					return nil, &codedError{exitNotFound, fmt.Errorf("parameters not found: %s", strings.Join(invalid, ", "))}
+				}
+				log.Println("Warning: parameters not found: ", strings.Join(invalid, ", "))
+			}
+
+			if nameParams, err = limitParams("named params", nameParams); err != nil {
+				return nil, err
+			}
+			addLayer(nameParams)
+		}
+
+		if opts.FilterType != "" {
+			allParams = filterByType(allParams, opts.FilterType)
+		}
+
+		if opts.FilterKeyID != "" {
+			var err error
+			allParams, err = filterByKeyID(svc, allParams, opts.FilterKeyID)
+			if err != nil {
+				return nil, fmt.Errorf("filtering params by key: %w", err)
+			}
+		}
+
+		return allParams, nil
+	}
+
+	// mustFetchAll is fetchAll for the first fetch, which has nothing to
+	// fall back on if it fails
+	mustFetchAll := func() []*ssm.Parameter {
+		params, err := fetchAll()
+		if err != nil {
+			fatal(exitCodeFor(err), "Error loading params: ", err)
+		}
+		return params
+	}
+
+	if !opts.Since.IsZero() {
+		incremental = newIncrementalFetch(opts.Since)
+	}
+
+	if opts.MaxParams > 0 {
+		paramLimit = &paramBudget{Max: opts.MaxParams, Fail: opts.FailMaxParams}
+	}
+
+	cacheID := cacheKey(aws.StringValue(sess.Config.Region), appEnv, appName, extraPaths, opts.Overlays, opts.Names,
+		opts.FilterType, opts.FilterKeyID, opts.InterpolateLayers)
+
+	var cacheCrypt *cacheKMS
+	if opts.CacheKMSKey != "" {
+		cacheCrypt = &cacheKMS{Client: kms.New(sess), KeyID: opts.CacheKMSKey}
+	}
+
+	if warmup {
+		runWarmup(opts.CacheFile, cacheID, cacheCrypt, opts.CacheTTL/2, fetchAll)
+	}
+
+	// A fresh cache stands in for fetching. Otherwise the params are
+	// fetched and cached for the next invocation.
+	var refresh <-chan error
+	fetchCached := func() []*ssm.Parameter {
+		if opts.CacheFile == "" {
+			return mustFetchAll()
+		}
+
+		cache, ok := readCache(opts.CacheFile, cacheID, cacheCrypt)
+		if revalidating := os.Getenv(revalidateEnv) != ""; ok && !revalidating {
+			if cache.Fresh(opts.CacheTTL) {
+				debugf("Using cached params from %s", opts.CacheFile)
+				return cache.Params
+			}
+
+			// Within --swr-ttl of going stale, the cache is used while a
+			// refresh runs in the background
+			if opts.SWRTTL > 0 && cache.Fresh(opts.CacheTTL+opts.SWRTTL) {
+				debugf("Using stale cached params from %s while refreshing them", opts.CacheFile)
+				refresh = startRevalidate()
+				return cache.Params
+			}
+		}
+
+		// A stale cache is still a base for --since to fetch changes over
+		if ok && incremental != nil && cache.Paths != nil {
+			incremental.Base = cache.Paths
+		}
+
+		// Only one process populates the cache at a time. The others wait
+		// and read what it wrote, or fetch for themselves if it's slow.
+		var params []*ssm.Parameter
+		err := withFileLock(opts.CacheFile, opts.CacheLockWait, func() error {
+			if cache, ok := readCache(opts.CacheFile, cacheID, cacheCrypt); ok && cache.Fresh(opts.CacheTTL) {
+				debugf("Using cached params from %s", opts.CacheFile)
+				params = cache.Params
+				return nil
+			}
+
+			params = mustFetchAll()
+			return writeCache(opts.CacheFile, cacheID, params, cacheCrypt)
+		})
+
+		switch {
+		case err == errLockTimeout:
+			debugf("Timed out waiting for the cache lock, fetching directly")
+			return mustFetchAll()
+		case err != nil:
+			log.Println("Warning: couldn't write the cache: ", err)
+		}
+		return params
+	}
+
+	allParams := fetchCached()
+	if os.Getenv(revalidateEnv) != "" {
+		os.Exit(exitOK)
+	}
+	if len(opts.WaitFor) > 0 {
+		allParams = waitForKeys(realClock{}, allParams, mustFetchAll, po, opts.WaitFor, opts.WaitTimeout)
+	}
+
+	if opts.Tree {
+		var descriptions map[string]string
+		if opts.Describe {
+			descriptions, err = describeParameters(svc, allParams)
+			if err != nil {
+				fatal(exitCodeFor(err), "Error describing params: ", err.Error())
+			}
+		}
+
+		printTree(os.Stdout, allParams, descriptions)
+		os.Exit(0)
+	}
+
+	// redactSecure adds the SecureStrings in params to what --redact-output
+	// hides, for each source as it's fetched
+	redactSecure := func(params []*ssm.Parameter) {
+		if opts.RedactOutput {
+			addRedacted(secureValues(params))
+		}
+	}
+	redactSecure(allParams)
+
+	// addManifest sets the --manifest params under their env var names,
+	// over any key from the paths
+	addManifest := func(m paramMap) error {
+		params, missing, err := opts.Manifest.Fetch(svc)
+		if err != nil {
+			return fmt.Errorf("fetching manifest params: %w", err)
+		}
+		redactSecure(params)
+		values := opts.Manifest.Env(params)
+
+		if len(missing) > 0 {
+			if opts.Strict {
+				return &codedError{exitNotFound, fmt.Errorf("manifest parameters not found: %s", strings.Join(missing, ", "))}
+			}
+			log.Println("Warning: manifest parameters not found: ", strings.Join(missing, ", "))
+		}
+
+		for key, value := range values {
+			if po.protected(key) {
+				log.Printf("Warning: not setting %s from the manifest, it's a protected key (see --protected-keys)\n", key)
+				continue
+			}
+			m[key] = value
+		}
+		return nil
+	}
+
+	sources := loaded.OSEnv.paramSources(allParams, po)
+	if err := loaded.SSM.AddParams(allParams, po); err != nil {
+		fatal(exitError, "Error loading params: ", err)
+	}
+	loaded.SSM.Rename(opts.Renames)
+
+	if opts.S3Config != nil {
+		if err := loaded.S3.AddS3Config(opts.S3Config.String(), po); err != nil {
+			fatal(exitError, "Error loading "+opts.S3Config.String()+": ", err)
+		}
+	}
+
+	if opts.Manifest != nil {
+		if err := addManifest(loaded.SSM); err != nil {
+			fatal(exitCodeFor(err), "Error loading params: ", err)
+		}
+	}
+
+	if opts.NormalizeKeys {
+		if err := loaded.NormalizeNames(po.protected); err != nil {
+			fatal(exitUsage, err)
+		}
+	}
+
+	// Fetch what's referenced by full name. Anything not found falls back
+	// to its default like any other missing key.
+	if refs := loaded.QualifiedRefs(); len(refs) > 0 {
+		qualified, invalid, err := getParametersByName(svc, refs)
+		if err != nil {
+			fatal(exitCodeFor(err), "Error fetching referenced params: ", err.Error())
+		}
+
+		if len(invalid) > 0 {
+			if opts.Strict {
+				fatal(exitNotFound, "Referenced parameters not found: ", strings.Join(invalid, ", "))
+			}
+			log.Println("Warning: referenced parameters not found: ", strings.Join(invalid, ", "))
+		}
+
+		redactSecure(qualified)
+
+		loaded.Qualified = make(paramMap)
+		for _, param := range qualified {
+			loaded.Qualified[aws.StringValue(param.Name)] = aws.StringValue(param.Value)
+		}
+	}
+
+	// Fetch what's referenced in Secrets Manager, once for the whole run
+	if refs := loaded.SecretRefs(); len(refs) > 0 {
+		var notFound []string
+		loaded.Secrets, notFound, err = getSecrets(secretsmanager.New(sess), refs)
+		if err != nil {
+			fatal(exitCodeFor(err), "Error fetching referenced secrets: ", err.Error())
+		}
+
+		if len(notFound) > 0 {
+			if opts.Strict {
+				fatal(exitNotFound, "Referenced secrets not found: ", strings.Join(notFound, ", "))
+			}
+			log.Println("Warning: referenced secrets not found: ", strings.Join(notFound, ", "))
+		}
+
+		if opts.RedactOutput {
+			var values []string
+			for _, value := range loaded.Secrets {
+				values = append(values, value)
+			}
+			addRedacted(values)
+		}
+	}
+
+	for _, m := range []paramMap{loaded.SSM, loaded.Stdin, loaded.Overrides} {
+		if unknown := m.UnknownFuncs(); len(unknown) > 0 {
+			if opts.Strict {
+				fatal(exitUsage, "Unknown interpolation functions: ", strings.Join(unknown, ", "))
+			}
+			log.Println("Warning: unknown interpolation functions are ignored: ", strings.Join(unknown, ", "))
+		}
+	}
+
+	// load turns fetched params into the SSM layer, like it's done above
+	load := func(params []*ssm.Parameter) (paramMap, error) {
+		redactSecure(params)
+
+		m := make(paramMap)
+		if err := m.AddParams(params, po); err != nil {
+			return nil, err
+		}
+		m.Rename(opts.Renames)
+
+		if opts.Manifest != nil {
+			if err := addManifest(m); err != nil {
+				return nil, err
+			}
+		}
+
+		if opts.NormalizeKeys {
+			if err := m.NormalizeNames(po.protected); err != nil {
+				return nil, &codedError{exitUsage, err}
+			}
+		}
+		return m, nil
+	}
+
+	// reload fetches and loads everything again, for watch mode's SIGHUP
+	// and --serve-refresh. It fails rather than exiting, so what's already
+	// loaded can be kept.
+	reload := func() (paramMap, error) {
+		params, err := fetchAll()
+		if err != nil {
+			return nil, err
+		}
+		return load(params)
+	}
+
+	for _, r := range opts.Renames {
+		if name, exists := sources[r.From]; exists {
+			delete(sources, r.From)
+			sources[r.To] = name
+		}
+	}
+
+	if len(opts.KnownKeys) > 0 {
+		if unknown := loaded.SSM.UnknownKeys(opts.KnownKeys); len(unknown) > 0 {
+			if opts.FailUnknown {
+				fatal(exitUsage, "Unknown parameters loaded: ", strings.Join(unknown, ", "))
+			}
+			log.Println("Unknown parameters loaded: ", strings.Join(unknown, ", "))
+		}
+	}
+
+	// The run ID is set like an inherited variable, so one that's already
+	// set by any source is kept
+	var runID string
+	if opts.RunIDKey != "" {
+		if id, exists := loaded.Env()[opts.RunIDKey]; exists {
+			runID = id
+		} else {
+			if runID, err = newRunID(opts.RunIDFormat); err != nil {
+				fatal(exitError, "Error generating a run ID: ", err)
+			}
+			loaded.OSEnv[opts.RunIDKey] = runID
+		}
+		debugf("Run ID %s", runID)
+	}
+
+	paramMap := loaded.Env()
+
+	if opts.ValidateNames {
+		if invalid := loaded.LoadedEnv().InvalidNames(); len(invalid) > 0 {
+			fatal(exitUsage, "Keys that aren't valid env var names (see --normalize-keys): ", strings.Join(invalid, ", "))
+		}
+	}
+
+	if opts.MaxValueLength > 0 {
+		if long := paramMap.longValues(loaded.SSM.SortedKeys(), opts.MaxValueLength); len(long) > 0 {
+			if opts.FailLongValues {
+				fatal(exitUsage, fmt.Sprintf("Values longer than %d bytes: %s", opts.MaxValueLength, strings.Join(long, ", ")))
+			}
+			log.Printf("Warning: values longer than %d bytes: %s\n", opts.MaxValueLength, strings.Join(long, ", "))
+		}
+	}
+
+	if opts.Schema != nil {
+		if err := paramMap.Validate(opts.Schema); err != nil {
+			fatal(exitUsage, err)
+		}
+	}
+
+	if opts.Types != nil {
+		if err := paramMap.Validate(opts.Types); err != nil {
+			fatal(exitUsage, err)
+		}
+	}
+
+	if opts.Metrics != nil {
+		stats.Params = len(loaded.SSM)
+		stats.Emit(opts.Metrics)
+	}
+
+	// Everything loaded and passed the checks above
+	if opts.NoExec {
+		debugf("Loaded %d params, not running anything", len(loaded.LoadedKeys()))
+		os.Exit(exitOK)
+	}
+
+	if opts.Serve != "" {
+		if err := serveEnv(opts.Serve, loaded, reload, opts.ServeRefresh); err != nil {
+			fatal(exitError, "Error serving env: ", err)
+		}
+		os.Exit(0)
+	}
+
+	// The Secret holds what was loaded rather than the whole env, which
+	// would drag in the OS env of wherever this runs
+	if opts.K8sSecret != "" {
+		paramMap.k8sSecret(os.Stdout, opts.K8sSecret, loaded.LoadedKeys())
+		os.Exit(0)
+	}
+
+	if opts.KeysOnly {
+		for _, key := range loaded.LoadedKeys() {
+			fmt.Println(key)
+		}
+		os.Exit(0)
+	}
+
+	if opts.DiffEnv {
+		writeEnvDiff(os.Stdout, paramMap, loaded.OSEnv, loaded.LoadedKeys(), opts.Masks)
+		os.Exit(0)
+	}
+
+	if opts.NestedJSON {
+		sep := opts.FlattenDelimiter
+		if opts.NamespaceSep != "" {
+			sep = opts.NamespaceSep
+		}
+
+		if err := paramMap.writeNestedJSON(os.Stdout, loaded.LoadedKeys(), sep); err != nil {
+			fatal(exitUsage, "Error writing nested JSON: ", err)
+		}
+		os.Exit(0)
+	}
+
+	if opts.Output && opts.ExportFormat != "" {
+		exportOpts = &exportOptions{Format: opts.ExportFormat}
+	}
+
+	if exportOpts != nil {
+		paramMap.Masked(opts.Masks).Export(os.Stdout, exportOpts)
+		os.Exit(0)
+	}
+
+	// If we have the output flag
+	if opts.Output {
+		paramMap.Masked(opts.Masks).WriteDotenv(os.Stdout)
+		os.Exit(0)
+	}
+
+	commands, err := splitCommands(args)
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+
+	if opts.ResultFile != "" && len(commands) > 0 {
+		result = &runResult{Path: opts.ResultFile, Start: time.Now(), Params: len(loaded.SSM), RunID: runID}
+	}
+
+	if opts.ExecReplace && len(commands) > 0 {
+		// ssm-loader isn't around to filter what the command writes, or
+		// to hand it anything but the stdin it was given
+		if opts.RedactOutput {
+			fatal(exitUsage, "--exec-replace can't be used with --redact-output, the command's output would go out unredacted")
+		}
+		if opts.NoStdin {
+			fatal(exitUsage, "--exec-replace can't be used with --no-stdin, the command inherits stdin as is")
+		}
+	}
+
+	if len(opts.RefreshKeys) > 0 && len(commands) > 0 {
+		if opts.ExecReplace {
+			fatal(exitUsage, "--exec-replace can't be used with --refresh-key, which has to stay running to restart the command")
+		}
+		// Once a background refresh has written the cache, the command
+		// is restarted with what it fetched
+		if refresh != nil {
+			revalidated = make(chan []*ssm.Parameter, 1)
+			go func() {
+				if err := <-refresh; err != nil {
+					log.Println("Warning: couldn't refresh the cache: ", err)
+					return
+				}
+				if cache, ok := readCache(opts.CacheFile, cacheID, cacheCrypt); ok {
+					revalidated <- cache.Params
+				}
+			}()
+		}
+
+		runWatched(clients, commands, loaded, sources, reload, load, opts)
+		result.write(exitOK)
+		return
+	}
+
+	if err := paramMap.Render(opts.Renders, opts.Strict); err != nil {
+		fatal(exitError, "Error rendering templates: ", err)
+	}
+
+	envsubstFiles, err := readEnvsubstFiles(opts.EnvsubstFiles, opts.EnvsubstSuffix)
+	if err != nil {
+		fatal(exitError, "Error reading files to substitute: ", err)
+	}
+
+	if err := paramMap.Envsubst(envsubstFiles, opts.Strict); err != nil {
+		fatal(exitError, "Error substituting files: ", err)
+	}
+
+	if err := paramMap.WriteFiles(opts.FileOuts); err != nil {
+		fatal(exitError, "Error writing files: ", err)
+	}
+
+	if opts.WriteEnv != "" {
+		if err := paramMap.WriteEnvFile(opts.WriteEnv); err != nil {
+			fatal(exitError, "Error writing env file: ", err)
+		}
+	}
+
+	if opts.SystemdEnv != "" {
+		if err := paramMap.WriteSystemdEnvFile(opts.SystemdEnv); err != nil {
+			fatal(exitError, "Error writing systemd env file: ", err)
+		}
+	}
+
+	// With no command, writing the env files is all there is to do
+	if len(args) == 0 && (opts.WriteEnv != "" || opts.SystemdEnv != "") {
+		os.Exit(0)
+	}
+
+	last := len(commands) - 1
+	runSequence(commands[:last], paramMap, opts)
+
+	if opts.ExecReplace {
+		err := execReplace(commands[last], paramMap, opts)
+		fatal(startExitCode(err), "Error replacing ssm-loader with the command: ", err)
+	}
+
+	cmd := startCommand(commands[last], paramMap, opts)
 
-	err = cmd.Wait()
+	err = waitCommand(cmd)
 
 	if err != nil {
-		log.Fatalln("Command finished with err: ", err)
+		fatal(commandExitCode(err), "Command finished with err: ", err)
 	}
+	result.write(exitOK)
 }
@@ -0,0 +1,2001 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// TestMain runs main itself, against a fakeSSM holding the params in
+// SSM_LOADER_TEST_PARAMS, when the test binary is started by runMain.
+// SSM_LOADER_TEST_ERR, as CODE:MESSAGE, is an AWS error for every call
+// to fail with. SSM_LOADER_TEST_TRACE logs each client created and each
+// call made to stderr.
+func TestMain(m *testing.M) {
+	if os.Getenv("SSM_LOADER_TEST_MAIN") != "1" {
+		os.Exit(m.Run())
+	}
+
+	var params []fakeParam
+	if err := json.Unmarshal([]byte(os.Getenv("SSM_LOADER_TEST_PARAMS")), &params); err != nil {
+		panic(err)
+	}
+
+	fake := newFakeSSM(params...)
+	if failure := os.Getenv("SSM_LOADER_TEST_ERR"); failure != "" {
+		pair := strings.SplitN(failure, ":", 2)
+		fake.Err = awserr.New(pair[0], pair[len(pair)-1], nil)
+	}
+
+	trace := os.Getenv("SSM_LOADER_TEST_TRACE") != ""
+	if trace {
+		fake.Trace = os.Stderr
+	}
+
+	newSSMClient = func(p client.ConfigProvider, cfgs ...*aws.Config) ssmiface.SSMAPI {
+		if trace {
+			fmt.Fprintln(os.Stderr, "fake: new SSM client")
+		}
+		return fake
+	}
+
+	main()
+	os.Exit(exitOK)
+}
+
+// runMain runs ssm-loader with args against a fakeSSM holding params.
+// The env is env on top of just enough for an AWS session.
+func runMain(t *testing.T, params []fakeParam, env []string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	return runMainStdin(t, "", params, env, args...)
+}
+
+// runMainStdin is runMain with stdin as the loader's stdin
+func runMainStdin(t *testing.T, stdin string, params []fakeParam, env []string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append([]string{
+		"SSM_LOADER_TEST_MAIN=1",
+		"SSM_LOADER_TEST_PARAMS=" + string(data),
+		"PATH=" + os.Getenv("PATH"),
+		"AWS_REGION=us-east-1",
+		"AWS_ACCESS_KEY_ID=test",
+		"AWS_SECRET_ACCESS_KEY=test",
+		"AWS_CONFIG_FILE=" + os.DevNull,
+		"AWS_SHARED_CREDENTIALS_FILE=" + os.DevNull,
+	}, env...)
+
+	var out, errOut bytes.Buffer
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+
+	return out.String(), errOut.String(), code
+}
+
+// fakeParam is a parameter held by fakeSSM
+type fakeParam struct {
+	Name        string
+	Value       string
+	Type        string
+	KeyID       string
+	Description string
+	Version     int64
+	Modified    time.Time
+}
+
+// fakeSSM is an in-memory SSM. Paths are listed one level deep, sorted by
+// name, with NextToken the index of the next parameter.
+type fakeSSM struct {
+	ssmiface.SSMAPI
+
+	// Denied is the SecureStrings the caller can't decrypt. Fetching one
+	// with decryption fails with a KMS AccessDeniedException.
+	Denied map[string]bool
+
+	// Endless hands back a NextToken on every page
+	Endless bool
+
+	// Err, when set, is returned from every call
+	Err error
+
+	// Delay is how long each GetParameter call takes
+	Delay time.Duration
+
+	// Trace, when set, gets a line for each call
+	Trace io.Writer
+
+	mu          sync.Mutex
+	params      []fakeParam
+	calls       map[string]int
+	pageSizes   []int64
+	inFlight    int
+	maxInFlight int
+}
+
+func newFakeSSM(params ...fakeParam) *fakeSSM {
+	f := &fakeSSM{
+		Denied: make(map[string]bool),
+		calls:  make(map[string]int),
+	}
+	for _, p := range params {
+		f.Put(p)
+	}
+	return f
+}
+
+// Put adds or replaces a parameter
+func (f *fakeSSM) Put(p fakeParam) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if p.Type == "" {
+		p.Type = ssm.ParameterTypeString
+	}
+	if p.Version == 0 {
+		p.Version = 1
+	}
+
+	for i := range f.params {
+		if f.params[i].Name == p.Name {
+			f.params[i] = p
+			return
+		}
+	}
+
+	f.params = append(f.params, p)
+	sort.Slice(f.params, func(i, j int) bool { return f.params[i].Name < f.params[j].Name })
+}
+
+// Calls is how many times the named API call was made
+func (f *fakeSSM) Calls(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[name]
+}
+
+// MaxInFlight is the most GetParameter calls that were made at once
+func (f *fakeSSM) MaxInFlight() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxInFlight
+}
+
+// PageSizes is the MaxResults of each GetParametersByPath call
+func (f *fakeSSM) PageSizes() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.pageSizes...)
+}
+
+func (f *fakeSSM) call(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[name]++
+	if f.Trace != nil {
+		fmt.Fprintln(f.Trace, "fake:", name)
+	}
+	return f.Err
+}
+
+func (f *fakeSSM) find(name string) (fakeParam, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, p := range f.params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return fakeParam{}, false
+}
+
+// parameter is p as SSM returns it, with its value encrypted unless
+// decrypt is set
+func (f *fakeSSM) parameter(p fakeParam, decrypt bool) (*ssm.Parameter, error) {
+	value := p.Value
+	if p.Type == ssm.ParameterTypeSecureString {
+		if !decrypt {
+			value = "encrypted:" + p.Value
+		} else if f.Denied[p.Name] {
+			return nil, kmsDenied(p.Name)
+		}
+	}
+
+	return &ssm.Parameter{
+		Name:             aws.String(p.Name),
+		Value:            aws.String(value),
+		Type:             aws.String(p.Type),
+		Version:          aws.Int64(p.Version),
+		LastModifiedDate: aws.Time(p.Modified),
+	}, nil
+}
+
+func kmsDenied(name string) error {
+	return awserr.New("AccessDeniedException",
+		"User is not authorized to perform kms:Decrypt on "+name, nil)
+}
+
+func (f *fakeSSM) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	if err := f.call("GetParametersByPath"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.pageSizes = append(f.pageSizes, aws.Int64Value(input.MaxResults))
+	var matches []fakeParam
+	for _, p := range f.params {
+		rest := strings.TrimPrefix(p.Name, aws.StringValue(input.Path))
+		if rest != p.Name && (aws.BoolValue(input.Recursive) || !strings.Contains(rest, "/")) {
+			matches = append(matches, p)
+		}
+	}
+	f.mu.Unlock()
+
+	start := 0
+	if token := aws.StringValue(input.NextToken); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	if start > len(matches) {
+		start = len(matches)
+	}
+
+	size := int(aws.Int64Value(input.MaxResults))
+	if size == 0 {
+		size = 10
+	}
+	end := start + size
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	output := &ssm.GetParametersByPathOutput{}
+	for _, p := range matches[start:end] {
+		param, err := f.parameter(p, aws.BoolValue(input.WithDecryption))
+		if err != nil {
+			return nil, err
+		}
+		output.Parameters = append(output.Parameters, param)
+	}
+
+	switch {
+	case f.Endless:
+		output.NextToken = aws.String(strconv.Itoa(start))
+	case end < len(matches):
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+
+	return output, nil
+}
+
+func (f *fakeSSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if err := f.call("GetParameter"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	time.Sleep(f.Delay)
+
+	p, ok := f.find(aws.StringValue(input.Name))
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil)
+	}
+
+	param, err := f.parameter(p, aws.BoolValue(input.WithDecryption))
+	if err != nil {
+		return nil, err
+	}
+	return &ssm.GetParameterOutput{Parameter: param}, nil
+}
+
+func (f *fakeSSM) GetParameters(input *ssm.GetParametersInput) (*ssm.GetParametersOutput, error) {
+	if err := f.call("GetParameters"); err != nil {
+		return nil, err
+	}
+	if len(input.Names) > 10 {
+		return nil, awserr.New("ValidationException", "1 validation error detected: Value at 'names' failed to satisfy constraint: Member must have length less than or equal to 10", nil)
+	}
+
+	output := &ssm.GetParametersOutput{}
+	for _, name := range aws.StringValueSlice(input.Names) {
+		p, ok := f.find(name)
+		if !ok {
+			output.InvalidParameters = append(output.InvalidParameters, aws.String(name))
+			continue
+		}
+
+		param, err := f.parameter(p, aws.BoolValue(input.WithDecryption))
+		if err != nil {
+			return nil, err
+		}
+		output.Parameters = append(output.Parameters, param)
+	}
+
+	return output, nil
+}
+
+// DescribeParametersPages supports the Name (Equals or BeginsWith), Path
+// (OneLevel or Recursive) and KeyId filters, one page per call. Every
+// filter has to match.
+func (f *fakeSSM) DescribeParametersPages(input *ssm.DescribeParametersInput, fn func(*ssm.DescribeParametersOutput, bool) bool) error {
+	if err := f.call("DescribeParametersPages"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	params := append([]fakeParam(nil), f.params...)
+	f.mu.Unlock()
+
+	page := &ssm.DescribeParametersOutput{}
+	for _, p := range params {
+		if !describeMatches(p, input.ParameterFilters) {
+			continue
+		}
+
+		page.Parameters = append(page.Parameters, &ssm.ParameterMetadata{
+			Name:             aws.String(p.Name),
+			Type:             aws.String(p.Type),
+			KeyId:            aws.String(p.KeyID),
+			Description:      aws.String(p.Description),
+			Version:          aws.Int64(p.Version),
+			LastModifiedDate: aws.Time(p.Modified),
+		})
+	}
+
+	fn(page, true)
+	return nil
+}
+
+func describeMatches(p fakeParam, filters []*ssm.ParameterStringFilter) bool {
+	for _, filter := range filters {
+		matched := false
+
+		for _, value := range aws.StringValueSlice(filter.Values) {
+			switch aws.StringValue(filter.Key) {
+			case "Name":
+				if aws.StringValue(filter.Option) == "BeginsWith" {
+					matched = matched || strings.HasPrefix(p.Name, value)
+				} else {
+					matched = matched || p.Name == value
+				}
+			case "Path":
+				dir := p.Name[:strings.LastIndex(p.Name, "/")]
+				if dir == "" {
+					dir = "/"
+				}
+				// SSM takes paths with or without the trailing slash
+				if value != "/" {
+					value = strings.TrimSuffix(value, "/")
+				}
+				if aws.StringValue(filter.Option) == "Recursive" {
+					matched = matched || dir == value || strings.HasPrefix(dir, strings.TrimSuffix(value, "/")+"/")
+				} else {
+					matched = matched || dir == value
+				}
+			case "KeyId":
+				matched = matched || p.KeyID == value
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeClock records the sleeps it's asked for and moves time on by them
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+// Slept is the total of the sleeps
+func (c *fakeClock) Slept() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total time.Duration
+	for _, d := range c.sleeps {
+		total += d
+	}
+	return total
+}
+
+// tempDir is a directory removed at the end of the test
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "ssm-loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// captureLog collects what's logged for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+// names returns the names of params, in order
+func names(params []*ssm.Parameter) []string {
+	list := make([]string, len(params))
+	for i, param := range params {
+		list[i] = aws.StringValue(param.Name)
+	}
+	return list
+}
+
+// values returns the values of params by name
+func values(params []*ssm.Parameter) map[string]string {
+	m := make(map[string]string, len(params))
+	for _, param := range params {
+		m[aws.StringValue(param.Name)] = aws.StringValue(param.Value)
+	}
+	return m
+}
+
+func TestGetParametersKMSAccessDenied(t *testing.T) {
+	newClient := func(denied ...string) *fakeSSM {
+		f := newFakeSSM(
+			fakeParam{Name: "/prod/app/A", Value: "a"},
+			fakeParam{Name: "/prod/app/B", Value: "b", Type: ssm.ParameterTypeSecureString},
+			fakeParam{Name: "/prod/app/C", Value: "c", Type: ssm.ParameterTypeSecureString},
+		)
+		for _, name := range denied {
+			f.Denied[name] = true
+		}
+		return f
+	}
+
+	tests := []struct {
+		name        string
+		client      *fakeSSM
+		decryptEach bool
+		want        map[string]string
+		failed      []string
+		getCalls    int
+	}{
+		{
+			name:   "all decrypted",
+			client: newClient(),
+			want:   map[string]string{"/prod/app/A": "a", "/prod/app/B": "b", "/prod/app/C": "c"},
+		},
+		{
+			name:     "one key denied",
+			client:   newClient("/prod/app/C"),
+			want:     map[string]string{"/prod/app/A": "a", "/prod/app/B": "b"},
+			failed:   []string{"/prod/app/C"},
+			getCalls: 2,
+		},
+		{
+			name:     "every key denied",
+			client:   newClient("/prod/app/B", "/prod/app/C"),
+			want:     map[string]string{"/prod/app/A": "a"},
+			failed:   []string{"/prod/app/B", "/prod/app/C"},
+			getCalls: 2,
+		},
+		{
+			name:        "decrypt each",
+			client:      newClient("/prod/app/B"),
+			decryptEach: true,
+			want:        map[string]string{"/prod/app/A": "a", "/prod/app/C": "c"},
+			failed:      []string{"/prod/app/B"},
+			getCalls:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := getParameters(&getParametersInput{
+				Client:      tt.client,
+				Path:        aws.String("/prod/app/"),
+				DecryptEach: tt.decryptEach,
+				Clock:       newFakeClock(),
+			})
+
+			if got := values(params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("params = %v, want %v", got, tt.want)
+			}
+
+			if tt.failed == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				var derr *decryptionError
+				if !errors.As(err, &derr) {
+					t.Fatalf("error = %v, want a decryptionError", err)
+				}
+				if !errors.Is(err, errDecryption) {
+					t.Errorf("errors.Is(%v, errDecryption) = false", err)
+				}
+
+				var failed []string
+				for _, f := range derr.Failures {
+					failed = append(failed, f.Name)
+					if !errors.Is(f.Err, errDecryption) {
+						t.Errorf("failure for %s = %v, want errDecryption", f.Name, f.Err)
+					}
+				}
+				if !reflect.DeepEqual(failed, tt.failed) {
+					t.Errorf("failed = %v, want %v", failed, tt.failed)
+				}
+				for _, name := range tt.failed {
+					if !strings.Contains(err.Error(), name) {
+						t.Errorf("error %q doesn't name %s", err, name)
+					}
+				}
+			}
+
+			if got := tt.client.Calls("GetParameter"); got != tt.getCalls {
+				t.Errorf("GetParameter calls = %d, want %d", got, tt.getCalls)
+			}
+		})
+	}
+}
+
+func TestGetParametersOtherAccessDenied(t *testing.T) {
+	client := newFakeSSM(fakeParam{Name: "/prod/app/A", Value: "a"})
+	client.Err = awserr.New("AccessDeniedException", "User is not authorized to perform ssm:GetParametersByPath", nil)
+
+	_, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: newFakeClock()})
+
+	if !errors.Is(err, errAccessDenied) || errors.Is(err, errDecryption) {
+		t.Errorf("error = %v, want errAccessDenied", err)
+	}
+	if got := client.Calls("GetParametersByPath"); got != 1 {
+		t.Errorf("GetParametersByPath calls = %d, want 1", got)
+	}
+}
+
+func TestHandleDecryptionError(t *testing.T) {
+	derr := &decryptionError{
+		Failures: []decryptFailure{{Name: "/prod/app/C", Err: classifyError(kmsDenied("/prod/app/C"))}},
+		Params:   []*ssm.Parameter{{Name: aws.String("/prod/app/A"), Value: aws.String("a")}},
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		skip    bool
+		want    []string
+		wantErr string
+	}{
+		{name: "skipped", err: derr, skip: true, want: []string{"/prod/app/A"}},
+		{name: "not skipped", err: derr, wantErr: "fetching app params: unable to decrypt 1 parameter(s): /prod/app/C (use --skip-undecryptable to continue without them)"},
+		{name: "other error", err: errThrottled, skip: true, wantErr: "fetching app params: request throttled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := handleDecryptionError("app", tt.err, tt.skip)
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if !errors.Is(err, tt.err) {
+					t.Errorf("error %v doesn't wrap %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := names(params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("params = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireEnv(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/SHARED", Value: "shared"},
+		{Name: "/prod/web/APP", Value: "app"},
+	}
+
+	tests := []struct {
+		name string
+		env  []string
+		args []string
+		want string
+		code int
+	}{
+		{
+			name: "APP_ENV set",
+			env:  []string{"APP_ENV=prod", "APP_NAME=web"},
+			args: []string{"--require-env", "--keys-only"},
+			want: "APP\nSHARED\n",
+		},
+		{
+			name: "WORKPATH_ENV set",
+			env:  []string{"WORKPATH_ENV=prod", "APP_NAME=web"},
+			args: []string{"--require-env", "--keys-only"},
+			want: "APP\nSHARED\n",
+		},
+		{
+			name: "unset",
+			env:  []string{"APP_NAME=web"},
+			args: []string{"--require-env", "--keys-only"},
+			code: exitUsage,
+		},
+		{
+			name: "empty",
+			env:  []string{"APP_ENV=", "APP_NAME=web"},
+			args: []string{"--require-env", "--keys-only"},
+			code: exitUsage,
+		},
+		{
+			name: "unset without --require-env",
+			env:  []string{"APP_NAME=web"},
+			args: []string{"--on-missing-env", "skip", "--keys-only"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, tt.env, tt.args...)
+
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout, tt.want)
+			}
+			if tt.code != exitOK && !strings.Contains(stderr, "No environment set") {
+				t.Errorf("stderr = %q, want it to say no environment is set", stderr)
+			}
+		})
+	}
+}
+
+func TestLoadedParamsInterpolationOrder(t *testing.T) {
+	loaded := &loadedParams{
+		OSEnv: paramMap{"DB_HOST": "os-db", "OS_ONLY": "from-os"},
+		SSM: paramMap{
+			"DB_HOST":  "ssm-db",
+			"BOTH":     "%%DB_HOST%%",
+			"OS_REF":   "%%OS_ONLY%%",
+			"DEFAULTS": "%%NOWHERE:-fallback%% %%OS_ONLY:-fallback%% %%DB_HOST:-fallback%%",
+			"MISSING":  "[%%NOWHERE%%]",
+		},
+		InterpolateOSEnv: true,
+	}
+
+	env := loaded.Env()
+
+	for key, want := range map[string]string{
+		"BOTH":     "ssm-db",
+		"OS_REF":   "from-os",
+		"DEFAULTS": "fallback from-os ssm-db",
+		"MISSING":  "[]",
+
+		// The OS env still wins as the value of a key it sets
+		"DB_HOST": "os-db",
+	} {
+		if got := env[key]; got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestPathFromParam(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/bootstrap/configpath", Value: "/tenants/acme"},
+		{Name: "/tenants/acme/TENANT_KEY", Value: "acme-key"},
+		{Name: "/tenants/other/OTHER_KEY", Value: "other-key"},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+		code int
+	}{
+		{name: "path from the param", args: []string{"--path-from-param", "/bootstrap/configpath"}, want: "TENANT_KEY\n"},
+		{name: "missing param", args: []string{"--path-from-param", "/bootstrap/missing"}, code: exitNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--on-missing-env", "skip", "--keys-only"}, tt.args...)
+			stdout, stderr, code := runMain(t, params, nil, args...)
+
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("keys = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCreatedOnceAcrossFetches(t *testing.T) {
+	// --wait-for fetches everything again until the key shows up, which it
+	// never does here
+	_, stderr, code := runMain(t, []fakeParam{{Name: "/prod/web/DB_HOST", Value: "db"}},
+		[]string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"},
+		"--wait-for", "NEVER", "--wait-timeout", "1500ms", "--no-exec")
+
+	if code != exitNotFound {
+		t.Fatalf("exit code = %d, want %d (stderr %q)", code, exitNotFound, stderr)
+	}
+
+	if got := strings.Count(stderr, "fake: new SSM client"); got != 1 {
+		t.Errorf("created %d clients, want 1", got)
+	}
+	if got := strings.Count(stderr, "fake: GetParametersByPath"); got < 4 {
+		t.Errorf("fetched %d paths, want each path fetched at least twice", got)
+	}
+}
+
+func TestValuesWithEqualsRoundTrip(t *testing.T) {
+	for _, value := range []string{"opts=a=b", "=leading", "trailing=", "==", "url=https://example.com/?a=1&b=2"} {
+		t.Run(value, func(t *testing.T) {
+			m := paramMap{"OPTS": value}
+
+			// -O output read back with --params-stdin
+			var buf bytes.Buffer
+			m.WriteDotenv(&buf)
+			parsed, err := parseEnvLines(buf.Bytes(), "stdin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed["OPTS"] != value {
+				t.Errorf("-O output %q read back as %q, want %q", buf.String(), parsed["OPTS"], value)
+			}
+
+			// The command's env, read back as the OS env
+			if got := parseEnviron(m.StringArray(), envDuplicatesLast)["OPTS"]; got != value {
+				t.Errorf("env entry read back as %q, want %q", got, value)
+			}
+		})
+	}
+}
+
+func TestSetOverrides(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+		{Name: "/prod/web/DB_URL", Value: "postgres://%%DB_HOST%%:%%DB_PORT:-5432%%/app"},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "SSM only",
+			want: "DB_HOST=prod-db\nDB_URL=postgres://prod-db:5432/app\n",
+		},
+		{
+			name: "--set wins and is interpolated",
+			args: []string{"--set", "DB_HOST=localhost", "--set", "DB_PORT=15432"},
+			want: "DB_HOST=localhost\nDB_PORT=15432\nDB_URL=postgres://localhost:15432/app\n",
+		},
+		{
+			name: "--set can interpolate SSM",
+			args: []string{"--set", "DB_URL=mysql://%%DB_HOST%%"},
+			want: "DB_HOST=prod-db\nDB_URL=mysql://prod-db\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, append(tt.args, "-O")...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+
+			if got := envLines(stdout, "DB_"); got != tt.want {
+				t.Errorf("env = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// envLines returns the lines of -O output for keys starting with prefix
+func envLines(output, prefix string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func TestParseArgsSet(t *testing.T) {
+	opts, _, err := parseArgs([]string{"--set", "A=1", "--set", "B=x=y", "--set", "A=2", "--set", "EMPTY="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (paramMap{"A": "2", "B": "x=y", "EMPTY": ""}); !reflect.DeepEqual(opts.Sets, want) {
+		t.Errorf("Sets = %v, want %v", opts.Sets, want)
+	}
+
+	for _, arg := range []string{"A", "=1"} {
+		if _, _, err := parseArgs([]string{"--set", arg}); err == nil {
+			t.Errorf("--set %s: expected an error", arg)
+		}
+	}
+}
+
+func TestOnMissingEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		code    int
+		warning string
+	}{
+		{name: "default", warning: "Warning: neither APP_ENV nor WORKPATH_ENV is set"},
+		{name: "warn", args: []string{"--on-missing-env", "warn"}, warning: "Warning: neither APP_ENV nor WORKPATH_ENV is set"},
+		{name: "skip", args: []string{"--on-missing-env", "skip"}},
+		{name: "error", args: []string{"--on-missing-env", "error"}, code: exitUsage, warning: "No environment set"},
+		{name: "unknown", args: []string{"--on-missing-env", "ignore"}, code: exitUsage, warning: "expected one of skip, warn, error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMain(t, nil, nil, append(tt.args, "--no-exec")...)
+
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if tt.warning == "" && stderr != "" {
+				t.Errorf("stderr = %q, want nothing", stderr)
+			}
+			if !strings.Contains(stderr, tt.warning) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.warning)
+			}
+		})
+	}
+}
+
+func TestLargeValues(t *testing.T) {
+	// An advanced-tier value close to the 8KB limit, with everything a
+	// parser could trip on
+	var big strings.Builder
+	for big.Len() < 8000 {
+		fmt.Fprintf(&big, "line %d: key=value \"quoted\" 'single' $VAR #comment \\ %%%% tab\t\n", big.Len())
+	}
+	value := big.String()
+
+	client := newFakeSSM(
+		fakeParam{Name: "/prod/app/BIG", Value: value, Type: ssm.ParameterTypeSecureString},
+		fakeParam{Name: "/prod/app/WRAPPED", Value: "<%%BIG%%>"},
+	)
+
+	params, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: newFakeClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := make(paramMap)
+	if err := m.AddParams(params, &paramOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.ReplaceInterpolations()
+
+	if m["BIG"] != value {
+		t.Fatalf("BIG is %d bytes after loading, want %d", len(m["BIG"]), len(value))
+	}
+	if m["WRAPPED"] != "<"+value+">" {
+		t.Errorf("WRAPPED is %d bytes after interpolation, want %d", len(m["WRAPPED"]), len(value)+2)
+	}
+
+	if got := parseEnviron(m.StringArray(), envDuplicatesLast); !reflect.DeepEqual(got, m) {
+		t.Errorf("the command's env doesn't hold the values intact")
+	}
+
+	var buf bytes.Buffer
+	m.WriteDotenv(&buf)
+	if strings.Count(buf.String(), "\n") != len(m) {
+		t.Errorf("-O output has %d lines for %d keys", strings.Count(buf.String(), "\n"), len(m))
+	}
+
+	parsed, err := parseEnvLines(buf.Bytes(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, m) {
+		t.Errorf("-O output doesn't read back intact")
+	}
+}
+
+func TestInterpolateOSEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		osEnv    bool
+		want     string
+		wantHome string
+	}{
+		{name: "default", osEnv: true, want: "bin=/usr/bin", wantHome: "/srv/app"},
+		{name: "SSM and --set only", osEnv: false, want: "bin=", wantHome: "/srv/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded := &loadedParams{
+				OSEnv:     paramMap{"PATH": "/usr/bin"},
+				SSM:       paramMap{"BIN": "bin=%%PATH%%", "APP_HOME": "/srv/app", "DATA": "%%APP_HOME%%"},
+				Overrides: paramMap{"HOME_REF": "%%APP_HOME%%"},
+
+				InterpolateOSEnv: tt.osEnv,
+			}
+
+			env := loaded.Env()
+			if env["BIN"] != tt.want {
+				t.Errorf("BIN = %q, want %q", env["BIN"], tt.want)
+			}
+			if env["DATA"] != tt.wantHome || env["HOME_REF"] != tt.wantHome {
+				t.Errorf("DATA = %q, HOME_REF = %q, want SSM keys to resolve either way", env["DATA"], env["HOME_REF"])
+			}
+			if env["PATH"] != "/usr/bin" {
+				t.Errorf("PATH = %q, want the OS env kept", env["PATH"])
+			}
+		})
+	}
+}
+
+func TestGetParametersByName(t *testing.T) {
+	client := newFakeSSM()
+	var all, want []string
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("/prod/app/KEY_%02d", i)
+		all = append(all, name)
+		if i%5 != 0 {
+			client.Put(fakeParam{Name: name, Value: "v"})
+			want = append(want, name)
+		}
+	}
+
+	params, invalid, err := getParametersByName(client, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := names(params); !reflect.DeepEqual(got, want) {
+		t.Errorf("found %v, want %v", got, want)
+	}
+	if wantInvalid := []string{"/prod/app/KEY_00", "/prod/app/KEY_05", "/prod/app/KEY_10"}; !reflect.DeepEqual(invalid, wantInvalid) {
+		t.Errorf("invalid = %v, want %v", invalid, wantInvalid)
+	}
+	if got := client.Calls("GetParameters"); got != 2 {
+		t.Errorf("GetParameters calls = %d, want 2 batches", got)
+	}
+}
+
+func TestNamesInvalid(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/app/DB_HOST", Value: "db"}}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+		code int
+	}{
+		{name: "warns", args: []string{"--names", "/prod/app/DB_HOST,/prod/app/DB_HSOT"}, want: "DB_HOST\n"},
+		{name: "strict", args: []string{"--names", "/prod/app/DB_HOST,/prod/app/DB_HSOT", "--strict"}, code: exitNotFound},
+		{name: "all found", args: []string{"--names", "/prod/app/DB_HOST", "--strict"}, want: "DB_HOST\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, nil, append(tt.args, "--on-missing-env", "skip", "--keys-only")...)
+
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("keys = %q, want %q", stdout, tt.want)
+			}
+			if strings.Contains(stderr, "/prod/app/DB_HSOT") != strings.Contains(strings.Join(tt.args, " "), "DB_HSOT") {
+				t.Errorf("stderr = %q, want the misspelled name reported", stderr)
+			}
+		})
+	}
+}
+
+func TestWarnEmptyPath(t *testing.T) {
+	client := newFakeSSM(fakeParam{Name: "/prod/web/DB_HOST", Value: "db"})
+
+	tests := []struct {
+		name string
+		path string
+		warn bool
+		want string
+	}{
+		{name: "empty", path: "/prod/wbe/", warn: true, want: "Warning: no parameters found under /prod/wbe/"},
+		{name: "empty without the flag", path: "/prod/wbe/"},
+		{name: "not empty", path: "/prod/web/", warn: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+
+			_, err := fetchPath(client, clientKey{}, "app", tt.path, &options{WarnEmptyPath: tt.warn})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want == "" && logged.Len() > 0 {
+				t.Errorf("logged %q, want nothing", logged)
+			}
+			if !strings.Contains(logged.String(), tt.want) {
+				t.Errorf("logged %q, want %q", logged, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetParametersMaxPages(t *testing.T) {
+	var params []fakeParam
+	for i := 0; i < 25; i++ {
+		params = append(params, fakeParam{Name: fmt.Sprintf("/prod/app/KEY_%02d", i), Value: "v"})
+	}
+
+	tests := []struct {
+		name     string
+		endless  bool
+		maxPages int
+		pages    int
+		warning  bool
+	}{
+		{name: "endless tokens stop at the cap", endless: true, maxPages: 5, pages: 5, warning: true},
+		{name: "last page has no token", maxPages: 5, pages: 3},
+		{name: "no cap", pages: 3},
+		{name: "cap on the last page", maxPages: 3, pages: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+			client := newFakeSSM(params...)
+			client.Endless = tt.endless
+
+			fetched, err := getParameters(&getParametersInput{
+				Client:   client,
+				Path:     aws.String("/prod/app/"),
+				MaxPages: tt.maxPages,
+				Clock:    newFakeClock(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := client.Calls("GetParametersByPath"); got != tt.pages {
+				t.Errorf("fetched %d pages, want %d", got, tt.pages)
+			}
+			if !tt.endless && len(fetched) != len(params) {
+				t.Errorf("fetched %d params, want %d", len(fetched), len(params))
+			}
+
+			warned := strings.Contains(logged.String(), fmt.Sprintf("Warning: stopped fetching /prod/app/ after %d pages (--max-pages)", tt.maxPages))
+			if warned != tt.warning {
+				t.Errorf("logged %q, want a warning: %v", logged, tt.warning)
+			}
+		})
+	}
+}
+
+func TestInterpolateLayers(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/L_HOST", Value: "shared-host"},
+		{Name: "/prod/L_BASE", Value: "https://base"},
+		{Name: "/prod/L_SHARED_URL", Value: "http://%%L_HOST%%"},
+		{Name: "/prod/L_SHARED_REF", Value: "shared sees %%L_APP_ONLY%%"},
+		{Name: "/prod/web/L_HOST", Value: "app-host"},
+		{Name: "/prod/web/L_APP_ONLY", Value: "app-only"},
+		{Name: "/prod/web/L_APP_URL", Value: "http://%%L_HOST%%"},
+		{Name: "/prod/web/L_APP_REF", Value: "%%L_BASE%%/web"},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want map[string]string
+	}{
+		{
+			name: "merged",
+			want: map[string]string{"L_HOST": "shared-host", "L_SHARED_URL": "http://shared-host", "L_APP_URL": "http://shared-host"},
+		},
+		{
+			name: "layers",
+			args: []string{"--interpolate-layers"},
+			want: map[string]string{"L_HOST": "shared-host", "L_SHARED_URL": "http://shared-host", "L_APP_URL": "http://app-host"},
+		},
+		{
+			name: "merged with the app winning",
+			args: []string{"--dedup-strategy", "last"},
+			want: map[string]string{"L_HOST": "app-host", "L_SHARED_URL": "http://app-host", "L_APP_URL": "http://app-host"},
+		},
+		{
+			name: "layers with the app winning",
+			args: []string{"--dedup-strategy", "last", "--interpolate-layers"},
+			want: map[string]string{"L_HOST": "app-host", "L_SHARED_URL": "http://shared-host", "L_APP_URL": "http://app-host"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, append(tt.args, "-O")...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+
+			env, err := parseEnvLines([]byte(envLines(stdout, "L_")), "stdout")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// References to keys only the other layer has resolve either way
+			tt.want["L_SHARED_REF"] = "shared sees app-only"
+			tt.want["L_APP_REF"] = "https://base/web"
+			for key, want := range tt.want {
+				if env[key] != want {
+					t.Errorf("%s = %q, want %q", key, env[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMasked(t *testing.T) {
+	m := paramMap{"DB_PASSWORD": "hunter2", "DB_HOST": "prod-db"}
+
+	masked := m.Masked([]string{"DB_PASSWORD", "NOT_SET"})
+	if want := (paramMap{"DB_PASSWORD": "***", "DB_HOST": "prod-db"}); !reflect.DeepEqual(masked, want) {
+		t.Errorf("Masked() = %v, want %v", masked, want)
+	}
+	if m["DB_PASSWORD"] != "hunter2" {
+		t.Errorf("Masked() changed the original to %q", m["DB_PASSWORD"])
+	}
+}
+
+func TestMaskFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/M_PASSWORD", Value: "hunter2", Type: ssm.ParameterTypeSecureString},
+		{Name: "/prod/web/M_TOKEN", Value: "abc123"},
+		{Name: "/prod/web/M_HOST", Value: "prod-db"},
+	}
+	mask := []string{"--mask", "M_PASSWORD,M_TOKEN"}
+
+	tests := []struct {
+		name   string
+		args   []string
+		prefix string
+		want   string
+	}{
+		{name: "dotenv", args: append(mask, "-O"), prefix: "M_", want: "M_HOST=prod-db\nM_PASSWORD=***\nM_TOKEN=***\n"},
+		{name: "export", args: append(mask, "export"), prefix: "export M_", want: "export M_HOST='prod-db'\nexport M_PASSWORD='***'\nexport M_TOKEN='***'\n"},
+		{name: "unmasked", args: []string{"-O"}, prefix: "M_", want: "M_HOST=prod-db\nM_PASSWORD=hunter2\nM_TOKEN=abc123\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, tt.args...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+
+			if got := envLines(stdout, tt.prefix); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// --write-env is for the command to use, so it isn't masked
+	path := filepath.Join(tempDir(t), "env")
+	if _, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, append(mask, "--write-env", path)...); code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if written := readFile(t, path); !strings.Contains(written, "hunter2") || strings.Contains(written, "***") {
+		t.Errorf("--write-env with --mask wrote %q, want the real values", written)
+	}
+}
+
+func TestAdaptivePageSize(t *testing.T) {
+	var params []fakeParam
+	for i := 0; i < 30; i++ {
+		params = append(params, fakeParam{Name: fmt.Sprintf("/prod/app/KEY_%02d", i), Value: "v"})
+	}
+
+	tests := []struct {
+		name     string
+		pageSize int64
+		adaptive bool
+		want     []int64
+	}{
+		{name: "fixed", pageSize: 10, want: []int64{10, 10, 10}},
+		{name: "grows to the API max", pageSize: 10, adaptive: true, want: []int64{2, 4, 8, 10, 10}},
+		{name: "grows to --page-size", pageSize: 5, adaptive: true, want: []int64{2, 4, 5, 5, 5, 5, 5}},
+		{name: "already small", pageSize: 2, adaptive: true, want: []int64{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}},
+		{name: "default size", adaptive: true, want: []int64{2, 4, 8, 10, 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeSSM(params...)
+
+			fetched, err := getParameters(&getParametersInput{
+				Client:           client,
+				Path:             aws.String("/prod/app/"),
+				PageSize:         tt.pageSize,
+				AdaptivePageSize: tt.adaptive,
+				Clock:            newFakeClock(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := client.PageSizes(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("page sizes = %v, want %v", got, tt.want)
+			}
+			if len(fetched) != len(params) {
+				t.Errorf("fetched %d params, want %d", len(fetched), len(params))
+			}
+		})
+	}
+}
+
+func TestNextPageSize(t *testing.T) {
+	tests := []struct {
+		size, limit, want int64
+	}{
+		{size: 2, limit: 10, want: 4},
+		{size: 4, limit: 10, want: 8},
+		{size: 8, limit: 10, want: 10},
+		{size: 10, limit: 10, want: 10},
+		{size: 4, limit: 5, want: 5},
+	}
+
+	for _, tt := range tests {
+		if got := nextPageSize(tt.size, tt.limit); got != tt.want {
+			t.Errorf("nextPageSize(%d, %d) = %d, want %d", tt.size, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestClampPageSize(t *testing.T) {
+	tests := []struct {
+		size    int
+		want    int
+		warning bool
+	}{
+		{size: 1, want: 1},
+		{size: 5, want: 5},
+		{size: 10, want: 10},
+		{size: 0, want: 1, warning: true},
+		{size: -3, want: 1, warning: true},
+		{size: 11, want: 10, warning: true},
+		{size: 50, want: 10, warning: true},
+	}
+
+	for _, tt := range tests {
+		logged := captureLog(t)
+
+		if got := clampPageSize(tt.size); got != tt.want {
+			t.Errorf("clampPageSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+
+		want := fmt.Sprintf("Warning: --page-size %d is outside 1-10, using %d", tt.size, tt.want)
+		if warned := strings.Contains(logged.String(), want); warned != tt.warning {
+			t.Errorf("clampPageSize(%d) logged %q, want a warning: %v", tt.size, logged, tt.warning)
+		}
+	}
+}
+
+func TestParseArgsPageSize(t *testing.T) {
+	tests := []struct {
+		args     []string
+		pageSize int
+		maxPages int
+	}{
+		{args: nil, pageSize: 10, maxPages: 100},
+		{args: []string{"--page-size", "5"}, pageSize: 5, maxPages: 200},
+		{args: []string{"--page-size", "1"}, pageSize: 1, maxPages: 1000},
+		{args: []string{"--page-size", "0"}, pageSize: 1, maxPages: 1000},
+		{args: []string{"--page-size", "100"}, pageSize: 10, maxPages: 100},
+		{args: []string{"--adaptive-page-size"}, pageSize: 10, maxPages: 103},
+		{args: []string{"--page-size", "50", "--max-pages", "7"}, pageSize: 10, maxPages: 7},
+	}
+
+	for _, tt := range tests {
+		captureLog(t)
+
+		opts, _, err := parseArgs(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.PageSize != tt.pageSize || opts.MaxPages != tt.maxPages {
+			t.Errorf("parseArgs(%q) page size %d, max pages %d, want %d, %d", tt.args, opts.PageSize, opts.MaxPages, tt.pageSize, tt.maxPages)
+		}
+	}
+}
+
+func TestPageSizeClampedInRequests(t *testing.T) {
+	var params []fakeParam
+	for i := 0; i < 12; i++ {
+		params = append(params, fakeParam{Name: fmt.Sprintf("/prod/web/KEY_%02d", i), Value: "v"})
+	}
+
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}, "--page-size", "25", "--keys-only")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if !strings.Contains(stderr, "Warning: --page-size 25 is outside 1-10, using 10") {
+		t.Errorf("stderr = %q, want the clamping logged", stderr)
+	}
+	// One page for the empty shared path, two of 10 for the app's 12
+	if pages := strings.Count(stderr, "fake: GetParametersByPath"); pages != 3 {
+		t.Errorf("%d pages for 12 params, want pages of 10", pages)
+	}
+}
+
+func TestAddParamsProtectedKeys(t *testing.T) {
+	params := []*ssm.Parameter{
+		{Name: aws.String("/prod/app/LD_PRELOAD"), Value: aws.String("/tmp/evil.so")},
+		{Name: aws.String("/prod/app/PATH"), Value: aws.String("/tmp/evil")},
+		{Name: aws.String("/prod/app/DB_HOST"), Value: aws.String("prod-db")},
+	}
+
+	tests := []struct {
+		name      string
+		protected []string
+		want      paramMap
+		blocked   []string
+	}{
+		{
+			name:      "defaults",
+			protected: defaultProtectedKeys,
+			want:      paramMap{"DB_HOST": "prod-db"},
+			blocked:   []string{"LD_PRELOAD", "PATH"},
+		},
+		{
+			name:      "replaced",
+			protected: []string{"DB_HOST"},
+			want:      paramMap{"LD_PRELOAD": "/tmp/evil.so", "PATH": "/tmp/evil"},
+			blocked:   []string{"DB_HOST"},
+		},
+		{
+			name: "none",
+			want: paramMap{"LD_PRELOAD": "/tmp/evil.so", "PATH": "/tmp/evil", "DB_HOST": "prod-db"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+
+			m := make(paramMap)
+			if err := m.AddParams(params, &paramOptions{Protected: tt.protected}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("AddParams() = %v, want %v", m, tt.want)
+			}
+
+			for _, key := range tt.blocked {
+				if want := fmt.Sprintf("Warning: not setting %s from /prod/app/%s, it's a protected key", key, key); !strings.Contains(logged.String(), want) {
+					t.Errorf("logged %q, want %q", logged, want)
+				}
+			}
+			if got := strings.Count(logged.String(), "protected key"); got != len(tt.blocked) {
+				t.Errorf("logged %d blocked keys, want %d", got, len(tt.blocked))
+			}
+		})
+	}
+}
+
+func TestProtectedKeysFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/LD_PRELOAD", Value: "/tmp/evil.so"},
+		{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "default", want: ""},
+		{name: "other keys", args: []string{"--protected-keys", "DB_HOST"}, want: "LD_PRELOAD=/tmp/evil.so\n"},
+		{name: "none", args: []string{"--protected-keys", ""}, want: "LD_PRELOAD=/tmp/evil.so\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, append(tt.args, "-O")...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+			if got := envLines(stdout, "LD_"); got != tt.want {
+				t.Errorf("env = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// secureParams are n SecureStrings as GetParametersByPath returns them
+// without decryption, with a String between each
+func secureParams(n int) ([]fakeParam, []*ssm.Parameter) {
+	var fakes []fakeParam
+	var params []*ssm.Parameter
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("/prod/app/SECRET_%02d", i)
+		fakes = append(fakes, fakeParam{Name: name, Value: "secret-" + name, Type: ssm.ParameterTypeSecureString})
+		params = append(params,
+			&ssm.Parameter{Name: aws.String(name), Value: aws.String("encrypted:secret-" + name), Type: aws.String(ssm.ParameterTypeSecureString)},
+			&ssm.Parameter{Name: aws.String(name + "_PLAIN"), Value: aws.String("plain"), Type: aws.String(ssm.ParameterTypeString)},
+		)
+	}
+	return fakes, params
+}
+
+func TestDecryptEachConcurrency(t *testing.T) {
+	tests := []struct {
+		concurrency int
+		want        int
+	}{
+		{concurrency: 0, want: 1},
+		{concurrency: 1, want: 1},
+		{concurrency: 3, want: 3},
+		{concurrency: 12, want: 12},
+		{concurrency: 50, want: 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.concurrency), func(t *testing.T) {
+			fakes, params := secureParams(12)
+			client := newFakeSSM(fakes...)
+			client.Delay = 30 * time.Millisecond
+
+			decrypted, failures := decryptEach(client, params, tt.concurrency)
+			if len(failures) > 0 {
+				t.Fatalf("failures: %v", failures)
+			}
+
+			if got := client.MaxInFlight(); got != tt.want {
+				t.Errorf("%d calls at once, want %d", got, tt.want)
+			}
+			if calls := client.Calls("GetParameter"); calls != 12 {
+				t.Errorf("%d GetParameter calls, want one per SecureString", calls)
+			}
+
+			// In the order given, whatever order the calls finished in
+			if got := names(decrypted); !reflect.DeepEqual(got, names(params)) {
+				t.Errorf("decrypted %v, want %v", got, names(params))
+			}
+			for _, param := range decrypted {
+				if strings.HasPrefix(aws.StringValue(param.Value), "encrypted:") {
+					t.Errorf("%s wasn't decrypted", aws.StringValue(param.Name))
+				}
+			}
+		})
+	}
+}
+
+func TestDecryptEachFailures(t *testing.T) {
+	fakes, params := secureParams(4)
+	client := newFakeSSM(fakes...)
+	client.Denied = map[string]bool{"/prod/app/SECRET_01": true, "/prod/app/SECRET_03": true}
+
+	decrypted, failures := decryptEach(client, params, 2)
+
+	if len(decrypted) != len(params)-2 {
+		t.Errorf("decrypted %d params, want all but the denied 2", len(decrypted))
+	}
+	var failed []string
+	for _, failure := range failures {
+		failed = append(failed, failure.Name)
+		if !errors.Is(failure.Err, errDecryption) {
+			t.Errorf("%s failed with %v, want errDecryption", failure.Name, failure.Err)
+		}
+	}
+	if want := []string{"/prod/app/SECRET_01", "/prod/app/SECRET_03"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failures = %v, want %v", failed, want)
+	}
+}
+
+func BenchmarkDecryptEach(b *testing.B) {
+	fakes, params := secureParams(50)
+	client := newFakeSSM(fakes...)
+	client.Delay = time.Millisecond
+
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(strconv.Itoa(concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, failures := decryptEach(client, params, concurrency); len(failures) > 0 {
+					b.Fatal(failures)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEnviron(t *testing.T) {
+	// As some platforms hand over os.Environ(), with a key repeated first
+	// and last and entries that aren't KEY=VALUE
+	environ := []string{
+		"DUP=first",
+		"HOME=/root",
+		"MALFORMED",
+		"",
+		"OPTS=-Dkey=value",
+		"EMPTY=",
+		"=C:=C:\\Windows",
+		"DUP=middle",
+		"DUP=last",
+	}
+
+	tests := []struct {
+		name       string
+		duplicates string
+		wantDup    string
+	}{
+		{name: "default", wantDup: "last"},
+		{name: "last", duplicates: envDuplicatesLast, wantDup: "last"},
+		{name: "first", duplicates: envDuplicatesFirst, wantDup: "first"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := paramMap{
+				"DUP":   tt.wantDup,
+				"HOME":  "/root",
+				"OPTS":  "-Dkey=value",
+				"EMPTY": "",
+				"":      "C:=C:\\Windows",
+			}
+			if got := parseEnviron(environ, tt.duplicates); !reflect.DeepEqual(got, want) {
+				t.Errorf("parseEnviron() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseArgsEnvDuplicates(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{args: []string{"--env-duplicates", "first"}, want: envDuplicatesFirst},
+		{args: []string{"--env-duplicates", "last"}, want: envDuplicatesLast},
+		{args: []string{"--env-duplicates", "middle"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		opts, _, err := parseArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+		if err == nil && opts.EnvDuplicates != tt.want {
+			t.Errorf("parseArgs(%q) duplicates = %q, want %q", tt.args, opts.EnvDuplicates, tt.want)
+		}
+	}
+}
+
+func TestParseEnvLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    paramMap
+		wantErr string
+	}{
+		{name: "empty", data: "", want: paramMap{}},
+		{
+			name: "-O output",
+			data: "DB_HOST=prod-db\nDB_URL=postgres://u:p@h/db?a=b\nGREETING=\"two words\"\nEMPTY=\n",
+			want: paramMap{"DB_HOST": "prod-db", "DB_URL": "postgres://u:p@h/db?a=b", "GREETING": "two words", "EMPTY": ""},
+		},
+		{
+			name: "comments and blank lines",
+			data: "# from the other loader\n\nA=1\n  # indented\nB=2",
+			want: paramMap{"A": "1", "B": "2"},
+		},
+		{name: "windows line endings", data: "A=1\r\nB=2\r\n", want: paramMap{"A": "1", "B": "2"}},
+		{name: "later lines win", data: "A=1\nA=2\n", want: paramMap{"A": "2"}},
+		{name: "no equals", data: "A=1\nB\n", wantErr: "stdin:2: expected KEY=VALUE"},
+		{name: "no key", data: "=1\n", wantErr: "stdin:1: expected KEY=VALUE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvLines([]byte(tt.data), "stdin")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseEnvLines() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEnvLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParamsStdinPrecedence(t *testing.T) {
+	loaded := &loadedParams{
+		OSEnv:     paramMap{"FROM_OS": "os", "STDIN_OVER_OS": "os"},
+		SSM:       paramMap{"FROM_SSM": "ssm", "STDIN_OVER_SSM": "ssm", "REF": "%%FROM_STDIN%%"},
+		Stdin:     paramMap{"FROM_STDIN": "stdin", "STDIN_OVER_OS": "stdin", "STDIN_OVER_SSM": "stdin", "SET_OVER_STDIN": "stdin"},
+		Overrides: paramMap{"SET_OVER_STDIN": "set"},
+	}
+
+	env := loaded.Env()
+	for key, want := range map[string]string{
+		"FROM_OS":        "os",
+		"FROM_SSM":       "ssm",
+		"FROM_STDIN":     "stdin",
+		"STDIN_OVER_OS":  "stdin",
+		"STDIN_OVER_SSM": "stdin",
+		"SET_OVER_STDIN": "set",
+		"REF":            "stdin",
+	} {
+		if env[key] != want {
+			t.Errorf("%s = %q, want %q", key, env[key], want)
+		}
+	}
+}
+
+func TestParamsStdinFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/P_HOST", Value: "ssm-host"},
+		{Name: "/prod/web/P_PORT", Value: "5432"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web"}
+
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+		code  int
+	}{
+		{name: "stdin wins over SSM", stdin: "P_HOST=stdin-host\nP_USER=app\n", want: "P_HOST=stdin-host\nP_PORT=5432\nP_USER=app\n"},
+		{name: "--set wins over stdin", stdin: "P_HOST=stdin-host\n", args: []string{"--set", "P_HOST=set-host"}, want: "P_HOST=set-host\nP_PORT=5432\n"},
+		{name: "another loader's output", stdin: "# upstream\nP_URL=\"postgres://%%P_HOST%%\"\n", want: "P_HOST=ssm-host\nP_PORT=5432\nP_URL=postgres://ssm-host\n"},
+		{name: "invalid line", stdin: "P_HOST\n", code: exitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMainStdin(t, tt.stdin, params, env, append(tt.args, "--params-stdin", "-O")...)
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tt.code, stderr)
+			}
+			if got := envLines(stdout, "P_"); got != tt.want {
+				t.Errorf("env = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetParametersPageInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   int
+		pageSize int64
+		maxPages int
+		endless  bool
+		pages    int
+	}{
+		{name: "empty path", params: 0, pages: 1},
+		{name: "one page", params: 7, pages: 1},
+		{name: "several pages", params: 25, pages: 3},
+		{name: "small pages", params: 25, pageSize: 2, pages: 13},
+		{name: "capped", endless: true, params: 30, maxPages: 7, pages: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+
+			var params []fakeParam
+			for i := 0; i < tt.params; i++ {
+				params = append(params, fakeParam{Name: fmt.Sprintf("/prod/app/KEY_%02d", i), Value: "v"})
+			}
+			client := newFakeSSM(params...)
+			client.Endless = tt.endless
+			clk := newFakeClock()
+
+			start := time.Now()
+			if _, err := getParameters(&getParametersInput{
+				Client:   client,
+				Path:     aws.String("/prod/app/"),
+				PageSize: tt.pageSize,
+				MaxPages: tt.maxPages,
+				Clock:    clk,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if calls := client.Calls("GetParametersByPath"); calls != tt.pages {
+				t.Fatalf("%d pages, want %d", calls, tt.pages)
+			}
+			if want := time.Duration(tt.pages) * pageInterval; clk.Slept() != want {
+				t.Errorf("slept %s for %d pages, want %s", clk.Slept(), tt.pages, want)
+			}
+			if elapsed := time.Since(start); elapsed >= pageInterval {
+				t.Errorf("took %s, the fake clock shouldn't really wait", elapsed)
+			}
+		})
+	}
+}
+
+func BenchmarkGetParameters(b *testing.B) {
+	var params []fakeParam
+	for i := 0; i < 200; i++ {
+		params = append(params, fakeParam{Name: fmt.Sprintf("/prod/app/KEY_%03d", i), Value: "v"})
+	}
+	client := newFakeSSM(params...)
+
+	for i := 0; i < b.N; i++ {
+		if _, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: newFakeClock()}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestQualifiedRefs(t *testing.T) {
+	loaded := &loadedParams{
+		SSM: paramMap{
+			"REGION_URL": "https://%%/global/REGION%%.example.com",
+			"BOTH":       "%%/global/REGION%% %%/global/ZONE:-a%%",
+			"LOCAL":      "%%DB_HOST%%",
+			"SECRET":     "%%secret:db-password%%",
+		},
+		Overrides: paramMap{"FROM_SET": "%%/prod/other/KEY%%"},
+		OSEnv:     paramMap{"OS_ONLY": "%%/prod/os/IGNORED%%"},
+		Qualified: paramMap{"/prod/other/KEY": "already fetched"},
+	}
+
+	want := []string{"/global/REGION", "/global/ZONE"}
+	if got := loaded.QualifiedRefs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("QualifiedRefs() = %q, want %q", got, want)
+	}
+}
+
+func TestQualifiedInterpolation(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/Q_URL", Value: "https://%%/global/REGION%%.example.com"},
+		{Name: "/prod/web/Q_AGAIN", Value: "%%/global/REGION%%"},
+		{Name: "/prod/web/Q_ZONE", Value: "%%/global/ZONE:-a%%"},
+		{Name: "/global/REGION", Value: "eu-west-1"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+
+	// /global/ isn't loaded, only the one name referenced from it
+	stdout, stderr, code := runMain(t, params, env, "-O")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	want := "Q_AGAIN=eu-west-1\nQ_URL=https://eu-west-1.example.com\nQ_ZONE=a\n"
+	if got := envLines(stdout, "Q_"); got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+	if calls := strings.Count(stderr, "fake: GetParameters\n"); calls != 1 {
+		t.Errorf("%d GetParameters calls, want the references fetched together once", calls)
+	}
+	if !strings.Contains(stderr, "Warning: referenced parameters not found: ") || !strings.Contains(stderr, "/global/ZONE") {
+		t.Errorf("stderr = %q, want the missing reference named", stderr)
+	}
+	if strings.Contains(stdout, "REGION=eu-west-1") {
+		t.Errorf("-O has the referenced parameter itself: %q", stdout)
+	}
+
+	// --strict fails rather than falling back
+	if _, stderr, code := runMain(t, params, env, "--strict", "-O"); code != exitNotFound {
+		t.Errorf("exit code = %d with --strict, want %d (stderr %q)", code, exitNotFound, stderr)
+	}
+}
+
+func TestIfParamFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/flags/beta", Value: "on"},
+		{Name: "/beta/IP_BETA", Value: "1"},
+		{Name: "/gamma/IP_GAMMA", Value: "1"},
+		{Name: "/always/IP_ALWAYS", Value: "1"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "matching",
+			args: []string{"--if-param", "/flags/beta=on", "--path", "/beta"},
+			want: "IP_BETA=1\n",
+		},
+		{
+			name: "not matching",
+			args: []string{"--if-param", "/flags/beta=off", "--path", "/beta", "--path", "/always"},
+			want: "IP_ALWAYS=1\n",
+		},
+		{
+			name: "guard missing",
+			args: []string{"--if-param", "/flags/missing=on", "--path", "/beta", "--path", "/always"},
+			want: "IP_ALWAYS=1\n",
+		},
+		{
+			name: "one guard for two paths",
+			args: []string{"--if-param", "/flags/beta=on", "--path", "/beta", "--if-param", "/flags/beta=on", "--path", "/gamma"},
+			want: "IP_BETA=1\nIP_GAMMA=1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runMain(t, params, env, append(tt.args, "-O")...)
+			if code != exitOK {
+				t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+			}
+			if got := envLines(stdout, "IP_"); got != tt.want {
+				t.Errorf("env = %q, want %q", got, tt.want)
+			}
+
+			// Each guard is fetched once, however many paths it gates
+			if calls := strings.Count(stderr, "fake: GetParameter\n"); calls != 1 {
+				t.Errorf("%d GetParameter calls, want 1", calls)
+			}
+		})
+	}
+
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "SSM_LOADER_TEST_ERR=AccessDeniedException:denied"},
+		"--if-param", "/flags/beta=on", "--path", "/beta", "-O")
+	if code != exitAccessDenied || !strings.Contains(stderr, "Error fetching /flags/beta: ") {
+		t.Errorf("exit code = %d, stderr %q, want the guard's error", code, stderr)
+	}
+}
+
+func TestLoadedKeys(t *testing.T) {
+	loaded := &loadedParams{
+		OSEnv:     paramMap{"HOME": "/root", "DB_HOST": "localhost"},
+		S3:        paramMap{"ZONE": "a", "DB_HOST": "s3"},
+		SSM:       paramMap{"DB_HOST": "ssm", "API_URL": "https://api"},
+		Stdin:     paramMap{"MODE": "blue"},
+		Overrides: paramMap{"API_URL": "https://override", "BUILD": "1"},
+	}
+
+	want := []string{"API_URL", "BUILD", "DB_HOST", "MODE", "ZONE"}
+	if got := loaded.LoadedKeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadedKeys() = %q, want %q", got, want)
+	}
+}
+
+func TestLoadedParamsNormalizeNames(t *testing.T) {
+	captureLog(t)
+
+	loaded := &loadedParams{
+		OSEnv:     paramMap{"odd.name": "os"},
+		SSM:       paramMap{"db.host": "ssm", "ssm.path": "/ssm"},
+		S3:        paramMap{"log-level": "debug", "s3-path": "/s3"},
+		Stdin:     paramMap{"mode.x": "blue"},
+		Overrides: paramMap{"1st": "one"},
+	}
+	protected := func(key string) bool { return key == "ssm_path" || key == "s3_path" }
+
+	if err := loaded.NormalizeNames(protected); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &loadedParams{
+		OSEnv:     paramMap{"odd.name": "os"},
+		SSM:       paramMap{"db_host": "ssm"},
+		S3:        paramMap{"log_level": "debug"},
+		Stdin:     paramMap{"mode_x": "blue"},
+		Overrides: paramMap{"_1st": "one"},
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("loaded = %+v, want %+v", loaded, want)
+	}
+}
+
+func TestKeysOnlyFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/web/ZEBRA", Value: "z-value"},
+		{Name: "/prod/web/DB_PASSWORD", Value: "hunter2", Type: ssm.ParameterTypeSecureString},
+		{Name: "/prod/ALPHA", Value: "a-value"},
+	}
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "HOME=/root"}
+
+	stdout, stderr, code := runMain(t, params, env, "--keys-only", "--set", "MIDDLE=m-value")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+	if want := "ALPHA\nDB_PASSWORD\nMIDDLE\nZEBRA\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	for _, value := range []string{"z-value", "hunter2", "a-value", "m-value", "HOME"} {
+		if strings.Contains(stdout+stderr, value) {
+			t.Errorf("output has %q: %q %q", value, stdout, stderr)
+		}
+	}
+}
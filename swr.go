@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// revalidateEnv is set for the copy of ssm-loader started to refresh a
+// stale cache in the background. It fetches, writes the cache and exits.
+const revalidateEnv = "SSM_LOADER_REVALIDATE"
+
+// revalidated gets the params from a background refresh, once it has
+// written them to the cache, for watch mode to restart the command with.
+// It's nil when there's no refresh, so it never fires.
+var revalidated chan []*ssm.Parameter
+
+// startRevalidate refreshes the cache in the background for
+// --swr-ttl, so the stale values can be used meanwhile. The refresh runs
+// as a separate process with the same args, so it can't fail this one.
+func startRevalidate() <-chan error {
+	done := make(chan error, 1)
+
+	exe, err := os.Executable()
+	if err != nil {
+		done <- err
+		return done
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), revalidateEnv+"=1")
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		done <- err
+		return done
+	}
+
+	go func() { done <- cmd.Wait() }()
+	return done
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.json")
+	env := []string{"APP_ENV=prod", "APP_NAME=web", "SSM_LOADER_TEST_TRACE=1"}
+	args := func(ttl, swr string) []string {
+		return []string{"--cache-file", path, "--cache-ttl", ttl, "--swr-ttl", swr, "-O"}
+	}
+	run := func(value string, args []string) (string, string) {
+		t.Helper()
+		stdout, stderr, code := runMain(t, []fakeParam{{Name: "/prod/web/SWR_HOST", Value: value}}, env, args...)
+		if code != exitOK {
+			t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+		}
+		return envLines(stdout, "SWR_"), stderr
+	}
+
+	if got, _ := run("old", args("1h", "1h")); got != "SWR_HOST=old\n" {
+		t.Fatalf("env = %q, want the fetched value", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// Stale but within --swr-ttl, the cached value is used straight away
+	// while a copy started in the background fetches the new one. The
+	// copy shares stderr, so its fetches show up there too.
+	got, stderr := run("new", args("10ms", "1h"))
+	if got != "SWR_HOST=old\n" {
+		t.Errorf("env = %q, want the stale cached value", got)
+	}
+	if !strings.Contains(stderr, "fake: GetParametersByPath") {
+		t.Errorf("stderr = %q, want the background refresh to fetch", stderr)
+	}
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("the refreshed cache", func() bool {
+		return strings.Contains(readFile(t, path), `"new"`)
+	})
+
+	// The refreshed cache is fresh, so it's used without fetching
+	got, stderr = run("newer", args("1h", "1h"))
+	if got != "SWR_HOST=new\n" {
+		t.Errorf("env = %q, want the refreshed value", got)
+	}
+	if strings.Contains(stderr, "fake: GetParametersByPath") {
+		t.Errorf("stderr = %q, want no fetch for a fresh cache", stderr)
+	}
+
+	// Past --swr-ttl as well, it's fetched before anything runs
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := run("newest", args("5ms", "5ms")); got != "SWR_HOST=newest\n" {
+		t.Errorf("env = %q, want the value fetched past --swr-ttl", got)
+	}
+}
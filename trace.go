@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// attachTrace adds a handler logging a line for every request made by
+// clients created from h afterwards, summarising what was asked for and
+// what came back. Values are never logged.
+func attachTrace(h *request.Handlers) {
+	h.Complete.PushBack(func(r *request.Request) {
+		parts := []string{r.ClientInfo.ServiceName + "." + r.Operation.Name}
+		parts = append(parts, traceInput(r.Params)...)
+
+		if r.Error != nil {
+			code := "error"
+			if aerr, ok := r.Error.(awserr.Error); ok {
+				code = aerr.Code()
+			}
+			parts = append(parts, "error="+code)
+		} else {
+			parts = append(parts, traceOutput(r.Data)...)
+		}
+
+		parts = append(parts,
+			fmt.Sprintf("retries=%d", r.RetryCount),
+			fmt.Sprintf("latency=%s", time.Since(r.Time).Round(time.Millisecond)),
+		)
+
+		log.Println("trace: " + strings.Join(parts, " "))
+	})
+}
+
+func traceInput(params interface{}) []string {
+	switch in := params.(type) {
+	case *ssm.GetParametersByPathInput:
+		return []string{
+			"path=" + aws.StringValue(in.Path),
+			fmt.Sprintf("page_size=%d", aws.Int64Value(in.MaxResults)),
+			fmt.Sprintf("next_token=%t", aws.StringValue(in.NextToken) != ""),
+			fmt.Sprintf("decrypt=%t", aws.BoolValue(in.WithDecryption)),
+		}
+	case *ssm.GetParameterInput:
+		return []string{"name=" + aws.StringValue(in.Name)}
+	case *ssm.GetParametersInput:
+		return []string{fmt.Sprintf("names=%d", len(in.Names))}
+	case *ssm.DescribeParametersInput:
+		return []string{fmt.Sprintf("next_token=%t", aws.StringValue(in.NextToken) != "")}
+	}
+	return nil
+}
+
+func traceOutput(data interface{}) []string {
+	switch out := data.(type) {
+	case *ssm.GetParametersByPathOutput:
+		return []string{
+			fmt.Sprintf("count=%d", len(out.Parameters)),
+			fmt.Sprintf("has_next=%t", aws.StringValue(out.NextToken) != ""),
+		}
+	case *ssm.GetParametersOutput:
+		return []string{
+			fmt.Sprintf("count=%d", len(out.Parameters)),
+			fmt.Sprintf("invalid=%d", len(out.InvalidParameters)),
+		}
+	case *ssm.DescribeParametersOutput:
+		return []string{
+			fmt.Sprintf("count=%d", len(out.Parameters)),
+			fmt.Sprintf("has_next=%t", aws.StringValue(out.NextToken) != ""),
+		}
+	}
+	return nil
+}
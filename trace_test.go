@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// tracedSSM is a client with --trace's handler attached, talking to an SSM
+// endpoint that answers every request with the next of responses
+func tracedSSM(t *testing.T, responses ...string) *ssm.SSM {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		response := responses[0]
+		if len(responses) > 1 {
+			responses = responses[1:]
+		}
+		if strings.Contains(response, "__type") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachTrace(&sess.Handlers)
+
+	return ssm.New(sess)
+}
+
+// traceLines returns the trace: lines logged, without their latency
+func traceLines(logged string) []string {
+	var lines []string
+	for _, line := range strings.Split(logged, "\n") {
+		if i := strings.Index(line, "trace: "); i >= 0 {
+			line = line[i:]
+			if j := strings.Index(line, " latency="); j >= 0 {
+				line = line[:j]
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestTracePages(t *testing.T) {
+	logs := captureLog(t)
+
+	client := tracedSSM(t,
+		`{"Parameters":[{"Name":"/prod/app/A","Type":"SecureString","Value":"hunter2"},{"Name":"/prod/app/B","Type":"String","Value":"b"}],"NextToken":"t1"}`,
+		`{"Parameters":[{"Name":"/prod/app/C","Type":"String","Value":"c"}]}`,
+	)
+
+	params, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), PageSize: 2, Clock: newFakeClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 3 {
+		t.Fatalf("got %d params, want 3", len(params))
+	}
+
+	want := []string{
+		"trace: ssm.GetParametersByPath path=/prod/app/ page_size=2 next_token=false decrypt=true count=2 has_next=true retries=0",
+		"trace: ssm.GetParametersByPath path=/prod/app/ page_size=2 next_token=true decrypt=true count=1 has_next=false retries=0",
+	}
+	if got := traceLines(logs.String()); !reflect.DeepEqual(got, want) {
+		t.Errorf("trace lines =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if strings.Contains(logs.String(), "hunter2") {
+		t.Errorf("trace logged a value: %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), " latency=") {
+		t.Errorf("trace lines have no latency: %q", logs.String())
+	}
+}
+
+func TestTraceError(t *testing.T) {
+	logs := captureLog(t)
+
+	client := tracedSSM(t, `{"__type":"AccessDeniedException","message":"not allowed"}`)
+	if _, _, err := getParametersByName(client, []string{"/prod/app/A", "/prod/app/B"}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	want := []string{"trace: ssm.GetParameters names=2 error=AccessDeniedException retries=0"}
+	if got := traceLines(logs.String()); !reflect.DeepEqual(got, want) {
+		t.Errorf("trace lines = %q, want %q", got, want)
+	}
+}
+
+func TestTraceFields(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		out  interface{}
+		want []string
+	}{
+		{
+			"GetParameter",
+			&ssm.GetParameterInput{Name: aws.String("/prod/app/A")},
+			&ssm.GetParameterOutput{},
+			[]string{"name=/prod/app/A"},
+		},
+		{
+			"GetParameters",
+			&ssm.GetParametersInput{Names: aws.StringSlice([]string{"a", "b", "c"})},
+			&ssm.GetParametersOutput{Parameters: []*ssm.Parameter{{}, {}}, InvalidParameters: aws.StringSlice([]string{"c"})},
+			[]string{"names=3", "count=2", "invalid=1"},
+		},
+		{
+			"DescribeParameters",
+			&ssm.DescribeParametersInput{NextToken: aws.String("t")},
+			&ssm.DescribeParametersOutput{Parameters: []*ssm.ParameterMetadata{{}}},
+			[]string{"next_token=true", "count=1", "has_next=false"},
+		},
+		{
+			"other",
+			&ssm.PutParameterInput{},
+			&ssm.PutParameterOutput{},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := append(traceInput(test.in), traceOutput(test.out)...)
+			if len(got) == 0 {
+				got = nil
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("fields = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
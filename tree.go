@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// treeNode is one segment of a parameter path
+type treeNode map[string]treeNode
+
+// printTree writes the names of params as a hierarchy, one path segment
+// per level, without their values. Parameters with an entry in
+// descriptions have it printed after their name.
+func printTree(w io.Writer, params []*ssm.Parameter, descriptions map[string]string) {
+	root := make(treeNode)
+
+	for _, param := range params {
+		node := root
+		for _, segment := range strings.Split(strings.Trim(aws.StringValue(param.Name), "/"), "/") {
+			if node[segment] == nil {
+				node[segment] = make(treeNode)
+			}
+			node = node[segment]
+		}
+	}
+
+	fmt.Fprintln(w, "/")
+	root.print(w, "/", "", descriptions)
+}
+
+func (n treeNode) print(w io.Writer, path, indent string, descriptions map[string]string) {
+	names := make([]string, 0, len(n))
+	for name := range n {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		child := n[name]
+		line := name
+		if len(child) > 0 {
+			line += "/"
+		} else if description := descriptions[path+name]; description != "" {
+			line += "  # " + description
+		}
+
+		fmt.Fprintln(w, indent+branch+line)
+		child.print(w, path+name+"/", indent+next, descriptions)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestPrintTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []string
+		want   string
+	}{
+		{name: "empty", want: "/\n"},
+		{
+			name:   "flat",
+			params: []string{"/prod/B", "/prod/A"},
+			want: `/
+└── prod/
+    ├── A
+    └── B
+`,
+		},
+		{
+			name:   "nested",
+			params: []string{"/prod/SHARED", "/prod/web/db/HOST", "/prod/web/db/PORT", "/prod/web/API_KEY", "/prod/worker/QUEUE", "/staging/web/HOST"},
+			want: `/
+├── prod/
+│   ├── SHARED
+│   ├── web/
+│   │   ├── API_KEY
+│   │   └── db/
+│   │       ├── HOST
+│   │       └── PORT
+│   └── worker/
+│       └── QUEUE
+└── staging/
+    └── web/
+        └── HOST
+`,
+		},
+		{
+			name:   "same name from two fetches",
+			params: []string{"/prod/web/HOST", "/prod/web/HOST"},
+			want: `/
+└── prod/
+    └── web/
+        └── HOST
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params []*ssm.Parameter
+			for _, name := range tt.params {
+				params = append(params, &ssm.Parameter{Name: aws.String(name), Value: aws.String("secret-" + name)})
+			}
+
+			var buf bytes.Buffer
+			printTree(&buf, params, nil)
+			if buf.String() != tt.want {
+				t.Errorf("printTree() =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTreeFlag(t *testing.T) {
+	params := []fakeParam{
+		{Name: "/prod/LOG_LEVEL", Value: "info"},
+		{Name: "/prod/web/DB_PASSWORD", Value: "hunter2", Type: ssm.ParameterTypeSecureString},
+		{Name: "/prod/web/DB_HOST", Value: "prod-db"},
+	}
+
+	stdout, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--tree")
+	if code != exitOK {
+		t.Fatalf("exit code = %d (stderr %q)", code, stderr)
+	}
+
+	want := `/
+└── prod/
+    ├── LOG_LEVEL
+    └── web/
+        ├── DB_HOST
+        └── DB_PASSWORD
+`
+	if stdout != want {
+		t.Errorf("--tree =\n%s\nwant\n%s", stdout, want)
+	}
+	for _, value := range []string{"info", "hunter2", "prod-db"} {
+		if strings.Contains(stdout, value) {
+			t.Errorf("--tree printed the value %q", value)
+		}
+	}
+}
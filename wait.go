@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// waitMaxInterval caps the backoff between fetches in waitForKeys
+const waitMaxInterval = 10 * time.Second
+
+// waitForKeys fetches again until params has every one of keys, backing
+// off between fetches, and fails once timeout has passed
+func waitForKeys(clk clock, params []*ssm.Parameter, fetch func() []*ssm.Parameter, po *paramOptions, keys []string, timeout time.Duration) []*ssm.Parameter {
+	deadline := clk.Now().Add(timeout)
+	interval := time.Second
+
+	for {
+		missing := po.missingKeys(params, keys)
+		if len(missing) == 0 {
+			return params
+		}
+
+		if clk.Now().Add(interval).After(deadline) {
+			fatal(exitNotFound, "Timed out waiting for parameters: ", strings.Join(missing, ", "))
+		}
+
+		debugf("Waiting %s for %s", interval, strings.Join(missing, ", "))
+		clk.Sleep(interval)
+
+		if interval *= 2; interval > waitMaxInterval {
+			interval = waitMaxInterval
+		}
+
+		params = fetch()
+	}
+}
+
+// missingKeys returns the keys that none of params loads as
+func (o *paramOptions) missingKeys(params []*ssm.Parameter, keys []string) []string {
+	loaded := o.Dedup(params)
+
+	var missing []string
+	for _, key := range keys {
+		if _, ok := loaded[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestWaitForKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		appearAt int
+		fetches  int
+		sleeps   []time.Duration
+	}{
+		{name: "already there", keys: []string{"DB_HOST"}, fetches: 0},
+		{name: "after one fetch", keys: []string{"DB_HOST", "DB_PASSWORD"}, appearAt: 1, fetches: 1, sleeps: []time.Duration{time.Second}},
+		{
+			name:     "backoff is capped",
+			keys:     []string{"DB_PASSWORD"},
+			appearAt: 6,
+			fetches:  6,
+			sleeps:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeSSM(fakeParam{Name: "/prod/app/DB_HOST", Value: "prod-db"})
+			clk := newFakeClock()
+
+			get := func() []*ssm.Parameter {
+				params, err := getParameters(&getParametersInput{Client: client, Path: aws.String("/prod/app/"), Clock: clk})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return params
+			}
+
+			// DB_PASSWORD is written just before the appearAt'th fetch
+			// after the first
+			fetches := 0
+			fetch := func() []*ssm.Parameter {
+				if fetches++; fetches == tt.appearAt {
+					client.Put(fakeParam{Name: "/prod/app/DB_PASSWORD", Value: "hunter2"})
+				}
+				return get()
+			}
+
+			initial := get()
+			params := waitForKeys(clk, initial, fetch, &paramOptions{}, tt.keys, time.Minute)
+			if missing := (&paramOptions{}).missingKeys(params, tt.keys); len(missing) > 0 {
+				t.Errorf("waitForKeys() returned without %v", missing)
+			}
+			if fetches != tt.fetches {
+				t.Errorf("fetched %d more times, want %d", fetches, tt.fetches)
+			}
+
+			// Leaving out the pauses between pages
+			var sleeps []time.Duration
+			for _, d := range clk.sleeps {
+				if d != pageInterval {
+					sleeps = append(sleeps, d)
+				}
+			}
+			if !reflect.DeepEqual(sleeps, tt.sleeps) {
+				t.Errorf("waited %v, want %v", sleeps, tt.sleeps)
+			}
+		})
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	params := []*ssm.Parameter{
+		{Name: aws.String("/prod/app/DB_HOST"), Value: aws.String("prod-db")},
+		{Name: aws.String("/prod/app/DB_PORT"), Value: aws.String("5432")},
+	}
+
+	tests := []struct {
+		keys []string
+		want []string
+	}{
+		{keys: []string{"DB_HOST", "DB_PORT"}},
+		{keys: []string{"DB_HOST", "DB_PASSWORD", "API_KEY"}, want: []string{"DB_PASSWORD", "API_KEY"}},
+	}
+
+	for _, tt := range tests {
+		if got := (&paramOptions{}).missingKeys(params, tt.keys); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("missingKeys(%q) = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	params := []fakeParam{{Name: "/prod/web/DB_HOST", Value: "prod-db"}}
+
+	start := time.Now()
+	_, stderr, code := runMain(t, params, []string{"APP_ENV=prod", "APP_NAME=web"}, "--wait-for", "DB_HOST,DB_PASSWORD", "--wait-timeout", "500ms", "--keys-only")
+	if code != exitNotFound {
+		t.Fatalf("exit code = %d, want %d (stderr %q)", code, exitNotFound, stderr)
+	}
+	if !strings.Contains(stderr, "Timed out waiting for parameters:") || !strings.HasSuffix(stderr, " DB_PASSWORD\n") {
+		t.Errorf("stderr = %q, want only the missing key", stderr)
+	}
+
+	// The first second of backoff is already past the timeout
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %s, want it to stop at the timeout", elapsed)
+	}
+}
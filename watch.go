@@ -0,0 +1,203 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type refreshKey struct {
+	Key      string
+	Interval time.Duration
+}
+
+// keyChange is a new value for a --refresh-key, with the name and type
+// of the parameter it came from
+type keyChange struct {
+	Key   string
+	Name  string
+	Type  string
+	Value string
+}
+
+// paramSources maps each key to the name of the SSM parameter AddParams
+// will take its value from. Keys already set in m are left out since
+// their SSM value is never used.
+func (m paramMap) paramSources(params []*ssm.Parameter, po *paramOptions) map[string]string {
+	sources := make(map[string]string)
+
+	for name, param := range po.Dedup(params) {
+		if _, exists := m[name]; !exists {
+			sources[name] = *param.Name
+		}
+	}
+
+	return sources
+}
+
+// watchKey polls a single parameter on its interval and sends a keyChange
+// whenever its value differs from the last one seen
+func watchKey(client ssmiface.SSMAPI, rk refreshKey, name string, current string, changes chan<- keyChange) {
+	ticker := time.NewTicker(rk.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := client.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+
+		if err != nil {
+			log.Printf("Error refreshing %s: %s\n", rk.Key, err.Error())
+			continue
+		}
+
+		value := aws.StringValue(result.Parameter.Value)
+		if value != current {
+			current = value
+			changes <- keyChange{Key: rk.Key, Name: name, Type: aws.StringValue(result.Parameter.Type), Value: value}
+		}
+	}
+}
+
+// stopCommand asks the command to exit and waits for it, killing it if
+// it hasn't gone away after a grace period
+func stopCommand(cmd *exec.Cmd, done <-chan error) {
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		cmd.Process.Kill()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// runWatched runs the commands and polls the refresh keys, restarting the
+// last command with a freshly interpolated env whenever one of them
+// changes. SIGHUP restarts it too, after replacing the SSM params with
+// the ones from reload, as does a --swr-ttl refresh finishing with
+// changes, whose params are turned into the SSM layer by load. If either
+// fails the command keeps running with what it has. Any commands before
+// it only run once, at startup.
+func runWatched(clients *clientSet, commands [][]string, loaded *loadedParams, sources map[string]string, reload func() (paramMap, error), load func([]*ssm.Parameter) (paramMap, error), opts *options) {
+	changes := make(chan keyChange)
+
+	// SIGHUP is taken as a request to reload rather than passed on
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for _, rk := range opts.RefreshKeys {
+		name, exists := sources[rk.Key]
+		if !exists {
+			log.Printf("Not watching %s: it wasn't loaded from SSM\n", rk.Key)
+			continue
+		}
+		go watchKey(clients.ForParam(name), rk, name, loaded.SSM[rk.Key], changes)
+	}
+
+	// Files rewritten in place lose their placeholders, so they're read
+	// once and substituted from that on every restart
+	envsubstFiles, err := readEnvsubstFiles(opts.EnvsubstFiles, opts.EnvsubstSuffix)
+	if err != nil {
+		fatal(exitError, "Error reading files to substitute: ", err)
+	}
+
+	last := len(commands) - 1
+
+	for first := true; ; first = false {
+		env := loaded.Env()
+
+		if err := env.Render(opts.Renders, opts.Strict); err != nil {
+			fatal(exitError, "Error rendering templates: ", err)
+		}
+
+		if err := env.Envsubst(envsubstFiles, opts.Strict); err != nil {
+			fatal(exitError, "Error substituting files: ", err)
+		}
+
+		if err := env.WriteFiles(opts.FileOuts); err != nil {
+			fatal(exitError, "Error writing files: ", err)
+		}
+
+		if opts.WriteEnv != "" {
+			if err := env.WriteEnvFile(opts.WriteEnv); err != nil {
+				fatal(exitError, "Error writing env file: ", err)
+			}
+		}
+
+		if opts.SystemdEnv != "" {
+			if err := env.WriteSystemdEnvFile(opts.SystemdEnv); err != nil {
+				fatal(exitError, "Error writing systemd env file: ", err)
+			}
+		}
+
+		if first {
+			runSequence(commands[:last], env, opts)
+		}
+
+		cmd := startCommand(commands[last], env, opts)
+		done := make(chan error, 1)
+		go func() { done <- waitCommand(cmd) }()
+
+		// Wait for something that needs a restart
+		for restart := false; !restart; {
+			select {
+			case err := <-done:
+				if err != nil {
+					fatal(commandExitCode(err), "Command finished with err: ", err)
+				}
+				return
+			case change := <-changes:
+				value, err := sanitizeValue(change.Name, change.Value, opts.SanitizeValues)
+				if err != nil {
+					log.Println("Warning: not using the new value of "+change.Key+", keeping the command running: ", err)
+					continue
+				}
+				if opts.RedactOutput && change.Type == ssm.ParameterTypeSecureString {
+					addRedacted([]string{value})
+				}
+
+				debugf("%s changed, restarting command", change.Key)
+				loaded.SSM[change.Key] = value
+				restart = true
+			case params := <-revalidated:
+				refreshed, err := load(params)
+				if err != nil {
+					log.Println("Warning: couldn't load the refreshed cache, keeping the command running: ", err)
+					continue
+				}
+				if reflect.DeepEqual(refreshed, loaded.SSM) {
+					debugf("Refreshed the cache, nothing changed")
+					continue
+				}
+
+				debugf("Refreshed the cache, restarting command")
+				loaded.SSM = refreshed
+				restart = true
+			case <-hup:
+				debugf("Got SIGHUP, reloading params and restarting command")
+				reloaded, err := reload()
+				if err != nil {
+					log.Println("Warning: couldn't reload params, keeping the command running: ", err)
+					continue
+				}
+				loaded.SSM = reloaded
+				restart = true
+			}
+		}
+
+		stopCommand(cmd, done)
+	}
+}
@@ -0,0 +1,284 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// waitFor polls until cond holds, failing the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchKeySendsChanges(t *testing.T) {
+	client := newFakeSSM(fakeParam{Name: "/prod/app/HOT", Value: "old"})
+	changes := make(chan keyChange, 1)
+
+	go watchKey(client, refreshKey{Key: "HOT", Interval: 10 * time.Millisecond}, "/prod/app/HOT", "old", changes)
+
+	waitFor(t, time.Second, "a poll", func() bool { return client.Calls("GetParameter") > 1 })
+	select {
+	case change := <-changes:
+		t.Fatalf("got %v before anything changed", change)
+	default:
+	}
+
+	client.Put(fakeParam{Name: "/prod/app/HOT", Value: "new"})
+
+	select {
+	case change := <-changes:
+		if change != (keyChange{Key: "HOT", Name: "/prod/app/HOT", Type: ssm.ParameterTypeString, Value: "new"}) {
+			t.Errorf("change = %v, want HOT=new", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change sent")
+	}
+}
+
+func TestRunWatchedRestartsOnlyForRefreshKeys(t *testing.T) {
+	out := filepath.Join(tempDir(t), "out")
+
+	client := newFakeSSM(
+		fakeParam{Name: "/prod/app/HOT", Value: "old"},
+		fakeParam{Name: "/prod/app/COLD", Value: "old"},
+	)
+
+	loaded := &loadedParams{
+		OSEnv: paramMap{"PATH": os.Getenv("PATH")},
+		SSM:   paramMap{"HOT": "old", "COLD": "old"},
+	}
+	sources := map[string]string{"HOT": "/prod/app/HOT", "COLD": "/prod/app/COLD"}
+	opts := &options{
+		RefreshKeys: []refreshKey{{Key: "HOT", Interval: 10 * time.Millisecond}},
+		NoStdin:     true,
+	}
+
+	// The command records its env on each start and sticks around until
+	// HOT has changed
+	command := []string{"/bin/sh", "-c", `echo "$HOT $COLD" >> ` + out + `; [ "$HOT" = new ] || exec sleep 5`}
+
+	lines := func() []string {
+		data, _ := ioutil.ReadFile(out)
+		if len(data) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		runWatched(newClientSet(nil, client), [][]string{command}, loaded, sources, nil, nil, opts)
+		close(done)
+	}()
+
+	waitFor(t, 2*time.Second, "the first start", func() bool { return len(lines()) == 1 })
+
+	// COLD isn't watched, so changing it alone restarts nothing
+	client.Put(fakeParam{Name: "/prod/app/COLD", Value: "new"})
+	time.Sleep(50 * time.Millisecond)
+	if got := lines(); len(got) != 1 {
+		t.Fatalf("restarted for an unwatched key: %v", got)
+	}
+
+	client.Put(fakeParam{Name: "/prod/app/HOT", Value: "new"})
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("command wasn't restarted")
+	}
+
+	want := []string{"old old", "new old"}
+	if got := lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("starts = %v, want %v", got, want)
+	}
+	if loaded.SSM["COLD"] != "old" {
+		t.Errorf("COLD = %q, want it left alone", loaded.SSM["COLD"])
+	}
+}
+
+func TestRunWatchedRedactsAndSanitizesChanges(t *testing.T) {
+	withRedacted(t)
+	out := filepath.Join(tempDir(t), "out")
+
+	client := newFakeSSM(fakeParam{Name: "/prod/app/HOT", Value: "old", Type: ssm.ParameterTypeSecureString})
+
+	loaded := &loadedParams{
+		OSEnv: paramMap{"PATH": os.Getenv("PATH")},
+		SSM:   paramMap{"HOT": "old"},
+	}
+	sources := map[string]string{"HOT": "/prod/app/HOT"}
+	opts := &options{
+		RefreshKeys:    []refreshKey{{Key: "HOT", Interval: 10 * time.Millisecond}},
+		RedactOutput:   true,
+		SanitizeValues: sanitizeStrip,
+		NoStdin:        true,
+	}
+	command := []string{"/bin/sh", "-c", `printf '%s\n' "$HOT" >> ` + out + `; [ "$HOT" = new ] || exec sleep 5`}
+
+	done := make(chan struct{})
+	go func() {
+		runWatched(newClientSet(nil, client), [][]string{command}, loaded, sources, nil, nil, opts)
+		close(done)
+	}()
+
+	waitFor(t, 2*time.Second, "the first start", func() bool {
+		data, _ := ioutil.ReadFile(out)
+		return len(data) > 0
+	})
+	client.Put(fakeParam{Name: "/prod/app/HOT", Value: "n\x07ew", Type: ssm.ParameterTypeSecureString})
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("command wasn't restarted")
+	}
+
+	if got, want := readFile(t, out), "old\nnew\n"; got != want {
+		t.Errorf("starts = %q, want %q", got, want)
+	}
+
+	redactedMu.Lock()
+	defer redactedMu.Unlock()
+	if want := []string{"new"}; !reflect.DeepEqual(redacted, want) {
+		t.Errorf("redacted = %q, want %q", redacted, want)
+	}
+}
+
+func TestRunWatchedReloadsOnSIGHUP(t *testing.T) {
+	out := filepath.Join(tempDir(t), "out")
+	logged := captureLog(t)
+
+	loaded := &loadedParams{
+		OSEnv: paramMap{"PATH": os.Getenv("PATH")},
+		SSM:   paramMap{"VALUE": "old"},
+	}
+	opts := &options{NoStdin: true}
+
+	// The first reload fails and the second fetches the new value
+	var mu sync.Mutex
+	reloads := 0
+	reload := func() (paramMap, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if reloads++; reloads == 1 {
+			return nil, errors.New("throttled")
+		}
+		return paramMap{"VALUE": "new"}, nil
+	}
+	reloaded := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return reloads
+	}
+
+	command := []string{"/bin/sh", "-c", `echo "$VALUE" >> ` + out + `; [ "$VALUE" = new ] || exec sleep 5`}
+
+	done := make(chan struct{})
+	go func() {
+		runWatched(newClientSet(nil, newFakeSSM()), [][]string{command}, loaded, nil, reload, nil, opts)
+		close(done)
+	}()
+
+	waitFor(t, 2*time.Second, "the first start", func() bool {
+		data, _ := ioutil.ReadFile(out)
+		return string(data) == "old\n"
+	})
+
+	for want := 1; want <= 2; want++ {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, 2*time.Second, "a reload", func() bool { return reloaded() == want })
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("command wasn't restarted")
+	}
+
+	if got := readFile(t, out); got != "old\nnew\n" {
+		t.Errorf("starts = %q, want the command restarted once with the reloaded params", got)
+	}
+	if !strings.Contains(logged.String(), "Warning: couldn't reload params, keeping the command running:") {
+		t.Errorf("logged %q, want the failed reload", logged)
+	}
+}
+
+func TestRunWatchedRestartsOnRevalidate(t *testing.T) {
+	out := filepath.Join(tempDir(t), "out")
+
+	revalidated = make(chan []*ssm.Parameter)
+	defer func() { revalidated = nil }()
+
+	loaded := &loadedParams{
+		OSEnv: paramMap{"PATH": os.Getenv("PATH")},
+		SSM:   paramMap{"VALUE": "stale"},
+	}
+	load := func(params []*ssm.Parameter) (paramMap, error) {
+		m := make(paramMap)
+		for _, param := range params {
+			m[aws.StringValue(param.Name)] = aws.StringValue(param.Value)
+		}
+		return m, nil
+	}
+	refreshed := func(value string) []*ssm.Parameter {
+		return []*ssm.Parameter{{Name: aws.String("VALUE"), Value: aws.String(value)}}
+	}
+
+	command := []string{"/bin/sh", "-c", `echo "$VALUE" >> ` + out + `; [ "$VALUE" = fresh ] || exec sleep 5`}
+
+	done := make(chan struct{})
+	go func() {
+		runWatched(newClientSet(nil, newFakeSSM()), [][]string{command}, loaded, nil, nil, load, &options{NoStdin: true})
+		close(done)
+	}()
+
+	waitFor(t, 2*time.Second, "the first start", func() bool {
+		data, _ := ioutil.ReadFile(out)
+		return string(data) == "stale\n"
+	})
+
+	// A refresh that changes nothing leaves the command running
+	revalidated <- refreshed("stale")
+	time.Sleep(50 * time.Millisecond)
+	if got := readFile(t, out); got != "stale\n" {
+		t.Fatalf("starts = %q, want no restart for an unchanged refresh", got)
+	}
+
+	revalidated <- refreshed("fresh")
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("command wasn't restarted")
+	}
+
+	if got := readFile(t, out); got != "stale\nfresh\n" {
+		t.Errorf("starts = %q, want the command restarted with the refreshed params", got)
+	}
+}